@@ -0,0 +1,263 @@
+// Package main provides `ask abtest`, which runs two prompts repeatedly and
+// has a judge model pick the better answer of each pair without knowing
+// which prompt produced it.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// isABTestCommand reports whether the arguments invoke `ask abtest`. A flag
+// must follow, so "ask abtest sample sizes" stays an ordinary prompt.
+func isABTestCommand(args []string) bool {
+	return len(args) >= 2 && args[0] == "abtest" && strings.HasPrefix(args[1], "-")
+}
+
+// defaultABRounds is how many pairs are judged unless -n says otherwise
+const defaultABRounds = 10
+
+// defaultABCriteria is what the judge weighs unless --criteria says otherwise
+const defaultABCriteria = "accuracy, helpfulness, and clarity"
+
+// abJudgePrompt asks for the better of two answers. Which prompt produced
+// which answer, and the prompts themselves, are left out so the judge
+// can't favor one.
+const abJudgePrompt = `Two assistants answered variants of the same request. Judge which answer is
+better on %s. Ignore which one comes first.
+
+Answer 1:
+%s
+
+Answer 2:
+%s
+
+Reply with JSON only: {"winner": "1", "2", or "tie", "reason": "one sentence"}`
+
+// abRound is one pair of answers and the verdict, as printed by --json
+type abRound struct {
+	Winner string `json:"winner,omitempty"` // "a", "b", or "tie"; empty if the round failed
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	A      string `json:"a"`
+	B      string `json:"b"`
+}
+
+// abReport is the outcome of a test, as printed by --json
+type abReport struct {
+	Model  string    `json:"model"`
+	Judge  string    `json:"judge"`
+	WinsA  int       `json:"wins_a"`
+	WinsB  int       `json:"wins_b"`
+	Ties   int       `json:"ties"`
+	Failed int       `json:"failed"`
+	Rounds []abRound `json:"rounds"`
+}
+
+// runABTestCommand asks the model both prompts -n times and reports how
+// often the judge preferred each
+func runABTestCommand(args []string) error {
+	var providerFlag, modelFlag, profileFlag, fileA, fileB, judge, criteria string
+	rounds := defaultABRounds
+	asJSON := false
+	fs := flag.NewFlagSet("abtest", flag.ContinueOnError)
+	fs.StringVar(&fileA, "a", "", "File with the first prompt")
+	fs.StringVar(&fileB, "b", "", "File with the second prompt")
+	fs.StringVar(&judge, "judge", "", "Model or provider that picks the winners (default: the model tested)")
+	fs.StringVar(&criteria, "criteria", defaultABCriteria, "What the judge should weigh")
+	fs.IntVar(&rounds, "n", defaultABRounds, "Pairs of answers to judge")
+	fs.StringVar(&modelFlag, "m", "", "Model to test the prompts on")
+	fs.StringVar(&providerFlag, "p", "", "Provider to test the prompts on")
+	fs.StringVar(&profileFlag, "P", "", "Profile to test the prompts on")
+	fs.BoolVar(&asJSON, "json", false, "Print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fileA == "" || fileB == "" || fs.NArg() > 0 {
+		return fmt.Errorf("usage: ask abtest --a prompt_a.txt --b prompt_b.txt [--judge model] [-n 10] [--json]")
+	}
+	if rounds < 1 {
+		return fmt.Errorf("-n must be at least 1")
+	}
+	var prompts [2]string
+	for i, file := range []string{fileA, fileB} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			return fmt.Errorf("%s is empty", file)
+		}
+		prompts[i] = string(data)
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		return err
+	}
+	if _, err := checkBudget(config.Budget, budgetOK); err != nil {
+		return err
+	}
+	for i := range prompts {
+		prompt, ok := screenOutgoing(prompts[i], confirmStdin)
+		if !ok {
+			return fmt.Errorf("not sent")
+		}
+		prompts[i] = prompt
+	}
+
+	providerName, modelName, err := ResolveModelAndProvider(providerFlag, modelFlag, profileFlag, config)
+	if err != nil {
+		return err
+	}
+	p, modelName, err := newSessionProvider(providerName, modelName)
+	if err != nil {
+		return err
+	}
+	spec := providerName + "/" + modelName
+	judgeProvider, judgeModel := providerName, modelName
+	if judge != "" {
+		judgeProvider, judgeModel = parseModelChoice(judge, config.DefaultProvider)
+	}
+	jp, judgeModel, err := newSessionProvider(judgeProvider, judgeModel)
+	if err != nil {
+		return err
+	}
+	report := abReport{Model: spec, Judge: judgeProvider + "/" + judgeModel, Rounds: []abRound{}}
+
+	// Ctrl+C stops early and reports the rounds so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	for i := 1; i <= rounds && ctx.Err() == nil; i++ {
+		if !asJSON {
+			fmt.Fprintf(os.Stderr, "\r%sRound %d/%d…%s\033[K", dim, i, rounds, reset)
+		}
+		round := runABRound(ctx, p, spec, jp, report.Judge, prompts, criteria)
+		if ctx.Err() != nil {
+			break
+		}
+		switch round.Winner {
+		case "a":
+			report.WinsA++
+		case "b":
+			report.WinsB++
+		case "tie":
+			report.Ties++
+		default:
+			report.Failed++
+		}
+		report.Rounds = append(report.Rounds, round)
+	}
+	if !asJSON {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "%sStopped early; showing the rounds that finished%s\n", yellow, reset)
+		}
+	}
+
+	jobOutcome = fmt.Sprintf("A won %d, B won %d, %d ties, %d failed", report.WinsA, report.WinsB, report.Ties, report.Failed)
+	if asJSON {
+		return json.NewEncoder(os.Stdout).Encode(report)
+	}
+	printABReport(report, fileA, fileB)
+	if len(report.Rounds) > 0 && report.Failed == len(report.Rounds) {
+		return fmt.Errorf("every round failed")
+	}
+	return nil
+}
+
+// runABRound gets one answer to each prompt and asks the judge, in a random
+// order, which is better
+func runABRound(ctx context.Context, p provider.Provider, spec string, judge provider.Provider, judgeSpec string, prompts [2]string, criteria string) abRound {
+	var round abRound
+	var answers [2]string
+	for i, prompt := range prompts {
+		run := runTimed(ctx, p, spec, []provider.Message{{Role: "user", Content: prompt}}, "abtest")
+		if run.err != nil {
+			round.Error = fmt.Sprintf("prompt %c: %v", 'A'+i, run.err)
+			return round
+		}
+		answers[i] = strings.TrimSpace(run.response)
+	}
+	round.A, round.B = answers[0], answers[1]
+
+	flip := rand.IntN(2) == 1
+	first, second := answers[0], answers[1]
+	if flip {
+		first, second = second, first
+	}
+	msgs := []provider.Message{{Role: "user", Content: fmt.Sprintf(abJudgePrompt, criteria, first, second)}}
+	run := runTimed(ctx, judge, judgeSpec, msgs, "abtest")
+	if run.err != nil {
+		round.Error = "judging: " + run.err.Error()
+		return round
+	}
+	var verdict struct {
+		Winner string `json:"winner"`
+		Reason string `json:"reason"`
+	}
+	if err := parseJSONAnswer(run.response, &verdict); err != nil {
+		round.Error = "the judge's reply couldn't be read"
+		return round
+	}
+	round.Reason = verdict.Reason
+	switch winner := strings.ToLower(strings.TrimSpace(verdict.Winner)); winner {
+	case "1", "2":
+		// Answer 2 is B unless the order was flipped
+		round.Winner = "a"
+		if (winner == "2") != flip {
+			round.Winner = "b"
+		}
+	case "tie":
+		round.Winner = "tie"
+	default:
+		round.Error = fmt.Sprintf("the judge named no winner (%q)", verdict.Winner)
+	}
+	return round
+}
+
+// printABReport lists the verdicts and the win rates
+func printABReport(r abReport, fileA, fileB string) {
+	if len(r.Rounds) == 0 {
+		return
+	}
+	for i, round := range r.Rounds {
+		switch round.Winner {
+		case "":
+			fmt.Printf("%s%3d  failed  %s%s\n", red, i+1, round.Error, reset)
+		case "tie":
+			fmt.Printf("%3d  %stie%s     %s%s%s\n", i+1, yellow, reset, dim, round.Reason, reset)
+		default:
+			fmt.Printf("%3d  %s%-7s%s %s%s%s\n", i+1, cyan, strings.ToUpper(round.Winner), reset, dim, round.Reason, reset)
+		}
+	}
+	judged := r.WinsA + r.WinsB + r.Ties
+	rate := func(n int) string {
+		if judged == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.0f%%", float64(n)/float64(judged)*100)
+	}
+	width := max(len(fileA), len(fileB), len("Ties")) + 4
+	fmt.Println()
+	fmt.Printf("%s%-*s %5d wins %5s%s\n", bold, width, "A "+fileA, r.WinsA, rate(r.WinsA), reset)
+	fmt.Printf("%s%-*s %5d wins %5s%s\n", bold, width, "B "+fileB, r.WinsB, rate(r.WinsB), reset)
+	fmt.Printf("%-*s %5d      %5s\n", width, "Ties", r.Ties, rate(r.Ties))
+	if r.Failed > 0 {
+		fmt.Printf("%s%d of %d rounds failed and aren't counted%s\n", red, r.Failed, len(r.Rounds), reset)
+	}
+	fmt.Printf("%sAnswers by %s, judged blind by %s in a random order%s\n", dim, r.Model, r.Judge, reset)
+}
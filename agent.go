@@ -0,0 +1,317 @@
+// Package main provides `ask agent`, which plans a task in the working
+// directory and carries it out step by step with the tools in tools.go,
+// asking before each change.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+
+	"github.com/chzyer/readline"
+)
+
+// isAgentCommand reports whether the arguments invoke `ask agent`
+func isAgentCommand(args []string) bool {
+	return len(args) >= 2 && args[0] == "agent"
+}
+
+// defaultAgentSteps bounds the act loop unless --max-steps says otherwise
+const defaultAgentSteps = 20
+
+// agentPlanPrompt asks for a plan before anything is done
+const agentPlanPrompt = `You are a careful software engineer working in a repository. Before doing
+anything, write a short numbered plan (at most 8 steps) for the task below:
+which files to look at, what to change, and how to check the result. Reply
+with the plan only.
+
+Task: %s
+
+Files in the working directory:
+%s`
+
+// agentActPrompt explains the tools and the reply format
+const agentActPrompt = `Carry out the plan one step at a time. In each reply, send exactly one JSON
+object and nothing else, either a tool call:
+
+{"thought": "why this step", "tool": "<name>", "args": {...}}
+
+or, when the task is finished or can't be done:
+
+{"thought": "...", "done": "what was changed and how it was checked"}
+
+Tools:
+%s
+Paths are relative to the working directory. Read a file before editing it,
+and copy the old text exactly. The user confirms each edit and command and
+may decline one; if so, adjust the approach. Tool results come back in the
+next message.
+
+Task: %s
+
+Plan:
+%s`
+
+// agentRun is the state of one `ask agent` run
+type agentRun struct {
+	config     *Config
+	p          provider.Provider
+	spec       string
+	tools      *toolRunner
+	msgs       []provider.Message
+	transcript strings.Builder
+	approveAll bool
+	budgetSeen budgetLevel
+	in         *bufio.Reader
+}
+
+// runAgentCommand plans the task, asks to go ahead, then runs the act loop
+func runAgentCommand(args []string) error {
+	var providerFlag, modelFlag, profileFlag string
+	maxSteps := defaultAgentSteps
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.StringVar(&modelFlag, "m", "", "Model to run the agent with")
+	fs.StringVar(&providerFlag, "p", "", "Provider to run the agent with")
+	fs.StringVar(&profileFlag, "P", "", "Profile to run the agent with")
+	fs.IntVar(&maxSteps, "max-steps", defaultAgentSteps, "Most tool calls before stopping")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	task := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if task == "" {
+		return fmt.Errorf("usage: ask agent [-m model] [--max-steps n] \"task\"")
+	}
+	if maxSteps < 1 {
+		return fmt.Errorf("--max-steps must be at least 1")
+	}
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("ask agent needs a terminal to confirm its steps")
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	markdownTheme = config.Theme
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		return err
+	}
+	providerName, modelName, err := ResolveModelAndProvider(providerFlag, modelFlag, profileFlag, config)
+	if err != nil {
+		return err
+	}
+	p, modelName, err := newSessionProvider(providerName, modelName)
+	if err != nil {
+		return err
+	}
+	task, ok := screenOutgoing(task, confirmStdin)
+	if !ok {
+		return fmt.Errorf("not sent")
+	}
+
+	root, err := workingDir()
+	if err != nil {
+		return err
+	}
+	// Ctrl+C stops the run but keeps the transcript
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	a := &agentRun{
+		config: config,
+		p:      p,
+		spec:   providerName + "/" + modelName,
+		tools:  &toolRunner{root: root, ctx: ctx, sandbox: config.Sandbox},
+		in:     bufio.NewReader(os.Stdin),
+	}
+	start := time.Now()
+	fmt.Fprintf(&a.transcript, "# Agent: %s\n\nModel: %s  \nDirectory: %s\n", task, a.spec, root)
+	defer func() {
+		saveHistory(providerName, modelName, "agent: "+task, a.transcript.String(), time.Since(start))
+	}()
+
+	// Plan
+	files, _ := a.tools.listFiles(toolArgs{})
+	a.msgs = []provider.Message{{Role: "user", Content: fmt.Sprintf(agentPlanPrompt, task, clipToolOutput(files))}}
+	plan, err := a.query(ctx, "Planning")
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	a.transcript.WriteString("\n## Plan\n\n" + plan + "\n")
+	fmt.Printf("\n%s%sPlan%s\n", bold, cyan, reset)
+	if err := renderMarkdown(plan); err != nil {
+		fmt.Println(plan)
+	}
+	if answer := a.ask(fmt.Sprintf("%sCarry out this plan? [y/N]: %s", yellow, reset)); answer != "y" && answer != "yes" {
+		a.transcript.WriteString("\nStopped before starting.\n")
+		jobOutcome = "stopped before starting"
+		return nil
+	}
+
+	// Act
+	a.msgs = []provider.Message{{Role: "user", Content: fmt.Sprintf(agentActPrompt, a.tools.describeTools(), task, plan)}}
+	for step := 1; step <= maxSteps; step++ {
+		reply, err := a.query(ctx, fmt.Sprintf("Step %d/%d", step, maxSteps))
+		if err != nil {
+			if ctx.Err() != nil {
+				a.transcript.WriteString("\nInterrupted.\n")
+				fmt.Printf("\n%sInterrupted. The transcript is saved in history.%s\n", dim, reset)
+				jobOutcome = "interrupted"
+				return nil
+			}
+			return err
+		}
+		action, args, err := parseAgentAction(reply)
+		if err != nil {
+			fmt.Printf("%s%sStep %d: unreadable reply (%v)%s\n", bold, yellow, step, err, reset)
+			fmt.Fprintf(&a.transcript, "\n## Step %d: unreadable reply\n\n%s\n", step, reply)
+			a.result(fmt.Sprintf("That reply couldn't be used (%v). Reply with exactly one JSON object as described.", err))
+			continue
+		}
+		if action.Done != "" {
+			fmt.Printf("\n%s%sDone%s\n", bold, green, reset)
+			if err := renderMarkdown(action.Done); err != nil {
+				fmt.Println(action.Done)
+			}
+			fmt.Fprintf(&a.transcript, "\n## Done\n\n%s\n", action.Done)
+			jobOutcome = clipMessage(strings.Join(strings.Fields(action.Done), " "), 300)
+			return nil
+		}
+		if !a.step(step, action, args) {
+			a.transcript.WriteString("\nStopped by the user.\n")
+			jobOutcome = "stopped by the user"
+			return nil
+		}
+		if ctx.Err() != nil {
+			a.transcript.WriteString("\nInterrupted.\n")
+			jobOutcome = "interrupted"
+			return nil
+		}
+	}
+	fmt.Printf("\n%sStopped after %d steps; raise --max-steps to let it go further.%s\n", yellow, maxSteps, reset)
+	fmt.Fprintf(&a.transcript, "\nStopped after %d steps.\n", maxSteps)
+	jobOutcome = fmt.Sprintf("stopped after %d steps", maxSteps)
+	return nil
+}
+
+// step shows a tool call, asks before one that changes anything, runs it,
+// and passes the result back. It returns false when the user quits.
+func (a *agentRun) step(n int, action *agentAction, args toolArgs) bool {
+	tool, _ := findTool(action.Tool)
+	summary := strings.TrimSpace(args.Path + " " + args.Pattern + " " + args.Language)
+	fmt.Printf("\n%s%sStep %d · %s%s %s\n", bold, cyan, n, tool.name, reset, summary)
+	if action.Thought != "" {
+		fmt.Printf("%s%s%s\n", dim, action.Thought, reset)
+	}
+	fmt.Fprintf(&a.transcript, "\n## Step %d: %s %s\n\n", n, tool.name, summary)
+	if action.Thought != "" {
+		fmt.Fprintf(&a.transcript, "> %s\n\n", action.Thought)
+	}
+	if len(action.Args) > 0 {
+		fmt.Fprintf(&a.transcript, "```json\n%s\n```\n\n", action.Args)
+	}
+
+	if tool.confirm {
+		preview, err := tool.preview(a.tools, args)
+		if err != nil {
+			fmt.Printf("%s%v%s\n", yellow, err, reset)
+			fmt.Fprintf(&a.transcript, "Error: %v\n", err)
+			a.result(fmt.Sprintf("Error from %s: %v", tool.name, err))
+			return true
+		}
+		fmt.Println(preview)
+		if !a.approveAll {
+			switch a.ask(fmt.Sprintf("%sRun this? [y]es / [n]o / [a]ll remaining / [q]uit: %s", yellow, reset)) {
+			case "y", "yes":
+			case "a", "all":
+				a.approveAll = true
+			case "q", "quit":
+				return false
+			default:
+				fmt.Fprintf(&a.transcript, "Declined by the user.\n")
+				a.result(fmt.Sprintf("The user declined this %s call. Try another approach or finish.", tool.name))
+				return true
+			}
+		}
+	}
+
+	out, err := tool.run(a.tools, args)
+	if err != nil {
+		fmt.Printf("%s%v%s\n", yellow, err, reset)
+		fmt.Fprintf(&a.transcript, "Error: %v\n", err)
+		a.result(fmt.Sprintf("Error from %s: %v", tool.name, err))
+		return true
+	}
+	out = clipToolOutput(out)
+	if tool.confirm {
+		fmt.Printf("%s%s%s\n", dim, lastLines(out, 15), reset)
+	}
+	fmt.Fprintf(&a.transcript, "```\n%s\n```\n", strings.TrimRight(out, "\n"))
+	a.result(fmt.Sprintf("Result of %s:\n%s", tool.name, out))
+	return true
+}
+
+// result passes a tool result to the model, screened like a prompt
+func (a *agentRun) result(text string) {
+	if screened, ok := screenOutgoing(text, confirmStdin); ok {
+		text = screened
+	} else {
+		text = "The result was withheld because it looks like it contains secrets or was flagged by moderation."
+	}
+	a.msgs = append(a.msgs, provider.Message{Role: "user", Content: text})
+}
+
+// query sends the conversation with a spinner, records usage, and adds the
+// reply to the conversation
+func (a *agentRun) query(ctx context.Context, label string) (string, error) {
+	level, err := checkBudget(a.config.Budget, a.budgetSeen)
+	if err != nil {
+		return "", err
+	}
+	a.budgetSeen = max(a.budgetSeen, level)
+
+	out := &liveWriter{quiet: true}
+	stopSpinner := startSpinner(label, "", &out.received)
+	start := time.Now()
+	err = a.p.QueryStreamWithHistory(ctx, a.msgs, out)
+	stopSpinner()
+	if err != nil {
+		countError(err)
+		return "", fmt.Errorf("error querying %s: %v", a.spec, err)
+	}
+	reply := strings.TrimSpace(out.buf.String())
+	saveUsage("agent", newTurnUsage(a.spec, a.msgs, reply, time.Since(start)))
+	a.msgs = append(a.msgs, provider.Message{Role: "assistant", Content: reply})
+	return reply, nil
+}
+
+// ask reads a lowercased answer from the terminal
+func (a *agentRun) ask(prompt string) string {
+	fmt.Print(prompt)
+	line, err := a.in.ReadString('\n')
+	if err != nil {
+		fmt.Println()
+	}
+	return strings.ToLower(strings.TrimSpace(line))
+}
+
+// lastLines keeps the end of long command output for the terminal
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) <= n {
+		return strings.Join(lines, "\n")
+	}
+	return fmt.Sprintf("… %d lines\n", len(lines)-n) + strings.Join(lines[len(lines)-n:], "\n")
+}
@@ -0,0 +1,141 @@
+// Package main provides file attachments for interactive sessions.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	maxAttachmentBytes   = 1 << 20  // refuse anything larger than 1 MB
+	maxImageBytes        = 20 << 20 // images shrink to their text, so allow more
+	largeAttachmentToken = 20000    // warn above this many estimated tokens
+	ocrTimeout           = time.Minute
+)
+
+// imageExts are the image formats tesseract reads
+var imageExts = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true,
+	".webp": true, ".bmp": true, ".tif": true, ".tiff": true,
+}
+
+// Attachment is a file queued to be sent with the next message
+type Attachment struct {
+	Path      string
+	Content   string
+	OCR       bool   // Content is the text read from an image
+	Condensed string // how Content was shortened to fit the context, if it was
+}
+
+// loadAttachment reads a text file for attaching to a prompt. An image is
+// attached as the text OCR finds in it, since the models are sent text only;
+// ocrLanguages is passed to tesseract, e.g. eng+deu.
+func loadAttachment(path, ocrLanguages string) (*Attachment, error) {
+	if strings.HasPrefix(path, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(homeDir, path[2:])
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	limit := int64(maxAttachmentBytes)
+	if imageExts[strings.ToLower(filepath.Ext(path))] {
+		limit = maxImageBytes
+	}
+	if info.Size() > limit {
+		return nil, fmt.Errorf("%s is too large (%s, limit %s)", path, formatBytes(info.Size()), formatBytes(limit))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(http.DetectContentType(data), "image/") {
+		text, err := ocrImage(path, ocrLanguages)
+		if err != nil {
+			return nil, err
+		}
+		return &Attachment{Path: path, Content: text, OCR: true}, nil
+	}
+	if bytes.IndexByte(data, 0) != -1 {
+		return nil, fmt.Errorf("%s looks like a binary file", path)
+	}
+
+	return &Attachment{Path: path, Content: string(data)}, nil
+}
+
+// ocrImage returns the text tesseract finds in an image
+func ocrImage(path, languages string) (string, error) {
+	if _, err := exec.LookPath("tesseract"); err != nil {
+		return "", fmt.Errorf("%s is an image; install tesseract to attach the text in it", path)
+	}
+	args := []string{path, "stdout"}
+	if languages != "" {
+		args = append(args, "-l", languages)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ocrTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "tesseract", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("reading text from %s: %s", path, lastLines(msg, 3))
+		}
+		return "", fmt.Errorf("reading text from %s: %v", path, err)
+	}
+	text := strings.TrimSpace(collapseBlankLines(string(out)))
+	if text == "" {
+		return "", fmt.Errorf("no text found in %s", path)
+	}
+	return text, nil
+}
+
+// withAttachments prepends attached files to a prompt as fenced blocks
+func withAttachments(prompt string, attachments []*Attachment) string {
+	if len(attachments) == 0 {
+		return prompt
+	}
+
+	var b strings.Builder
+	for _, a := range attachments {
+		if a.Condensed != "" {
+			fmt.Fprintf(&b, "File: %s (%s)\n```\n%s\n```\n\n", a.Path, a.Condensed, strings.TrimRight(a.Content, "\n"))
+			continue
+		}
+		if a.OCR {
+			fmt.Fprintf(&b, "Image: %s (its text, read with OCR)\n```\n%s\n```\n\n", a.Path, a.Content)
+			continue
+		}
+		lang := strings.TrimPrefix(filepath.Ext(a.Path), ".")
+		fmt.Fprintf(&b, "File: %s\n```%s\n%s\n```\n\n", a.Path, lang, strings.TrimRight(a.Content, "\n"))
+	}
+	b.WriteString(prompt)
+	return b.String()
+}
+
+// formatBytes formats a byte count for display
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
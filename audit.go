@@ -0,0 +1,378 @@
+// Package main provides audit records of the exact requests sent to
+// providers and the `ask audit` command.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// auditCommands are the actions of `ask audit`
+var auditCommands = map[string]bool{"list": true, "show": true, "prune": true}
+
+// isAuditCommand reports whether the arguments invoke `ask audit`
+func isAuditCommand(args []string) bool {
+	if len(args) == 0 || args[0] != "audit" {
+		return false
+	}
+	return len(args) == 1 || auditCommands[args[1]]
+}
+
+// auditRecord is one request and its response, as stored on disk
+type auditRecord struct {
+	Time            time.Time         `json:"time"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	RequestHeaders  map[string]string `json:"request_headers"`
+	Request         json.RawMessage   `json:"request,omitempty"` // the body exactly as sent
+	Status          int               `json:"status,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	Response        string            `json:"response"` // the body as received; streams are raw SSE
+	Error           string            `json:"error,omitempty"`
+	DurationMs      int64             `json:"duration_ms"`
+}
+
+// auditHeaders are never stored with their values
+var auditHeaders = map[string]bool{"authorization": true, "x-api-key": true, "api-key": true, "x-goog-api-key": true, "cookie": true, "set-cookie": true}
+
+// auditor writes one read-only file per request to a directory
+type auditor struct {
+	dir     string
+	secrets []string // configured API keys, scrubbed from every record
+	seq     atomic.Int64
+}
+
+// auditDirPath returns the configured audit directory with ~ expanded
+func auditDirPath(config *Config) string {
+	path := config.AuditDir
+	if strings.HasPrefix(path, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(homeDir, path[2:])
+		}
+	}
+	return path
+}
+
+// openAudit turns on audit records when audit_dir is configured, and off
+// when it isn't
+func openAudit(config *Config) {
+	if config.AuditDir == "" {
+		provider.SetWrapTransport(nil)
+		return
+	}
+	a := &auditor{dir: auditDirPath(config), secrets: configuredSecrets(config)}
+	provider.SetWrapTransport(func(rt http.RoundTripper) http.RoundTripper {
+		return &auditTransport{base: rt, audit: a}
+	})
+}
+
+// redact scrubs configured API keys from s
+func (a *auditor) redact(s string) string {
+	for _, secret := range a.secrets {
+		s = strings.ReplaceAll(s, secret, "[REDACTED]")
+	}
+	return s
+}
+
+// headers copies h for a record, hiding credentials
+func (a *auditor) headers(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		value := strings.Join(values, ", ")
+		if auditHeaders[strings.ToLower(name)] {
+			value = "[REDACTED]"
+		}
+		out[name] = a.redact(value)
+	}
+	return out
+}
+
+// write stores a record in a new file. Existing records are never
+// opened for writing, and files are created read-only.
+func (a *auditor) write(rec auditRecord) {
+	data, err := json.Marshal(rec) // compact, so request bodies keep their exact bytes
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "%s[!] Audit record not written: %v%s\n", red, err, reset)
+		return
+	}
+	name := fmt.Sprintf("%s-%d-%d.json", rec.Time.UTC().Format(auditTimeLayout), os.Getpid(), a.seq.Add(1))
+	f, err := os.OpenFile(filepath.Join(a.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s[!] Audit record not written: %v%s\n", red, err, reset)
+		return
+	}
+	defer f.Close()
+	f.Write(append(data, '\n'))
+}
+
+// auditTransport records each request and response passing through it
+type auditTransport struct {
+	base  http.RoundTripper
+	audit *auditor
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := auditRecord{
+		Time:           time.Now(),
+		Method:         req.Method,
+		URL:            t.audit.redact(req.URL.String()),
+		RequestHeaders: t.audit.headers(req.Header),
+	}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			body.Close()
+			rec.Request = rawJSON(t.audit.redact(string(data)))
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		rec.Error = t.audit.redact(err.Error())
+		rec.DurationMs = time.Since(rec.Time).Milliseconds()
+		t.audit.write(rec)
+		return resp, err
+	}
+	rec.Status = resp.StatusCode
+	rec.ResponseHeaders = t.audit.headers(resp.Header)
+	resp.Body = &auditBody{ReadCloser: resp.Body, rec: rec, audit: t.audit}
+	return resp, nil
+}
+
+// rawJSON keeps a JSON body as is, or stores anything else as a string
+func rawJSON(s string) json.RawMessage {
+	if s == "" {
+		return nil
+	}
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	quoted, _ := json.Marshal(s)
+	return quoted
+}
+
+// auditBody captures a response body as it is read and writes the record
+// when the body is closed
+type auditBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	rec   auditRecord
+	audit *auditor
+	once  sync.Once
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err != nil && err != io.EOF {
+		b.rec.Error = b.audit.redact(err.Error())
+	}
+	return n, err
+}
+
+func (b *auditBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.rec.Response = b.audit.redact(b.buf.String())
+		b.rec.DurationMs = time.Since(b.rec.Time).Milliseconds()
+		b.audit.write(b.rec)
+	})
+	return err
+}
+
+// auditTimeLayout is the time at the start of a record's name
+const auditTimeLayout = "20060102T150405.000000Z"
+
+// auditRecordName matches the names auditor.write gives records: the
+// time of the request, then the process and a sequence number
+var auditRecordName = regexp.MustCompile(`^(\d{8}T\d{6}\.\d{6}Z)-\d+-\d+\.json$`)
+
+// auditFile is a record on disk
+type auditFile struct {
+	name string
+	time time.Time
+	size int64
+}
+
+// listAuditFiles returns the records in dir, newest first. Only files named
+// like records count, since audit_dir may be a folder shared with other
+// files, and their time comes from the name, which touching or copying a
+// file doesn't change.
+func listAuditFiles(dir string) ([]auditFile, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []auditFile
+	for _, e := range entries {
+		m := auditRecordName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		t, err := time.Parse(auditTimeLayout, m[1])
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, auditFile{name: e.Name(), time: t, size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name > files[j].name })
+	return files, nil
+}
+
+// runAuditCommand lists, shows, or prunes audit records
+func runAuditCommand(args []string) error {
+	action := "list"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+	config, err := LoadConfigSafe()
+	if err != nil {
+		return err
+	}
+	if config.AuditDir == "" {
+		return fmt.Errorf("audit records are off. Set audit_dir in config.yaml to turn them on")
+	}
+	dir := auditDirPath(config)
+
+	switch action {
+	case "list":
+		limit := 20
+		fs := flag.NewFlagSet("audit list", flag.ContinueOnError)
+		fs.IntVar(&limit, "limit", 20, "Show at most `N` records")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		files, err := listAuditFiles(dir)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			fmt.Printf("No audit records in %s\n", dir)
+			return nil
+		}
+		for i, f := range files {
+			if i == limit {
+				fmt.Printf("%s... %d more%s\n", dim, len(files)-limit, reset)
+				break
+			}
+			var rec auditRecord
+			if data, err := os.ReadFile(filepath.Join(dir, f.name)); err == nil {
+				json.Unmarshal(data, &rec)
+			}
+			status := fmt.Sprint(rec.Status)
+			if rec.Error != "" {
+				status = "error"
+			}
+			fmt.Printf("%s  %s  %-5s %-45s %8s\n", f.name, rec.Time.Local().Format("2006-01-02 15:04:05"), status,
+				rec.Method+" "+rec.URL, formatBytes(f.size))
+		}
+		return nil
+
+	case "show":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: ask audit show <record>")
+		}
+		files, err := listAuditFiles(dir)
+		if err != nil {
+			return err
+		}
+		var match []string
+		for _, f := range files {
+			if strings.HasPrefix(f.name, args[0]) {
+				match = append(match, f.name)
+			}
+		}
+		switch len(match) {
+		case 0:
+			return fmt.Errorf("no audit record matches '%s'", args[0])
+		case 1:
+			data, err := os.ReadFile(filepath.Join(dir, match[0]))
+			if err != nil {
+				return err
+			}
+			var out bytes.Buffer
+			if err := json.Indent(&out, data, "", "  "); err != nil {
+				out.Reset()
+				out.Write(data)
+			}
+			os.Stdout.Write(out.Bytes())
+			return nil
+		}
+		return fmt.Errorf("'%s' matches %d records; give more of the name", args[0], len(match))
+
+	case "prune":
+		before, yes := "", false
+		fs := flag.NewFlagSet("audit prune", flag.ContinueOnError)
+		fs.StringVar(&before, "before", "", "Delete records older than `DATE` (2006-01-02) or a duration (90d)")
+		fs.BoolVar(&yes, "y", false, "Don't ask for confirmation")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if before == "" {
+			return fmt.Errorf("usage: ask audit prune --before <date|90d> [-y]")
+		}
+		cutoff, err := parseHistoryDate(before)
+		if err != nil {
+			return err
+		}
+		files, err := listAuditFiles(dir)
+		if err != nil {
+			return err
+		}
+		var old []auditFile
+		for _, f := range files {
+			if f.time.Before(cutoff) {
+				old = append(old, f)
+			}
+		}
+		if len(old) == 0 {
+			fmt.Println("No audit records to prune")
+			return nil
+		}
+		if !yes {
+			fmt.Printf("Delete %d audit records from before %s? [y/N]: ", len(old), cutoff.Format("2006-01-02 15:04"))
+			scanner := bufio.NewScanner(os.Stdin)
+			answer := ""
+			if scanner.Scan() {
+				answer = strings.ToLower(strings.TrimSpace(scanner.Text()))
+			}
+			if answer != "y" && answer != "yes" {
+				fmt.Println("Cancelled")
+				return nil
+			}
+		}
+		for _, f := range old {
+			if err := os.Remove(filepath.Join(dir, f.name)); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Deleted %d audit records\n", len(old))
+		return nil
+	}
+	return fmt.Errorf("unknown audit command '%s' (list, show, prune)", action)
+}
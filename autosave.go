@@ -0,0 +1,115 @@
+// Package main provides autosave of the running session and recovery of
+// sessions lost to a crash or dropped connection.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// autosave writes the conversation so far to the database, keyed by this
+// process. Errors are ignored: autosave must never interrupt the session.
+func (s *Session) autosave() {
+	db, err := openStore()
+	if err != nil {
+		return
+	}
+	saved := s.snapshot()
+	if len(saved.Messages) == 0 {
+		db.Exec("DELETE FROM autosave WHERE pid = ?", os.Getpid())
+		return
+	}
+	saved.UpdatedAt = time.Now()
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return
+	}
+	db.Exec("INSERT OR REPLACE INTO autosave (pid, data, updated_at) VALUES (?, ?, ?)",
+		os.Getpid(), string(data), toMillis(saved.UpdatedAt))
+}
+
+// discardAutosave removes the autosave when the session ends normally
+func (s *Session) discardAutosave() {
+	if db, err := openStore(); err == nil {
+		db.Exec("DELETE FROM autosave WHERE pid = ?", os.Getpid())
+	}
+}
+
+// processAlive reports whether a process with the given id is running
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true // FindProcess already fails for processes that have exited
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
+
+// findCrashedSession returns the most recent autosave left behind by a
+// session that is no longer running, and the id of the process that left it
+func findCrashedSession() (*SavedSession, int) {
+	db, err := openStore()
+	if err != nil {
+		return nil, 0
+	}
+	rows, err := db.Query("SELECT pid, data FROM autosave ORDER BY updated_at DESC")
+	if err != nil {
+		return nil, 0
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pid int
+		var data string
+		if rows.Scan(&pid, &data) != nil || pid != os.Getpid() && processAlive(pid) {
+			continue
+		}
+		var saved SavedSession
+		if json.Unmarshal([]byte(data), &saved) == nil && len(saved.Messages) > 0 {
+			return &saved, pid
+		}
+	}
+	return nil, 0
+}
+
+// offerRecovery asks whether to restore a session that ended without
+// exiting normally. Returns nil if there is none or the user declines; the
+// autosave is removed either way.
+func offerRecovery() *SavedSession {
+	saved, pid := findCrashedSession()
+	if saved == nil {
+		return nil
+	}
+
+	what := "An unsaved session"
+	if saved.Name != "" {
+		what = fmt.Sprintf("Session '%s'", saved.Name)
+	}
+	fmt.Printf("\n%s%s%s did not exit cleanly (%d messages with %s/%s, %s).\n",
+		yellow, what, reset, len(saved.Messages), saved.Provider, saved.Model,
+		saved.UpdatedAt.Format("2006-01-02 15:04"))
+	fmt.Print("Restore it? [Y/n]: ")
+
+	answer := ""
+	scanner := bufio.NewScanner(os.Stdin)
+	if scanner.Scan() {
+		answer = strings.ToLower(strings.TrimSpace(scanner.Text()))
+	}
+	if db, err := openStore(); err == nil {
+		db.Exec("DELETE FROM autosave WHERE pid = ?", pid)
+	}
+	if answer != "" && answer != "y" && answer != "yes" {
+		return nil
+	}
+	return saved
+}
@@ -0,0 +1,298 @@
+// Package main provides `ask bench`, which sends the same prompt to several
+// models a number of times and compares their speed and reliability.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// isBenchCommand reports whether the arguments invoke `ask bench`. A flag
+// must follow, so "ask bench press form tips" stays an ordinary prompt.
+func isBenchCommand(args []string) bool {
+	return len(args) >= 2 && args[0] == "bench" && strings.HasPrefix(args[1], "-")
+}
+
+// defaultBenchRuns is how often each model is asked unless -n says otherwise
+const defaultBenchRuns = 3
+
+// timedRun is one request with its timings
+type timedRun struct {
+	response   string
+	firstToken time.Duration // zero if nothing arrived
+	total      time.Duration
+	err        error
+}
+
+// tokensPerSecond is the streaming rate after the first token
+func (r timedRun) tokensPerSecond() float64 {
+	streaming := r.total - r.firstToken
+	tokens := estimateTokens(r.response)
+	if r.firstToken == 0 || streaming <= 0 || tokens == 0 {
+		return 0
+	}
+	return float64(tokens) / streaming.Seconds()
+}
+
+// runTimed sends msgs and records the time to first token and the total,
+// adding the request to the usage ledger under usage
+func runTimed(ctx context.Context, p provider.Provider, spec string, msgs []provider.Message, usage string) timedRun {
+	var first time.Time
+	out := &liveWriter{quiet: true, onFirst: func() { first = time.Now() }}
+	start := time.Now()
+	err := p.QueryStreamWithHistory(ctx, msgs, out)
+	run := timedRun{response: out.buf.String(), total: time.Since(start), err: err}
+	if !first.IsZero() {
+		run.firstToken = first.Sub(start)
+	}
+	if err != nil {
+		countError(err)
+	} else {
+		saveUsage(usage, newTurnUsage(spec, msgs, run.response, run.total))
+	}
+	return run
+}
+
+// benchStats summarizes one model's runs, as printed by --json
+type benchStats struct {
+	Model          string   `json:"model"`
+	Runs           int      `json:"runs"`
+	Failures       int      `json:"failures"`
+	FailureRate    float64  `json:"failure_rate"`
+	FirstTokenP50  int64    `json:"first_token_p50_ms"`
+	FirstTokenMean int64    `json:"first_token_mean_ms"`
+	TotalP50       int64    `json:"total_p50_ms"`
+	TotalMean      int64    `json:"total_mean_ms"`
+	TotalMax       int64    `json:"total_max_ms"`
+	TokensPerSec   float64  `json:"tokens_per_sec"`
+	CostUSD        *float64 `json:"cost_usd"` // estimated, for all runs; nil when the price is unknown
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// runBenchCommand asks each model the prompt -n times, one request at a
+// time so they don't slow each other down, and prints the comparison
+func runBenchCommand(args []string) error {
+	var models, promptFile string
+	runs := defaultBenchRuns
+	asJSON := false
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.StringVar(&models, "models", "", "Comma-separated models or provider/model specs to compare")
+	fs.StringVar(&promptFile, "prompt-file", "", "Read the prompt from this file")
+	fs.IntVar(&runs, "n", defaultBenchRuns, "Requests per model")
+	fs.BoolVar(&asJSON, "json", false, "Print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	prompt := strings.Join(fs.Args(), " ")
+	if promptFile != "" {
+		if prompt != "" {
+			return fmt.Errorf("give either --prompt-file or a prompt, not both")
+		}
+		data, err := os.ReadFile(promptFile)
+		if err != nil {
+			return err
+		}
+		prompt = string(data)
+	}
+	if models == "" || strings.TrimSpace(prompt) == "" {
+		return fmt.Errorf("usage: ask bench --models a,b [-n 3] [--json] (--prompt-file file | \"prompt\")")
+	}
+	if runs < 1 {
+		return fmt.Errorf("-n must be at least 1")
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		return err
+	}
+	if _, err := checkBudget(config.Budget, budgetOK); err != nil {
+		return err
+	}
+	prompt, ok := screenOutgoing(prompt, confirmStdin)
+	if !ok {
+		return fmt.Errorf("not sent")
+	}
+
+	type contender struct {
+		spec string
+		p    provider.Provider
+	}
+	var contenders []contender
+	for _, m := range strings.Split(models, ",") {
+		if m = strings.TrimSpace(m); m == "" {
+			continue
+		}
+		providerName, modelName, err := ResolveModelAndProvider("", m, "", config)
+		if err != nil {
+			return err
+		}
+		p, modelName, err := newSessionProvider(providerName, modelName)
+		if err != nil {
+			return err
+		}
+		contenders = append(contenders, contender{providerName + "/" + modelName, p})
+	}
+
+	// Ctrl+C stops early and reports the runs so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	msgs := []provider.Message{{Role: "user", Content: prompt}}
+	var results []benchStats
+	for _, c := range contenders {
+		var done []timedRun
+		for i := 1; i <= runs && ctx.Err() == nil; i++ {
+			fmt.Fprintf(os.Stderr, "\r%s%s run %d/%d…%s\033[K", dim, c.spec, i, runs, reset)
+			run := runTimed(ctx, c.p, c.spec, msgs, "bench")
+			if ctx.Err() != nil {
+				break
+			}
+			done = append(done, run)
+		}
+		if len(done) > 0 {
+			results = append(results, summarizeBench(c.spec, prompt, done))
+		}
+	}
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "%sStopped early; showing the runs that finished%s\n", yellow, reset)
+	}
+
+	var fastest *benchStats
+	for i, r := range results {
+		if r.Failures < r.Runs && (fastest == nil || r.TotalP50 < fastest.TotalP50) {
+			fastest = &results[i]
+		}
+	}
+	if fastest != nil {
+		jobOutcome = fmt.Sprintf("%d models compared; fastest %s at %.2fs", len(results), fastest.Model, float64(fastest.TotalP50)/1000)
+	}
+	if asJSON {
+		if results == nil {
+			results = []benchStats{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(results)
+	}
+	printBench(results)
+	return nil
+}
+
+// summarizeBench computes one model's statistics; timings cover the
+// successful runs only
+func summarizeBench(spec, prompt string, runs []timedRun) benchStats {
+	s := benchStats{Model: spec, Runs: len(runs)}
+	var firsts, totals []time.Duration
+	var rates []float64
+	var cost float64
+	priced := true
+	for _, r := range runs {
+		if r.err != nil {
+			s.Failures++
+			s.Errors = append(s.Errors, r.err.Error())
+			continue
+		}
+		if r.firstToken > 0 {
+			firsts = append(firsts, r.firstToken)
+		}
+		totals = append(totals, r.total)
+		if rate := r.tokensPerSecond(); rate > 0 {
+			rates = append(rates, rate)
+		}
+		c, ok := estimateCost(spec, estimateTokens(prompt), estimateTokens(r.response))
+		cost += c
+		priced = priced && ok
+	}
+	s.FailureRate = float64(s.Failures) / float64(s.Runs)
+	s.FirstTokenP50, s.FirstTokenMean = percentile(firsts, 50).Milliseconds(), mean(firsts).Milliseconds()
+	s.TotalP50, s.TotalMean, s.TotalMax = percentile(totals, 50).Milliseconds(), mean(totals).Milliseconds(), percentile(totals, 100).Milliseconds()
+	if len(rates) > 0 {
+		sum := 0.0
+		for _, r := range rates {
+			sum += r
+		}
+		s.TokensPerSec = math.Round(sum/float64(len(rates))*10) / 10
+	}
+	if priced && len(totals) > 0 {
+		s.CostUSD = &cost
+	}
+	return s
+}
+
+// percentile returns the p-th percentile by nearest rank
+func percentile(values []time.Duration, p int) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(float64(p)/100*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
+
+// mean returns the average duration
+func mean(values []time.Duration) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, v := range values {
+		sum += v
+	}
+	return sum / time.Duration(len(values))
+}
+
+// printBench shows the comparison as a table, fastest first
+func printBench(results []benchStats) {
+	if len(results) == 0 {
+		return
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Failures == results[i].Runs) != (results[j].Failures == results[j].Runs) {
+			return results[j].Failures == results[j].Runs
+		}
+		return results[i].TotalP50 < results[j].TotalP50
+	})
+	ms := func(v int64) string {
+		if v == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2fs", float64(v)/1000)
+	}
+	fmt.Printf("%s%-36s %5s %8s %11s %10s %10s %8s %9s%s\n", dim, "Model", "Runs", "Failed", "First token", "Total p50", "Total max", "Tok/s", "Cost", reset)
+	for _, r := range results {
+		failed := fmt.Sprintf("%.0f%%", r.FailureRate*100)
+		if r.Failures > 0 {
+			failed = red + fmt.Sprintf("%7s", failed) + reset
+		} else {
+			failed = fmt.Sprintf("%7s", failed)
+		}
+		rate, cost := "-", "-"
+		if r.TokensPerSec > 0 {
+			rate = fmt.Sprintf("%.0f", r.TokensPerSec)
+		}
+		if r.CostUSD != nil {
+			cost = fmt.Sprintf("$%.4f", *r.CostUSD)
+		}
+		fmt.Printf("%-36s %5d %s %11s %10s %10s %8s %9s\n", r.Model, r.Runs, " "+failed, ms(r.FirstTokenP50), ms(r.TotalP50), ms(r.TotalMax), rate, cost)
+	}
+	for _, r := range results {
+		if len(r.Errors) > 0 {
+			fmt.Printf("%s%s: %s%s\n", dim, r.Model, r.Errors[len(r.Errors)-1], reset)
+		}
+	}
+	fmt.Printf("%sFirst token is the median; token counts and costs are estimates%s\n", dim, reset)
+}
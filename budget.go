@@ -0,0 +1,101 @@
+// Package main provides monthly budget alerts from the usage ledger.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultBudgetWarnAt is the share of the budget that triggers a warning
+const defaultBudgetWarnAt = 0.8
+
+// BudgetConfig sets a monthly spending limit on estimated costs
+type BudgetConfig struct {
+	Monthly float64 `yaml:"monthly,omitempty"` // USD; 0 turns alerts off
+	WarnAt  float64 `yaml:"warn_at,omitempty"` // fraction of the budget, default 0.8
+	Block   bool    `yaml:"block,omitempty"`   // refuse requests over budget unless --force
+}
+
+// forceBudget lets requests through over a blocking budget (--force)
+var forceBudget bool
+
+// budgetLevel is how far this month's spend is into the budget
+type budgetLevel int
+
+const (
+	budgetOK budgetLevel = iota
+	budgetWarning
+	budgetExceeded
+)
+
+// monthSpend returns the estimated cost of requests since the start of the
+// current month
+func monthSpend() (float64, error) {
+	db, err := openStore()
+	if err != nil {
+		return 0, err
+	}
+	y, m, _ := time.Now().Date()
+	start := time.Date(y, m, 1, 0, 0, 0, 0, time.Local)
+	var spend float64
+	err = db.QueryRow("SELECT coalesce(sum(cost), 0) FROM usage WHERE created_at >= ?", toMillis(start)).Scan(&spend)
+	return spend, err
+}
+
+// budgetStatus returns how far this month's spend is into the budget, with
+// a summary such as "$8.00 of your $10.00 monthly budget spent (80%)"
+func budgetStatus(budget BudgetConfig) (budgetLevel, string) {
+	if budget.Monthly <= 0 {
+		return budgetOK, ""
+	}
+	spend, err := monthSpend()
+	if err != nil {
+		return budgetOK, "" // the ledger is unavailable; don't get in the way
+	}
+
+	warnAt := budget.WarnAt
+	if warnAt <= 0 || warnAt > 1 {
+		warnAt = defaultBudgetWarnAt
+	}
+	level := budgetOK
+	switch {
+	case spend >= budget.Monthly:
+		level = budgetExceeded
+	case spend >= budget.Monthly*warnAt:
+		level = budgetWarning
+	}
+	return level, fmt.Sprintf("$%.2f of your $%.2f monthly budget spent (%.0f%%)", spend, budget.Monthly, spend/budget.Monthly*100)
+}
+
+// budgetBlocked reports whether a request at level must be refused
+func budgetBlocked(budget BudgetConfig, level budgetLevel, force bool) bool {
+	return level == budgetExceeded && budget.Block && !force
+}
+
+// checkBudget compares this month's spend with the budget, printing a
+// warning once the threshold is crossed. With block set, requests over
+// budget are refused unless --force was given. No warning is printed at or
+// below the shown level.
+func checkBudget(budget BudgetConfig, shown budgetLevel) (budgetLevel, error) {
+	level, summary := budgetStatus(budget)
+	if budgetBlocked(budget, level, forceBudget) {
+		return level, fmt.Errorf("monthly budget exceeded: %s. Use --force to send anyway", summary)
+	}
+	if level > shown {
+		if level == budgetExceeded {
+			fmt.Fprintf(os.Stderr, "%s%s⚠ Budget exceeded: %s%s\n", bold, red, summary, reset)
+		} else if level == budgetWarning {
+			fmt.Fprintf(os.Stderr, "%s%s⚠ Budget warning: %s%s\n", bold, yellow, summary, reset)
+		}
+	}
+	return level, nil
+}
+
+// checkBudget runs the budget check before a session request, warning only
+// when the session first reaches a new level
+func (s *Session) checkBudget() error {
+	level, err := checkBudget(s.budget, s.budgetShown)
+	s.budgetShown = max(s.budgetShown, level)
+	return err
+}
@@ -0,0 +1,75 @@
+// Package main provides the opt-in cache of one-shot answers.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// defaultCacheTTL is how long cached answers are reused when no ttl is set
+const defaultCacheTTL = 24 * time.Hour
+
+// CacheConfig turns on reuse of answers to identical one-shot requests
+type CacheConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	TTL     string `yaml:"ttl,omitempty"` // how long answers are reused, e.g. 1h or 7d; default 24h
+}
+
+// ttl returns how long cached answers are reused
+func (c CacheConfig) ttl() time.Duration {
+	if days, ok := strings.CutSuffix(c.TTL, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour
+		}
+	}
+	if d, err := time.ParseDuration(c.TTL); err == nil && d > 0 {
+		return d
+	}
+	return defaultCacheTTL
+}
+
+// cacheKey identifies a request by everything that affects its answer
+func cacheKey(providerName, modelName string, opts provider.Options, msgs []provider.Message) string {
+	data, _ := json.Marshal(struct {
+		Provider string
+		Model    string
+		Options  provider.Options
+		Messages []provider.Message
+	}{providerName, modelName, opts, msgs})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedAnswer returns a stored answer for key younger than ttl, and when it
+// was stored
+func cachedAnswer(key string, ttl time.Duration) (string, time.Time, bool) {
+	db, err := openStore()
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	var response string
+	var created int64
+	err = db.QueryRow("SELECT response, created_at FROM response_cache WHERE key = ? AND created_at >= ?",
+		key, toMillis(time.Now().Add(-ttl))).Scan(&response, &created)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return response, fromMillis(created), true
+}
+
+// storeAnswer caches an answer, dropping entries older than ttl
+func storeAnswer(key, response string, ttl time.Duration) {
+	db, err := openStore()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	db.Exec("DELETE FROM response_cache WHERE created_at < ?", toMillis(now.Add(-ttl)))
+	db.Exec("INSERT OR REPLACE INTO response_cache (key, response, created_at) VALUES (?, ?, ?)", key, response, toMillis(now))
+}
@@ -0,0 +1,60 @@
+// Package main provides cancellation of in-flight requests in session mode.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// beginRequest returns a context for a provider request that Esc or Ctrl+C
+// cancels. The returned done function must be called once the request ends.
+func (s *Session) beginRequest() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	s.cancel = cancel
+	s.cancelled = false
+	s.mu.Unlock()
+
+	stopWatch := watchEscape(func() { s.cancelRequest() })
+	return ctx, func() {
+		stopWatch()
+		s.mu.Lock()
+		s.cancel = nil
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancelRequest aborts the in-flight request, reporting false if there was
+// none or it was already cancelled
+func (s *Session) cancelRequest() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel == nil || s.cancelled {
+		return false
+	}
+	s.cancelled = true
+	s.cancel()
+	return true
+}
+
+// handleInterrupts cancels the in-flight request on the first Ctrl+C and
+// exits on a second one, or immediately when nothing is running
+func (s *Session) handleInterrupts() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range sigChan {
+			if sig == os.Interrupt && s.cancelRequest() {
+				continue
+			}
+			s.discardAutosave()
+			fmt.Printf("\n\n%s👋 Goodbye!%s\n\n", yellow, reset)
+			os.Exit(0)
+		}
+	}()
+}
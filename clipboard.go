@@ -0,0 +1,114 @@
+// Package main provides clipboard access for copying responses.
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// copyToClipboard copies text using the platform's clipboard tool. Over SSH,
+// or when no tool is available, it falls back to the OSC52 escape sequence,
+// which most modern terminals forward to the local clipboard.
+func copyToClipboard(text string) (string, error) {
+	if os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != "" {
+		return "OSC52", writeOSC52(text)
+	}
+
+	for _, tool := range clipboardCommands() {
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(tool[0], tool[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return tool[0], fmt.Errorf("%s failed: %w", tool[0], err)
+		}
+		return tool[0], nil
+	}
+
+	return "OSC52", writeOSC52(text)
+}
+
+// clipboardCommands lists candidate clipboard tools for this platform in order of preference
+func clipboardCommands() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip.exe"}, {"clip"}}
+	default:
+		var cmds [][]string
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			cmds = append(cmds, []string{"wl-copy"})
+		}
+		cmds = append(cmds,
+			[]string{"xclip", "-selection", "clipboard"},
+			[]string{"xsel", "--clipboard", "--input"},
+			[]string{"clip.exe"}, // WSL
+		)
+		return cmds
+	}
+}
+
+// writeOSC52 asks the terminal to set the clipboard, wrapping the sequence
+// for tmux passthrough when needed
+func writeOSC52(text string) error {
+	seq := "\033]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if os.Getenv("TMUX") != "" {
+		seq = "\033Ptmux;\033" + seq + "\033\\"
+	}
+	_, err := fmt.Fprint(os.Stdout, seq)
+	return err
+}
+
+// extractCodeBlocks returns the contents of fenced code blocks in markdown
+func extractCodeBlocks(content string) []string {
+	var blocks []string
+	for _, b := range extractFencedBlocks(content) {
+		blocks = append(blocks, b.code)
+	}
+	return blocks
+}
+
+// codeBlock is a fenced code block and the language named on its fence
+type codeBlock struct {
+	lang, code string
+}
+
+// extractFencedBlocks returns the fenced code blocks in markdown
+func extractFencedBlocks(content string) []codeBlock {
+	var blocks []codeBlock
+	var current []string
+	fence, lang := "", ""
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence == "" {
+			if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+				fence = trimmed[:3]
+				lang = strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1]))
+				if f := strings.Fields(lang); len(f) > 0 {
+					lang = f[0]
+				}
+				current = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			blocks = append(blocks, codeBlock{lang, strings.Join(current, "\n")})
+			fence = ""
+			continue
+		}
+		current = append(current, line)
+	}
+
+	// Keep an unterminated block (e.g. a truncated response)
+	if fence != "" && len(current) > 0 {
+		blocks = append(blocks, codeBlock{lang, strings.Join(current, "\n")})
+	}
+	return blocks
+}
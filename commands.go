@@ -0,0 +1,153 @@
+// Package main provides the session command table, help, and command palette.
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sessionCommand describes a slash command for help, completion, and the
+// command palette
+type sessionCommand struct {
+	name     string // canonical name, e.g. "/model"
+	aliases  string // shorter forms shown in help
+	args     string // argument synopsis
+	desc     string
+	needsArg bool // the palette fills in the command for editing instead of running it
+}
+
+// sessionCommandTable lists the session commands in help order
+var sessionCommandTable = []sessionCommand{
+	{name: "/help", aliases: "/h, ?", desc: "Show this help"},
+	{name: "/model", aliases: "/m", args: "[model]", desc: "Switch model (e.g., /model gpt-4o), or pick from a list"},
+	{name: "/clear", aliases: "/c", desc: "Clear conversation history"},
+	{name: "/retry", aliases: "/r", args: "[model]", desc: "Resend a failed message or regenerate the last response (e.g., /retry claude)"},
+	{name: "/diff", desc: "Show what changed between the last /retry answer and the one it replaced"},
+	{name: "/run", args: "[n]", desc: "Run the last answer's python or go block (or block n) in the sandbox and send the output back"},
+	{name: "/write", args: "[on|off]", desc: "Let the model propose files; each is shown as a diff and written once you approve it"},
+	{name: "/allow", args: "[path|glob|dir/|clear]", desc: "List or add paths that /write proposals may write without asking, this session only"},
+	{name: "/undo", aliases: "/u", desc: "Remove the last exchange from history"},
+	{name: "/compare", args: "<model>", desc: "Ask another model the last question, side by side", needsArg: true},
+	{name: "/dual", args: "[model|off]", desc: "Also send every message to a second model"},
+	{name: "/copy", aliases: "/y", args: "[#n] [code [n|last]]", desc: "Copy the last answer or message #n, its code blocks, or one block"},
+	{name: "/file", aliases: "/f", args: "<path|clear>", desc: "Attach a file to your next message", needsArg: true},
+	{name: "/attach", args: "[filter]", desc: "Pick a file to attach from a fuzzy-filtered list"},
+	{name: "/tokens", aliases: "/t", desc: "Show context window usage"},
+	{name: "/stats", desc: "Show tokens, estimated cost, and latency for this session"},
+	{name: "/summarize", desc: "Replace the history with a summary to free context"},
+	{name: "/search", args: "<text>", desc: "Search this and saved sessions", needsArg: true},
+	{name: "/transcript", aliases: "/view", desc: "Page through the conversation (PgUp/PgDn, g/G)"},
+	{name: "/find", args: "<text>", desc: "Open the transcript at the first match (n/N for next/previous)", needsArg: true},
+	{name: "/persona", args: "[name|off]", desc: "Switch persona (system prompt), or list personas"},
+	{name: "/set", args: "[name value]", desc: "Show or change temperature, max_tokens, stream, system"},
+	{name: "/fav", args: "[#n]", desc: "Save the last prompt, or message #n, as a favorite for ask --fav"},
+	{name: "/pin", args: "[#n]", desc: "Bookmark the last answer, or answer #n, for ask pins"},
+	{name: "/tag", args: "[tag|-tag]", desc: "Show, add, or remove tags on this session"},
+	{name: "/save", args: "[name]", desc: "Save this session", needsArg: true},
+	{name: "/load", args: "[name]", desc: "Load a saved session, or pick one from a list"},
+	{name: "/fork", args: "<name>", desc: "Continue in a copy, keeping the original saved", needsArg: true},
+	{name: "/export", args: "<md|json|html> [file]", desc: "Export the transcript", needsArg: true},
+	{name: "/exit", aliases: "/quit, /q", desc: "Exit session"},
+}
+
+// commandNames returns the canonical command names for tab completion
+func commandNames() []string {
+	names := make([]string, len(sessionCommandTable))
+	for i, c := range sessionCommandTable {
+		names[i] = c.name
+	}
+	return names
+}
+
+// usage returns the command with its aliases and arguments, as shown in help
+func (c sessionCommand) usage() string {
+	u := c.name
+	if c.aliases != "" {
+		u += ", " + c.aliases
+	}
+	if c.args != "" {
+		u += " " + c.args
+	}
+	return u
+}
+
+// printHelp lists the session commands and keys
+func printHelp() {
+	width := 0
+	for _, c := range sessionCommandTable {
+		width = max(width, len(c.usage()))
+	}
+
+	fmt.Printf("\n%s", dim)
+	fmt.Println("  Commands:")
+	for _, c := range sessionCommandTable {
+		fmt.Printf("    %-*s  %s\n", width, c.usage(), c.desc)
+	}
+	fmt.Println()
+	fmt.Println("  Type / alone to search commands.")
+	fmt.Println("  End a line with \\ or wrap text in \"\"\" for multi-line messages.")
+	fmt.Println("  Esc or Ctrl+C stops a streaming answer; Ctrl+C twice exits.")
+	fmt.Printf("%s\n", reset)
+}
+
+// filterCommands keeps commands whose name or description contains every
+// word of the filter
+func filterCommands(filter string) []sessionCommand {
+	words := strings.Fields(strings.ToLower(filter))
+	var matched []sessionCommand
+	for _, c := range sessionCommandTable {
+		text := strings.ToLower(c.usage() + " " + c.desc)
+		ok := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// commandPalette shows a filterable list of commands. A command that runs
+// without arguments is returned to be run; one that needs arguments is
+// filled in at the next prompt for editing and "" is returned.
+func (s *Session) commandPalette() string {
+	filter := ""
+	fmt.Println()
+	for {
+		shown := filterCommands(filter)
+		if len(shown) == 0 {
+			fmt.Printf("%s  No commands match '%s'%s\n", dim, filter, reset)
+		}
+		for i, c := range shown {
+			fmt.Printf("  %s%3d.%s %-12s %s%s%s\n", dim, i+1, reset, c.name, dim, c.desc, reset)
+		}
+
+		line, ok := s.readLine(fmt.Sprintf("%s  Number to run, text to filter, Enter to cancel › %s", dim, reset))
+		if !ok {
+			return ""
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return ""
+		}
+		if num, err := strconv.Atoi(line); err == nil {
+			if num < 1 || num > len(shown) {
+				fmt.Printf("%s✗ Pick a number between 1 and %d%s\n", red, len(shown), reset)
+				continue
+			}
+			c := shown[num-1]
+			if c.needsArg {
+				s.rl.WriteStdin([]byte(c.name + " "))
+				return ""
+			}
+			return c.name
+		}
+		filter = line
+		fmt.Println()
+	}
+}
@@ -0,0 +1,187 @@
+// Package main provides tab completion for the session prompt.
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sessionCompleter implements readline.AutoCompleter for session mode
+type sessionCompleter struct {
+	session *Session
+}
+
+// Do returns the possible suffixes for the word under the cursor and the
+// length of that word
+func (c *sessionCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	text := string(line[:pos])
+	if !strings.HasPrefix(text, "/") {
+		return nil, 0
+	}
+
+	space := strings.IndexByte(text, ' ')
+	if space == -1 {
+		return completeWord(text, commandNames())
+	}
+
+	cmd := strings.ToLower(text[:space])
+	arg := strings.TrimLeft(text[space:], " ")
+
+	switch cmd {
+	case "/model", "/m", "/retry", "/r", "/compare", "/dual":
+		if strings.Contains(arg, " ") {
+			return nil, 0
+		}
+		return completeWord(arg, c.session.modelSpecs())
+	case "/load":
+		return completeWord(arg, savedSessionNames())
+	case "/persona":
+		config, err := LoadConfigSafe()
+		if err != nil {
+			return nil, 0
+		}
+		return completeWord(arg, append(personaNames(config), "off"))
+	case "/export":
+		if strings.Contains(arg, " ") {
+			return completePath(arg[strings.LastIndexByte(arg, ' ')+1:])
+		}
+		return completeWord(arg, []string{"md", "json", "html"})
+	case "/set":
+		if strings.Contains(arg, " ") {
+			return nil, 0
+		}
+		return completeWord(arg, []string{"temperature", "max_tokens", "stream", "system"})
+	case "/copy", "/y":
+		if strings.HasPrefix(arg, "code ") {
+			return completeWord(strings.TrimLeft(arg[len("code"):], " "), []string{"last"})
+		}
+		return completeWord(arg, []string{"code"})
+	case "/file", "/f", "/attach":
+		return completePath(arg)
+	}
+	return nil, 0
+}
+
+// completeWord returns the suffixes of candidates that start with prefix
+func completeWord(prefix string, candidates []string) ([][]rune, int) {
+	var out [][]rune
+	for _, cand := range candidates {
+		if strings.HasPrefix(cand, prefix) && cand != prefix {
+			out = append(out, []rune(cand[len(prefix):]+" "))
+		}
+	}
+	return out, len([]rune(prefix))
+}
+
+// completePath completes file system paths, appending "/" to directories
+func completePath(prefix string) ([][]rune, int) {
+	expanded := prefix
+	if strings.HasPrefix(prefix, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			expanded = filepath.Join(homeDir, prefix[2:])
+			if strings.HasSuffix(prefix, "/") {
+				expanded += "/"
+			}
+		}
+	}
+
+	dir, base := filepath.Split(expanded)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil, 0
+	}
+
+	var out [][]rune
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		// Hide dotfiles unless explicitly asked for
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		suffix := name[len(base):]
+		if entry.IsDir() {
+			suffix += "/"
+		} else {
+			suffix += " "
+		}
+		out = append(out, []rune(suffix))
+	}
+	return out, len([]rune(base))
+}
+
+// modelSpecs returns provider/model names for completion, preferring models
+// fetched by the picker and falling back to each provider's built-in list
+func (s *Session) modelSpecs() []string {
+	config, err := LoadConfigSafe()
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var specs []string
+	add := func(spec string) {
+		if !seen[spec] {
+			seen[spec] = true
+			specs = append(specs, spec)
+		}
+	}
+
+	for _, p := range providerInfo {
+		pc, ok := config.Providers[p.name]
+		if !ok || pc.APIKey == "" || isPlaceholderKey(pc.APIKey) {
+			continue
+		}
+		add(p.name)
+
+		s.mu.Lock()
+		models, cached := s.modelCache[p.name]
+		s.mu.Unlock()
+		if !cached {
+			if prov := createProvider(p.name, "", ""); prov != nil {
+				models, _ = prov.ListModels(context.Background())
+			}
+		}
+		for _, m := range models {
+			id := strings.TrimPrefix(m.ID, "models/")
+			add(id)
+			add(p.name + "/" + id)
+		}
+	}
+
+	sort.Strings(specs)
+	return specs
+}
+
+// savedSessionNames returns the names of saved sessions
+func savedSessionNames() []string {
+	sessions, err := listSessions()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(sessions))
+	for _, saved := range sessions {
+		names = append(names, saved.Name)
+	}
+	return names
+}
+
+// personaNames returns the sorted persona names from config
+func personaNames(config *Config) []string {
+	names := make([]string, 0, len(config.Personas))
+	for name := range config.Personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -16,6 +16,47 @@ type Config struct {
 	Default         string                    `yaml:"default,omitempty"` // Alias for default_provider
 	Providers       map[string]ProviderConfig `yaml:"providers"`
 	Profiles        map[string]string         `yaml:"profiles,omitempty"`
+	Context         ContextConfig             `yaml:"context,omitempty"`
+	Notify          NotifyConfig              `yaml:"notify,omitempty"`
+	Personas        map[string]Persona        `yaml:"personas,omitempty"`
+	Theme           string                    `yaml:"theme,omitempty"`     // auto, dark, light, notty, or a glamour style file
+	LogFile         string                    `yaml:"log_file,omitempty"`  // append a JSON line per request here
+	AuditDir        string                    `yaml:"audit_dir,omitempty"` // store each exact request and response here
+	Budget          BudgetConfig              `yaml:"budget,omitempty"`
+	Cache           CacheConfig               `yaml:"cache,omitempty"`
+	Redact          RedactConfig              `yaml:"redact,omitempty"`
+	Moderation      ModerationConfig          `yaml:"moderation,omitempty"`
+	Sync            SyncConfig                `yaml:"sync,omitempty"`
+	Sandbox         SandboxConfig             `yaml:"sandbox,omitempty"`
+	Voice           VoiceConfig               `yaml:"voice,omitempty"`
+
+	HistoryRetentionDays int    `yaml:"history_retention_days,omitempty"` // purge sessions and history older than this
+	CommitModel          string `yaml:"commit_model,omitempty"`           // profile or model for ask commit and ask pr
+	PRTemplate           string `yaml:"pr_template,omitempty"`            // file with the layout ask pr follows
+	OCRLanguages         string `yaml:"ocr_languages,omitempty"`          // tesseract languages for attached images, e.g. eng+deu
+}
+
+// Persona is a named system prompt, optionally tied to a model
+type Persona struct {
+	SystemPrompt string `yaml:"system_prompt"`
+	Model        string `yaml:"model,omitempty"` // optional provider/model spec
+}
+
+// ContextConfig controls what session mode does when history nears the
+// model's context limit
+type ContextConfig struct {
+	Strategy  string  `yaml:"strategy,omitempty"`   // truncate (default), sliding, summarize, or off
+	Threshold float64 `yaml:"threshold,omitempty"`  // fraction of the context window, default 0.8
+	KeepTurns int     `yaml:"keep_turns,omitempty"` // recent exchanges kept by sliding/summarize, default 6
+	Oversize  string  `yaml:"oversize,omitempty"`   // attachments too big to send: map_reduce (default), refine, truncate, or off
+}
+
+// NotifyConfig controls the alert when a long answer finishes in session
+// mode, and the webhooks --notify posts one-shot answers to
+type NotifyConfig struct {
+	Method       string            `yaml:"method,omitempty"`        // bell (default), desktop, both, or off
+	AfterSeconds int               `yaml:"after_seconds,omitempty"` // only for answers slower than this, default 30
+	Webhooks     map[string]string `yaml:"webhooks,omitempty"`      // name: Slack or Discord incoming webhook URL
 }
 
 type ProviderConfig struct {
@@ -84,6 +125,15 @@ func LoadConfig() (*Config, error) {
 	return &config, nil
 }
 
+// configDir returns ~/.config/ask, where config, sessions, and history live
+func configDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".config", "ask"), nil
+}
+
 // ConfigNotFoundError indicates config file doesn't exist
 type ConfigNotFoundError struct{}
 
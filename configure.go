@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -182,7 +183,7 @@ func runConfigureWizard(singleProvider string) error {
 		if finalKey != "" && !isPlaceholderKey(finalKey) {
 			prov := createProvider(p.name, finalKey, "")
 			if prov != nil {
-				models, err := prov.ListModels()
+				models, err := listModelsCached(context.Background(), p.name, finalKey, prov, false)
 				if err == nil && len(models) > 0 {
 					fmt.Println("\n    Available models:")
 					for i, model := range models {
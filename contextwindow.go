@@ -0,0 +1,174 @@
+// Package main provides automatic context window management for sessions.
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// Context management defaults
+const (
+	defaultContextThreshold = 0.8
+	defaultKeepTurns        = 6
+)
+
+// contextSettings returns the context config with defaults applied
+func contextSettings(cfg ContextConfig) ContextConfig {
+	cfg.Strategy = strings.ToLower(cfg.Strategy)
+	if cfg.Strategy == "" {
+		cfg.Strategy = "truncate"
+	}
+	if cfg.Threshold <= 0 || cfg.Threshold > 1 {
+		cfg.Threshold = defaultContextThreshold
+	}
+	if cfg.KeepTurns <= 0 {
+		cfg.KeepTurns = defaultKeepTurns
+	}
+	cfg.Oversize = strings.ReplaceAll(strings.ToLower(cfg.Oversize), "-", "_")
+	if cfg.Oversize == "" || cfg.Oversize == "mapreduce" {
+		cfg.Oversize = "map_reduce"
+	}
+	return cfg
+}
+
+// historyTokens estimates the tokens used by the conversation history
+func historyTokens(messages []SessionMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += estimateTokens(msg.Content)
+	}
+	return total
+}
+
+// turnBoundary returns the index of the first user message at or after i,
+// so that cuts never separate a question from its answer
+func turnBoundary(messages []SessionMessage, i int) int {
+	for i < len(messages) && messages[i].Role != "user" {
+		i++
+	}
+	return i
+}
+
+// keepRecentTurns returns the index from which the last n exchanges start
+func keepRecentTurns(messages []SessionMessage, n int) int {
+	seen := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			seen++
+			if seen > n {
+				return turnBoundary(messages, i+1)
+			}
+		}
+	}
+	return 0
+}
+
+// manageContext shrinks the history when it approaches the model's context
+// limit, using the configured strategy. The last user message is always kept.
+func (s *Session) manageContext(p provider.Provider, modelName string) {
+	cfg := contextSettings(s.contextConfig)
+	if cfg.Strategy == "off" || cfg.Strategy == "none" {
+		return
+	}
+
+	limit := int(float64(contextWindow(modelName)) * cfg.Threshold)
+
+	s.mu.Lock()
+	before := historyTokens(s.messages)
+	count := len(s.messages)
+	s.mu.Unlock()
+	if before < limit || count < 2 {
+		return
+	}
+
+	switch cfg.Strategy {
+	case "summarize":
+		s.mu.Lock()
+		cut := keepRecentTurns(s.messages, cfg.KeepTurns)
+		if cut == 0 {
+			cut = turnBoundary(s.messages, 1)
+		}
+		s.mu.Unlock()
+		if _, err := s.summarizeHistory(p, cut); err != nil {
+			fmt.Printf("%s! Could not summarize history (%v), dropping old messages instead%s\n", yellow, err, reset)
+		}
+	case "sliding":
+		s.mu.Lock()
+		s.messages = s.messages[keepRecentTurns(s.messages, cfg.KeepTurns):]
+		s.mu.Unlock()
+	}
+
+	// Truncate whatever is still over the limit, oldest exchange first
+	s.mu.Lock()
+	for historyTokens(s.messages) >= limit {
+		cut := turnBoundary(s.messages, 1)
+		if cut >= len(s.messages) {
+			break // only the current question is left
+		}
+		s.messages = s.messages[cut:]
+	}
+	after := historyTokens(s.messages)
+	dropped := count - len(s.messages)
+	s.mu.Unlock()
+
+	if after < before {
+		fmt.Printf("%s↺ Context nearly full: %s (~%s → ~%s tokens)%s\n", dim,
+			contextActionLabel(cfg.Strategy, dropped), formatTokenCount(before), formatTokenCount(after), reset)
+	}
+}
+
+func contextActionLabel(strategy string, dropped int) string {
+	if strategy == "summarize" {
+		return "summarized older messages"
+	}
+	return fmt.Sprintf("dropped %d old messages", dropped)
+}
+
+// summaryPrompt asks the model to condense a transcript
+const summaryPrompt = `Summarize the following conversation so it can replace the original messages as context for continuing it. Keep key facts, decisions, code snippets, names, and open questions. Be concise and write the summary only.
+
+`
+
+// summarizeHistory replaces messages[:cut] with a model-written summary and
+// returns the number of tokens reclaimed
+func (s *Session) summarizeHistory(p provider.Provider, cut int) (int, error) {
+	s.mu.Lock()
+	if cut <= 0 || cut > len(s.messages) {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("nothing to summarize")
+	}
+	old := append([]SessionMessage{}, s.messages[:cut]...)
+	s.mu.Unlock()
+
+	var transcript strings.Builder
+	transcript.WriteString(summaryPrompt)
+	for _, msg := range old {
+		if msg.Role == "system" {
+			continue // a persona note
+		}
+		role := "User"
+		if msg.Role == "assistant" {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", role, msg.Content)
+	}
+
+	summary, err := s.queryWithSpinner(p, s.providerName+"/"+s.modelName, "Thinking...", []provider.Message{{Role: "user", Content: transcript.String()}})
+	if err != nil {
+		return 0, err
+	}
+
+	// Providers require alternating roles, so the summary is stored as an
+	// acknowledged user message
+	replacement := []SessionMessage{
+		{Role: "user", Content: "Summary of our conversation so far:\n\n" + strings.TrimSpace(summary), Time: old[0].Time},
+		{Role: "assistant", Content: "Got it, I'll continue from that summary.", Model: old[len(old)-1].Model, Time: old[len(old)-1].Time},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(replacement, s.messages[cut:]...)
+	return historyTokens(old) - historyTokens(replacement), nil
+}
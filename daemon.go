@@ -0,0 +1,458 @@
+// Package main provides `ask daemon`, which keeps the config loaded and
+// provider connections open so one-shot prompts start without delay.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// daemonCommands are the actions of `ask daemon`; none starts it
+var daemonCommands = map[string]bool{"": true, "stop": true, "status": true}
+
+// isDaemonCommand reports whether the arguments invoke `ask daemon`
+func isDaemonCommand(args []string) bool {
+	if len(args) == 0 || args[0] != "daemon" {
+		return false
+	}
+	return len(args) == 1 || (len(args) == 2 && daemonCommands[args[1]])
+}
+
+// daemonSocketPath returns the location of the daemon's unix socket
+func daemonSocketPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.sock"), nil
+}
+
+// daemonRequest is a one-shot prompt sent to the daemon, with the flags
+// that affect it
+type daemonRequest struct {
+	Prompt       string `json:"prompt,omitempty"`
+	Provider     string `json:"provider,omitempty"`
+	Model        string `json:"model,omitempty"`
+	Profile      string `json:"profile,omitempty"`
+	NoCache      bool   `json:"no_cache,omitempty"`
+	Force        bool   `json:"force,omitempty"`
+	AllowSecrets bool   `json:"allow_secrets,omitempty"` // send despite redact mode block
+	Stop         bool   `json:"stop,omitempty"`
+	Status       bool   `json:"status,omitempty"`
+}
+
+// daemonReply is one line of the daemon's answer: streamed text, a notice
+// to show on stderr, and finally done or an error
+type daemonReply struct {
+	Text     string `json:"text,omitempty"`
+	Notice   string `json:"notice,omitempty"`
+	Secrets  string `json:"secrets,omitempty"` // found in block mode; ask, then resend
+	Error    string `json:"error,omitempty"`
+	Done     bool   `json:"done,omitempty"`
+	Spec     string `json:"spec,omitempty"`
+	Theme    string `json:"theme,omitempty"`
+	CachedAt int64  `json:"cached_at,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// daemon answers requests with a config it reloads when the file changes,
+// reusing providers so their connections stay open
+type daemon struct {
+	mu          sync.Mutex
+	config      *Config
+	configStamp time.Time
+	providers   map[string]provider.Provider
+	started     time.Time
+	served      int
+	stop        func()
+}
+
+// runDaemonCommand starts the daemon, or stops it or reports on it
+func runDaemonCommand(args []string) error {
+	path, err := daemonSocketPath()
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		var reply *daemonReply
+		err := dialDaemon(context.Background(), path, daemonRequest{Stop: args[0] == "stop", Status: args[0] == "status"}, func(r *daemonReply) { reply = r })
+		if errors.Is(err, errNoDaemon) {
+			fmt.Println("The daemon is not running")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if reply != nil && reply.Status != "" {
+			fmt.Println(reply.Status)
+		}
+		return nil
+	}
+
+	// Config lookup starts in the working directory; use the one clients use
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	d := &daemon{started: time.Now(), providers: map[string]provider.Provider{}}
+	if _, err := d.loadConfig(); err != nil {
+		return err
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("a daemon is already running on %s", path)
+	}
+	os.Remove(path) // left by a daemon that didn't exit cleanly
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	d.stop = stop
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	fmt.Fprintf(os.Stderr, "ask daemon listening on %s %s(Ctrl-C to stop)%s\n", path, dim, reset)
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.handle(conn)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// loadConfig returns the config, loading it again when config.yaml has
+// changed since the last request
+func (d *daemon) loadConfig() (*Config, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	info, err := os.Stat("config.yaml")
+	if err != nil {
+		return nil, &ConfigNotFoundError{}
+	}
+	if d.config != nil && info.ModTime().Equal(d.configStamp) {
+		return d.config, nil
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	// These replace the process-wide settings atomically, so requests still
+	// running finish with the old ones
+	if err := openScreening(config); err != nil {
+		return nil, err
+	}
+	openRequestLog(config)
+	openAudit(config)
+	d.config, d.configStamp = config, info.ModTime()
+	clear(d.providers)
+	return config, nil
+}
+
+// provider returns the provider for a model, creating it on first use
+func (d *daemon) provider(name, apiKey, model string) provider.Provider {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := name + "/" + model
+	if p, ok := d.providers[key]; ok {
+		return p
+	}
+	p := createProvider(name, apiKey, model)
+	if p != nil {
+		d.providers[key] = p
+	}
+	return p
+}
+
+// daemonWriter sends streamed text to the client
+type daemonWriter struct {
+	enc *json.Encoder
+	buf []byte
+}
+
+func (w *daemonWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), w.enc.Encode(daemonReply{Text: string(p)})
+}
+
+// handle answers one request
+func (d *daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	var req daemonRequest
+	reader := bufio.NewReader(conn)
+	if err := json.NewDecoder(reader).Decode(&req); err != nil {
+		return
+	}
+	enc := json.NewEncoder(conn)
+
+	switch {
+	case req.Stop:
+		enc.Encode(daemonReply{Status: "Stopped the daemon", Done: true})
+		d.stop()
+		return
+	case req.Status:
+		d.mu.Lock()
+		status := fmt.Sprintf("Running since %s (pid %d), %d prompts answered", d.started.Format("2006-01-02 15:04"), os.Getpid(), d.served)
+		d.mu.Unlock()
+		enc.Encode(daemonReply{Status: status, Done: true})
+		return
+	}
+
+	// The client closing the connection cancels the request
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		io.Copy(io.Discard, reader)
+		cancel()
+	}()
+	if err := d.answer(ctx, &req, enc); err != nil {
+		enc.Encode(daemonReply{Error: err.Error()})
+	}
+}
+
+// answer handles a one-shot prompt the way ask does without a daemon
+func (d *daemon) answer(ctx context.Context, req *daemonRequest, enc *json.Encoder) error {
+	config, err := d.loadConfig()
+	if err != nil {
+		return err
+	}
+	providerName, modelName, err := ResolveModelAndProvider(req.Provider, req.Model, req.Profile, config)
+	if err != nil {
+		return err
+	}
+	pc, ok := config.Providers[providerName]
+	if !ok {
+		return fmt.Errorf("provider '%s' not found in config (available: %s)", providerName, getConfiguredProviders(config))
+	}
+	if isPlaceholderKey(pc.APIKey) {
+		return fmt.Errorf("API key not configured for '%s'", providerName)
+	}
+	if modelName == "" {
+		modelName = pc.Model
+	}
+	if modelName == "" {
+		modelName = defaultModels[providerName]
+	}
+	p := d.provider(providerName, pc.APIKey, modelName)
+	if p == nil {
+		return fmt.Errorf("unknown provider: %s", providerName)
+	}
+
+	prompt := req.Prompt
+	if scanner := outboundSecrets.Load(); scanner != nil {
+		if masked, found := scanner.scan(prompt); found != "" {
+			if scanner.mask {
+				enc.Encode(daemonReply{Notice: "⚠ Masked before sending: " + found})
+				prompt = masked
+			} else if !req.AllowSecrets {
+				return enc.Encode(daemonReply{Secrets: found})
+			}
+		}
+	}
+	if notice, ok := outboundModeration.Load().verdict(prompt); !ok {
+		return fmt.Errorf("%s", notice)
+	} else if notice != "" {
+		enc.Encode(daemonReply{Notice: "⚠ " + notice})
+	}
+	spec := providerName + "/" + modelName
+	msgs := []provider.Message{{Role: "user", Content: prompt}}
+
+	var key string
+	if config.Cache.Enabled && !req.NoCache {
+		key = cacheKey(providerName, modelName, provider.Options{}, msgs)
+		if response, cachedAt, ok := cachedAnswer(key, config.Cache.ttl()); ok {
+			countEvent("oneshot:cached")
+			enc.Encode(daemonReply{Text: response})
+			return enc.Encode(daemonReply{Done: true, Spec: spec, Theme: config.Theme, CachedAt: toMillis(cachedAt)})
+		}
+	}
+
+	level, summary := budgetStatus(config.Budget)
+	if budgetBlocked(config.Budget, level, req.Force) {
+		return fmt.Errorf("monthly budget exceeded: %s. Use --force to send anyway", summary)
+	}
+	if level == budgetExceeded {
+		enc.Encode(daemonReply{Notice: "⚠ Budget exceeded: " + summary})
+	} else if level == budgetWarning {
+		enc.Encode(daemonReply{Notice: "⚠ Budget warning: " + summary})
+	}
+
+	countEvent("oneshot:daemon")
+	out := &daemonWriter{enc: enc}
+	start := time.Now()
+	if err := p.QueryStream(ctx, prompt, out); err != nil {
+		countError(err)
+		if ctx.Err() != nil && len(out.buf) > 0 {
+			latency := time.Since(start)
+			savePartialHistory(providerName, modelName, prompt, string(out.buf), latency)
+			saveUsage("", newTurnUsage(spec, msgs, string(out.buf), latency))
+		}
+		return fmt.Errorf("error querying %s: %v", providerName, err)
+	}
+	response := string(out.buf)
+	latency := time.Since(start)
+	saveHistory(providerName, modelName, prompt, response, latency)
+	saveUsage("", newTurnUsage(spec, msgs, response, latency))
+	if key != "" && response != "" {
+		storeAnswer(key, response, config.Cache.ttl())
+	}
+	d.mu.Lock()
+	d.served++
+	d.mu.Unlock()
+	return enc.Encode(daemonReply{Done: true, Spec: spec, Theme: config.Theme})
+}
+
+// errNoDaemon means no daemon is listening
+var errNoDaemon = errors.New("no daemon running")
+
+// dialDaemon sends a request and calls onReply for each line of the
+// answer, returning errNoDaemon when nothing listens on the socket
+func dialDaemon(ctx context.Context, path string, req daemonRequest, onReply func(*daemonReply)) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return errNoDaemon
+	}
+	defer conn.Close()
+	// Closing the connection tells the daemon to stop the request
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return errNoDaemon
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var reply daemonReply
+		if err := dec.Decode(&reply); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == io.EOF {
+				return fmt.Errorf("the daemon closed the connection")
+			}
+			return err
+		}
+		if reply.Error != "" {
+			return errors.New(reply.Error)
+		}
+		onReply(&reply)
+		if reply.Done || reply.Secrets != "" {
+			return nil
+		}
+	}
+}
+
+// oneShotViaDaemon sends a one-shot prompt to a running daemon and prints
+// the answer. It reports false, having done nothing, when no daemon is
+// running or a config.yaml in the working directory would be missed.
+func oneShotViaDaemon(req daemonRequest, timing bool) (bool, error) {
+	if _, err := os.Stat("config.yaml"); err == nil {
+		return false, nil
+	}
+	path, err := daemonSocketPath()
+	if err != nil {
+		return false, nil
+	}
+
+	// Ctrl+C stops the answer but keeps what arrived
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	for {
+		var firstToken time.Time
+		var response []byte
+		var done *daemonReply
+		var secrets string
+		start := time.Now()
+		err := dialDaemon(ctx, path, req, func(r *daemonReply) {
+			switch {
+			case r.Text != "":
+				if firstToken.IsZero() {
+					firstToken = time.Now()
+				}
+				response = append(response, r.Text...)
+			case r.Notice != "":
+				fmt.Fprintf(os.Stderr, "%s%s%s\n", yellow, r.Notice, reset)
+			case r.Secrets != "":
+				secrets = r.Secrets
+			case r.Done:
+				done = r
+			}
+		})
+		if errors.Is(err, errNoDaemon) {
+			return false, nil
+		}
+		if ctx.Err() != nil {
+			// The daemon saves what it sent as a partial answer
+			if len(response) > 0 {
+				if err := renderMarkdown(string(response)); err != nil {
+					fmt.Println(string(response))
+				}
+				fmt.Fprintf(os.Stderr, "%s⏹ Interrupted; the partial answer is saved in history%s\n", yellow, reset)
+			} else {
+				fmt.Fprintf(os.Stderr, "\n%s⏹ Interrupted%s\n", yellow, reset)
+			}
+			os.Exit(130)
+		}
+		if err != nil {
+			return true, err
+		}
+		if secrets != "" {
+			fmt.Fprintf(os.Stderr, "%s%s⚠ This looks like it contains secrets: %s%s\n", bold, yellow, secrets, reset)
+			if !confirmStdin(fmt.Sprintf("%sSend it anyway? [y/N]: %s", yellow, reset)) {
+				return true, fmt.Errorf("not sent")
+			}
+			req.AllowSecrets = true
+			continue
+		}
+
+		end := time.Now()
+		markdownTheme = done.Theme
+		if err := renderMarkdown(string(response)); err != nil {
+			fmt.Println(string(response))
+		}
+		if timing {
+			if done.CachedAt != 0 {
+				fmt.Fprintf(os.Stderr, "%s%s · cached %s ago%s\n", dim, done.Spec, time.Since(fromMillis(done.CachedAt)).Round(time.Second), reset)
+			} else {
+				printTiming(done.Spec, string(response), start, firstToken, end)
+			}
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,215 @@
+// Package main provides /diff, a word-level comparison of a retried answer
+// with the one it replaced, and the line diffs shown for proposed edits.
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// retriedAnswer is the answer a successful /retry replaced
+type retriedAnswer struct {
+	previous SessionMessage
+	index    int // position of the new answer in the history
+}
+
+// diffOp is a run of text that is in both answers, only the old one (-1),
+// or only the new one (+1)
+type diffOp struct {
+	kind int
+	text string
+}
+
+// diffTokens splits text into words and the whitespace between them
+var diffTokens = regexp.MustCompile(`\S+|\s+`)
+
+// maxDiffCells bounds the comparison table; larger answers are compared
+// line by line instead of word by word
+const maxDiffCells = 4_000_000
+
+// wordDiff returns the edits that turn a into b, by word, or by line for
+// very long texts
+func wordDiff(a, b string) []diffOp {
+	x, y := diffTokens.FindAllString(a, -1), diffTokens.FindAllString(b, -1)
+	if len(x)*len(y) > maxDiffCells {
+		x, y = strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n")
+	}
+	return diffSeq(x, y)
+}
+
+// lineDiff returns the edits that turn a into b, by line
+func lineDiff(a, b string) []diffOp {
+	return diffSeq(strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n"))
+}
+
+// diffSeq returns the edits that turn the tokens x into y
+func diffSeq(x, y []string) []diffOp {
+	// Common prefix and suffix need no table
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	mx, my := x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]
+
+	// lcs[i][j] is the longest common subsequence of mx[i:] and my[j:]
+	lcs := make([][]int32, len(mx)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(my)+1)
+	}
+	for i := len(mx) - 1; i >= 0; i-- {
+		for j := len(my) - 1; j >= 0; j-- {
+			if mx[i] == my[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	add := func(kind int, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(ops); n > 0 && ops[n-1].kind == kind {
+			ops[n-1].text += text
+			return
+		}
+		ops = append(ops, diffOp{kind, text})
+	}
+	add(0, strings.Join(x[:prefix], ""))
+	i, j := 0, 0
+	for i < len(mx) || j < len(my) {
+		switch {
+		case i < len(mx) && j < len(my) && mx[i] == my[j]:
+			add(0, mx[i])
+			i++
+			j++
+		case i < len(mx) && (j == len(my) || lcs[i+1][j] >= lcs[i][j+1]):
+			add(-1, mx[i])
+			i++
+		default:
+			add(1, my[j])
+			j++
+		}
+	}
+	add(0, strings.Join(x[len(x)-suffix:], ""))
+	return ops
+}
+
+// formatDiff colors removed text red and struck through, and added text green
+func formatDiff(ops []diffOp) string {
+	var b strings.Builder
+	for _, op := range ops {
+		switch op.kind {
+		case -1:
+			b.WriteString(red + strike + op.text + reset)
+		case 1:
+			b.WriteString(green + op.text + reset)
+		default:
+			b.WriteString(op.text)
+		}
+	}
+	return b.String()
+}
+
+// diffContextLines is how many unchanged lines formatLineDiff keeps around
+// each change
+const diffContextLines = 3
+
+// formatLineDiff shows a line diff with - and + markers in red and green,
+// eliding unchanged lines away from the changes
+func formatLineDiff(ops []diffOp) string {
+	type line struct {
+		kind int
+		text string
+	}
+	var lines []line
+	for _, op := range ops {
+		for _, l := range strings.SplitAfter(op.text, "\n") {
+			if l != "" {
+				lines = append(lines, line{op.kind, strings.TrimSuffix(l, "\n")})
+			}
+		}
+	}
+	near := make([]bool, len(lines))
+	for i, l := range lines {
+		if l.kind != 0 {
+			for j := max(0, i-diffContextLines); j <= min(len(lines)-1, i+diffContextLines); j++ {
+				near[j] = true
+			}
+		}
+	}
+
+	var b strings.Builder
+	skipped := false
+	for i, l := range lines {
+		if !near[i] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			b.WriteString(dim + "  …" + reset + "\n")
+			skipped = false
+		}
+		switch l.kind {
+		case -1:
+			b.WriteString(red + "- " + l.text + reset + "\n")
+		case 1:
+			b.WriteString(green + "+ " + l.text + reset + "\n")
+		default:
+			b.WriteString(dim + "  " + l.text + reset + "\n")
+		}
+	}
+	if skipped {
+		b.WriteString(dim + "  …" + reset + "\n")
+	}
+	return b.String()
+}
+
+// diffCommand shows how the last answer differs from the one /retry replaced
+func (s *Session) diffCommand() {
+	s.mu.Lock()
+	retried := s.retried
+	var current SessionMessage
+	if retried != nil && retried.index < len(s.messages) {
+		current = s.messages[retried.index]
+	}
+	last := len(s.messages) - 1
+	s.mu.Unlock()
+
+	if retried == nil || retried.index != last || current.Role != "assistant" {
+		fmt.Printf("\n%sNothing to compare. /diff works right after /retry regenerates an answer.%s\n", dim, reset)
+		return
+	}
+
+	ops := wordDiff(retried.previous.Content, current.Content)
+	removed, added := 0, 0
+	for _, op := range ops {
+		switch op.kind {
+		case -1:
+			removed += len(strings.Fields(op.text))
+		case 1:
+			added += len(strings.Fields(op.text))
+		}
+	}
+	if removed == 0 && added == 0 {
+		fmt.Printf("\n%sThe answers are the same%s\n", dim, reset)
+		return
+	}
+
+	from, to := retried.previous.Model, current.Model
+	if from == "" {
+		from = "previous"
+	}
+	if to == "" {
+		to = "retry"
+	}
+	fmt.Printf("\n%s%s%s → %s%s%s  %s-%d +%d words%s\n\n", red, from, reset, green, to, reset, dim, removed, added, reset)
+	fmt.Println(strings.TrimRight(formatDiff(ops), "\n"))
+}
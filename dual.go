@@ -0,0 +1,107 @@
+// Package main provides dual-model mode, where each message is also sent to
+// a second model for side-by-side comparison.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// dualRun is a request to the second model running alongside the main one
+type dualRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	out    liveWriter
+	text   string
+	err    error
+	took   time.Duration
+}
+
+// startDual sends msgs to the second model in the background
+func (s *Session) startDual(msgs []provider.Message) *dualRun {
+	s.applyOptions(s.dualProvider)
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &dualRun{cancel: cancel, done: make(chan struct{}), out: liveWriter{quiet: true}}
+
+	go func() {
+		defer close(run.done)
+		start := time.Now()
+		run.err = s.dualProvider.QueryStreamWithHistory(ctx, msgs, &run.out)
+		run.text, run.took = run.out.buf.String(), time.Since(start)
+		if ctx.Err() != nil {
+			run.err = context.Canceled
+		}
+	}()
+	return run
+}
+
+// finishDual waits for the second model and shows its answer below the
+// main one. It is abandoned if the main request was cancelled.
+func (s *Session) finishDual(run *dualRun, msgs []provider.Message, mainErr error) {
+	if errors.Is(mainErr, context.Canceled) {
+		run.cancel()
+		<-run.done
+		return
+	}
+
+	ctx, done := s.beginRequest()
+	stop := startSpinner(fmt.Sprintf("Waiting for %s...", s.dualSpec), "(Esc to skip)", &run.out.received)
+	select {
+	case <-run.done:
+	case <-ctx.Done():
+		run.cancel()
+		<-run.done
+	}
+	stop()
+	done()
+
+	if run.text != "" {
+		s.recordUsage(s.dualSpec, msgs, run.text, run.took)
+	}
+	fmt.Printf("\n%s%s[B] %s › %s\n", bold, magenta, s.dualSpec, reset)
+	if run.err != nil {
+		if run.text != "" {
+			fmt.Println(run.text)
+		}
+		if errors.Is(run.err, context.Canceled) {
+			fmt.Printf("%s⏹ Skipped%s\n", yellow, reset)
+		} else {
+			fmt.Printf("%s✗ Error: %v%s\n", red, run.err, reset)
+		}
+		return
+	}
+	renderMarkdownToTerminal(run.text)
+	printReplyStatus(s.dualSpec, estimateTokens(run.text), run.took, 0)
+}
+
+// setDual turns dual-model mode on for spec, or off
+func (s *Session) setDual(spec string) {
+	switch strings.ToLower(spec) {
+	case "":
+		if s.dualProvider == nil {
+			fmt.Printf("\n%sDual mode is off. Use /dual <model> to also ask a second model.%s\n", dim, reset)
+		} else {
+			fmt.Printf("\n%sDual mode: each message also goes to %s (/dual off to stop)%s\n", dim, s.dualSpec, reset)
+		}
+		return
+	case "off":
+		s.dualProvider, s.dualSpec = nil, ""
+		fmt.Printf("\n%s✓ Dual mode off%s\n", dim, reset)
+		return
+	}
+
+	providerName, modelName := s.resolveModelSpec(spec)
+	p, modelName, err := newSessionProvider(providerName, modelName)
+	if err != nil {
+		fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+		return
+	}
+	s.dualProvider, s.dualSpec = p, providerName+"/"+modelName
+	fmt.Printf("\n%s✓ Dual mode on: messages also go to %s, shown as [B] below each answer%s\n", green, s.dualSpec, reset)
+	fmt.Printf("%s  Only the main model's answers are kept in the conversation%s\n", dim, reset)
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+// watchEscape is unsupported on this platform; Ctrl+C still cancels requests
+func watchEscape(onEscape func()) func() {
+	return func() {}
+}
@@ -0,0 +1,61 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchEscape calls onEscape when Esc is pressed until the returned stop
+// function is called. The terminal is switched to unbuffered input without
+// echo meanwhile; Ctrl+C still raises SIGINT.
+func watchEscape(onEscape func()) func() {
+	fd := int(os.Stdin.Fd())
+	saved, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return func() {} // not a terminal
+	}
+
+	raw := *saved
+	raw.Lflag &^= unix.ICANON | unix.ECHO
+	raw.Cc[unix.VMIN] = 0
+	raw.Cc[unix.VTIME] = 1 // reads time out after 100ms so stop can't hang
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &raw); err != nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 16)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			n, err := unix.Read(fd, buf)
+			if err != nil && err != unix.EINTR {
+				return
+			}
+			// A lone ESC byte; arrow and function keys arrive as longer sequences
+			if n == 1 && buf[0] == 0x1b {
+				onEscape()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			unix.IoctlSetTermios(fd, ioctlSetTermios, saved)
+		})
+	}
+}
@@ -0,0 +1,446 @@
+// Package main provides `ask eval`, which runs a suite of prompts against
+// models and checks each answer, for regression testing prompts.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isEvalCommand reports whether the arguments invoke `ask eval`. Only a flag
+// or an existing suite file may follow, so "ask eval this expression" stays
+// an ordinary prompt.
+func isEvalCommand(args []string) bool {
+	if len(args) < 2 || args[0] != "eval" {
+		return false
+	}
+	if strings.HasPrefix(args[1], "-") {
+		return true
+	}
+	info, err := os.Stat(args[1])
+	return err == nil && info.Mode().IsRegular()
+}
+
+// defaultEvalJobs is how many requests run at once
+const defaultEvalJobs = 4
+
+// evalSuite is the YAML file `ask eval` runs
+type evalSuite struct {
+	Models      []string   `yaml:"models"`
+	Judge       string     `yaml:"judge,omitempty"`  // grades rubrics; default the first model
+	System      string     `yaml:"system,omitempty"` // system prompt for every case
+	Concurrency int        `yaml:"concurrency,omitempty"`
+	Cases       []evalCase `yaml:"cases"`
+}
+
+// evalCase is one prompt and what its answer must satisfy
+type evalCase struct {
+	Name       string     `yaml:"name"`
+	Prompt     string     `yaml:"prompt,omitempty"`
+	PromptFile string     `yaml:"prompt_file,omitempty"` // relative to the suite
+	Models     []string   `yaml:"models,omitempty"`      // instead of the suite's
+	Expect     evalExpect `yaml:"expect"`
+}
+
+// evalExpect lists the checks; an answer passes when all of them do
+type evalExpect struct {
+	Contains    stringList `yaml:"contains,omitempty"`
+	NotContains stringList `yaml:"not_contains,omitempty"`
+	Regex       stringList `yaml:"regex,omitempty"`
+	Rubric      string     `yaml:"rubric,omitempty"` // graded by the judge model
+	IgnoreCase  bool       `yaml:"ignore_case,omitempty"`
+}
+
+// stringList accepts a single string or a list of them
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = stringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// evalResult is the outcome of one case on one model
+type evalResult struct {
+	Case      string   `json:"case"`
+	Model     string   `json:"model"`
+	Pass      bool     `json:"pass"`
+	Failures  []string `json:"failures,omitempty"`
+	Response  string   `json:"response"`
+	LatencyMS int64    `json:"latency_ms"`
+}
+
+// evalJudgePrompt asks the judge to grade an answer against a rubric
+const evalJudgePrompt = `You are grading an AI assistant's answer against a rubric. Be strict: pass
+only if the answer meets every point of the rubric.
+
+Prompt:
+%s
+
+Answer:
+%s
+
+Rubric:
+%s
+
+Reply with JSON only: {"pass": true or false, "reason": "one sentence"}`
+
+// loadEvalSuite reads and checks a suite file
+func loadEvalSuite(path string) (*evalSuite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var suite evalSuite
+	if err := yaml.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(suite.Cases) == 0 {
+		return nil, fmt.Errorf("%s has no cases", path)
+	}
+	names := map[string]bool{}
+	for i := range suite.Cases {
+		c := &suite.Cases[i]
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("case %d", i+1)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("%s: two cases are named '%s'", path, c.Name)
+		}
+		names[c.Name] = true
+		if c.PromptFile != "" {
+			if c.Prompt != "" {
+				return nil, fmt.Errorf("%s: give '%s' either prompt or prompt_file", path, c.Name)
+			}
+			file := c.PromptFile
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(path), file)
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", c.Name, err)
+			}
+			c.Prompt = string(data)
+		}
+		if strings.TrimSpace(c.Prompt) == "" {
+			return nil, fmt.Errorf("%s: '%s' has no prompt", path, c.Name)
+		}
+		for _, re := range c.Expect.Regex {
+			if _, err := regexp.Compile(re); err != nil {
+				return nil, fmt.Errorf("%s: '%s' has a bad regex: %v", path, c.Name, err)
+			}
+		}
+		if len(c.Models) == 0 && len(suite.Models) == 0 {
+			return nil, fmt.Errorf("%s: '%s' has no models to run on; list them under models", path, c.Name)
+		}
+	}
+	return &suite, nil
+}
+
+// runEvalCommand runs a suite and prints the report. It fails when any
+// case does, so scripts and CI can gate on it.
+func runEvalCommand(args []string) error {
+	var models string
+	jobs := 0
+	asJSON := false
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	fs.StringVar(&models, "models", "", "Comma-separated models to run every case on, instead of the suite's")
+	fs.IntVar(&jobs, "j", 0, "Requests to run at once (default: the suite's concurrency, or 4)")
+	fs.BoolVar(&asJSON, "json", false, "Print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ask eval [--models a,b] [-j 4] [--json] suite.yaml")
+	}
+	suite, err := loadEvalSuite(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if models != "" {
+		suite.Models = strings.Split(models, ",")
+		for i := range suite.Cases {
+			suite.Cases[i].Models = nil
+		}
+	}
+	if jobs <= 0 {
+		jobs = suite.Concurrency
+	}
+	if jobs <= 0 {
+		jobs = defaultEvalJobs
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		return err
+	}
+	if _, err := checkBudget(config.Budget, budgetOK); err != nil {
+		return err
+	}
+
+	// Resolve every model up front, so a typo fails before anything is sent
+	specs := map[string]string{}
+	resolve := func(m string) error {
+		m = strings.TrimSpace(m)
+		if _, ok := specs[m]; ok {
+			return nil
+		}
+		providerName, modelName, err := ResolveModelAndProvider("", m, "", config)
+		if err != nil {
+			return err
+		}
+		if _, modelName, err = newSessionProvider(providerName, modelName); err != nil {
+			return err
+		}
+		specs[m] = providerName + "/" + modelName
+		return nil
+	}
+	type evalJob struct {
+		c     *evalCase
+		model string
+	}
+	var work []evalJob
+	needJudge := false
+	for i := range suite.Cases {
+		c := &suite.Cases[i]
+		prompt, ok := screenOutgoing(c.Prompt, confirmStdin)
+		if !ok {
+			return fmt.Errorf("not sent: '%s' was withheld by the secret or moderation check", c.Name)
+		}
+		c.Prompt = prompt
+		caseModels := c.Models
+		if len(caseModels) == 0 {
+			caseModels = suite.Models
+		}
+		for _, m := range caseModels {
+			if err := resolve(m); err != nil {
+				return err
+			}
+			work = append(work, evalJob{c, specs[strings.TrimSpace(m)]})
+		}
+		needJudge = needJudge || c.Expect.Rubric != ""
+	}
+	judge := suite.Judge
+	if judge == "" && len(suite.Models) > 0 {
+		judge = suite.Models[0]
+	}
+	if needJudge {
+		if judge == "" {
+			return fmt.Errorf("rubric checks need a judge model; set judge in the suite")
+		}
+		if err := resolve(judge); err != nil {
+			return err
+		}
+		judge = specs[strings.TrimSpace(judge)]
+	}
+
+	// Ctrl+C stops the suite and reports what finished
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results := make([]*evalResult, len(work))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, jobs)
+	finished := 0
+	for i, j := range work {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+			r := runEvalCase(ctx, j.c, j.model, suite.System, judge)
+			if ctx.Err() != nil {
+				return
+			}
+			mu.Lock()
+			results[i] = r
+			finished++
+			if !asJSON {
+				fmt.Fprintf(os.Stderr, "\r%s%d/%d done…%s\033[K", dim, finished, len(work), reset)
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if !asJSON {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+
+	var done []*evalResult
+	for _, r := range results {
+		if r != nil {
+			done = append(done, r)
+		}
+	}
+	failed := 0
+	for _, r := range done {
+		if !r.Pass {
+			failed++
+		}
+	}
+	if asJSON {
+		if done == nil {
+			done = []*evalResult{}
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(done); err != nil {
+			return err
+		}
+	} else {
+		printEvalReport(done)
+	}
+	jobOutcome = fmt.Sprintf("%d of %d runs passed", len(done)-failed, len(work))
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("stopped after %d of %d runs", len(done), len(work))
+	case failed > 0:
+		return fmt.Errorf("%d of %d runs failed", failed, len(done))
+	}
+	return nil
+}
+
+// runEvalCase asks one model one case and checks the answer
+func runEvalCase(ctx context.Context, c *evalCase, spec, system, judge string) *evalResult {
+	result := &evalResult{Case: c.Name, Model: spec}
+	var msgs []provider.Message
+	if system != "" {
+		msgs = append(msgs, provider.Message{Role: "system", Content: system})
+	}
+	msgs = append(msgs, provider.Message{Role: "user", Content: c.Prompt})
+
+	run, err := evalQuery(ctx, spec, msgs)
+	result.Response, result.LatencyMS = run.response, run.total.Milliseconds()
+	if err != nil {
+		result.Failures = []string{"request failed: " + err.Error()}
+		return result
+	}
+
+	answer, fold := run.response, func(s string) string { return s }
+	if c.Expect.IgnoreCase {
+		fold = strings.ToLower
+	}
+	for _, want := range c.Expect.Contains {
+		if !strings.Contains(fold(answer), fold(want)) {
+			result.Failures = append(result.Failures, fmt.Sprintf("doesn't contain %q", want))
+		}
+	}
+	for _, unwanted := range c.Expect.NotContains {
+		if strings.Contains(fold(answer), fold(unwanted)) {
+			result.Failures = append(result.Failures, fmt.Sprintf("contains %q", unwanted))
+		}
+	}
+	for _, pattern := range c.Expect.Regex {
+		re := pattern
+		if c.Expect.IgnoreCase {
+			re = "(?i)" + re
+		}
+		if !regexp.MustCompile(re).MatchString(answer) {
+			result.Failures = append(result.Failures, fmt.Sprintf("doesn't match /%s/", pattern))
+		}
+	}
+	if c.Expect.Rubric != "" {
+		prompt := fmt.Sprintf(evalJudgePrompt, c.Prompt, answer, c.Expect.Rubric)
+		graded, err := evalQuery(ctx, judge, []provider.Message{{Role: "user", Content: prompt}})
+		var verdict struct {
+			Pass   bool   `json:"pass"`
+			Reason string `json:"reason"`
+		}
+		switch {
+		case err != nil:
+			result.Failures = append(result.Failures, "grading failed: "+err.Error())
+		case parseJSONAnswer(graded.response, &verdict) != nil:
+			result.Failures = append(result.Failures, "the judge's reply couldn't be read")
+		case !verdict.Pass:
+			result.Failures = append(result.Failures, "rubric: "+verdict.Reason)
+		}
+	}
+	result.Pass = len(result.Failures) == 0
+	return result
+}
+
+// evalQuery sends one request with a provider of its own, since runs
+// happen in parallel
+func evalQuery(ctx context.Context, spec string, msgs []provider.Message) (timedRun, error) {
+	providerName, modelName, _ := strings.Cut(spec, "/")
+	p, _, err := newSessionProvider(providerName, modelName)
+	if err != nil {
+		return timedRun{}, err
+	}
+	run := runTimed(ctx, p, spec, msgs, "eval")
+	return run, run.err
+}
+
+// printEvalReport lists each run, failures first, then the pass rate per
+// model
+func printEvalReport(results []*evalResult) {
+	type tally struct{ passed, total int }
+	byModel := map[string]*tally{}
+	var order []string
+	width := 0
+	for _, r := range results {
+		width = max(width, len(r.Case))
+		if byModel[r.Model] == nil {
+			byModel[r.Model] = &tally{}
+			order = append(order, r.Model)
+		}
+	}
+	for _, pass := range []bool{false, true} {
+		for _, r := range results {
+			if r.Pass != pass {
+				continue
+			}
+			latency := time.Duration(r.LatencyMS) * time.Millisecond
+			if r.Pass {
+				fmt.Printf("%s✓%s %-*s  %s %s(%s)%s\n", green, reset, width, r.Case, r.Model, dim, latency.Round(10*time.Millisecond), reset)
+				continue
+			}
+			fmt.Printf("%s✗%s %-*s  %s\n", red, reset, width, r.Case, r.Model)
+			for _, f := range r.Failures {
+				fmt.Printf("  %s%s%s\n", red, f, reset)
+			}
+		}
+	}
+	fmt.Println()
+	for _, r := range results {
+		t := byModel[r.Model]
+		t.total++
+		if r.Pass {
+			t.passed++
+		}
+	}
+	for _, m := range order {
+		t := byModel[m]
+		color := green
+		if t.passed < t.total {
+			color = red
+		}
+		fmt.Printf("%s%-36s%s %s%d/%d passed%s\n", bold, m, reset, color, t.passed, t.total, reset)
+	}
+}
@@ -0,0 +1,322 @@
+// Package main provides transcript export for sessions and history.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Supported export formats and their file extensions
+var exportFormats = map[string]string{
+	"md":       "md",
+	"markdown": "md",
+	"json":     "json",
+	"html":     "html",
+}
+
+// exportSession writes the session transcript in the given format. When path is
+// empty a timestamped file name is generated in the current directory.
+func exportSession(saved *SavedSession, format, path string) (string, error) {
+	ext, ok := exportFormats[strings.ToLower(format)]
+	if !ok {
+		return "", fmt.Errorf("unknown export format '%s' (use md, json, or html)", format)
+	}
+
+	if path == "" {
+		base := saved.Name
+		if base == "" {
+			base = "ask-session"
+		}
+		path = fmt.Sprintf("%s-%s.%s", base, time.Now().Format("20060102-150405"), ext)
+	}
+
+	var data []byte
+	var err error
+	switch ext {
+	case "md":
+		data = []byte(exportMarkdown(saved))
+	case "json":
+		data, err = json.MarshalIndent(saved, "", "  ")
+	case "html":
+		data, err = exportHTML(saved)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to export session: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// exportTitle returns a heading for the exported transcript
+func exportTitle(saved *SavedSession) string {
+	if saved.Name != "" {
+		return saved.Name
+	}
+	return "Ask session"
+}
+
+// speakerLabel returns the display name for a message author
+func speakerLabel(msg SessionMessage) string {
+	label := msg.Role
+	if msg.Role == "assistant" && msg.Model != "" {
+		label = msg.Model
+	}
+	if msg.Partial {
+		label += " (partial)"
+	}
+	return label
+}
+
+// formatMessageTime formats a message timestamp, tolerating missing values
+func formatMessageTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func exportMarkdown(saved *SavedSession) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", exportTitle(saved))
+	fmt.Fprintf(&b, "- Model: %s/%s\n", saved.Provider, saved.Model)
+	if saved.Persona != "" {
+		fmt.Fprintf(&b, "- Persona: %s\n", saved.Persona)
+	}
+	if saved.System != "" {
+		fmt.Fprintf(&b, "- System prompt: %s\n", strings.Join(strings.Fields(saved.System), " "))
+	}
+	if len(saved.Tags) > 0 {
+		fmt.Fprintf(&b, "- Tags: %s\n", strings.Join(saved.Tags, ", "))
+	}
+	fmt.Fprintf(&b, "- Exported: %s\n\n", time.Now().Format("2006-01-02 15:04:05"))
+
+	for _, msg := range saved.Messages {
+		b.WriteString("---\n\n")
+		fmt.Fprintf(&b, "### %s", speakerLabel(msg))
+		if ts := formatMessageTime(msg.Time); ts != "" {
+			fmt.Fprintf(&b, " · %s", ts)
+		}
+		b.WriteString("\n\n")
+		b.WriteString(strings.TrimSpace(msg.Content))
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
+
+var exportHTMLTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; max-width: 860px; margin: 2rem auto; padding: 0 1rem; color: #222; }
+.meta { color: #777; font-size: 0.9rem; }
+.message { border-left: 4px solid #ccc; padding: 0.25rem 1rem; margin: 1.5rem 0; }
+.message.user { border-color: #2aa1b3; }
+.message.assistant { border-color: #3c9a4c; }
+.message.system { border-color: #b38b2a; }
+.speaker { font-weight: bold; }
+.prompt { white-space: pre-wrap; }
+pre { background: #f5f5f5; padding: 0.75rem; overflow-x: auto; }
+code { font-family: ui-monospace, SFMono-Regular, Menlo, monospace; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Model: {{.Model}} · Exported: {{.Exported}}</p>
+{{if .System}}<p class="meta">System prompt: {{.System}}</p>
+{{end}}
+{{range .Messages}}<div class="message {{.Role}}">
+<p><span class="speaker">{{.Speaker}}</span> <span class="meta">{{.Time}}</span></p>
+{{.Body}}
+</div>
+{{end}}</body>
+</html>
+`))
+
+func exportHTML(saved *SavedSession) ([]byte, error) {
+	type htmlMessage struct {
+		Role    string
+		Speaker string
+		Time    string
+		Body    template.HTML
+	}
+
+	md := goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	var messages []htmlMessage
+	for _, msg := range saved.Messages {
+		var body bytes.Buffer
+		if msg.Role == "assistant" {
+			// goldmark omits raw HTML by default, so model output can't inject markup
+			if err := md.Convert([]byte(msg.Content), &body); err != nil {
+				return nil, err
+			}
+		} else {
+			// Prompts are shown verbatim
+			body.WriteString(`<p class="prompt">`)
+			template.HTMLEscape(&body, []byte(msg.Content))
+			body.WriteString(`</p>`)
+		}
+		messages = append(messages, htmlMessage{
+			Role:    msg.Role,
+			Speaker: speakerLabel(msg),
+			Time:    formatMessageTime(msg.Time),
+			Body:    template.HTML(body.String()),
+		})
+	}
+
+	var out bytes.Buffer
+	err := exportHTMLTemplate.Execute(&out, struct {
+		Title    string
+		Model    string
+		System   string
+		Exported string
+		Messages []htmlMessage
+	}{
+		Title:    exportTitle(saved),
+		System:   saved.System,
+		Model:    saved.Provider + "/" + saved.Model,
+		Exported: time.Now().Format("2006-01-02 15:04:05"),
+		Messages: messages,
+	})
+	return out.Bytes(), err
+}
+
+// conversation is a saved session or one-shot answer in a bulk export
+type conversation struct {
+	source string // "session" or "history"
+	id     string // session name or history entry id
+	saved  *SavedSession
+}
+
+// readHistoryConversations loads one-shot answers matching the filter as
+// two-message conversations, oldest first
+func readHistoryConversations(f historyFilter) ([]conversation, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := readTags(db, tagHistory)
+	if err != nil {
+		return nil, err
+	}
+	where, params := f.whereTagged(tagHistory, "created_at")
+	rows, err := db.Query("SELECT id, provider, model, prompt, response, partial, created_at FROM history "+where+" ORDER BY created_at", params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var convs []conversation
+	for rows.Next() {
+		var id, created int64
+		var providerName, modelName, prompt, response string
+		var partial bool
+		if err := rows.Scan(&id, &providerName, &modelName, &prompt, &response, &partial, &created); err != nil {
+			return nil, err
+		}
+		t := fromMillis(created)
+		convs = append(convs, conversation{
+			source: "history",
+			id:     strconv.FormatInt(id, 10),
+			saved: &SavedSession{
+				Name:     fmt.Sprintf("History #%d", id),
+				Provider: providerName,
+				Model:    modelName,
+				Tags:     tags[id],
+				Messages: []SessionMessage{
+					{Role: "user", Content: prompt, Time: t},
+					{Role: "assistant", Content: response, Model: providerName + "/" + modelName, Time: t, Partial: partial},
+				},
+				CreatedAt: t,
+				UpdatedAt: t,
+			},
+		})
+	}
+	return convs, rows.Err()
+}
+
+// readSessionConversations loads saved sessions matching the filter, oldest
+// first. Dates apply to when the session was last updated.
+func readSessionConversations(f historyFilter) ([]conversation, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	where, params := f.whereTagged(tagSession, "updated_at")
+	sessions, err := readSessions(db, where, params...)
+	if err != nil {
+		return nil, err
+	}
+	convs := make([]conversation, 0, len(sessions))
+	for i := len(sessions) - 1; i >= 0; i-- {
+		if len(sessions[i].Messages) == 0 {
+			continue
+		}
+		convs = append(convs, conversation{source: "session", id: sessions[i].Name, saved: sessions[i]})
+	}
+	return convs, nil
+}
+
+// exportConversations writes conversations as JSON Lines, one chat per line
+// in the messages format used for fine-tuning, or as one Markdown document
+func exportConversations(w io.Writer, convs []conversation, format string) error {
+	switch strings.ToLower(format) {
+	case "jsonl":
+		type jsonlMessage struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		}
+		type jsonlMetadata struct {
+			Source    string    `json:"source"`
+			ID        string    `json:"id"`
+			Provider  string    `json:"provider"`
+			Model     string    `json:"model"`
+			CreatedAt time.Time `json:"created_at"`
+			UpdatedAt time.Time `json:"updated_at"`
+			Tags      []string  `json:"tags,omitempty"`
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, c := range convs {
+			var messages []jsonlMessage
+			for _, m := range toProviderMessages(c.saved.System, c.saved.Messages) {
+				messages = append(messages, jsonlMessage{Role: m.Role, Content: m.Content})
+			}
+			err := enc.Encode(struct {
+				Messages []jsonlMessage `json:"messages"`
+				Metadata jsonlMetadata  `json:"metadata"`
+			}{messages, jsonlMetadata{c.source, c.id, c.saved.Provider, c.saved.Model, c.saved.CreatedAt, c.saved.UpdatedAt, c.saved.Tags}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "md", "markdown":
+		for i, c := range convs {
+			if i > 0 {
+				fmt.Fprint(w, "\n")
+			}
+			if _, err := fmt.Fprint(w, exportMarkdown(c.saved)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown export format '%s' (use jsonl or markdown)", format)
+}
@@ -0,0 +1,191 @@
+// Package main provides favorite prompts and the picker for `ask --fav`.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// favorite is a saved prompt
+type favorite struct {
+	id     int64
+	prompt string
+}
+
+// addFavorite saves prompt as a favorite, returning its id. Saving the same
+// prompt twice returns the existing favorite.
+func addFavorite(prompt string) (int64, error) {
+	db, err := openStore()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec("INSERT OR IGNORE INTO favorites (prompt, created_at) VALUES (?, ?)", prompt, toMillis(time.Now())); err != nil {
+		return 0, err
+	}
+	var id int64
+	err = db.QueryRow("SELECT id FROM favorites WHERE prompt = ?", prompt).Scan(&id)
+	return id, err
+}
+
+// removeFavorite deletes a favorite by id
+func removeFavorite(id int64) error {
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec("DELETE FROM favorites WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("favorite %d not found", id)
+	}
+	return nil
+}
+
+// listFavorites returns the favorites, most recently added first
+func listFavorites() ([]favorite, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT id, prompt FROM favorites ORDER BY created_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var favs []favorite
+	for rows.Next() {
+		var f favorite
+		if err := rows.Scan(&f.id, &f.prompt); err != nil {
+			return nil, err
+		}
+		favs = append(favs, f)
+	}
+	return favs, rows.Err()
+}
+
+// favoritePreview flattens a prompt to one line of at most width characters
+func favoritePreview(prompt string, width int) string {
+	preview := strings.Join(strings.Fields(prompt), " ")
+	if r := []rune(preview); len(r) > width {
+		preview = string(r[:width-1]) + "…"
+	}
+	return preview
+}
+
+// filterFavorites returns the favorites fuzzy-matching pattern, best first
+func filterFavorites(favs []favorite, pattern string) []favorite {
+	if pattern == "" {
+		return favs
+	}
+	type match struct {
+		fav   favorite
+		score int
+	}
+	var matches []match
+	for _, f := range favs {
+		if score, ok := fuzzyScore(f.prompt, pattern); ok {
+			matches = append(matches, match{f, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score < matches[j].score })
+
+	out := make([]favorite, len(matches))
+	for i, m := range matches {
+		out[i] = m.fav
+	}
+	return out
+}
+
+// pickFavorite shows a fuzzy-filtered, numbered list of favorites and
+// returns the chosen prompt, or "" if the user cancels
+func pickFavorite(filter string) (string, error) {
+	favs, err := listFavorites()
+	if err != nil {
+		return "", err
+	}
+	if len(favs) == 0 {
+		return "", fmt.Errorf("no favorites yet. Add one with 'ask history fav <id>' or /fav in a session")
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	fmt.Println()
+	for {
+		shown := filterFavorites(favs, filter)
+		if len(shown) == 1 && filter != "" {
+			return shown[0].prompt, nil
+		}
+		if len(shown) == 0 {
+			fmt.Printf("%s  No favorites match '%s'%s\n", dim, filter, reset)
+		}
+		for i, f := range shown {
+			if i == maxPickerRows {
+				fmt.Printf("%s  ... %d more, type to filter%s\n", dim, len(shown)-maxPickerRows, reset)
+				break
+			}
+			fmt.Printf("  %s%3d.%s %s\n", dim, i+1, reset, favoritePreview(f.prompt, 70))
+		}
+
+		fmt.Printf("%s  Number to send, text to filter, Enter to cancel › %s", dim, reset)
+		if !scanner.Scan() {
+			return "", nil
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			return "", nil
+		}
+		if num, err := strconv.Atoi(line); err == nil {
+			if num > 0 && num <= len(shown) && num <= maxPickerRows {
+				return shown[num-1].prompt, nil
+			}
+			fmt.Printf("%s✗ Pick a number between 1 and %d%s\n", red, min(len(shown), maxPickerRows), reset)
+			continue
+		}
+		filter = line
+		fmt.Println()
+	}
+}
+
+// favCommand saves the last prompt, or message #n, as a favorite
+func (s *Session) favCommand(args []string) {
+	s.mu.Lock()
+	messages := append([]SessionMessage{}, s.messages...)
+	s.mu.Unlock()
+
+	prompt := ""
+	if len(args) > 0 {
+		n, ok := s.selectMessage(strings.TrimPrefix(args[0], "#"))
+		if !ok {
+			return
+		}
+		if messages[n-1].Role != "user" {
+			fmt.Printf("\n%s✗ Message #%d is not a prompt%s\n", red, n, reset)
+			return
+		}
+		prompt = messages[n-1].Content
+	} else {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == "user" {
+				prompt = messages[i].Content
+				break
+			}
+		}
+		if prompt == "" {
+			fmt.Printf("\n%sNo prompt to save yet%s\n", dim, reset)
+			return
+		}
+	}
+
+	id, err := addFavorite(prompt)
+	if err != nil {
+		fmt.Printf("\n%s✗ Error saving favorite: %v%s\n", red, err, reset)
+		return
+	}
+	fmt.Printf("\n%s✓ Saved as favorite %d%s %s(recall with ask --fav)%s\n", green, id, reset, dim, reset)
+}
@@ -0,0 +1,155 @@
+// Package main provides the fuzzy file picker for attachments.
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits for the file picker
+const (
+	maxPickerFiles = 5000 // stop walking large trees after this many files
+	maxFileRows    = 20
+)
+
+// skippedDirs are never walked by the file picker
+var skippedDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+	"target":       true,
+	"dist":         true,
+	"__pycache__":  true,
+}
+
+// listFiles returns files below the working directory, skipping hidden and
+// dependency directories
+func listFiles() []string {
+	var files []string
+	filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != "." && (strings.HasPrefix(name, ".") || skippedDirs[name]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, ".") || !d.Type().IsRegular() {
+			return nil
+		}
+		files = append(files, path)
+		if len(files) >= maxPickerFiles {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return files
+}
+
+// fuzzyScore reports whether the characters of pattern appear in order in
+// s (case-insensitive). Lower scores are better matches: they favor
+// consecutive characters and shorter paths.
+func fuzzyScore(s, pattern string) (int, bool) {
+	s, pattern = strings.ToLower(s), strings.ToLower(pattern)
+	score, last := 0, -1
+	pos := 0
+	for _, r := range pattern {
+		i := strings.IndexRune(s[pos:], r)
+		if i == -1 {
+			return 0, false
+		}
+		i += pos
+		if last != -1 && i != last+1 {
+			score += i - last
+		}
+		last = i
+		pos = i + len(string(r))
+	}
+	return score*10 + len(s), true
+}
+
+// fuzzyFilter returns the files matching pattern, best matches first
+func fuzzyFilter(files []string, pattern string) []string {
+	if pattern == "" {
+		return files
+	}
+
+	type match struct {
+		path  string
+		score int
+	}
+	var matches []match
+	for _, f := range files {
+		if score, ok := fuzzyScore(f, pattern); ok {
+			matches = append(matches, match{f, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score < matches[j].score })
+
+	out := make([]string, len(matches))
+	for i, m := range matches {
+		out[i] = m.path
+	}
+	return out
+}
+
+// pickFile shows a fuzzy-filtered, numbered list of files below the
+// working directory and returns the chosen path, or "" if the user cancels
+func (s *Session) pickFile(filter string) string {
+	files := listFiles()
+	if len(files) == 0 {
+		fmt.Printf("\n%sNo files found in the current directory%s\n", dim, reset)
+		return ""
+	}
+
+	fmt.Println()
+	for {
+		shown := fuzzyFilter(files, filter)
+		if len(shown) == 0 {
+			fmt.Printf("%s  No files match '%s'%s\n", dim, filter, reset)
+		}
+		for i, f := range shown {
+			if i == maxFileRows {
+				fmt.Printf("%s  ... %d more, type to filter%s\n", dim, len(shown)-maxFileRows, reset)
+				break
+			}
+			fmt.Printf("  %s%3d.%s %s\n", dim, i+1, reset, f)
+		}
+
+		line, ok := s.readLine(fmt.Sprintf("%s  Number to attach, text to filter, Enter to cancel › %s", dim, reset))
+		if !ok {
+			return ""
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return ""
+		}
+		if num, err := strconv.Atoi(line); err == nil {
+			if num > 0 && num <= len(shown) && num <= maxFileRows {
+				return shown[num-1]
+			}
+			fmt.Printf("%s✗ Pick a number between 1 and %d%s\n", red, min(len(shown), maxFileRows), reset)
+			continue
+		}
+		filter = line
+		fmt.Println()
+	}
+}
+
+// attachmentChips summarizes pending attachments for the prompt
+func (s *Session) attachmentChips() string {
+	if len(s.attachments) == 0 {
+		return ""
+	}
+	names := make([]string, len(s.attachments))
+	for i, a := range s.attachments {
+		names[i] = filepath.Base(a.Path)
+	}
+	return fmt.Sprintf(" %s[📎 %s]%s%s", reset+dim, strings.Join(names, ", "), bold, cyan)
+}
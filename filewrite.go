@@ -0,0 +1,235 @@
+// Package main provides /write, which lets the model propose files in its
+// answers. Each proposed file is shown as a diff and written only once the
+// user approves it, or without asking when its path is on the /allow list.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileBlockPrefix starts the info string of a proposed file's code block
+const fileBlockPrefix = "file:"
+
+// writeFilesPrompt tells the model how to propose files while /write is on
+const writeFilesPrompt = `You can create or replace files in the user's working directory. To propose
+a file, put its complete new content in a fenced code block whose info string
+is file: followed by the path relative to the working directory, such as
+` + "```file:cmd/hello/main.go" + `. Always give the whole file, never a fragment or
+a placeholder for unchanged parts. The user reviews a diff of each file and
+may decline it. Use ordinary code blocks for code that shouldn't be written.`
+
+// fileProposal is a file the model proposed writing
+type fileProposal struct {
+	path    string
+	content string
+}
+
+// fileProposals finds the file blocks in an answer, in order; a later block
+// for the same path replaces an earlier one
+func fileProposals(answer string) []fileProposal {
+	var proposals []fileProposal
+	seen := map[string]int{}
+	for _, b := range extractFencedBlocks(answer) {
+		name, ok := strings.CutPrefix(b.lang, fileBlockPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		content := b.code
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		if i, ok := seen[name]; ok {
+			proposals[i].content = content
+			continue
+		}
+		seen[name] = len(proposals)
+		proposals = append(proposals, fileProposal{path: name, content: content})
+	}
+	return proposals
+}
+
+// system returns the system prompt, with the file instructions while /write
+// is on
+func (s *Session) system() string {
+	if !s.writeFiles {
+		return s.systemPrompt
+	}
+	if s.systemPrompt == "" {
+		return writeFilesPrompt
+	}
+	return s.systemPrompt + "\n\n" + writeFilesPrompt
+}
+
+// writeCommand turns file proposals on or off, or shows whether they are on
+func (s *Session) writeCommand(args []string) {
+	arg := ""
+	if len(args) > 0 {
+		arg = strings.ToLower(args[0])
+	}
+	switch arg {
+	case "":
+		if s.writeFiles {
+			fmt.Printf("\n%sFile proposals are on; /write off to stop%s\n", dim, reset)
+		} else {
+			fmt.Printf("\n%sFile proposals are off; /write on lets the model propose files%s\n", dim, reset)
+		}
+	case "on":
+		root, err := workingDir()
+		if err != nil {
+			fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+			return
+		}
+		s.writeFiles = true
+		fmt.Printf("\n%s✓ The model can now propose files in %s; you approve each one%s\n", green, root, reset)
+	case "off":
+		s.writeFiles = false
+		fmt.Printf("\n%s✓ File proposals off%s\n", green, reset)
+	default:
+		fmt.Printf("\n%sUsage: /write [on|off]%s\n", dim, reset)
+	}
+}
+
+// allowCommand manages the paths written without asking: /allow lists them,
+// /allow <path|glob|dir/> adds one, and /allow clear empties the list
+func (s *Session) allowCommand(args []string) {
+	if len(args) == 0 {
+		if len(s.allowedPaths) == 0 {
+			fmt.Printf("\n%sNo paths allowed; every proposed file asks first%s\n", dim, reset)
+			return
+		}
+		fmt.Printf("\n%sWritten without asking this session:%s\n", bold, reset)
+		for _, p := range s.allowedPaths {
+			fmt.Printf("  %s%s%s\n", cyan, p, reset)
+		}
+		return
+	}
+	if args[0] == "clear" {
+		s.allowedPaths = nil
+		fmt.Printf("\n%s✓ Allowed paths cleared%s\n", green, reset)
+		return
+	}
+	pattern := cleanAllowPattern(args[0])
+	if _, err := path.Match(pattern, ""); err != nil || strings.HasPrefix(pattern, "../") || path.IsAbs(pattern) {
+		fmt.Printf("\n%s✗ '%s' isn't a path or glob inside the working directory%s\n", red, args[0], reset)
+		return
+	}
+	s.addAllowedPath(pattern)
+	fmt.Printf("\n%s✓ Files matching %s are written without asking this session%s\n", green, pattern, reset)
+}
+
+// cleanAllowPattern normalizes a path or glob, keeping the trailing slash
+// that marks a directory
+func cleanAllowPattern(p string) string {
+	dir := strings.HasSuffix(p, "/")
+	p = path.Clean(filepath.ToSlash(p))
+	if dir && p != "/" {
+		p += "/"
+	}
+	return p
+}
+
+// addAllowedPath adds a pattern to the allowlist once
+func (s *Session) addAllowedPath(pattern string) {
+	for _, p := range s.allowedPaths {
+		if p == pattern {
+			return
+		}
+	}
+	s.allowedPaths = append(s.allowedPaths, pattern)
+}
+
+// allowed reports whether a path relative to the working directory matches
+// the allowlist: the path itself, a glob, or a directory ending in /
+func (s *Session) allowed(rel string) bool {
+	for _, p := range s.allowedPaths {
+		if dir, ok := strings.CutSuffix(p, "/"); ok {
+			if dir == "." || strings.HasPrefix(rel, dir+"/") {
+				return true
+			}
+			continue
+		}
+		if p == rel {
+			return true
+		}
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyFileProposals shows each file proposed in an answer as a diff and
+// writes the ones the user approves
+func (s *Session) applyFileProposals(answer string) {
+	proposals := fileProposals(answer)
+	if len(proposals) == 0 {
+		return
+	}
+	root, err := workingDir()
+	if err != nil {
+		fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+		return
+	}
+	tools := &toolRunner{root: root}
+
+	for _, fp := range proposals {
+		full, before, after, err := tools.writtenContent(toolArgs{Path: fp.path, Content: fp.content})
+		if err != nil {
+			fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+			continue
+		}
+		rel := tools.relative(full)
+		if before == after {
+			fmt.Printf("\n%s%s is unchanged%s\n", dim, rel, reset)
+			continue
+		}
+		ops := lineDiff(before, after)
+		added, removed := diffStats(ops)
+		status := "modified"
+		if _, err := os.Stat(full); err != nil {
+			status = "new file"
+		}
+		fmt.Printf("\n%s%s✎ %s%s %s(%s, %s+%d%s %s-%d%s)%s\n", bold, cyan, rel, reset, dim, status, green, added, dim, red, removed, dim, reset)
+		fmt.Print(formatLineDiff(ops))
+
+		if s.allowed(rel) {
+			fmt.Printf("%sAllowed by /allow%s\n", dim, reset)
+		} else {
+			line, _ := s.readLine(fmt.Sprintf("%sWrite %s? [y]es / [n]o / [a]lways this path: %s", yellow, rel, reset))
+			switch strings.ToLower(strings.TrimSpace(line)) {
+			case "y", "yes":
+			case "a", "always":
+				s.addAllowedPath(rel)
+			default:
+				fmt.Printf("%sSkipped %s%s\n", dim, rel, reset)
+				continue
+			}
+		}
+		if err := writeKeepingMode(full, after); err != nil {
+			fmt.Printf("%s✗ %v%s\n", red, err, reset)
+			continue
+		}
+		fmt.Printf("%s✓ Wrote %s%s\n", green, rel, reset)
+	}
+}
+
+// diffStats counts the lines a line diff adds and removes
+func diffStats(ops []diffOp) (added, removed int) {
+	for _, op := range ops {
+		n := strings.Count(op.text, "\n")
+		if !strings.HasSuffix(op.text, "\n") && op.text != "" {
+			n++
+		}
+		switch op.kind {
+		case 1:
+			added += n
+		case -1:
+			removed += n
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,251 @@
+// Package main provides `ask commit`, which drafts commit messages from the
+// staged diff, and `ask git install-hooks`, which runs it from git.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// isCommitCommand reports whether the arguments invoke `ask commit`. Only
+// flags may follow, so "ask commit this to memory" stays an ordinary prompt.
+func isCommitCommand(args []string) bool {
+	return len(args) > 0 && args[0] == "commit" && (len(args) == 1 || strings.HasPrefix(args[1], "-"))
+}
+
+// isGitCommand reports whether the arguments invoke `ask git`
+func isGitCommand(args []string) bool {
+	return len(args) >= 2 && args[0] == "git" && (args[1] == "install-hooks" || args[1] == "uninstall-hooks")
+}
+
+// maxCommitDiff bounds how much of the staged diff is sent
+const maxCommitDiff = 60_000
+
+// commitHookMarker identifies hooks written by ask
+const commitHookMarker = "# Installed by ask git install-hooks"
+
+// commitPrompt asks for a message in git's usual shape
+const commitPrompt = `Write a git commit message for the staged changes below.
+Start with a subject line in the imperative mood of at most 72 characters,
+then, if the change needs explaining, a blank line and a short body wrapped
+at 72 characters saying what changed and why. Reply with the message only.`
+
+// codeFence matches a fence around the whole answer
+var codeFence = regexp.MustCompile("(?s)^```[a-z]*\\n(.*?)\\n?```$")
+
+// gitOutput runs git and returns its trimmed output
+func gitOutput(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// draftCommitMessage asks the configured model for a message describing
+// the staged diff, or returns "" when nothing is staged
+func draftCommitMessage(config *Config, providerFlag, modelFlag, profileFlag string) (string, error) {
+	diff, err := gitOutput("diff", "--cached", "--no-color", "--no-ext-diff")
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(diff) == "" {
+		return "", nil
+	}
+	stat, _ := gitOutput("diff", "--cached", "--stat", "--no-color")
+	if len(diff) > maxCommitDiff {
+		diff = diff[:maxCommitDiff] + "\n[diff truncated]"
+	}
+
+	return gitQuery(config, providerFlag, modelFlag, profileFlag, commitPrompt+"\n\n"+stat+"\n\n"+diff, "commit")
+}
+
+// gitQuery sends a prompt about a repository to commit_model, or the model
+// the flags pick, and returns the answer without a surrounding code fence.
+// usage names the request in the usage ledger.
+func gitQuery(config *Config, providerFlag, modelFlag, profileFlag, prompt, usage string) (string, error) {
+	if providerFlag == "" && modelFlag == "" && profileFlag == "" && config.CommitModel != "" {
+		if _, ok := config.Profiles[config.CommitModel]; ok {
+			profileFlag = config.CommitModel
+		} else {
+			modelFlag = config.CommitModel
+		}
+	}
+	answer, err := queryOnce(config, providerFlag, modelFlag, profileFlag, prompt, usage)
+	if err != nil {
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if m := codeFence.FindStringSubmatch(answer); m != nil {
+		answer = strings.TrimSpace(m[1])
+	}
+	return answer, nil
+}
+
+// runCommitCommand prints a message for the staged changes, or with --hook
+// writes it into the message file of git's prepare-commit-msg hook
+func runCommitCommand(args []string) error {
+	var providerFlag, modelFlag, profileFlag string
+	hook := false
+	fs := flag.NewFlagSet("commit", flag.ContinueOnError)
+	fs.StringVar(&modelFlag, "m", "", "Model to draft the message with")
+	fs.StringVar(&providerFlag, "p", "", "Provider to draft the message with")
+	fs.StringVar(&profileFlag, "P", "", "Profile to draft the message with")
+	fs.BoolVar(&hook, "hook", false, "Run as git's prepare-commit-msg hook: <message file> [source] [sha]")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !hook {
+		config, err := LoadConfig()
+		if err != nil {
+			return err
+		}
+		openRequestLog(config)
+		openAudit(config)
+		if err := openScreening(config); err != nil {
+			return err
+		}
+		message, err := draftCommitMessage(config, providerFlag, modelFlag, profileFlag)
+		if err != nil {
+			return err
+		}
+		if message == "" {
+			return fmt.Errorf("nothing is staged; use git add first")
+		}
+		fmt.Println(message)
+		return nil
+	}
+
+	// A failing hook would block the commit, so problems are only reported
+	if fs.NArg() == 0 {
+		return fmt.Errorf("--hook needs the commit message file")
+	}
+	if err := commitHook(fs.Arg(0), fs.Arg(1), providerFlag, modelFlag, profileFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "ask: no commit message drafted: %v\n", err)
+	}
+	return nil
+}
+
+// commitHook drafts a message into file unless the commit already has one:
+// from -m, -F, or -C, a merge, a squash, or an amend. ASK_NO_HOOK skips it.
+func commitHook(file, source, providerFlag, modelFlag, profileFlag string) error {
+	if os.Getenv("ASK_NO_HOOK") != "" {
+		return nil
+	}
+	if source != "" && source != "template" {
+		return nil
+	}
+	if gitDir, err := gitOutput("rev-parse", "--git-dir"); err == nil {
+		if _, err := os.Stat(filepath.Join(gitDir, "MERGE_HEAD")); err == nil {
+			return nil
+		}
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	openRequestLog(config)
+	openAudit(config)
+	if err := openScreening(config); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%sask: drafting a commit message (ASK_NO_HOOK=1 skips this)…%s\n", dim, reset)
+	message, err := draftCommitMessage(config, providerFlag, modelFlag, profileFlag)
+	if err != nil || message == "" {
+		return err // an empty diff leaves the message alone
+	}
+
+	// Keep git's comment lines below the draft, for the editor
+	existing, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append([]byte(message+"\n"), existing...), 0644)
+}
+
+// commitHookScript runs ask from git, falling back to the ask in PATH when
+// the binary that installed the hook has moved
+func commitHookScript(exe string) string {
+	return `#!/bin/sh
+` + commitHookMarker + `: drafts the commit message from the staged diff.
+# Set ASK_NO_HOOK=1 to skip it, or run ask git uninstall-hooks to remove it.
+[ -n "$ASK_NO_HOOK" ] && exit 0
+ASK='` + strings.ReplaceAll(exe, "'", `'\''`) + `'
+[ -x "$ASK" ] || ASK=ask
+command -v "$ASK" >/dev/null 2>&1 || exit 0
+"$ASK" commit --hook "$@" </dev/null || true
+`
+}
+
+// runGitCommand installs or removes the prepare-commit-msg hook in the
+// current repository
+func runGitCommand(args []string) error {
+	action, args := args[0], args[1:]
+	force := false
+	fs := flag.NewFlagSet("git "+action, flag.ContinueOnError)
+	if action == "install-hooks" {
+		fs.BoolVar(&force, "force", false, "Replace an existing prepare-commit-msg hook, keeping it as .bak")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	hooksDir, err := gitOutput("rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(hooksDir, "prepare-commit-msg")
+	current, readErr := os.ReadFile(path)
+	ours := readErr == nil && strings.Contains(string(current), commitHookMarker)
+
+	if action == "uninstall-hooks" {
+		if !ours {
+			return fmt.Errorf("no hook installed by ask in %s", hooksDir)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if _, err := os.Stat(path + ".bak"); err == nil {
+			if err := os.Rename(path+".bak", path); err != nil {
+				return err
+			}
+			fmt.Printf("Removed the ask hook and restored %s\n", path)
+			return nil
+		}
+		fmt.Printf("Removed %s\n", path)
+		return nil
+	}
+
+	if readErr == nil && !ours {
+		if !force {
+			return fmt.Errorf("%s already exists; use --force to replace it (it is kept as prepare-commit-msg.bak)", path)
+		}
+		if err := os.Rename(path, path+".bak"); err != nil {
+			return err
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		exe = "ask"
+	}
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(commitHookScript(exe)), 0755); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s\n", path)
+	fmt.Printf("%sCommits without -m now open the editor with a drafted message. Set ASK_NO_HOOK=1 to skip it.%s\n", dim, reset)
+	return nil
+}
@@ -0,0 +1,474 @@
+// Package main provides the history of one-shot answers and the
+// `ask history` command.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historySubcommands are the actions of `ask history`. Other words after
+// "history" are treated as an ordinary prompt ("ask history of rome").
+var historySubcommands = map[string]bool{"list": true, "show": true, "search": true, "export": true, "delete": true, "clear": true, "fav": true, "unfav": true, "tag": true, "untag": true, "pin": true, "purge": true}
+
+// isHistoryCommand reports whether the arguments invoke `ask history`
+func isHistoryCommand(args []string) bool {
+	if len(args) == 0 || args[0] != "history" {
+		return false
+	}
+	return len(args) == 1 || historySubcommands[args[1]]
+}
+
+// saveHistory records a one-shot answer. Errors are ignored so a database
+// problem never hides the answer.
+func saveHistory(providerName, modelName, prompt, response string, latency time.Duration) {
+	insertHistory(providerName, modelName, prompt, response, latency, false)
+}
+
+// savePartialHistory records an answer cut short by Ctrl+C, marked partial
+func savePartialHistory(providerName, modelName, prompt, response string, latency time.Duration) {
+	insertHistory(providerName, modelName, prompt, response, latency, true)
+}
+
+func insertHistory(providerName, modelName, prompt, response string, latency time.Duration, partial bool) {
+	db, err := openStore()
+	if err != nil {
+		return
+	}
+	db.Exec("INSERT INTO history (provider, model, prompt, response, latency_ms, partial, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		providerName, modelName, prompt, response, latency.Milliseconds(), partial, toMillis(time.Now()))
+}
+
+// partialLabel marks an answer cut short in listings
+func partialLabel(partial bool) string {
+	if !partial {
+		return ""
+	}
+	return " " + yellow + "(partial)" + reset
+}
+
+// historyFilter selects history entries by date, provider, model, and tag
+type historyFilter struct {
+	since, until time.Time
+	provider     string
+	model        string
+	tag          string
+}
+
+// addFlags registers the filter flags on fs
+func (f *historyFilter) addFlags(fs *flag.FlagSet, since, until *string) {
+	fs.StringVar(since, "since", "", "Only entries on or after `DATE` (2006-01-02) or within a duration (7d, 12h)")
+	fs.StringVar(until, "until", "", "Only entries before `DATE` (2006-01-02)")
+	fs.StringVar(&f.provider, "provider", "", "Only entries from this provider")
+	fs.StringVar(&f.model, "model", "", "Only entries whose model name contains this text")
+	fs.StringVar(&f.tag, "tag", "", "Only entries with this `tag`")
+}
+
+// parseHistoryDate parses a date, or a duration back from now like 7d or 12h
+func parseHistoryDate(value string) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date '%s' (use 2006-01-02, 7d, or 12h)", value)
+}
+
+// where returns the SQL condition and arguments for the filter, with dates
+// compared against timeColumn
+func (f historyFilter) where(timeColumn string) (string, []any) {
+	conds := []string{"1 = 1"}
+	var args []any
+	if !f.since.IsZero() {
+		conds = append(conds, timeColumn+" >= ?")
+		args = append(args, toMillis(f.since))
+	}
+	if !f.until.IsZero() {
+		conds = append(conds, timeColumn+" < ?")
+		args = append(args, toMillis(f.until))
+	}
+	if f.provider != "" {
+		conds = append(conds, "provider = ?")
+		args = append(args, f.provider)
+	}
+	if f.model != "" {
+		conds = append(conds, "instr(lower(model), lower(?)) > 0")
+		args = append(args, f.model)
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// parseHistoryFlags parses filter flags, plus any extra flags, for the named
+// command
+func parseHistoryFlags(name string, args []string, extra func(fs *flag.FlagSet)) (historyFilter, []string, error) {
+	var f historyFilter
+	var since, until string
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.addFlags(fs, &since, &until)
+	if extra != nil {
+		extra(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return f, nil, err
+	}
+
+	var err error
+	if since != "" {
+		if f.since, err = parseHistoryDate(since); err != nil {
+			return f, nil, err
+		}
+	}
+	if until != "" {
+		if f.until, err = parseHistoryDate(until); err != nil {
+			return f, nil, err
+		}
+	}
+	return f, fs.Args(), nil
+}
+
+// runHistoryCommand runs `ask history <action> [args]`
+func runHistoryCommand(args []string) error {
+	action := "list"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		limit := 20
+		f, _, err := parseHistoryFlags("history "+action, args, func(fs *flag.FlagSet) {
+			fs.IntVar(&limit, "limit", 20, "Show at most `N` entries")
+		})
+		if err != nil {
+			return err
+		}
+		tags, err := readTags(db, tagHistory)
+		if err != nil {
+			return err
+		}
+		where, params := f.whereTagged(tagHistory, "created_at")
+		rows, err := db.Query("SELECT id, provider, model, prompt, partial, created_at FROM history "+where+
+			" ORDER BY created_at DESC LIMIT ?", append(params, limit)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		count := 0
+		for rows.Next() {
+			var id, created int64
+			var providerName, modelName, prompt string
+			var partial bool
+			if err := rows.Scan(&id, &providerName, &modelName, &prompt, &partial, &created); err != nil {
+				return err
+			}
+			prompt = strings.Join(strings.Fields(prompt), " ")
+			if r := []rune(prompt); len(r) > 60 {
+				prompt = string(r[:59]) + "…"
+			}
+			fmt.Printf("%5d  %s  %-30s %s%s%s\n", id, fromMillis(created).Format("2006-01-02 15:04"),
+				providerName+"/"+modelName, prompt, partialLabel(partial), tagLabel(tags[int64(id)]))
+			count++
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if count == 0 && f.tag != "" {
+			fmt.Printf("No history entries tagged '%s'\n", f.tag)
+		} else if count == 0 {
+			fmt.Println("No history yet. One-shot answers (ask <prompt>) are recorded here.")
+		}
+		return nil
+
+	case "show":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: ask history show <id>")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid history id '%s'", args[0])
+		}
+		var providerName, modelName, prompt, response string
+		var latency, created int64
+		var partial bool
+		err = db.QueryRow("SELECT provider, model, prompt, response, latency_ms, partial, created_at FROM history WHERE id = ?", id).
+			Scan(&providerName, &modelName, &prompt, &response, &latency, &partial, &created)
+		if err != nil {
+			return fmt.Errorf("history entry %d not found", id)
+		}
+
+		if config, err := LoadConfigSafe(); err == nil {
+			markdownTheme = config.Theme
+		}
+		tags, err := readTags(db, tagHistory)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s#%d  %s  %s/%s  %s%s%s%s\n\n", dim, id, fromMillis(created).Format("2006-01-02 15:04"),
+			providerName, modelName, (time.Duration(latency) * time.Millisecond).Round(100*time.Millisecond), reset,
+			partialLabel(partial), tagLabel(tags[int64(id)]))
+		fmt.Printf("%s%s› %s%s\n", bold, cyan, reset, prompt)
+		if err := renderMarkdown(response); err != nil {
+			fmt.Println(response)
+		}
+		return nil
+
+	case "search":
+		limit, tag := 20, ""
+		fs := flag.NewFlagSet("history search", flag.ContinueOnError)
+		fs.IntVar(&limit, "limit", 20, "Show at most `N` session messages and N history entries")
+		fs.StringVar(&tag, "tag", "", "Only sessions and entries with this `tag`")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			return fmt.Errorf("usage: ask history search [--tag tag] <text>")
+		}
+		return printStoredMatches(strings.Join(fs.Args(), " "), strings.ToLower(tag), limit)
+
+	case "export":
+		format, source, output := "jsonl", "all", ""
+		f, _, err := parseHistoryFlags("history "+action, args, func(fs *flag.FlagSet) {
+			fs.StringVar(&format, "format", "jsonl", "Output `format`: jsonl or markdown")
+			fs.StringVar(&source, "source", "all", "What to export: all, sessions, or history")
+			fs.StringVar(&output, "o", "", "Write to `file` instead of standard output")
+		})
+		if err != nil {
+			return err
+		}
+		return exportHistory(f, format, source, output)
+
+	case "fav":
+		if len(args) == 0 {
+			favs, err := listFavorites()
+			if err != nil {
+				return err
+			}
+			if len(favs) == 0 {
+				fmt.Println("No favorites yet. Add one with 'ask history fav <id>'.")
+			}
+			for _, f := range favs {
+				fmt.Printf("%5d  %s\n", f.id, favoritePreview(f.prompt, 90))
+			}
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("usage: ask history fav [id]")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid history id '%s'", args[0])
+		}
+		var prompt string
+		if err := db.QueryRow("SELECT prompt FROM history WHERE id = ?", id).Scan(&prompt); err != nil {
+			return fmt.Errorf("history entry %d not found", id)
+		}
+		favID, err := addFavorite(prompt)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Saved history entry %d as favorite %d (recall with ask --fav)\n", id, favID)
+		return nil
+
+	case "unfav":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: ask history unfav <favorite id>")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid favorite id '%s'", args[0])
+		}
+		if err := removeFavorite(int64(id)); err != nil {
+			return err
+		}
+		fmt.Printf("Removed favorite %d\n", id)
+		return nil
+
+	case "pin":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: ask history pin <id>")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid history id '%s'", args[0])
+		}
+		pinID, err := pinHistoryEntry(id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Pinned history entry %d as %d (see ask pins)\n", id, pinID)
+		return nil
+
+	case "purge":
+		olderThan, yes := "", false
+		fs := flag.NewFlagSet("history purge", flag.ContinueOnError)
+		fs.StringVar(&olderThan, "older-than", "", "Delete sessions and entries older than `AGE` (90d) or a date (2006-01-02)")
+		fs.BoolVar(&yes, "y", false, "Don't ask for confirmation")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if olderThan == "" {
+			if config, err := LoadConfigSafe(); err == nil && config.HistoryRetentionDays > 0 {
+				olderThan = fmt.Sprintf("%dd", config.HistoryRetentionDays)
+			} else {
+				return fmt.Errorf("usage: ask history purge --older-than <90d|date> [-y]")
+			}
+		}
+		cutoff, err := parseHistoryDate(olderThan)
+		if err != nil {
+			return err
+		}
+		if !yes {
+			fmt.Printf("Delete sessions and history from before %s? [y/N]: ", cutoff.Format("2006-01-02 15:04"))
+			scanner := bufio.NewScanner(os.Stdin)
+			answer := ""
+			if scanner.Scan() {
+				answer = strings.ToLower(strings.TrimSpace(scanner.Text()))
+			}
+			if answer != "y" && answer != "yes" {
+				fmt.Println("Cancelled")
+				return nil
+			}
+		}
+		history, sessions, err := purgeOlderThan(cutoff)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d history entries and %d sessions\n", history, sessions)
+		return nil
+
+	case "tag", "untag":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: ask history %s <id> <tag>...", action)
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid history id '%s'", args[0])
+		}
+		tags, err := normalizeTags(args[1:])
+		if err != nil {
+			return err
+		}
+		tags, err = tagHistoryEntry(int64(id), tags, action == "untag")
+		if err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			fmt.Printf("History entry %d has no tags\n", id)
+			return nil
+		}
+		fmt.Printf("History entry %d tagged #%s\n", id, strings.Join(tags, " #"))
+		return nil
+
+	case "delete":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: ask history delete <id>")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid history id '%s'", args[0])
+		}
+		res, err := db.Exec("DELETE FROM history WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("history entry %d not found", id)
+		}
+		fmt.Printf("Deleted history entry %d\n", id)
+		return nil
+
+	case "clear":
+		yes := false
+		f, _, err := parseHistoryFlags("history "+action, args, func(fs *flag.FlagSet) {
+			fs.BoolVar(&yes, "y", false, "Don't ask for confirmation")
+		})
+		if err != nil {
+			return err
+		}
+		where, params := f.whereTagged(tagHistory, "created_at")
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM history "+where, params...).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			fmt.Println("No matching history entries")
+			return nil
+		}
+		if !yes {
+			fmt.Printf("Delete %d history entries? [y/N]: ", count)
+			scanner := bufio.NewScanner(os.Stdin)
+			answer := ""
+			if scanner.Scan() {
+				answer = strings.ToLower(strings.TrimSpace(scanner.Text()))
+			}
+			if answer != "y" && answer != "yes" {
+				fmt.Println("Cancelled")
+				return nil
+			}
+		}
+		if _, err := db.Exec("DELETE FROM history "+where, params...); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d history entries\n", count)
+		return nil
+	}
+
+	return fmt.Errorf("unknown history command '%s' (list, show, search, export, fav, unfav, pin, tag, untag, delete, clear, purge)", action)
+}
+
+// exportHistory writes saved sessions and one-shot answers matching the
+// filter for `ask history export`
+func exportHistory(f historyFilter, format, source, output string) error {
+	var convs []conversation
+	if source == "all" || source == "sessions" {
+		sessions, err := readSessionConversations(f)
+		if err != nil {
+			return err
+		}
+		convs = append(convs, sessions...)
+	}
+	if source == "all" || source == "history" {
+		history, err := readHistoryConversations(f)
+		if err != nil {
+			return err
+		}
+		convs = append(convs, history...)
+	}
+	if source != "all" && source != "sessions" && source != "history" {
+		return fmt.Errorf("unknown source '%s' (use all, sessions, or history)", source)
+	}
+
+	w := os.Stdout
+	if output != "" {
+		file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := exportConversations(w, convs, format); err != nil {
+		return err
+	}
+	if output != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d conversations to %s\n", len(convs), output)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Package main provides --on-complete-url and --on-complete-cmd, which
+// report the end of a long job such as `ask agent` or `ask eval` so it can
+// be left running unattended.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// jobHookTimeout bounds the webhook request and the hook command
+const jobHookTimeout = 30 * time.Second
+
+// jobOutcome is set by a job to a one-line account of what it did, such as
+// "11 of 12 runs passed", for the completion report
+var jobOutcome string
+
+// jobHook is where the end of a job is reported
+type jobHook struct {
+	url     string // POSTed the summary as JSON
+	command string // run with the summary as JSON on stdin
+}
+
+// jobSummary is what a job hook receives
+type jobSummary struct {
+	Job        string   `json:"job"`
+	Args       []string `json:"args"`
+	Status     string   `json:"status"` // ok or failed
+	Error      string   `json:"error,omitempty"`
+	Outcome    string   `json:"outcome,omitempty"`
+	Text       string   `json:"text"` // one line for people; Slack shows this
+	Dir        string   `json:"dir"`
+	Host       string   `json:"host"`
+	Started    string   `json:"started"`
+	Finished   string   `json:"finished"`
+	DurationMS int64    `json:"duration_ms"`
+}
+
+// extractJobHook removes --on-complete-url and --on-complete-cmd from a
+// job's arguments
+func extractJobHook(args []string) ([]string, jobHook, error) {
+	var hook jobHook
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || (name != "on-complete-url" && name != "on-complete-cmd") {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return nil, hook, fmt.Errorf("--%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "on-complete-url" {
+			if !strings.HasPrefix(value, "https://") && !strings.HasPrefix(value, "http://") {
+				return nil, hook, fmt.Errorf("--on-complete-url must be an http or https URL")
+			}
+			hook.url = value
+		} else {
+			hook.command = value
+		}
+	}
+	return rest, hook, nil
+}
+
+// runJob runs a long subcommand, reports how it ended to the hooks given on
+// its command line, and exits
+func runJob(name string, args []string, run func([]string) error) {
+	args, hook, err := extractJobHook(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+		os.Exit(1)
+	}
+	start := time.Now()
+	err = run(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+	}
+	if hook.url != "" || hook.command != "" {
+		countEvent("job:hook")
+		if herr := hook.report(newJobSummary(name, args, start, err)); herr != nil {
+			fmt.Fprintf(os.Stderr, "[!] Could not report the end of the job: %v\n", herr)
+		}
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// newJobSummary describes a finished job
+func newJobSummary(name string, args []string, start time.Time, err error) jobSummary {
+	end := time.Now()
+	s := jobSummary{
+		Job:        name,
+		Args:       args,
+		Status:     "ok",
+		Outcome:    jobOutcome,
+		Started:    start.UTC().Format(time.RFC3339),
+		Finished:   end.UTC().Format(time.RFC3339),
+		DurationMS: end.Sub(start).Milliseconds(),
+	}
+	if s.Args == nil {
+		s.Args = []string{}
+	}
+	s.Dir, _ = os.Getwd()
+	s.Host, _ = os.Hostname()
+	if err != nil {
+		s.Status, s.Error = "failed", err.Error()
+	}
+	took := end.Sub(start).Round(time.Second)
+	switch {
+	case err != nil:
+		s.Text = fmt.Sprintf("%s %s failed after %s: %s", AppName, name, took, s.Error)
+	case s.Outcome != "":
+		s.Text = fmt.Sprintf("%s %s finished in %s: %s", AppName, name, took, s.Outcome)
+	default:
+		s.Text = fmt.Sprintf("%s %s finished in %s", AppName, name, took)
+	}
+	return s
+}
+
+// report sends the summary to the webhook and the hook command
+func (h jobHook) report(s jobSummary) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), jobHookTimeout)
+	defer cancel()
+
+	var errs []string
+	if h.url != "" {
+		if err := postJobSummary(ctx, h.url, body); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if h.command != "" {
+		cmd := exec.CommandContext(ctx, "sh", "-c", h.command)
+		cmd.Stdin = bytes.NewReader(body)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		cmd.Env = append(os.Environ(), "ASK_JOB="+s.Job, "ASK_JOB_STATUS="+s.Status)
+		if err := cmd.Run(); err != nil {
+			errs = append(errs, "--on-complete-cmd: "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// postJobSummary posts the JSON summary to a webhook
+func postJobSummary(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", AppName+"/"+Version)
+	resp, err := provider.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
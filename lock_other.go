@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "os"
+
+// lockFile is unsupported on this platform; an encrypted database should
+// not be used by two ask processes at once
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+// unlockFile releases the lock taken by lockFile
+func unlockFile(f *os.File) error {
+	return nil
+}
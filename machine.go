@@ -0,0 +1,298 @@
+// Package main provides --machine, a strict one-shot mode for cron jobs and
+// scripts: no colors, spinners, rendering, or prompts, and exit codes that
+// say what went wrong.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// Exit codes in machine mode
+const (
+	exitOK          = 0
+	exitError       = 1 // anything not covered below
+	exitUsage       = 2 // bad flags or arguments
+	exitConfig      = 3 // missing or invalid config
+	exitAuth        = 4 // the provider rejected the API key
+	exitRateLimited = 5 // the provider is rate limiting requests
+	exitBudget      = 6 // over a blocking budget, or out of provider credits
+	exitProvider    = 7 // the request failed for another reason
+	exitRefused     = 8 // not sent because the prompt contains secrets
+	exitNotify      = 9 // the answer was printed but --notify failed
+)
+
+// machineMode is set by --machine; code that would prompt fails instead
+var machineMode bool
+
+// machineError is a failure with its exit code
+type machineError struct {
+	code int
+	err  error
+}
+
+func (e *machineError) Error() string { return e.err.Error() }
+
+// fail wraps err with an exit code
+func fail(code int, format string, args ...any) *machineError {
+	return &machineError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// oneShotResult is the answer to a one-shot prompt, as printed by --json
+// and given to --format templates
+type oneShotResult struct {
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Prompt           string  `json:"prompt"`
+	Response         string  `json:"response"`
+	Cached           bool    `json:"cached"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd,omitempty"`
+	LatencyMS        int64   `json:"latency_ms"`
+	FirstTokenMS     int64   `json:"first_token_ms,omitempty"`
+}
+
+// machineOptions are the main flags machine mode honors
+type machineOptions struct {
+	providerFlag, modelFlag, profileFlag string
+	noCache, timing, json                bool
+	notify, format, tee                  string
+	piped                                *pipeContext // from --with-context
+	emit                                 bool         // --emit-context
+}
+
+// formatFuncs are available in --format templates besides the builtins
+var formatFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// parseFormat compiles a --format template and tries it on an empty result,
+// so a misspelled field fails before anything is sent
+func parseFormat(text string) (*template.Template, error) {
+	t, err := template.New("format").Funcs(formatFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(io.Discard, &oneShotResult{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// runMachine answers one prompt and exits. Output is the raw answer, the
+// --format template applied to the result, or with --json a single JSON
+// object: the result, or {"error", "code"}.
+func runMachine(opts machineOptions, prompt string) {
+	var format *template.Template
+	if opts.format != "" {
+		var err error
+		if opts.json {
+			err = fmt.Errorf("use either --json or --format")
+		} else if format, err = parseFormat(opts.format); err != nil {
+			err = fmt.Errorf("--format: %v", err)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ask: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	result, err := machineOneShot(opts, prompt)
+	code := exitOK
+	if err != nil {
+		code = exitError
+		var me *machineError
+		if errors.As(err, &me) {
+			code = me.code
+		}
+	}
+
+	// A failed --notify still has an answer to print
+	switch {
+	case opts.json && result != nil:
+		json.NewEncoder(os.Stdout).Encode(result)
+	case opts.json:
+		json.NewEncoder(os.Stdout).Encode(map[string]any{"error": err.Error(), "code": code})
+	case result == nil:
+		fmt.Fprintf(os.Stderr, "ask: %v\n", err)
+	case opts.emit:
+		if eerr := emitPipeContext(opts.piped, result.Provider, result.Model, result.Prompt, result.Response); eerr != nil {
+			fmt.Fprintf(os.Stderr, "ask: %v\n", eerr)
+			os.Exit(exitError)
+		}
+	case format != nil:
+		var b strings.Builder
+		if ferr := format.Execute(&b, result); ferr != nil {
+			fmt.Fprintf(os.Stderr, "ask: --format: %v\n", ferr)
+			os.Exit(exitUsage)
+		}
+		fmt.Print(b.String())
+		if !strings.HasSuffix(b.String(), "\n") {
+			fmt.Println()
+		}
+	default:
+		fmt.Print(result.Response)
+		if !strings.HasSuffix(result.Response, "\n") {
+			fmt.Println()
+		}
+		if opts.timing {
+			fmt.Fprintf(os.Stderr, "%s/%s total=%dms first_token=%dms cached=%t\n", result.Provider, result.Model, result.LatencyMS, result.FirstTokenMS, result.Cached)
+		}
+	}
+	os.Exit(code)
+}
+
+// machineOneShot is the one-shot path of main without its interactive
+// fallbacks
+func machineOneShot(opts machineOptions, prompt string) (*oneShotResult, error) {
+	if strings.TrimSpace(prompt) == "" {
+		return nil, fail(exitUsage, "no prompt given")
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, fail(exitConfig, "loading config: %v", err)
+	}
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		return nil, fail(exitConfig, "%v", err)
+	}
+	autoPurge(config)
+
+	hook := ""
+	if opts.notify != "" {
+		if hook, err = webhookURL(config, opts.notify); err != nil {
+			return nil, fail(exitConfig, "%v", err)
+		}
+	}
+	providerName, modelName, err := ResolveModelAndProvider(opts.providerFlag, opts.modelFlag, opts.profileFlag, config)
+	if err != nil {
+		return nil, fail(exitConfig, "%v", err)
+	}
+	pc, ok := config.Providers[providerName]
+	if !ok {
+		return nil, fail(exitConfig, "provider '%s' not found in config", providerName)
+	}
+	if isPlaceholderKey(pc.APIKey) {
+		return nil, fail(exitConfig, "placeholder API key for provider '%s'", providerName)
+	}
+	if modelName == "" {
+		modelName = pc.Model
+	}
+	if modelName == "" {
+		modelName = defaultModels[providerName]
+	}
+	p := createProvider(providerName, pc.APIKey, modelName)
+	if p == nil {
+		return nil, fail(exitConfig, "unknown provider: %s", providerName)
+	}
+
+	prompt, ok = screenOutgoing(prompt, func(string) bool { return false })
+	if !ok {
+		return nil, fail(exitRefused, "not sent: the prompt looks like it contains secrets or was flagged by moderation")
+	}
+	spec := providerName + "/" + modelName
+	msgs := append(opts.piped.messages(), provider.Message{Role: "user", Content: prompt})
+	result := &oneShotResult{Provider: providerName, Model: modelName, Prompt: prompt}
+
+	var key string
+	if config.Cache.Enabled && !opts.noCache {
+		key = cacheKey(providerName, modelName, provider.Options{}, msgs)
+		if response, _, ok := cachedAnswer(key, config.Cache.ttl()); ok {
+			countEvent("oneshot:cached")
+			result.Response, result.Cached = response, true
+			if opts.tee != "" {
+				if err := os.WriteFile(opts.tee, []byte(response), 0644); err != nil {
+					return result, fail(exitError, "--tee: %v", err)
+				}
+			}
+			return result, machineNotify(hook, spec, prompt, response)
+		}
+	}
+
+	// Warnings are for people; only a blocking budget matters here
+	if _, err := checkBudget(config.Budget, budgetExceeded); err != nil {
+		return nil, fail(exitBudget, "%v", err)
+	}
+
+	countEvent("oneshot")
+	var firstToken time.Time
+	out := &liveWriter{quiet: true, onFirst: func() { firstToken = time.Now() }}
+	tee, err := openTee(opts.tee)
+	if err != nil {
+		return nil, fail(exitError, "--tee: %v", err)
+	}
+	if tee != nil {
+		defer tee.Close()
+		out.tee = tee
+	}
+	start := time.Now()
+	if opts.piped != nil {
+		err = p.QueryStreamWithHistory(context.Background(), msgs, out)
+	} else {
+		err = p.QueryStream(context.Background(), prompt, out)
+	}
+	if err != nil {
+		countError(err)
+		code := exitProvider
+		switch {
+		case errors.Is(err, provider.ErrInvalidAPIKey):
+			code = exitAuth
+		case errors.Is(err, provider.ErrRateLimited):
+			code = exitRateLimited
+		case errors.Is(err, provider.ErrInsufficientCredits):
+			code = exitBudget
+		}
+		return nil, fail(code, "error querying %s: %v", spec, err)
+	}
+	if out.teeErr != nil {
+		fmt.Fprintf(os.Stderr, "ask: --tee: %v\n", out.teeErr)
+	}
+	latency := time.Since(start)
+	response := out.buf.String()
+	usage := newTurnUsage(spec, msgs, response, latency)
+	saveHistory(providerName, modelName, prompt, response, latency)
+	saveUsage("", usage)
+	if key != "" && response != "" {
+		storeAnswer(key, response, config.Cache.ttl())
+	}
+
+	result.Response = response
+	result.PromptTokens, result.CompletionTokens = usage.promptTokens, usage.completionTokens
+	result.CostUSD, _ = estimateCost(spec, usage.promptTokens, usage.completionTokens)
+	result.LatencyMS = latency.Milliseconds()
+	if !firstToken.IsZero() {
+		result.FirstTokenMS = firstToken.Sub(start).Milliseconds()
+	}
+	return result, machineNotify(hook, spec, prompt, response)
+}
+
+// machineNotify posts the answer for --notify. A failure still returns the
+// result, so the answer is printed before exiting with exitNotify.
+func machineNotify(hook, spec, prompt, response string) error {
+	if hook == "" {
+		return nil
+	}
+	countEvent("notify:webhook")
+	if err := postAnswer(hook, spec, prompt, response); err != nil {
+		fmt.Fprintf(os.Stderr, "ask: could not post the answer: %v\n", err)
+		return &machineError{code: exitNotify, err: err}
+	}
+	return nil
+}
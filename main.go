@@ -4,14 +4,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
-	"ask/provider"
+	"github.com/metolius25/ask/provider"
 
 	"github.com/charmbracelet/glamour"
+	"github.com/chzyer/readline"
 )
 
 // Default models for each provider (used as fallback)
@@ -36,11 +43,27 @@ func main() {
 	flag.StringVar(profileFlag, "P", "", "Profile (short for -profile)")
 
 	listModels := flag.Bool("list-models", false, "List available models for all providers")
+	refreshFlag := flag.Bool("refresh", false, "With --list-models, fetch model lists again instead of using the cache")
 	versionFlag := flag.Bool("version", false, "Show version information")
 	flag.BoolVar(versionFlag, "v", false, "Version (short for -version)")
 
 	sessionFlag := flag.Bool("session", false, "Start interactive session mode")
 	flag.BoolVar(sessionFlag, "s", false, "Session (short for -session)")
+	timingFlag := flag.Bool("timing", false, "After a one-shot answer, print time to first token, duration, and tokens/sec")
+
+	flag.BoolVar(&forceBudget, "force", false, "Send requests even when over a blocking monthly budget")
+	favFlag := flag.Bool("fav", false, "Pick a favorite prompt to send; words after the flags filter the list")
+	noCacheFlag := flag.Bool("no-cache", false, "Query the provider even when a cached answer exists")
+	machineFlag := flag.Bool("machine", false, "For scripts: plain output, no colors or prompts, and distinct exit codes")
+	jsonFlag := flag.Bool("json", false, "Print the one-shot answer with usage and timing as JSON (implies --machine)")
+	formatFlag := flag.String("format", "", "Print the one-shot answer through a Go template, e.g. '{{.Model}}: {{.Response}}' (implies --machine)")
+	emitContextFlag := flag.Bool("emit-context", false, "Print the conversation as JSON for another ask reading it with --with-context")
+	withContextFlag := flag.Bool("with-context", false, "Continue the conversation piped from ask --emit-context")
+	speakFlag := flag.Bool("speak", false, "Read the answer aloud (say, espeak, or OpenAI speech; see voice in config)")
+	listenFlag := flag.Bool("listen", false, "Speak the prompt: record from the microphone until Enter and transcribe with Whisper")
+	notifyFlag := flag.String("notify", "", "Post the one-shot answer to a webhook named under notify.webhooks in config (e.g. slack)")
+	teeFlag := flag.String("tee", "", "Also write the raw one-shot answer to this file as it streams in")
+
 	// Keep -S for backwards compatibility
 	legacySessionFlag := flag.Bool("S", false, "Start interactive session mode (deprecated, use -s)")
 
@@ -57,13 +80,37 @@ func main() {
 		fmt.Println("  ask -m gpt-4o Write a haiku about Go")
 		fmt.Println("  ask -p claude Explain quantum computing")
 		fmt.Println("  ask -P fast Tell me a joke")
+		fmt.Println("  ask --timing -m gpt-4o-mini Hello  # Show latency and tokens/sec")
+		fmt.Println("  ask --no-cache Summarize this    # Skip the response cache")
+		fmt.Println("  ask --notify slack Summarize the nightly eval  # Also post the answer to Slack")
+		fmt.Println("  ask --tee notes.md Write a long design doc    # Save the answer as it streams")
 		fmt.Println("  ask -s  # Start interactive session mode")
+		fmt.Println("  ask -s --resume            # Pick a saved session to resume")
+		fmt.Println("  ask -s --resume api-design # Resume a saved session by name")
+		fmt.Println("  ask history list --since 7d --model gpt  # Past one-shot answers")
+		fmt.Println("  ask history show 12")
+		fmt.Println("  ask history fav 12     # Save a prompt, then recall it with: ask --fav")
+		fmt.Println("  ask history pin 12     # Bookmark an answer; list them with: ask pins")
+		fmt.Println("  ask replay api-design -m claude  # Rerun a session's questions with another model")
+		fmt.Println("  ask usage --since 30d --by provider  # Tokens and estimated cost")
+		fmt.Println("  ask commit           # Draft a commit message for the staged changes")
+		fmt.Println("  ask git install-hooks  # Draft one whenever you run git commit")
+		fmt.Println("  ask daemon &         # Answer one-shot prompts faster from a warm daemon")
 		fmt.Println("  ask --list-models")
 		fmt.Println("  ask -v")
 		fmt.Println("  ask --config        # Configure all providers")
 		fmt.Println("  ask --config qwen   # Configure specific provider")
 	}
 
+	// `ask wipe` deletes all local data; checked first since it takes --config
+	if isWipeCommand(os.Args[1:]) {
+		if err := runWipeCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Handle --config BEFORE flag.Parse() to avoid parsing issues
 	for i, arg := range os.Args[1:] {
 		if arg == "--config" || arg == "-config" {
@@ -88,8 +135,198 @@ func main() {
 		}
 	}
 
+	// `ask history ...` manages past one-shot answers
+	if isHistoryCommand(os.Args[1:]) {
+		sub := "list"
+		if len(os.Args) > 2 {
+			sub = os.Args[2]
+		}
+		countEvent("history:" + sub)
+		if err := runHistoryCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask usage` reports requests and estimated cost
+	if isUsageCommand(os.Args[1:]) {
+		countEvent("usage")
+		if err := runUsageCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask pins` shows bookmarked answers
+	if isPinsCommand(os.Args[1:]) {
+		countEvent("pins")
+		if err := runPinsCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask replay` re-runs a saved conversation against another model
+	if isReplayCommand(os.Args[1:]) {
+		countEvent("replay")
+		if err := runReplayCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask audit` inspects and prunes audit records
+	if isAuditCommand(os.Args[1:]) {
+		if err := runAuditCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask sync` pushes and pulls the encrypted database
+	if isSyncCommand(os.Args[1:]) {
+		countEvent("sync")
+		if err := runSyncCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask daemon` keeps the config and connections warm for one-shot prompts
+	if isDaemonCommand(os.Args[1:]) {
+		countEvent("daemon")
+		if err := runDaemonCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask commit` drafts a commit message; `ask git` installs it as a hook
+	if isCommitCommand(os.Args[1:]) {
+		countEvent("commit")
+		if err := runCommitCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if isPRCommand(os.Args[1:]) {
+		countEvent("pr")
+		if err := runPRCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if isAgentCommand(os.Args[1:]) {
+		countEvent("agent")
+		runJob("agent", os.Args[2:], runAgentCommand)
+	}
+
+	if isEvalCommand(os.Args[1:]) {
+		countEvent("eval")
+		runJob("eval", os.Args[2:], runEvalCommand)
+	}
+
+	if isABTestCommand(os.Args[1:]) {
+		countEvent("abtest")
+		runJob("abtest", os.Args[2:], runABTestCommand)
+	}
+
+	if isBenchCommand(os.Args[1:]) {
+		countEvent("bench")
+		runJob("bench", os.Args[2:], runBenchCommand)
+	}
+
+	if isSgrepCommand(os.Args[1:]) {
+		countEvent("sgrep")
+		if err := runSgrepCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if isTranslateCommand(os.Args[1:]) {
+		countEvent("translate")
+		runJob("translate", os.Args[2:], runTranslateCommand)
+	}
+
+	if isSummarizeCommand(os.Args[1:]) {
+		countEvent("summarize")
+		if err := runSummarizeCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if isGitCommand(os.Args[1:]) {
+		countEvent("git:" + os.Args[2])
+		if err := runGitCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask mcp-serve` offers ask's models as tools over the Model Context Protocol
+	if isMCPCommand(os.Args[1:]) {
+		countEvent("mcp")
+		if err := runMCPCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask serve` runs an OpenAI-compatible endpoint
+	if isServeCommand(os.Args[1:]) {
+		countEvent("serve")
+		if err := runServeCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask db` encrypts or decrypts the database
+	if isDBCommand(os.Args[1:]) {
+		if err := runDBCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// `ask telemetry` shows or changes the opt-in usage telemetry
+	if isTelemetryCommand(os.Args[1:]) {
+		if err := runTelemetryCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Handle --resume BEFORE flag.Parse() since its argument is optional
+	resumeRequested, resumeArg := extractResumeArg()
+
 	flag.Parse()
 
+	// Count which flags are used (names only), if the user opted in
+	flag.Visit(func(f *flag.Flag) { countEvent("flag:" + f.Name) })
+	if resumeRequested {
+		countEvent("flag:resume")
+	}
+
 	// Handle version flag
 	if *versionFlag {
 		fmt.Printf("%s v%s\n", AppName, Version)
@@ -98,10 +335,85 @@ func main() {
 
 	// Handle list-models command
 	if *listModels {
-		printAvailableModels()
+		printAvailableModels(*refreshFlag)
 		os.Exit(0)
 	}
 
+	// Pick up the conversation piped from a previous ask, and its model
+	// unless one is given here
+	var piped *pipeContext
+	prompt := strings.Join(flag.Args(), " ")
+	if *withContextFlag || *emitContextFlag {
+		if *sessionFlag || *legacySessionFlag || resumeRequested || *favFlag {
+			fmt.Fprintln(os.Stderr, "[!] --emit-context and --with-context work with one-shot prompts only")
+			os.Exit(1)
+		}
+		if *jsonFlag || *formatFlag != "" {
+			fmt.Fprintln(os.Stderr, "[!] --emit-context and --with-context can't be used with --json or --format")
+			os.Exit(1)
+		}
+	}
+	if *withContextFlag {
+		var text string
+		var err error
+		if piped, text, err = readPipeContext(); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		if text != "" {
+			prompt = strings.TrimSpace(text + "\n\n" + prompt)
+		}
+		if *providerFlag == "" && *modelFlag == "" && *profileFlag == "" {
+			*modelFlag = piped.spec()
+		}
+	}
+
+	// Scripts get the strict one-shot path, which never prompts
+	if *machineFlag || *jsonFlag || *formatFlag != "" {
+		machineMode = true
+		if *sessionFlag || *legacySessionFlag || resumeRequested || *favFlag {
+			fmt.Fprintln(os.Stderr, "ask: --machine works with one-shot prompts only")
+			os.Exit(exitUsage)
+		}
+		if *speakFlag || *listenFlag {
+			fmt.Fprintln(os.Stderr, "ask: --speak and --listen can't be used with --machine")
+			os.Exit(exitUsage)
+		}
+		runMachine(machineOptions{
+			providerFlag: *providerFlag,
+			modelFlag:    *modelFlag,
+			profileFlag:  *profileFlag,
+			noCache:      *noCacheFlag,
+			timing:       *timingFlag,
+			json:         *jsonFlag,
+			notify:       *notifyFlag,
+			format:       *formatFlag,
+			piped:        piped,
+			emit:         *emitContextFlag,
+			tee:          *teeFlag,
+		}, prompt)
+	}
+
+	// A running `ask daemon` answers plain one-shot prompts without loading
+	// the config here
+	if !*sessionFlag && !*legacySessionFlag && !resumeRequested && !*favFlag && *notifyFlag == "" && !*withContextFlag && !*emitContextFlag && !*speakFlag && !*listenFlag && *teeFlag == "" && flag.NArg() > 0 {
+		handled, err := oneShotViaDaemon(daemonRequest{
+			Prompt:   strings.Join(flag.Args(), " "),
+			Provider: *providerFlag,
+			Model:    *modelFlag,
+			Profile:  *profileFlag,
+			NoCache:  *noCacheFlag,
+			Force:    forceBudget,
+		}, *timingFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		if handled {
+			return
+		}
+	}
+
 	// Load configuration
 	config, err := LoadConfig()
 	if err != nil {
@@ -119,6 +431,41 @@ func main() {
 		os.Exit(1)
 	}
 
+	markdownTheme = config.Theme
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := renderANSI("", renderWidth); err != nil {
+		fmt.Fprintf(os.Stderr, "[!] Invalid theme '%s': %v (using auto)\n", config.Theme, err)
+		markdownTheme = ""
+	}
+	autoPurge(config)
+	go uploadTelemetry()
+
+	// Find the session to resume so its model can be preselected
+	var resumed *SavedSession
+	if resumeRequested {
+		resumed, err = resolveResumeTarget(resumeArg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		if resumed == nil {
+			os.Exit(0)
+		}
+	} else if (*sessionFlag || *legacySessionFlag) && readline.IsTerminal(int(os.Stdin.Fd())) {
+		// Offer to bring back a session lost to a crash or dropped connection
+		resumed = offerRecovery()
+	}
+	if resumed != nil {
+		if *providerFlag == "" && *modelFlag == "" && *profileFlag == "" && resumed.Provider != "" {
+			*modelFlag = resumed.Provider + "/" + resumed.Model
+		}
+	}
+
 	// Resolve provider and model using the new resolver
 	selectedProvider, selectedModel, err := ResolveModelAndProvider(
 		*providerFlag, *modelFlag, *profileFlag, config,
@@ -160,72 +507,327 @@ func main() {
 	p := createProvider(selectedProvider, providerConfig.APIKey, selectedModel)
 	if p == nil {
 		fmt.Fprintf(os.Stderr, "Unknown provider: %s\n", selectedProvider)
-		fmt.Fprintf(os.Stderr, "Supported providers: gemini, claude, chatgpt, deepseek, mistral\n")
+		fmt.Fprintf(os.Stderr, "Supported providers: %s\n", strings.Join(provider.DefaultRegistry.Names(), ", "))
 		os.Exit(1)
 	}
 
+	// Check the webhook before spending anything on the answer
+	var webhook string
+	if *notifyFlag != "" {
+		if *sessionFlag || *legacySessionFlag || resumeRequested {
+			fmt.Fprintln(os.Stderr, "[!] --notify works with one-shot prompts, not sessions")
+			os.Exit(1)
+		}
+		if webhook, err = webhookURL(config, *notifyFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Handle session mode (support both -s and legacy -S)
-	if *sessionFlag || *legacySessionFlag {
-		if err := RunSessionREPL(p, selectedProvider, selectedModel); err != nil {
+	if *sessionFlag || *legacySessionFlag || resumeRequested {
+		if *listenFlag || *teeFlag != "" {
+			fmt.Fprintln(os.Stderr, "[!] --listen and --tee work with one-shot prompts, not sessions")
+			os.Exit(1)
+		}
+		speakAnswers = *speakFlag
+		countEvent("session")
+		if err := RunSessionREPL(p, selectedProvider, selectedModel, resumed); err != nil {
 			fmt.Fprintf(os.Stderr, "\n[!] Session error: %v\n", err)
 			os.Exit(1)
 		}
 		os.Exit(0)
 	}
 
-	// Get the prompt (everything after flags)
-	args := flag.Args()
-	if len(args) == 0 {
+	// Get the prompt (everything after flags and any piped text), or a favorite
+	if *favFlag {
+		fav, err := pickFavorite(prompt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		if fav == "" {
+			os.Exit(0)
+		}
+		prompt = fav
+	} else if *listenFlag {
+		heard, err := newVoice(config).listen()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		prompt = strings.TrimSpace(prompt + "\n\n" + heard)
+	} else if prompt == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
+	prompt, ok := screenOutgoing(prompt, confirmStdin)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Not sent.")
+		os.Exit(1)
+	}
+	spec := selectedProvider + "/" + selectedModel
+	msgs := append(piped.messages(), provider.Message{Role: "user", Content: prompt})
+	tee, err := openTee(*teeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[!] --tee: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeTee(tee)
+
+	// Identical requests reuse a cached answer; they cost nothing, so skip
+	// the budget check, history, and usage ledger
+	var key string
+	if config.Cache.Enabled && !*noCacheFlag {
+		key = cacheKey(selectedProvider, selectedModel, provider.Options{}, msgs)
+		if response, cachedAt, ok := cachedAnswer(key, config.Cache.ttl()); ok {
+			countEvent("oneshot:cached")
+			writeTee(tee, response)
+			printAnswer(response, *emitContextFlag, piped, selectedProvider, selectedModel, prompt)
+			if *timingFlag {
+				fmt.Fprintf(os.Stderr, "%s%s · cached %s ago%s\n", dim, spec, time.Since(cachedAt).Round(time.Second), reset)
+			}
+			if *speakFlag {
+				speakAnswer(config, response)
+			}
+			notifyWebhook(webhook, *notifyFlag, spec, prompt, response)
+			return
+		}
+	}
 
-	prompt := strings.Join(args, " ")
+	if _, err := checkBudget(config.Budget, budgetOK); err != nil {
+		fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+		os.Exit(1)
+	}
 
 	// Query the provider
-	var responseBuffer strings.Builder
-	if err := p.QueryStream(prompt, &responseBuffer); err != nil {
+	countEvent("oneshot")
+	var firstToken time.Time
+	out := &liveWriter{quiet: true, onFirst: func() { firstToken = time.Now() }}
+	if tee != nil {
+		out.tee = tee
+	}
+	// Ctrl+C stops the answer but keeps what arrived
+	ctx, stopInterrupt := signal.NotifyContext(context.Background(), os.Interrupt)
+	start := time.Now()
+	if piped != nil {
+		err = p.QueryStreamWithHistory(ctx, msgs, out)
+	} else {
+		err = p.QueryStream(ctx, prompt, out)
+	}
+	stopInterrupt()
+	if out.teeErr != nil {
+		fmt.Fprintf(os.Stderr, "[!] --tee: %v\n", out.teeErr)
+	}
+	if ctx.Err() != nil {
+		countError(context.Canceled)
+		if response := out.buf.String(); response != "" {
+			latency := time.Since(start)
+			savePartialHistory(selectedProvider, selectedModel, prompt, response, latency)
+			saveUsage("", newTurnUsage(spec, msgs, response, latency))
+			printAnswer(response, false, nil, selectedProvider, selectedModel, prompt)
+			fmt.Fprintf(os.Stderr, "%s⏹ Interrupted; the partial answer is saved in history%s\n", yellow, reset)
+		} else {
+			fmt.Fprintf(os.Stderr, "\n%s⏹ Interrupted%s\n", yellow, reset)
+		}
+		closeTee(tee)
+		os.Exit(130)
+	}
+	if err != nil {
+		countError(err)
 		fmt.Fprintf(os.Stderr, "\nError querying %s: %v\n", selectedProvider, err)
+		if errors.Is(err, provider.ErrInvalidAPIKey) {
+			fmt.Fprintln(os.Stderr, "Check the API key in your config.yaml")
+		}
+		closeTee(tee)
 		os.Exit(1)
 	}
 
 	// Render the markdown response
-	response := responseBuffer.String()
+	response := out.buf.String()
+	latency := time.Since(start)
+	saveHistory(selectedProvider, selectedModel, prompt, response, latency)
+	saveUsage("", newTurnUsage(spec, msgs, response, latency))
+	if key != "" && response != "" {
+		storeAnswer(key, response, config.Cache.ttl())
+	}
+	printAnswer(response, *emitContextFlag, piped, selectedProvider, selectedModel, prompt)
+
+	if *timingFlag {
+		printTiming(spec, response, start, firstToken, start.Add(latency))
+	}
+	if *speakFlag {
+		speakAnswer(config, response)
+	}
+	notifyWebhook(webhook, *notifyFlag, spec, prompt, response)
+}
+
+// printAnswer renders a one-shot answer, or with --emit-context writes the
+// conversation for the next ask in a pipe
+func printAnswer(response string, emit bool, piped *pipeContext, providerName, modelName, prompt string) {
+	if emit {
+		if err := emitPipeContext(piped, providerName, modelName, prompt, response); err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if err := renderMarkdown(response); err != nil {
 		fmt.Println(response)
 	}
 }
 
-// createProvider creates a provider instance
+// openTee creates the --tee file, or returns nil without one
+func openTee(path string) (*os.File, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+// writeTee writes a whole answer, such as a cached one, to the --tee file
+func writeTee(tee *os.File, response string) {
+	if tee == nil {
+		return
+	}
+	if _, err := tee.WriteString(response); err != nil {
+		fmt.Fprintf(os.Stderr, "[!] --tee: %v\n", err)
+	}
+}
+
+// closeTee closes the --tee file, reporting a failed final write
+func closeTee(tee *os.File) {
+	if tee == nil {
+		return
+	}
+	if err := tee.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		fmt.Fprintf(os.Stderr, "[!] --tee: %v\n", err)
+	}
+}
+
+// speakAnswer reads a one-shot answer aloud; Ctrl+C stops it
+func speakAnswer(config *Config, response string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := newVoice(config).speak(ctx, response); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "[!] --speak: %v\n", err)
+	}
+}
+
+// notifyWebhook posts a one-shot answer for --notify, exiting with an error
+// if that fails so scripts notice
+func notifyWebhook(hook, name, spec, prompt, response string) {
+	if hook == "" {
+		return
+	}
+	countEvent("notify:webhook")
+	if err := postAnswer(hook, spec, prompt, response); err != nil {
+		fmt.Fprintf(os.Stderr, "[!] Could not post the answer to %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// printTiming reports time to first token, total duration, and streaming
+// speed on stderr, so it stays out of piped output
+func printTiming(spec, response string, start, firstToken, end time.Time) {
+	tokens := estimateTokens(response)
+	timing := fmt.Sprintf("%s · total %s", spec, end.Sub(start).Round(10*time.Millisecond))
+	if !firstToken.IsZero() {
+		timing += fmt.Sprintf(" · first token %s", firstToken.Sub(start).Round(10*time.Millisecond))
+		if streaming := end.Sub(firstToken); streaming > 0 && tokens > 0 {
+			timing += fmt.Sprintf(" · ~%s tokens at %.0f tok/s", formatTokenCount(tokens), float64(tokens)/streaming.Seconds())
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s%s%s\n", dim, timing, reset)
+}
+
+// extractResumeArg removes --resume [name] from os.Args, reporting whether it
+// was present and the optional session name or list index that followed it
+func extractResumeArg() (bool, string) {
+	args := []string{os.Args[0]}
+	requested := false
+	value := ""
+
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		switch {
+		case arg == "--resume" || arg == "-resume":
+			requested = true
+			// Check if next arg exists and is not a flag
+			if i+1 < len(os.Args) && !strings.HasPrefix(os.Args[i+1], "-") {
+				value = os.Args[i+1]
+				i++
+			}
+		case strings.HasPrefix(arg, "--resume=") || strings.HasPrefix(arg, "-resume="):
+			requested = true
+			value = arg[strings.Index(arg, "=")+1:]
+		default:
+			args = append(args, arg)
+		}
+	}
+
+	os.Args = args
+	return requested, value
+}
+
+// createProvider creates a provider instance, logging its requests when a
+// log file is configured
 func createProvider(name, apiKey, model string) provider.Provider {
-	switch name {
-	case "gemini":
-		return provider.NewGeminiProvider(apiKey, model)
-	case "claude":
-		return provider.NewClaudeProvider(apiKey, model)
-	case "chatgpt":
-		return provider.NewChatGPTProvider(apiKey, model)
-	case "deepseek":
-		return provider.NewDeepSeekProvider(apiKey, model)
-	case "mistral":
-		return provider.NewMistralProvider(apiKey, model)
-	case "qwen":
-		return provider.NewQwenProvider(apiKey, model)
-	default:
+	p, err := provider.New(name, apiKey, model)
+	if err != nil {
 		return nil
 	}
+	return withRequestLog(p, name, model)
 }
 
-func renderMarkdown(content string) error {
-	r, err := glamour.NewTermRenderer(
-		glamour.WithAutoStyle(),
-		glamour.WithWordWrap(100),
-	)
-	if err != nil {
-		return err
+// markdownTheme is the configured theme for rendered answers
+var markdownTheme string
+
+// renderWidth is the column rendered answers wrap at
+const renderWidth = 100
+
+// cachedRenderer is kept between answers, since building a renderer is
+// slow: it compiles the style and, for auto, asks the terminal for its
+// background. It is rebuilt when the theme or width changes.
+var cachedRenderer struct {
+	sync.Mutex
+	key string
+	r   *glamour.TermRenderer
+}
+
+// renderANSI renders markdown for the terminal with the configured theme
+func renderANSI(content string, width int) (string, error) {
+	cachedRenderer.Lock()
+	defer cachedRenderer.Unlock()
+	key := fmt.Sprintf("%s\x00%d", markdownTheme, width)
+	if cachedRenderer.r == nil || cachedRenderer.key != key {
+		r, err := glamour.NewTermRenderer(markdownStyle(), glamour.WithWordWrap(width))
+		if err != nil {
+			return "", err
+		}
+		cachedRenderer.key, cachedRenderer.r = key, r
+	}
+	return cachedRenderer.r.Render(content)
+}
+
+// markdownStyle returns the glamour style for the configured theme. "auto"
+// (the default) picks dark or light from the terminal background; other
+// values are a built-in style name or a path to a JSON style file.
+func markdownStyle() glamour.TermRendererOption {
+	theme := markdownTheme
+	if theme == "" || strings.EqualFold(theme, "auto") {
+		return glamour.WithAutoStyle()
+	}
+	if strings.HasPrefix(theme, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			theme = filepath.Join(homeDir, theme[2:])
+		}
 	}
+	return glamour.WithStylePath(theme)
+}
 
-	out, err := r.Render(content)
+func renderMarkdown(content string) error {
+	out, err := renderANSI(content, renderWidth)
 	if err != nil {
 		return err
 	}
@@ -274,7 +876,17 @@ func printQuickHelp() {
 	fmt.Println()
 }
 
-func printAvailableModels() {
+// listModelsTimeout is how long --list-models waits for each provider
+const listModelsTimeout = 10 * time.Second
+
+// modelListing is the outcome of fetching one provider's models
+type modelListing struct {
+	name   string
+	models []provider.ModelInfo
+	status string // shown after the name when the list is not live
+}
+
+func printAvailableModels(refresh bool) {
 	// Try to load config, but don't require it
 	config, err := LoadConfig()
 
@@ -291,62 +903,50 @@ func printAvailableModels() {
 	fmt.Println("  Fetching Available Models...")
 	fmt.Println()
 
-	providers := []struct {
-		name string
-		key  string
-	}{
-		{"gemini", "gemini"},
-		{"claude", "claude"},
-		{"chatgpt", "chatgpt"},
-		{"deepseek", "deepseek"},
-		{"mistral", "mistral"},
-	}
+	providers := []string{"gemini", "claude", "chatgpt", "deepseek", "mistral"}
 
-	for _, p := range providers {
-		// Check if provider is configured
-		providerConfig, exists := config.Providers[p.name]
+	// Fetch configured providers concurrently, each with its own timeout,
+	// so one slow provider doesn't hold up the others
+	results := make(chan modelListing, len(providers))
+	pending := 0
+	for _, name := range providers {
+		providerConfig, exists := config.Providers[name]
 		if !exists || providerConfig.APIKey == "" || isPlaceholderKey(providerConfig.APIKey) {
-			fmt.Printf("[>] %s (not configured)\n", strings.ToUpper(p.name))
-
 			// Show fallback models from the provider's own implementation
-			prov := createProvider(p.name, "", "")
-			if prov != nil {
-				models, _ := prov.ListModels()
-				for _, model := range models {
-					fmt.Printf("   • %s\n", model.ID)
-				}
-			}
-			fmt.Println()
+			printModelListing(modelListing{name: name, models: provider.FallbackModels(name), status: "not configured"})
 			continue
 		}
-
-		// Create provider instance
-		prov := createProvider(p.name, providerConfig.APIKey, "")
+		prov := createProvider(name, providerConfig.APIKey, "")
 		if prov == nil {
 			continue
 		}
 
-		// Fetch models
-		models, err := prov.ListModels()
-		if err != nil || len(models) == 0 {
-			fmt.Printf("[>] %s (API error - showing defaults)\n", strings.ToUpper(p.name))
-			fmt.Println()
-			continue
-		}
-
-		fmt.Printf("[>] %s ✓\n", strings.ToUpper(p.name))
-		for _, model := range models {
-			modelID := model.ID
-			// Clean up Gemini model names
-			modelID = strings.TrimPrefix(modelID, "models/")
-
-			if model.Description != "" {
-				fmt.Printf("   • %s - %s\n", modelID, model.Description)
-			} else {
-				fmt.Printf("   • %s\n", modelID)
+		pending++
+		go func(name, apiKey string, prov provider.Provider) {
+			done := make(chan modelListing, 1)
+			ctx, cancel := context.WithTimeout(context.Background(), listModelsTimeout)
+			defer cancel()
+			go func() {
+				// Fetch models, or reuse them from the last day
+				models, err := listModelsCached(ctx, name, apiKey, prov, refresh)
+				if err != nil || len(models) == 0 {
+					done <- modelListing{name: name, models: provider.FallbackModels(name), status: "API error - showing defaults"}
+					return
+				}
+				done <- modelListing{name: name, models: models}
+			}()
+			select {
+			case r := <-done:
+				results <- r
+			case <-ctx.Done():
+				results <- modelListing{name: name, models: provider.FallbackModels(name), status: "timed out - showing defaults"}
 			}
-		}
-		fmt.Println()
+		}(name, providerConfig.APIKey, prov)
+	}
+
+	// Print each provider as soon as its models arrive
+	for ; pending > 0; pending-- {
+		printModelListing(<-results)
 	}
 
 	fmt.Println("Usage:")
@@ -354,16 +954,30 @@ func printAvailableModels() {
 	fmt.Println("  ask -m gemini/gemini-2.5-pro Explain AI")
 }
 
+// printModelListing prints one provider's models for --list-models
+func printModelListing(r modelListing) {
+	if r.status != "" {
+		fmt.Printf("[>] %s (%s)\n", strings.ToUpper(r.name), r.status)
+	} else {
+		fmt.Printf("[>] %s ✓\n", strings.ToUpper(r.name))
+	}
+	for _, model := range r.models {
+		// Clean up Gemini model names
+		modelID := strings.TrimPrefix(model.ID, "models/")
+		if model.Description != "" && r.status == "" {
+			fmt.Printf("   • %s - %s\n", modelID, model.Description)
+		} else {
+			fmt.Printf("   • %s\n", modelID)
+		}
+	}
+	fmt.Println()
+}
+
 func printFallbackModels() {
 	providers := []string{"gemini", "claude", "chatgpt", "deepseek", "mistral"}
 
 	for _, name := range providers {
-		prov := createProvider(name, "", "")
-		if prov == nil {
-			continue
-		}
-
-		models, _ := prov.ListModels()
+		models := provider.FallbackModels(name)
 		fmt.Printf("[>] %s\n", strings.ToUpper(name))
 		for _, model := range models {
 			fmt.Printf("   • %s\n", model.ID)
@@ -0,0 +1,322 @@
+// Package main provides `ask mcp-serve`, a Model Context Protocol server on
+// stdin and stdout that lets editors and agents query models through ask.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// mcpProtocolVersion is the MCP revision ask implements
+const mcpProtocolVersion = "2024-11-05"
+
+// isMCPCommand reports whether the arguments invoke `ask mcp-serve`
+func isMCPCommand(args []string) bool {
+	return len(args) > 0 && args[0] == "mcp-serve"
+}
+
+// rpcMessage is a JSON-RPC 2.0 request, notification, or response
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is a JSON-RPC error
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpTool describes a tool to the client
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// mcpTools are the tools ask offers
+var mcpTools = []mcpTool{
+	{
+		Name:        "ask_llm",
+		Description: "Send a prompt to a language model configured in ask and return its answer",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"prompt": map[string]any{"type": "string", "description": "The question or instruction"},
+				"model":  map[string]any{"type": "string", "description": "A profile, model name, or provider/model; the default model when omitted"},
+				"system": map[string]any{"type": "string", "description": "Optional system prompt"},
+			},
+			"required": []string{"prompt"},
+		},
+	},
+	{
+		Name:        "list_models",
+		Description: "List the profiles and models available through ask",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"provider": map[string]any{"type": "string", "description": "Only list this provider's models"},
+			},
+		},
+	},
+	{
+		Name:        "search_history",
+		Description: "Full-text search of the user's saved ask sessions and one-shot history",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{"type": "string", "description": "Words that must all appear"},
+				"tag":   map[string]any{"type": "string", "description": "Only entries with this tag"},
+				"limit": map[string]any{"type": "integer", "description": "Most results of each kind, default 10"},
+			},
+			"required": []string{"query"},
+		},
+	},
+}
+
+// runMCPCommand answers MCP requests on stdin until it closes. Diagnostics
+// go to stderr; stdout carries only protocol messages.
+func runMCPCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: ask mcp-serve")
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		return err
+	}
+	providerName, modelName, err := ResolveModelAndProvider("", "", "", config)
+	if err != nil {
+		return err
+	}
+	if modelName == "" {
+		modelName = defaultModels[providerName]
+	}
+	s := &server{config: config, spec: providerName + "/" + modelName}
+
+	in := bufio.NewReader(os.Stdin)
+	out := json.NewEncoder(os.Stdout)
+	for {
+		line, err := in.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var msg rpcMessage
+			if jerr := json.Unmarshal(line, &msg); jerr != nil {
+				out.Encode(rpcMessage{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{-32700, "parse error"}})
+			} else if reply := s.handleMCP(&msg); reply != nil {
+				out.Encode(reply)
+			}
+		}
+		if err != nil {
+			return nil // the client closed stdin
+		}
+	}
+}
+
+// handleMCP answers one message, or returns nil for notifications
+func (s *server) handleMCP(msg *rpcMessage) *rpcMessage {
+	if msg.ID == nil {
+		return nil
+	}
+	reply := &rpcMessage{JSONRPC: "2.0", ID: msg.ID}
+	switch msg.Method {
+	case "initialize":
+		reply.Result = map[string]any{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": AppName, "version": Version},
+		}
+	case "ping":
+		reply.Result = map[string]any{}
+	case "tools/list":
+		reply.Result = map[string]any{"tools": mcpTools}
+	case "tools/call":
+		var call struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(msg.Params, &call); err != nil {
+			reply.Error = &rpcError{-32602, "invalid params"}
+			return reply
+		}
+		// Event names never hold client input, so unknown tools count as one
+		event := "mcp:unknown"
+		for _, tool := range mcpTools {
+			if tool.Name == call.Name {
+				event = "mcp:" + tool.Name
+			}
+		}
+		countEvent(event)
+		text, err := s.callTool(call.Name, call.Arguments)
+		if err != nil {
+			// Tool failures are results the model can read, not protocol errors
+			reply.Result = map[string]any{"content": []any{mcpText(err.Error())}, "isError": true}
+		} else {
+			reply.Result = map[string]any{"content": []any{mcpText(text)}}
+		}
+	default:
+		reply.Error = &rpcError{-32601, "method not found: " + msg.Method}
+	}
+	return reply
+}
+
+// mcpText is a text content block
+func mcpText(text string) map[string]any {
+	return map[string]any{"type": "text", "text": text}
+}
+
+// callTool runs a tool, returning its text output
+func (s *server) callTool(name string, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Prompt   string `json:"prompt"`
+		Model    string `json:"model"`
+		System   string `json:"system"`
+		Provider string `json:"provider"`
+		Query    string `json:"query"`
+		Tag      string `json:"tag"`
+		Limit    int    `json:"limit"`
+	}
+	if len(arguments) > 0 {
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %v", err)
+		}
+	}
+	switch name {
+	case "ask_llm":
+		return s.askLLM(args.Prompt, args.Model, args.System)
+	case "list_models":
+		return s.listModels(args.Provider), nil
+	case "search_history":
+		tag := ""
+		if args.Tag != "" {
+			var err error
+			if tag, err = normalizeTag(args.Tag); err != nil {
+				return "", err
+			}
+		}
+		return searchHistoryText(args.Query, tag, args.Limit)
+	}
+	return "", fmt.Errorf("unknown tool '%s'", name)
+}
+
+// askLLM sends one prompt, with the budget, secret screening, and usage
+// ledger of any other request
+func (s *server) askLLM(prompt, model, system string) (string, error) {
+	if strings.TrimSpace(prompt) == "" {
+		return "", fmt.Errorf("prompt is required")
+	}
+	providerName, modelName, err := s.resolve(model)
+	if err != nil {
+		return "", err
+	}
+	var msgs []provider.Message
+	for _, m := range []provider.Message{{Role: "system", Content: system}, {Role: "user", Content: prompt}} {
+		if m.Content == "" {
+			continue
+		}
+		content, ok := screenOutgoing(m.Content, func(string) bool { return false })
+		if !ok {
+			return "", fmt.Errorf("not sent: the prompt looks like it contains secrets and redact mode is block, or moderation blocked it")
+		}
+		msgs = append(msgs, provider.Message{Role: m.Role, Content: content})
+	}
+	if err := s.checkBudget(); err != nil {
+		return "", err
+	}
+	pc := s.config.Providers[providerName]
+	p := createProvider(providerName, pc.APIKey, modelName)
+	if p == nil {
+		return "", fmt.Errorf("unknown provider: %s", providerName)
+	}
+
+	spec := providerName + "/" + modelName
+	out := &liveWriter{quiet: true}
+	start := time.Now()
+	if err := p.QueryStreamWithHistory(context.Background(), msgs, out); err != nil {
+		countError(err)
+		return "", fmt.Errorf("error querying %s: %v", spec, err)
+	}
+	response := out.buf.String()
+	saveUsage("mcp", newTurnUsage(spec, msgs, response, time.Since(start)))
+	return response, nil
+}
+
+// listModels lists profiles and each configured provider's models
+func (s *server) listModels(only string) string {
+	var b strings.Builder
+	if only == "" && len(s.config.Profiles) > 0 {
+		b.WriteString("Profiles:\n")
+		for _, name := range slices.Sorted(maps.Keys(s.config.Profiles)) {
+			fmt.Fprintf(&b, "- %s (%s)\n", name, s.config.Profiles[name])
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.config.Providers)) {
+		pc := s.config.Providers[name]
+		if (only != "" && name != only) || isPlaceholderKey(pc.APIKey) {
+			continue
+		}
+		p := createProvider(name, pc.APIKey, pc.Model)
+		if p == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), listModelsTimeout)
+		models, err := listModelsCached(ctx, name, pc.APIKey, p, false)
+		cancel()
+		if err != nil || len(models) == 0 {
+			models = provider.FallbackModels(name)
+		}
+		fmt.Fprintf(&b, "%s:\n", name)
+		for _, m := range models {
+			id := strings.TrimPrefix(m.ID, "models/")
+			if m.Description != "" {
+				fmt.Fprintf(&b, "- %s/%s: %s\n", name, id, m.Description)
+			} else {
+				fmt.Fprintf(&b, "- %s/%s\n", name, id)
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return "No models found"
+	}
+	fmt.Fprintf(&b, "Default: %s\n", s.spec)
+	return b.String()
+}
+
+// searchHistoryText runs a history search, marking matches in bold
+func searchHistoryText(query, tag string, limit int) (string, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	sessions, history, err := searchStore(query, tag, limit)
+	if err != nil {
+		return "", err
+	}
+	if len(sessions) == 0 && len(history) == 0 {
+		return "No matches", nil
+	}
+	mark := strings.NewReplacer(bold+yellow, "**", reset, "**")
+	var b strings.Builder
+	for _, m := range sessions {
+		fmt.Fprintf(&b, "- Session %q, message %d (%s, %s): %s\n", m.session, m.id, m.role, m.time.Format("2006-01-02"), mark.Replace(m.snippet))
+	}
+	for _, m := range history {
+		fmt.Fprintf(&b, "- History #%d (%s, %s): %s\n", m.id, m.model, m.time.Format("2006-01-02"), mark.Replace(m.snippet))
+	}
+	return b.String(), nil
+}
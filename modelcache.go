@@ -0,0 +1,49 @@
+// Package main provides the on-disk cache of provider model lists.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// modelCacheTTL is how long fetched model lists are reused
+const modelCacheTTL = 24 * time.Hour
+
+// listModelsCached returns a provider's models from the cache when they
+// were fetched within modelCacheTTL with the same API key, and otherwise
+// fetches and caches them. refresh always fetches.
+func listModelsCached(ctx context.Context, name, apiKey string, prov provider.Provider, refresh bool) ([]provider.ModelInfo, error) {
+	sum := sha256.Sum256([]byte(apiKey))
+	keyHash := hex.EncodeToString(sum[:8])
+
+	db, dbErr := openStore()
+	if dbErr == nil && !refresh {
+		var data string
+		err := db.QueryRow("SELECT models FROM model_cache WHERE provider = ? AND key_hash = ? AND fetched_at >= ?",
+			name, keyHash, toMillis(time.Now().Add(-modelCacheTTL))).Scan(&data)
+		var models []provider.ModelInfo
+		if err == nil && json.Unmarshal([]byte(data), &models) == nil && len(models) > 0 {
+			return models, nil
+		}
+	}
+
+	models, err := prov.ListModels(ctx)
+	// Providers return their built-in list when the API fails; keep
+	// trying the API next time instead of caching it
+	if err != nil || len(models) == 0 || reflect.DeepEqual(models, provider.FallbackModels(name)) {
+		return models, err
+	}
+	if dbErr == nil {
+		if data, err := json.Marshal(models); err == nil {
+			db.Exec("INSERT OR REPLACE INTO model_cache (provider, key_hash, models, fetched_at) VALUES (?, ?, ?, ?)",
+				name, keyHash, string(data), toMillis(time.Now()))
+		}
+	}
+	return models, nil
+}
@@ -0,0 +1,164 @@
+// Package main provides the interactive model picker for session mode.
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// maxPickerRows limits how many models are listed at once
+const maxPickerRows = 30
+
+// modelChoice is a selectable provider/model pair
+type modelChoice struct {
+	provider string
+	model    string
+	desc     string
+}
+
+func (c modelChoice) spec() string {
+	return c.provider + "/" + c.model
+}
+
+// availableModels lists models for every configured provider, caching the
+// results for the lifetime of the session
+func (s *Session) availableModels() ([]modelChoice, error) {
+	config, err := LoadConfigSafe()
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+
+	var names []string
+	for _, p := range providerInfo {
+		pc, ok := config.Providers[p.name]
+		if ok && pc.APIKey != "" && !isPlaceholderKey(pc.APIKey) {
+			names = append(names, p.name)
+		}
+	}
+
+	// Fetch uncached providers concurrently
+	var wg sync.WaitGroup
+	for _, name := range names {
+		s.mu.Lock()
+		_, cached := s.modelCache[name]
+		s.mu.Unlock()
+		if cached {
+			continue
+		}
+
+		apiKey := config.Providers[name].APIKey
+		prov := createProvider(name, apiKey, "")
+		if prov == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, prov provider.Provider) {
+			defer wg.Done()
+			models, _ := listModelsCached(context.Background(), name, apiKey, prov, false)
+			s.mu.Lock()
+			if s.modelCache == nil {
+				s.modelCache = make(map[string][]provider.ModelInfo)
+			}
+			s.modelCache[name] = models
+			s.mu.Unlock()
+		}(name, prov)
+	}
+	wg.Wait()
+
+	var choices []modelChoice
+	s.mu.Lock()
+	for _, name := range names {
+		for _, m := range s.modelCache[name] {
+			choices = append(choices, modelChoice{
+				provider: name,
+				model:    strings.TrimPrefix(m.ID, "models/"),
+				desc:     m.Description,
+			})
+		}
+	}
+	s.mu.Unlock()
+	return choices, nil
+}
+
+// filterModels keeps choices whose provider/model contains every word of the filter
+func filterModels(choices []modelChoice, filter string) []modelChoice {
+	words := strings.Fields(strings.ToLower(filter))
+	if len(words) == 0 {
+		return choices
+	}
+
+	var matched []modelChoice
+	for _, c := range choices {
+		spec := strings.ToLower(c.spec())
+		ok := true
+		for _, w := range words {
+			if !strings.Contains(spec, w) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// pickModel shows a filterable, numbered list of models and returns the
+// chosen provider/model spec, or "" if the user cancels
+func (s *Session) pickModel() string {
+	fmt.Printf("\n%sFetching models...%s", dim, reset)
+	choices, err := s.availableModels()
+	fmt.Print(clearLine)
+	if err != nil {
+		fmt.Printf("%s✗ %v%s\n", red, err, reset)
+		return ""
+	}
+	if len(choices) == 0 {
+		fmt.Printf("%sNo configured providers. Run 'ask --config' to add one.%s\n", dim, reset)
+		return ""
+	}
+
+	current := s.providerName + "/" + s.modelName
+	filter := ""
+	for {
+		shown := filterModels(choices, filter)
+		if len(shown) == 0 {
+			fmt.Printf("%s  No models match '%s'%s\n", dim, filter, reset)
+		}
+		for i, c := range shown {
+			if i == maxPickerRows {
+				fmt.Printf("%s  ... %d more, type to filter%s\n", dim, len(shown)-maxPickerRows, reset)
+				break
+			}
+			marker := ""
+			if c.spec() == current {
+				marker = fmt.Sprintf(" %s(current)%s", green, reset)
+			}
+			fmt.Printf("  %s%3d.%s %s%s\n", dim, i+1, reset, c.spec(), marker)
+		}
+
+		line, ok := s.readLine(fmt.Sprintf("%s  Number to select, text to filter, Enter to cancel › %s", dim, reset))
+		if !ok {
+			return ""
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return ""
+		}
+		if num, err := strconv.Atoi(line); err == nil {
+			if num > 0 && num <= len(shown) {
+				return shown[num-1].spec()
+			}
+			fmt.Printf("%s✗ Pick a number between 1 and %d%s\n", red, len(shown), reset)
+			continue
+		}
+		filter = line
+		fmt.Println()
+	}
+}
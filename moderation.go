@@ -0,0 +1,262 @@
+// Package main provides the optional moderation check of outgoing prompts,
+// with OpenAI's moderation endpoint or a local classifier, for shared and
+// kiosk machines.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// ModerationConfig controls the check of prompts before they are sent
+type ModerationConfig struct {
+	Mode       string   `yaml:"mode,omitempty"`       // off (default), warn, or block
+	Backend    string   `yaml:"backend,omitempty"`    // openai (default) or command
+	Command    string   `yaml:"command,omitempty"`    // local classifier, for the command backend
+	Model      string   `yaml:"model,omitempty"`      // OpenAI moderation model, default omni-moderation-latest
+	Categories []string `yaml:"categories,omitempty"` // only these count; default all
+}
+
+const (
+	openAIModerationURL     = "https://api.openai.com/v1/moderations"
+	defaultModerationModel  = "omni-moderation-latest"
+	moderationTimeout       = 30 * time.Second
+	maxModerationChunkToken = 8000 // per input sent to OpenAI
+)
+
+// moderator checks outgoing text
+type moderator struct {
+	cfg    ModerationConfig
+	apiKey string // OpenAI key from the chatgpt provider
+}
+
+// outboundModeration is set from the moderation config; nil when it's off.
+// It is atomic because the daemon replaces it while requests run.
+var outboundModeration atomic.Pointer[moderator]
+
+// newModerator checks the moderation config. It returns nil when
+// moderation is off.
+func newModerator(config *Config) (*moderator, error) {
+	cfg := config.Moderation
+	switch cfg.Mode {
+	case "", "off":
+		return nil, nil
+	case "warn", "block":
+	default:
+		return nil, fmt.Errorf("unknown moderation mode '%s' (use off, warn, or block)", cfg.Mode)
+	}
+	m := &moderator{cfg: cfg}
+	switch cfg.Backend {
+	case "", "openai":
+		pc, ok := config.Providers["chatgpt"]
+		if !ok || isPlaceholderKey(pc.APIKey) {
+			return nil, fmt.Errorf("moderation uses OpenAI, but there is no chatgpt API key in config (or set moderation.backend: command)")
+		}
+		m.apiKey = pc.APIKey
+	case "command":
+		if strings.TrimSpace(cfg.Command) == "" {
+			return nil, fmt.Errorf("moderation.backend is command, but moderation.command is empty")
+		}
+	default:
+		return nil, fmt.Errorf("unknown moderation backend '%s' (use openai or command)", cfg.Backend)
+	}
+	return m, nil
+}
+
+// openScreening sets up the secret scanner and the moderator from config
+func openScreening(config *Config) error {
+	secrets, err := newSecretScanner(config.Redact)
+	if err != nil {
+		return err
+	}
+	m, err := newModerator(config)
+	if err != nil {
+		return err
+	}
+	outboundSecrets.Store(secrets)
+	outboundModeration.Store(m)
+	return nil
+}
+
+// screenOutgoing checks text about to be sent to a provider for secrets,
+// then with the moderator. It returns the text to send, or false to send
+// nothing.
+func screenOutgoing(text string, confirm func(prompt string) bool) (string, bool) {
+	text, ok := screenSecrets(text, confirm)
+	if !ok {
+		return "", false
+	}
+	return text, moderatePrompt(text)
+}
+
+// moderatePrompt reports whether text may be sent. In block mode flagged
+// text is refused, as is any text that couldn't be checked; in warn mode a
+// warning is shown and it is sent anyway.
+func moderatePrompt(text string) bool {
+	notice, ok := outboundModeration.Load().verdict(text)
+	if notice == "" {
+		return true
+	}
+	switch {
+	case machineMode:
+		fmt.Fprintf(os.Stderr, "ask: %s\n", notice)
+	case ok:
+		fmt.Fprintf(os.Stderr, "%s⚠ %s%s\n", yellow, notice, reset)
+	default:
+		fmt.Fprintf(os.Stderr, "%s%s⛔ %s%s\n", bold, red, notice, reset)
+	}
+	return ok
+}
+
+// verdict checks text and describes the result: an empty notice if it
+// passed, and whether it may be sent
+func (m *moderator) verdict(text string) (string, bool) {
+	if m == nil || strings.TrimSpace(text) == "" {
+		return "", true
+	}
+	block := m.cfg.Mode == "block"
+	ctx, cancel := context.WithTimeout(context.Background(), moderationTimeout)
+	defer cancel()
+	flagged, err := m.check(ctx, text)
+	switch {
+	case err != nil && block:
+		return fmt.Sprintf("Not sent: the moderation check failed: %v", err), false
+	case err != nil:
+		return fmt.Sprintf("The moderation check failed: %v", err), true
+	case len(flagged) == 0:
+		return "", true
+	case block:
+		return "Not sent: flagged by moderation for " + strings.Join(flagged, ", "), false
+	}
+	return "Flagged by moderation for " + strings.Join(flagged, ", "), true
+}
+
+// check returns the categories text is flagged for, limited to the
+// configured ones
+func (m *moderator) check(ctx context.Context, text string) ([]string, error) {
+	var found []string
+	var err error
+	if m.cfg.Backend == "command" {
+		found, err = m.checkCommand(ctx, text)
+	} else {
+		found, err = m.checkOpenAI(ctx, text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var flagged []string
+	for _, c := range found {
+		if (len(m.cfg.Categories) == 0 || slices.Contains(m.cfg.Categories, c)) && !slices.Contains(flagged, c) {
+			flagged = append(flagged, c)
+		}
+	}
+	sort.Strings(flagged)
+	return flagged, nil
+}
+
+// checkOpenAI sends text to OpenAI's moderation endpoint, in parts if it
+// is long
+func (m *moderator) checkOpenAI(ctx context.Context, text string) ([]string, error) {
+	model := m.cfg.Model
+	if model == "" {
+		model = defaultModerationModel
+	}
+	body, err := json.Marshal(map[string]any{"model": model, "input": chunkText(text, maxModerationChunkToken)})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", openAIModerationURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	req.Header.Set("User-Agent", AppName+"/"+Version)
+	resp, err := provider.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Results []struct {
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("unreadable response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if result.Error.Message != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, result.Error.Message)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	var flagged []string
+	for _, r := range result.Results {
+		for category, hit := range r.Categories {
+			if hit {
+				flagged = append(flagged, category)
+			}
+		}
+	}
+	return flagged, nil
+}
+
+// checkCommand runs the local classifier with text on its stdin. It prints
+// the flagged categories, one per line or as JSON like
+// {"flagged": true, "categories": ["violence"]}, and nothing if the text
+// is fine.
+func (m *moderator) checkCommand(ctx context.Context, text string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", m.cfg.Command)
+	cmd.Stdin = strings.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, lastLines(msg, 3))
+		}
+		return nil, err
+	}
+	output := strings.TrimSpace(string(out))
+	if strings.HasPrefix(output, "{") {
+		var verdict struct {
+			Flagged    bool     `json:"flagged"`
+			Categories []string `json:"categories"`
+		}
+		if err := json.Unmarshal([]byte(output), &verdict); err != nil {
+			return nil, fmt.Errorf("unreadable classifier output: %v", err)
+		}
+		if verdict.Flagged && len(verdict.Categories) == 0 {
+			return []string{"flagged"}, nil
+		}
+		return verdict.Categories, nil
+	}
+	var flagged []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			flagged = append(flagged, line)
+		}
+	}
+	return flagged, nil
+}
@@ -0,0 +1,143 @@
+// Package main provides alerts when a slow answer finishes, and posting
+// answers to chat webhooks.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// defaultNotifyAfter is how long an answer must take before it triggers
+// an alert, unless configured otherwise
+const defaultNotifyAfter = 30 * time.Second
+
+// notifyDone alerts the user that an answer is ready if it took long
+// enough that they may have switched away. Terminals usually only flag a
+// bell in unfocused tabs or windows, so it is harmless when watching.
+func (s *Session) notifyDone(spec string, elapsed time.Duration) {
+	method := strings.ToLower(s.notifyConfig.Method)
+	if method == "" {
+		method = "bell"
+	}
+	after := defaultNotifyAfter
+	if s.notifyConfig.AfterSeconds > 0 {
+		after = time.Duration(s.notifyConfig.AfterSeconds) * time.Second
+	}
+	if method == "off" || elapsed < after {
+		return
+	}
+
+	if method == "bell" || method == "both" {
+		fmt.Print("\a")
+	}
+	if method == "desktop" || method == "both" {
+		desktopNotify(AppName, fmt.Sprintf("Answer from %s ready after %s", spec, elapsed.Round(time.Second)))
+	}
+}
+
+// desktopNotify shows a desktop notification where a notifier is available,
+// without waiting for it
+func desktopNotify(title, message string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux", "freebsd", "openbsd", "netbsd":
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			return
+		}
+		cmd = exec.Command("notify-send", title, message)
+	default:
+		return
+	}
+	if err := cmd.Start(); err == nil {
+		go cmd.Wait()
+	}
+}
+
+// Chat services cap message length; longer answers are cut to fit
+const (
+	slackMessageLimit   = 39000
+	discordMessageLimit = 2000
+)
+
+// webhookURL returns the configured webhook for --notify name
+func webhookURL(config *Config, name string) (string, error) {
+	hook, ok := config.Notify.Webhooks[name]
+	if !ok || hook == "" {
+		return "", fmt.Errorf("no webhook '%s' in config (add it under notify.webhooks)", name)
+	}
+	return hook, nil
+}
+
+// isDiscordWebhook reports whether a webhook URL belongs to Discord rather
+// than Slack, which decides the message format
+func isDiscordWebhook(hook string) bool {
+	u, err := url.Parse(hook)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == "discord.com" || host == "discordapp.com" || strings.HasSuffix(host, ".discord.com")
+}
+
+// postAnswer posts a finished answer, headed by the model and the prompt,
+// to a Slack or Discord incoming webhook
+func postAnswer(hook, spec, prompt, answer string) error {
+	prompt = strings.Join(strings.Fields(prompt), " ")
+	if r := []rune(prompt); len(r) > 200 {
+		prompt = string(r[:199]) + "…"
+	}
+	var payload map[string]string
+	if isDiscordWebhook(hook) {
+		text := fmt.Sprintf("**%s** · %s\n> %s\n\n", AppName, spec, prompt)
+		payload = map[string]string{"content": text + clipMessage(answer, discordMessageLimit-len([]rune(text)))}
+	} else {
+		text := fmt.Sprintf("*%s* · %s\n> %s\n\n", AppName, spec, prompt)
+		payload = map[string]string{"text": text + clipMessage(answer, slackMessageLimit-len([]rune(text)))}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", hook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := provider.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// clipMessage shortens text to at most limit characters, saying so
+func clipMessage(text string, limit int) string {
+	const note = "\n…(truncated)"
+	r := []rune(text)
+	if len(r) <= limit {
+		return text
+	}
+	return string(r[:max(limit-len([]rune(note)), 0)]) + note
+}
@@ -0,0 +1,262 @@
+// Package main provides the handling of attachments too large for the
+// model's context window: they are summarized in parts (map-reduce or
+// refine) or cut short, instead of failing with a context-length error.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// Limits on condensing an attachment
+const (
+	minAttachmentShare = 1000 // tokens an attachment keeps however full the context is
+	maxCondenseRounds  = 3    // reduce passes before the result is cut to size
+	confirmCondense    = 20   // ask before summarizing in more parts than this
+)
+
+// condenseMapPrompt summarizes one part of a file for the question
+const condenseMapPrompt = `This is part %d of %d of the file %s, which is too large to read at once.
+The user is about to ask:
+
+%s
+
+Summarize this part in at most %d words. Keep what matters for that
+question: facts, names, numbers, definitions, function signatures, and any
+passages worth quoting exactly. Write the summary only.
+
+%s`
+
+// condenseReducePrompt combines the summaries of consecutive parts
+const condenseReducePrompt = `These are summaries of consecutive parts of the file %s, in order. The user
+is about to ask:
+
+%s
+
+Combine them into one summary of at most %d words, keeping what matters for
+that question. Write the summary only.
+
+%s`
+
+// condenseRefinePrompt updates a running summary with the next part
+const condenseRefinePrompt = `You are summarizing the file %s, which is too large to read at once, part
+by part. The user is about to ask:
+
+%s
+
+Summary of parts 1 to %d:
+%s
+
+Part %d of %d:
+%s
+
+Update the summary with this part, in at most %d words, keeping what matters
+for the question. Write the updated summary only.`
+
+// fitAttachments returns the attachments shrunk to fit the model's context
+// with the configured oversize strategy. Smaller files are kept whole first;
+// the history isn't counted, since manageContext trims it to make room.
+// It returns false if the user stopped or condensing failed.
+func (s *Session) fitAttachments(input string, attachments []*Attachment) ([]*Attachment, bool) {
+	cfg := contextSettings(s.contextConfig)
+	if len(attachments) == 0 || cfg.Oversize == "off" {
+		return attachments, true
+	}
+	window := contextWindow(s.modelName)
+	room := int(float64(window)*cfg.Threshold) - estimateTokens(s.system()) - estimateTokens(input)
+	total := 0
+	for _, a := range attachments {
+		total += estimateTokens(a.Content)
+	}
+	if total <= room {
+		return attachments, true
+	}
+
+	order := make([]int, len(attachments))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(attachments[order[i]].Content) < len(attachments[order[j]].Content)
+	})
+	fitted := make([]*Attachment, len(attachments))
+	for n, i := range order {
+		a := attachments[i]
+		share := max(room/(len(order)-n), minAttachmentShare)
+		tokens := estimateTokens(a.Content)
+		if tokens <= share {
+			fitted[i] = a
+			room -= tokens
+			continue
+		}
+		condensed, err := s.condenseAttachment(a, input, share, window, cfg.Oversize)
+		if err != nil {
+			switch {
+			case errors.Is(err, context.Canceled):
+				fmt.Printf("%s⏹ Cancelled%s\n", yellow, reset)
+			case err != errNotSent:
+				fmt.Printf("%s✗ Couldn't shrink %s to fit: %v%s\n", red, a.Path, err, reset)
+			}
+			return nil, false
+		}
+		fitted[i] = condensed
+		room -= estimateTokens(condensed.Content)
+	}
+	return fitted, true
+}
+
+// errNotSent means the user chose not to go ahead
+var errNotSent = fmt.Errorf("not sent")
+
+// condenseAttachment shrinks one attachment to about target tokens
+func (s *Session) condenseAttachment(a *Attachment, question string, target, window int, strategy string) (*Attachment, error) {
+	name := filepath.Base(a.Path)
+	tokens := estimateTokens(a.Content)
+	if strategy == "truncate" {
+		fmt.Printf("%s↺ %s is ~%s tokens, more than fits; sending the first ~%s%s\n", dim, name, formatTokenCount(tokens), formatTokenCount(target), reset)
+		return &Attachment{Path: a.Path, OCR: a.OCR, Condensed: "cut short to fit the context window",
+			Content: clipText(a.Content, target*4) + "\n[the rest of the file didn't fit]"}, nil
+	}
+	if strategy != "map_reduce" && strategy != "refine" {
+		return nil, fmt.Errorf("unknown context.oversize '%s' (use map_reduce, refine, truncate, or off)", strategy)
+	}
+
+	// Everything sent on the way is screened like the message itself
+	content, ok := screenOutgoing(a.Content, s.confirm)
+	if !ok {
+		return nil, errNotSent
+	}
+	chunks := chunkText(content, window/2)
+	fmt.Printf("%s↺ %s is ~%s tokens, more than fits; summarizing it in %d parts (%s)%s\n", dim, name, formatTokenCount(tokens), len(chunks), strings.ReplaceAll(strategy, "_", "-"), reset)
+	if len(chunks) > confirmCondense && !s.confirm(fmt.Sprintf("%sThat takes at least %d requests. Go ahead? [y/N]: %s", yellow, len(chunks), reset)) {
+		return nil, errNotSent
+	}
+
+	if strings.TrimSpace(question) == "" {
+		question = "(nothing yet; they want to know what the file contains)"
+	}
+	words := max(target*3/4, 200)
+	var summary string
+	var err error
+	if strategy == "refine" {
+		summary, err = s.refineChunks(name, question, chunks, words)
+	} else {
+		summary, err = s.mapReduceChunks(name, question, chunks, words, window/2)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(summary) > target*4 {
+		summary = clipText(summary, target*4) + "\n[summary cut short]"
+	}
+	return &Attachment{Path: a.Path, OCR: a.OCR, Content: summary,
+		Condensed: fmt.Sprintf("summarized from %d parts because it is too large to send whole", len(chunks))}, nil
+}
+
+// mapReduceChunks summarizes each chunk, then combines the summaries, in
+// groups if they don't fit together
+func (s *Session) mapReduceChunks(name, question string, chunks []string, words, chunkTokens int) (string, error) {
+	partWords := max(words/len(chunks), 150)
+	summaries := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		prompt := fmt.Sprintf(condenseMapPrompt, i+1, len(chunks), name, question, partWords, chunk)
+		summary, err := s.condenseQuery(fmt.Sprintf("Summarizing %s, part %d/%d...", name, i+1, len(chunks)), prompt)
+		if err != nil {
+			return "", err
+		}
+		summaries[i] = summary
+	}
+
+	for round := 1; len(summaries) > 1; round++ {
+		groups := chunkText(strings.Join(summaries, "\n\n---\n\n"), chunkTokens)
+		if round > maxCondenseRounds {
+			return strings.Join(summaries, "\n\n"), nil
+		}
+		next := make([]string, len(groups))
+		for i, group := range groups {
+			label := fmt.Sprintf("Combining summaries of %s...", name)
+			if len(groups) > 1 {
+				label = fmt.Sprintf("Combining summaries of %s, group %d/%d...", name, i+1, len(groups))
+			}
+			summary, err := s.condenseQuery(label, fmt.Sprintf(condenseReducePrompt, name, question, words, group))
+			if err != nil {
+				return "", err
+			}
+			next[i] = summary
+		}
+		summaries = next
+	}
+	return summaries[0], nil
+}
+
+// refineChunks reads the chunks in order, updating one running summary
+func (s *Session) refineChunks(name, question string, chunks []string, words int) (string, error) {
+	summary := "(nothing yet)"
+	for i, chunk := range chunks {
+		prompt := fmt.Sprintf(condenseRefinePrompt, name, question, i, summary, i+1, len(chunks), chunk, words)
+		if i == 0 {
+			prompt = fmt.Sprintf(condenseMapPrompt, 1, len(chunks), name, question, words, chunk)
+		}
+		next, err := s.condenseQuery(fmt.Sprintf("Summarizing %s, part %d/%d...", name, i+1, len(chunks)), prompt)
+		if err != nil {
+			return "", err
+		}
+		summary = next
+	}
+	return summary, nil
+}
+
+// condenseQuery sends one condensing request with the session's model
+func (s *Session) condenseQuery(label, prompt string) (string, error) {
+	answer, err := s.queryWithSpinner(s.provider, s.providerName+"/"+s.modelName, label, []provider.Message{{Role: "user", Content: prompt}})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// chunkText splits text into pieces of at most maxTokens, between lines
+// where possible
+func chunkText(text string, maxTokens int) []string {
+	limit := max(maxTokens, 1) * 4
+	var chunks []string
+	var current strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for len(line) > limit {
+			if current.Len() > 0 {
+				chunks = append(chunks, current.String())
+				current.Reset()
+			}
+			piece := clipText(line, limit)
+			chunks = append(chunks, piece)
+			line = line[len(piece):]
+		}
+		if current.Len()+len(line) > limit {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// clipText returns at most n bytes of s, not splitting a character
+func clipText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
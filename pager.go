@@ -0,0 +1,71 @@
+// Package main provides paging through long session transcripts.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// pagerCommand returns the pager to use: $PAGER, or less with colors kept
+func pagerCommand() []string {
+	if pager := strings.Fields(os.Getenv("PAGER")); len(pager) > 0 {
+		return pager
+	}
+	return []string{"less", "-R"}
+}
+
+// ansiEscape matches terminal escape sequences: colors and other CSI
+// sequences, and OSC sequences such as hyperlinks
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;?]*[ -/]*[@-~]|\x1b\\][^\x07\x1b]*(?:\x07|\x1b\\\\)")
+
+// viewTranscript renders the conversation and opens it in a pager, where
+// PgUp/PgDn, Ctrl+U/Ctrl+D, and g/G move through it. With a query, less
+// opens at the first match with all matches highlighted, and n/N jump
+// between them. less matches against the raw text, so a match spanning a
+// color change would be missed; when searching, the colors are dropped.
+// Without a terminal or pager the transcript is printed instead.
+func (s *Session) viewTranscript(query string) {
+	saved := s.snapshot()
+	if len(saved.Messages) == 0 {
+		fmt.Printf("\n%sNothing to show yet. Ask something first.%s\n", dim, reset)
+		return
+	}
+
+	text := exportMarkdown(saved)
+	if out, err := renderANSI(text, renderWidth); err == nil {
+		text = out
+	}
+
+	if !readline.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Print(text)
+		return
+	}
+
+	args := pagerCommand()
+	if _, err := exec.LookPath(args[0]); err != nil {
+		fmt.Print(text)
+		return
+	}
+	if query != "" {
+		if filepath.Base(args[0]) != "less" {
+			fmt.Printf("\n%sJumping to matches needs less; search with your pager's own keys%s\n", dim, reset)
+		} else {
+			text = ansiEscape.ReplaceAllString(text, "")
+			args = append(args, "-I", "+/"+regexp.QuoteMeta(query))
+		}
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("\n%s✗ Pager failed: %v%s\n", red, err, reset)
+	}
+}
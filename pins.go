@@ -0,0 +1,214 @@
+// Package main provides pinned answers and the `ask pins` command.
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pinsCommands are the actions of `ask pins`
+var pinsCommands = map[string]bool{"list": true, "show": true, "delete": true}
+
+// isPinsCommand reports whether the arguments invoke `ask pins`
+func isPinsCommand(args []string) bool {
+	if len(args) == 0 || args[0] != "pins" {
+		return false
+	}
+	return len(args) == 1 || pinsCommands[args[1]]
+}
+
+// pin is a bookmarked answer. It keeps its own copy of the exchange, so it
+// survives the session or history entry it came from.
+type pin struct {
+	id        int64
+	prompt    string
+	response  string
+	model     string // provider/model that answered
+	source    string // where it was pinned from, e.g. "history #12"
+	createdAt time.Time
+}
+
+// addPin bookmarks an answer, returning its id. Pinning the same exchange
+// twice returns the existing pin.
+func addPin(p pin) (int64, error) {
+	db, err := openStore()
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.Exec("INSERT OR IGNORE INTO pins (prompt, response, model, source, created_at) VALUES (?, ?, ?, ?, ?)",
+		p.prompt, p.response, p.model, p.source, toMillis(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = db.QueryRow("SELECT id FROM pins WHERE prompt = ? AND response = ?", p.prompt, p.response).Scan(&id)
+	return id, err
+}
+
+// listPins returns the pins, most recently added first
+func listPins() ([]pin, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT id, prompt, response, model, source, created_at FROM pins ORDER BY created_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pins []pin
+	for rows.Next() {
+		var p pin
+		var created int64
+		if err := rows.Scan(&p.id, &p.prompt, &p.response, &p.model, &p.source, &created); err != nil {
+			return nil, err
+		}
+		p.createdAt = fromMillis(created)
+		pins = append(pins, p)
+	}
+	return pins, rows.Err()
+}
+
+// pinHistoryEntry pins the answer of a one-shot history entry
+func pinHistoryEntry(id int) (int64, error) {
+	db, err := openStore()
+	if err != nil {
+		return 0, err
+	}
+	var providerName, modelName, prompt, response string
+	err = db.QueryRow("SELECT provider, model, prompt, response FROM history WHERE id = ?", id).
+		Scan(&providerName, &modelName, &prompt, &response)
+	if err != nil {
+		return 0, fmt.Errorf("history entry %d not found", id)
+	}
+	return addPin(pin{prompt: prompt, response: response, model: providerName + "/" + modelName, source: fmt.Sprintf("history #%d", id)})
+}
+
+// runPinsCommand lists, shows, or deletes pinned answers
+func runPinsCommand(args []string) error {
+	action := "list"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	switch action {
+	case "list":
+		pins, err := listPins()
+		if err != nil {
+			return err
+		}
+		if len(pins) == 0 {
+			fmt.Println("No pinned answers. Pin one with /pin in a session or 'ask history pin <id>'.")
+			return nil
+		}
+		for _, p := range pins {
+			fmt.Printf("%5d  %s  %-30s %s\n", p.id, p.createdAt.Format("2006-01-02 15:04"), p.model, favoritePreview(p.prompt, 60))
+		}
+		return nil
+
+	case "show":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: ask pins show <id>")
+		}
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid pin id '%s'", args[0])
+		}
+		pins, err := listPins()
+		if err != nil {
+			return err
+		}
+		for _, p := range pins {
+			if p.id != id {
+				continue
+			}
+			if config, err := LoadConfigSafe(); err == nil {
+				markdownTheme = config.Theme
+			}
+			fmt.Printf("%sPin %d  %s  %s  from %s%s\n\n", dim, p.id, p.createdAt.Format("2006-01-02 15:04"), p.model, p.source, reset)
+			fmt.Printf("%s%s› %s%s\n", bold, cyan, reset, p.prompt)
+			if err := renderMarkdown(p.response); err != nil {
+				fmt.Println(p.response)
+			}
+			return nil
+		}
+		return fmt.Errorf("pin %d not found", id)
+
+	case "delete":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: ask pins delete <id>")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid pin id '%s'", args[0])
+		}
+		db, err := openStore()
+		if err != nil {
+			return err
+		}
+		res, err := db.Exec("DELETE FROM pins WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("pin %d not found", id)
+		}
+		fmt.Printf("Deleted pin %d\n", id)
+		return nil
+	}
+	return fmt.Errorf("unknown pins command '%s' (list, show, delete)", action)
+}
+
+// pinCommand pins the last answer, or answer #n, with the prompt before it
+func (s *Session) pinCommand(args []string) {
+	s.mu.Lock()
+	messages := append([]SessionMessage{}, s.messages...)
+	s.mu.Unlock()
+
+	n := 0
+	if len(args) > 0 {
+		var ok bool
+		if n, ok = s.selectMessage(strings.TrimPrefix(args[0], "#")); !ok {
+			return
+		}
+		if messages[n-1].Role != "assistant" {
+			fmt.Printf("\n%s✗ Message #%d is not an answer%s\n", red, n, reset)
+			return
+		}
+	} else {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == "assistant" {
+				n = i + 1
+				break
+			}
+		}
+		if n == 0 {
+			fmt.Printf("\n%sNo answer to pin yet%s\n", dim, reset)
+			return
+		}
+	}
+
+	answer := messages[n-1]
+	p := pin{response: answer.Content, model: answer.Model, source: fmt.Sprintf("session #%d", n)}
+	if s.name != "" {
+		p.source = fmt.Sprintf("%s #%d", s.name, n)
+	}
+	for i := n - 2; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			p.prompt = messages[i].Content
+			break
+		}
+	}
+	if p.model == "" {
+		p.model = s.providerName + "/" + s.modelName
+	}
+
+	id, err := addPin(p)
+	if err != nil {
+		fmt.Printf("\n%s✗ Error pinning answer: %v%s\n", red, err, reset)
+		return
+	}
+	fmt.Printf("\n%s✓ Pinned as %d%s %s(ask pins show %d)%s\n", green, id, reset, dim, id, reset)
+}
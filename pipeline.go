@@ -0,0 +1,97 @@
+// Package main provides --emit-context and --with-context, which pass a
+// conversation from one ask to the next through a pipe with its roles and
+// models intact.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/metolius25/ask/provider"
+
+	"github.com/chzyer/readline"
+)
+
+// pipeContextVersion marks the envelope format
+const pipeContextVersion = 1
+
+// maxPipeContext bounds how much piped input is read
+const maxPipeContext = 16 << 20
+
+// pipeContext is the JSON envelope --emit-context writes
+type pipeContext struct {
+	Version  int           `json:"ask_context"`
+	Provider string        `json:"provider"`
+	Model    string        `json:"model"`
+	Messages []pipeMessage `json:"messages"`
+}
+
+// pipeMessage is one message of the conversation; Model names the model
+// behind an assistant message
+type pipeMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	Model   string `json:"model,omitempty"`
+}
+
+// readPipeContext reads the conversation piped from `ask --emit-context`.
+// Other input is returned as text, for the caller to put before the prompt.
+func readPipeContext() (*pipeContext, string, error) {
+	if readline.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, "", fmt.Errorf("--with-context reads a conversation piped from ask --emit-context")
+	}
+	data, err := io.ReadAll(io.LimitReader(os.Stdin, maxPipeContext))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading piped context: %v", err)
+	}
+	var pc pipeContext
+	if json.Unmarshal(data, &pc) != nil || pc.Version == 0 {
+		return nil, strings.TrimSpace(string(data)), nil
+	}
+	if pc.Version > pipeContextVersion {
+		return nil, "", fmt.Errorf("the piped context is from a newer ask (format %d)", pc.Version)
+	}
+	for _, m := range pc.Messages {
+		if m.Role != "system" && m.Role != "user" && m.Role != "assistant" {
+			return nil, "", fmt.Errorf("the piped context has an unknown role '%s'", m.Role)
+		}
+	}
+	return &pc, "", nil
+}
+
+// messages returns the conversation for a provider
+func (pc *pipeContext) messages() []provider.Message {
+	if pc == nil {
+		return nil
+	}
+	msgs := make([]provider.Message, len(pc.Messages))
+	for i, m := range pc.Messages {
+		msgs[i] = provider.Message{Role: m.Role, Content: m.Content}
+	}
+	return msgs
+}
+
+// spec returns the model the conversation ended with, as provider/model
+func (pc *pipeContext) spec() string {
+	if pc == nil || pc.Provider == "" || pc.Model == "" {
+		return ""
+	}
+	return pc.Provider + "/" + pc.Model
+}
+
+// emitPipeContext writes the conversation so far, the prompt, and the
+// answer as an envelope for the next ask in the pipe
+func emitPipeContext(pc *pipeContext, providerName, modelName, prompt, response string) error {
+	out := pipeContext{Version: pipeContextVersion, Provider: providerName, Model: modelName}
+	if pc != nil {
+		out.Messages = append(out.Messages, pc.Messages...)
+	}
+	out.Messages = append(out.Messages,
+		pipeMessage{Role: "user", Content: prompt},
+		pipeMessage{Role: "assistant", Content: response, Model: providerName + "/" + modelName},
+	)
+	return json.NewEncoder(os.Stdout).Encode(out)
+}
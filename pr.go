@@ -0,0 +1,233 @@
+// Package main provides `ask pr`, which drafts a pull request title and
+// description, or a changelog entry, from the commits on the current branch.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// isPRCommand reports whether the arguments invoke `ask pr`. Only flags may
+// follow, so "ask pr reviews take too long" stays an ordinary prompt.
+func isPRCommand(args []string) bool {
+	return len(args) > 0 && args[0] == "pr" && (len(args) == 1 || strings.HasPrefix(args[1], "-"))
+}
+
+// prTemplateFiles are where repositories keep a pull request template
+var prTemplateFiles = []string{
+	".github/pull_request_template.md",
+	".github/PULL_REQUEST_TEMPLATE.md",
+	"PULL_REQUEST_TEMPLATE.md",
+	"docs/pull_request_template.md",
+}
+
+// defaultPRTemplate is used when neither config nor repository has one
+const defaultPRTemplate = `## Summary
+
+## Changes
+
+## Testing`
+
+// prPrompt asks for a title line and a description following a template
+const prPrompt = `Write a pull request title and description for the branch below.
+Reply with the title on the first line as "Title: <title>" (at most 72
+characters, imperative mood), a blank line, then the description in Markdown
+following this template. Fill each section from the commits and diff, and
+drop sections that don't apply. Don't invent testing that isn't evident.
+
+Template:
+`
+
+// changelogPrompt asks for a Keep a Changelog style entry
+const changelogPrompt = `Write a changelog entry for the commits below, in Keep a Changelog style:
+group user-visible changes under ### Added, ### Changed, ### Fixed, and
+### Removed, one short bullet each, leaving out empty groups and internal
+changes such as refactors, tests, and CI. Reply with the Markdown only.`
+
+// prBase returns the branch a pull request would merge into: origin's
+// default branch, or else main or master
+func prBase() (string, error) {
+	if ref, err := gitOutput("symbolic-ref", "--short", "refs/remotes/origin/HEAD"); err == nil {
+		return ref, nil
+	}
+	if _, err := exec.LookPath("gh"); err == nil {
+		if out, err := exec.Command("gh", "repo", "view", "--json", "defaultBranchRef", "-q", ".defaultBranchRef.name").Output(); err == nil {
+			if name := strings.TrimSpace(string(out)); name != "" {
+				return name, nil
+			}
+		}
+	}
+	for _, name := range []string{"main", "master"} {
+		if _, err := gitOutput("rev-parse", "--verify", "--quiet", name); err == nil {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("can't tell the base branch; use --base")
+}
+
+// loadPRTemplate returns pr_template from config, the repository's pull
+// request template, or a plain Summary/Changes/Testing layout
+func loadPRTemplate(config *Config) (string, error) {
+	if path := config.PRTemplate; path != "" {
+		if strings.HasPrefix(path, "~/") {
+			if homeDir, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(homeDir, path[2:])
+			}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("pr_template: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if root, err := gitOutput("rev-parse", "--show-toplevel"); err == nil {
+		for _, name := range prTemplateFiles {
+			if data, err := os.ReadFile(filepath.Join(root, name)); err == nil {
+				return strings.TrimSpace(string(data)), nil
+			}
+		}
+	}
+	return defaultPRTemplate, nil
+}
+
+// splitPRDraft separates the title line from the description
+func splitPRDraft(draft string) (title, body string) {
+	title, body, _ = strings.Cut(draft, "\n")
+	title = strings.TrimSpace(title)
+	title = strings.TrimPrefix(title, "**Title:**")
+	title = strings.TrimPrefix(title, "Title:")
+	return strings.Trim(strings.TrimSpace(title), `"`), strings.TrimSpace(body)
+}
+
+// runPRCommand prints a drafted pull request, opens it with --create, or
+// prints a changelog entry with --changelog
+func runPRCommand(args []string) error {
+	var providerFlag, modelFlag, profileFlag, base string
+	var create, draft, changelog bool
+	fs := flag.NewFlagSet("pr", flag.ContinueOnError)
+	fs.StringVar(&modelFlag, "m", "", "Model to draft with")
+	fs.StringVar(&providerFlag, "p", "", "Provider to draft with")
+	fs.StringVar(&profileFlag, "P", "", "Profile to draft with")
+	fs.StringVar(&base, "base", "", "Branch the pull request merges into (default: origin's default branch; the latest tag with --changelog)")
+	fs.BoolVar(&create, "create", false, "Open the pull request with gh pr create")
+	fs.BoolVar(&draft, "draft", false, "With --create, open it as a draft")
+	fs.BoolVar(&changelog, "changelog", false, "Draft a changelog entry instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument '%s'", fs.Arg(0))
+	}
+	if changelog && create {
+		return fmt.Errorf("--create can't be used with --changelog")
+	}
+	if draft && !create {
+		return fmt.Errorf("--draft needs --create")
+	}
+	if create {
+		if _, err := exec.LookPath("gh"); err != nil {
+			return fmt.Errorf("--create needs the GitHub CLI (gh) in PATH")
+		}
+	}
+
+	if base == "" && changelog {
+		base, _ = gitOutput("describe", "--tags", "--abbrev=0")
+	}
+	if base == "" {
+		var err error
+		if base, err = prBase(); err != nil {
+			return err
+		}
+	}
+	mergeBase, err := gitOutput("merge-base", base, "HEAD")
+	if err != nil {
+		return err
+	}
+	commits, err := gitOutput("log", "--no-merges", "--reverse", "--format=- %s%n%w(0,2,2)%b", mergeBase+"..HEAD")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(commits) == "" {
+		return fmt.Errorf("no commits since %s", base)
+	}
+	stat, _ := gitOutput("diff", "--stat", "--no-color", mergeBase, "HEAD")
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	openRequestLog(config)
+	openAudit(config)
+	if err := openScreening(config); err != nil {
+		return err
+	}
+
+	if changelog {
+		prompt := changelogPrompt + "\n\nCommits:\n" + commits + "\n\n" + stat
+		entry, err := gitQuery(config, providerFlag, modelFlag, profileFlag, prompt, "pr")
+		if err != nil {
+			return err
+		}
+		fmt.Println(entry)
+		return nil
+	}
+
+	template, err := loadPRTemplate(config)
+	if err != nil {
+		return err
+	}
+	diff, err := gitOutput("diff", "--no-color", "--no-ext-diff", mergeBase, "HEAD")
+	if err != nil {
+		return err
+	}
+	if len(diff) > maxCommitDiff {
+		diff = diff[:maxCommitDiff] + "\n[diff truncated]"
+	}
+	prompt := prPrompt + template + "\n\nCommits:\n" + commits + "\n\n" + stat + "\n\n" + diff
+	answer, err := gitQuery(config, providerFlag, modelFlag, profileFlag, prompt, "pr")
+	if err != nil {
+		return err
+	}
+	title, body := splitPRDraft(answer)
+	if title == "" {
+		return fmt.Errorf("the model returned an empty draft")
+	}
+	if !create {
+		fmt.Println(title)
+		fmt.Println()
+		fmt.Println(body)
+		return nil
+	}
+	return createPR(strings.TrimPrefix(base, "origin/"), title, body, draft)
+}
+
+// createPR opens a pull request with gh, which may ask where to push the
+// branch, so the terminal stays attached
+func createPR(base, title, body string, draft bool) error {
+	f, err := os.CreateTemp("", "ask-pr-*.md")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(body + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	fmt.Printf("%s%s%s\n\n%s\n\n", bold, title, reset, body)
+	args := []string{"pr", "create", "--base", base, "--title", title, "--body-file", f.Name()}
+	if draft {
+		args = append(args, "--draft")
+	}
+	cmd := exec.Command("gh", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("gh pr create: %v", err)
+	}
+	return nil
+}
@@ -1,8 +1,8 @@
 package provider
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,11 +10,15 @@ import (
 	"strings"
 )
 
+// ChatGPTProvider queries OpenAI's ChatGPT models
 type ChatGPTProvider struct {
 	apiKey string
 	model  string
+	settings
 }
 
+// NewChatGPTProvider returns a provider for model, or the first built-in model
+// when model is empty
 func NewChatGPTProvider(apiKey, model string) *ChatGPTProvider {
 	// If no model specified, use first available from fallback list
 	if model == "" {
@@ -30,9 +34,11 @@ func NewChatGPTProvider(apiKey, model string) *ChatGPTProvider {
 }
 
 type chatGPTRequest struct {
-	Model    string           `json:"model"`
-	Messages []chatGPTMessage `json:"messages"`
-	Stream   bool             `json:"stream"`
+	Model       string           `json:"model"`
+	Messages    []chatGPTMessage `json:"messages"`
+	Stream      bool             `json:"stream"`
+	Temperature *float64         `json:"temperature,omitempty"`
+	MaxTokens   int              `json:"max_tokens,omitempty"`
 }
 
 type chatGPTMessage struct {
@@ -40,15 +46,8 @@ type chatGPTMessage struct {
 	Content string `json:"content"`
 }
 
-type chatGPTStreamResponse struct {
-	Choices []struct {
-		Delta struct {
-			Content string `json:"content"`
-		} `json:"delta"`
-	} `json:"choices"`
-}
-
-func (c *ChatGPTProvider) QueryStream(prompt string, writer io.Writer) error {
+func (c *ChatGPTProvider) QueryStream(ctx context.Context, prompt string, writer io.Writer) error {
+	opts := c.options()
 	reqBody := chatGPTRequest{
 		Model: c.model,
 		Messages: []chatGPTMessage{
@@ -57,7 +56,9 @@ func (c *ChatGPTProvider) QueryStream(prompt string, writer io.Writer) error {
 				Content: prompt,
 			},
 		},
-		Stream: true,
+		Stream:      true,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -65,7 +66,7 @@ func (c *ChatGPTProvider) QueryStream(prompt string, writer io.Writer) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -73,8 +74,7 @@ func (c *ChatGPTProvider) QueryStream(prompt string, writer io.Writer) error {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -82,39 +82,14 @@ func (c *ChatGPTProvider) QueryStream(prompt string, writer io.Writer) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return HandleAPIError(resp.StatusCode, body, "ChatGPT")
-	}
-
-	// Parse SSE stream
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var streamResp chatGPTStreamResponse
-			if err := json.Unmarshal([]byte(data), &streamResp); err == nil {
-				if len(streamResp.Choices) > 0 {
-					content := streamResp.Choices[0].Delta.Content
-					if content != "" {
-						fmt.Fprint(writer, content)
-					}
-				}
-			}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading stream: %w", err)
+		return responseError(resp, body, "ChatGPT")
 	}
 
-	return nil
+	return copyChatStream(resp.Body, writer)
 }
 
-func (c *ChatGPTProvider) QueryStreamWithHistory(messages []Message, writer io.Writer) error {
+func (c *ChatGPTProvider) QueryStreamWithHistory(ctx context.Context, messages []Message, writer io.Writer) error {
+	opts := c.options()
 	// Convert our Message type to ChatGPT's message format
 	var chatGPTMessages []chatGPTMessage
 	for _, msg := range messages {
@@ -122,9 +97,11 @@ func (c *ChatGPTProvider) QueryStreamWithHistory(messages []Message, writer io.W
 	}
 
 	reqBody := chatGPTRequest{
-		Model:    c.model,
-		Messages: chatGPTMessages,
-		Stream:   true,
+		Model:       c.model,
+		Messages:    chatGPTMessages,
+		Stream:      true,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -132,7 +109,7 @@ func (c *ChatGPTProvider) QueryStreamWithHistory(messages []Message, writer io.W
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -140,8 +117,7 @@ func (c *ChatGPTProvider) QueryStreamWithHistory(messages []Message, writer io.W
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -149,48 +125,23 @@ func (c *ChatGPTProvider) QueryStreamWithHistory(messages []Message, writer io.W
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return HandleAPIError(resp.StatusCode, body, "ChatGPT")
-	}
-
-	// Parse SSE stream
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var streamResp chatGPTStreamResponse
-			if err := json.Unmarshal([]byte(data), &streamResp); err == nil {
-				if len(streamResp.Choices) > 0 {
-					content := streamResp.Choices[0].Delta.Content
-					if content != "" {
-						fmt.Fprint(writer, content)
-					}
-				}
-			}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading stream: %w", err)
+		return responseError(resp, body, "ChatGPT")
 	}
 
-	return nil
+	return copyChatStream(resp.Body, writer)
 }
 
-func (c *ChatGPTProvider) ListModels() ([]ModelInfo, error) {
-	req, err := http.NewRequest("GET", "https://api.openai.com/v1/models", nil)
+// ListModels returns the chat models the API lists, or a built-in list
+// when it can't be reached
+func (c *ChatGPTProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.openai.com/v1/models", nil)
 	if err != nil {
 		return getFallbackChatGPTModels(), nil
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return getFallbackChatGPTModels(), nil
 	}
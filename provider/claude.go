@@ -2,6 +2,7 @@ package provider
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,11 +10,19 @@ import (
 	"strings"
 )
 
+// ClaudeProvider queries Anthropic's Claude models
 type ClaudeProvider struct {
 	apiKey string
 	model  string
+	settings
 }
 
+// claudeDefaultMaxTokens is used when no max tokens option is set, since
+// the API requires one
+const claudeDefaultMaxTokens = 4096
+
+// NewClaudeProvider returns a provider for model, or the first built-in model
+// when model is empty
 func NewClaudeProvider(apiKey, model string) *ClaudeProvider {
 	// If no model specified, use first available from fallback list
 	if model == "" {
@@ -28,11 +37,21 @@ func NewClaudeProvider(apiKey, model string) *ClaudeProvider {
 	}
 }
 
+// claudeMaxTokens returns the configured max tokens or the default
+func claudeMaxTokens(opts Options) int {
+	if opts.MaxTokens > 0 {
+		return opts.MaxTokens
+	}
+	return claudeDefaultMaxTokens
+}
+
 type claudeRequest struct {
-	Model     string          `json:"model"`
-	Messages  []claudeMessage `json:"messages"`
-	MaxTokens int             `json:"max_tokens"`
-	Stream    bool            `json:"stream"`
+	Model       string          `json:"model"`
+	System      string          `json:"system,omitempty"`
+	Messages    []claudeMessage `json:"messages"`
+	MaxTokens   int             `json:"max_tokens"`
+	Stream      bool            `json:"stream"`
+	Temperature *float64        `json:"temperature,omitempty"`
 }
 
 type claudeMessage struct {
@@ -49,7 +68,26 @@ type claudeStreamEvent struct {
 	} `json:"delta,omitempty"`
 }
 
-func (c *ClaudeProvider) QueryStream(prompt string, writer io.Writer) error {
+// copyClaudeStream writes the text of a Messages API stream to w as it
+// arrives. Events that can't be read are skipped.
+func copyClaudeStream(body io.Reader, w io.Writer) error {
+	var event claudeStreamEvent
+	var werr error
+	err := readSSE(body, func(data []byte) bool {
+		event = claudeStreamEvent{}
+		if json.Unmarshal(data, &event) == nil && event.Type == "content_block_delta" && event.Delta.Text != "" {
+			_, werr = io.WriteString(w, event.Delta.Text)
+		}
+		return werr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return werr
+}
+
+func (c *ClaudeProvider) QueryStream(ctx context.Context, prompt string, writer io.Writer) error {
+	opts := c.options()
 	reqBody := claudeRequest{
 		Model: c.model,
 		Messages: []claudeMessage{
@@ -58,8 +96,9 @@ func (c *ClaudeProvider) QueryStream(prompt string, writer io.Writer) error {
 				Content: prompt,
 			},
 		},
-		MaxTokens: 4096,
-		Stream:    true,
+		MaxTokens:   claudeMaxTokens(opts),
+		Stream:      true,
+		Temperature: opts.Temperature,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -67,7 +106,7 @@ func (c *ClaudeProvider) QueryStream(prompt string, writer io.Writer) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -76,8 +115,7 @@ func (c *ClaudeProvider) QueryStream(prompt string, writer io.Writer) error {
 	req.Header.Set("x-api-key", c.apiKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -85,55 +123,33 @@ func (c *ClaudeProvider) QueryStream(prompt string, writer io.Writer) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return HandleAPIError(resp.StatusCode, body, "Claude")
-	}
-
-	// Parse SSE stream
-	buf := make([]byte, 4096)
-
-	for {
-		n, err := resp.Body.Read(buf)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("error reading stream: %w", err)
-		}
-		if n == 0 {
-			break
-		}
-
-		// Parse SSE events
-		lines := strings.Split(string(buf[:n]), "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "data: ") {
-				data := strings.TrimPrefix(line, "data: ")
-				if data == "[DONE]" {
-					continue
-				}
-
-				var event claudeStreamEvent
-				if err := json.Unmarshal([]byte(data), &event); err == nil {
-					if event.Type == "content_block_delta" && event.Delta.Text != "" {
-						fmt.Fprint(writer, event.Delta.Text)
-					}
-				}
-			}
-		}
+		return responseError(resp, body, "Claude")
 	}
 
-	return nil
+	return copyClaudeStream(resp.Body, writer)
 }
 
-func (c *ClaudeProvider) QueryStreamWithHistory(messages []Message, writer io.Writer) error {
-	// Convert our Message type to Claude's message format
+func (c *ClaudeProvider) QueryStreamWithHistory(ctx context.Context, messages []Message, writer io.Writer) error {
+	opts := c.options()
+	// Convert our Message type to Claude's message format. Claude takes the
+	// system prompt as a separate field rather than a message.
 	var claudeMessages []claudeMessage
+	var system []string
 	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
 		claudeMessages = append(claudeMessages, claudeMessage(msg))
 	}
 
 	reqBody := claudeRequest{
-		Model:     c.model,
-		Messages:  claudeMessages,
-		MaxTokens: 4096,
-		Stream:    true,
+		Model:       c.model,
+		System:      strings.Join(system, "\n\n"),
+		Messages:    claudeMessages,
+		MaxTokens:   claudeMaxTokens(opts),
+		Stream:      true,
+		Temperature: opts.Temperature,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -141,7 +157,7 @@ func (c *ClaudeProvider) QueryStreamWithHistory(messages []Message, writer io.Wr
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -150,8 +166,7 @@ func (c *ClaudeProvider) QueryStreamWithHistory(messages []Message, writer io.Wr
 	req.Header.Set("x-api-key", c.apiKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -159,45 +174,16 @@ func (c *ClaudeProvider) QueryStreamWithHistory(messages []Message, writer io.Wr
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return HandleAPIError(resp.StatusCode, body, "Claude")
-	}
-
-	// Parse SSE stream
-	buf := make([]byte, 4096)
-
-	for {
-		n, err := resp.Body.Read(buf)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("error reading stream: %w", err)
-		}
-		if n == 0 {
-			break
-		}
-
-		// Parse SSE events
-		lines := strings.Split(string(buf[:n]), "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "data: ") {
-				data := strings.TrimPrefix(line, "data: ")
-				if data == "[DONE]" {
-					continue
-				}
-
-				var event claudeStreamEvent
-				if err := json.Unmarshal([]byte(data), &event); err == nil {
-					if event.Type == "content_block_delta" && event.Delta.Text != "" {
-						fmt.Fprint(writer, event.Delta.Text)
-					}
-				}
-			}
-		}
+		return responseError(resp, body, "Claude")
 	}
 
-	return nil
+	return copyClaudeStream(resp.Body, writer)
 }
 
-func (c *ClaudeProvider) ListModels() ([]ModelInfo, error) {
-	req, err := http.NewRequest("GET", "https://api.anthropic.com/v1/models", nil)
+// ListModels returns the chat models the API lists, or a built-in list
+// when it can't be reached
+func (c *ClaudeProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.anthropic.com/v1/models", nil)
 	if err != nil {
 		return getFallbackClaudeModels(), nil
 	}
@@ -205,8 +191,7 @@ func (c *ClaudeProvider) ListModels() ([]ModelInfo, error) {
 	req.Header.Set("x-api-key", c.apiKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return getFallbackClaudeModels(), nil
 	}
@@ -1,20 +1,23 @@
 package provider
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 )
 
+// DeepSeekProvider queries DeepSeek models
 type DeepSeekProvider struct {
 	apiKey string
 	model  string
+	settings
 }
 
+// NewDeepSeekProvider returns a provider for model, or the first built-in model
+// when model is empty
 func NewDeepSeekProvider(apiKey, model string) *DeepSeekProvider {
 	// If no model specified, use first available from fallback list
 	if model == "" {
@@ -30,9 +33,11 @@ func NewDeepSeekProvider(apiKey, model string) *DeepSeekProvider {
 }
 
 type deepseekRequest struct {
-	Model    string            `json:"model"`
-	Messages []deepseekMessage `json:"messages"`
-	Stream   bool              `json:"stream"`
+	Model       string            `json:"model"`
+	Messages    []deepseekMessage `json:"messages"`
+	Stream      bool              `json:"stream"`
+	Temperature *float64          `json:"temperature,omitempty"`
+	MaxTokens   int               `json:"max_tokens,omitempty"`
 }
 
 type deepseekMessage struct {
@@ -40,15 +45,8 @@ type deepseekMessage struct {
 	Content string `json:"content"`
 }
 
-type deepseekStreamResponse struct {
-	Choices []struct {
-		Delta struct {
-			Content string `json:"content"`
-		} `json:"delta"`
-	} `json:"choices"`
-}
-
-func (d *DeepSeekProvider) QueryStream(prompt string, writer io.Writer) error {
+func (d *DeepSeekProvider) QueryStream(ctx context.Context, prompt string, writer io.Writer) error {
+	opts := d.options()
 	reqBody := deepseekRequest{
 		Model: d.model,
 		Messages: []deepseekMessage{
@@ -57,7 +55,9 @@ func (d *DeepSeekProvider) QueryStream(prompt string, writer io.Writer) error {
 				Content: prompt,
 			},
 		},
-		Stream: true,
+		Stream:      true,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -65,7 +65,7 @@ func (d *DeepSeekProvider) QueryStream(prompt string, writer io.Writer) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.deepseek.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.deepseek.com/v1/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -73,8 +73,7 @@ func (d *DeepSeekProvider) QueryStream(prompt string, writer io.Writer) error {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+d.apiKey)
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -82,39 +81,14 @@ func (d *DeepSeekProvider) QueryStream(prompt string, writer io.Writer) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return HandleAPIError(resp.StatusCode, body, "DeepSeek")
-	}
-
-	// Parse SSE stream
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var streamResp deepseekStreamResponse
-			if err := json.Unmarshal([]byte(data), &streamResp); err == nil {
-				if len(streamResp.Choices) > 0 {
-					content := streamResp.Choices[0].Delta.Content
-					if content != "" {
-						fmt.Fprint(writer, content)
-					}
-				}
-			}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading stream: %w", err)
+		return responseError(resp, body, "DeepSeek")
 	}
 
-	return nil
+	return copyChatStream(resp.Body, writer)
 }
 
-func (d *DeepSeekProvider) QueryStreamWithHistory(messages []Message, writer io.Writer) error {
+func (d *DeepSeekProvider) QueryStreamWithHistory(ctx context.Context, messages []Message, writer io.Writer) error {
+	opts := d.options()
 	// Convert our Message type to DeepSeek's message format
 	var deepseekMessages []deepseekMessage
 	for _, msg := range messages {
@@ -122,9 +96,11 @@ func (d *DeepSeekProvider) QueryStreamWithHistory(messages []Message, writer io.
 	}
 
 	reqBody := deepseekRequest{
-		Model:    d.model,
-		Messages: deepseekMessages,
-		Stream:   true,
+		Model:       d.model,
+		Messages:    deepseekMessages,
+		Stream:      true,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -132,7 +108,7 @@ func (d *DeepSeekProvider) QueryStreamWithHistory(messages []Message, writer io.
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.deepseek.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.deepseek.com/v1/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -140,8 +116,7 @@ func (d *DeepSeekProvider) QueryStreamWithHistory(messages []Message, writer io.
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+d.apiKey)
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -149,48 +124,23 @@ func (d *DeepSeekProvider) QueryStreamWithHistory(messages []Message, writer io.
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return HandleAPIError(resp.StatusCode, body, "DeepSeek")
-	}
-
-	// Parse SSE stream
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var streamResp deepseekStreamResponse
-			if err := json.Unmarshal([]byte(data), &streamResp); err == nil {
-				if len(streamResp.Choices) > 0 {
-					content := streamResp.Choices[0].Delta.Content
-					if content != "" {
-						fmt.Fprint(writer, content)
-					}
-				}
-			}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading stream: %w", err)
+		return responseError(resp, body, "DeepSeek")
 	}
 
-	return nil
+	return copyChatStream(resp.Body, writer)
 }
 
-func (d *DeepSeekProvider) ListModels() ([]ModelInfo, error) {
-	req, err := http.NewRequest("GET", "https://api.deepseek.com/v1/models", nil)
+// ListModels returns the chat models the API lists, or a built-in list
+// when it can't be reached
+func (d *DeepSeekProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.deepseek.com/v1/models", nil)
 	if err != nil {
 		return getFallbackDeepSeekModels(), nil
 	}
 
 	req.Header.Set("Authorization", "Bearer "+d.apiKey)
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return getFallbackDeepSeekModels(), nil
 	}
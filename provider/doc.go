@@ -0,0 +1,22 @@
+// Package provider is a small client library for the chat APIs of Gemini,
+// Claude, ChatGPT, DeepSeek, Mistral, and Qwen behind one interface. It
+// streams answers to an io.Writer, takes a context for cancellation, and
+// reports API failures as *APIError. It does not read any configuration;
+// API keys and models are passed in.
+//
+//	p, err := provider.New("claude", os.Getenv("ANTHROPIC_API_KEY"), "")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	err = p.QueryStreamWithHistory(ctx, []provider.Message{
+//		{Role: "system", Content: "Answer in one sentence."},
+//		{Role: "user", Content: "What is a goroutine?"},
+//	}, os.Stdout)
+//	if errors.Is(err, provider.ErrRateLimited) {
+//		// wait and try again
+//	}
+//
+// Providers are safe for concurrent use. Providers that accept generation
+// settings implement Configurable. Custom providers can be added to a
+// Registry next to the built-in ones.
+package provider
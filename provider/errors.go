@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors for common API failures. Test for them with errors.Is.
+var (
+	ErrInvalidAPIKey       = errors.New("invalid API key")
+	ErrInsufficientCredits = errors.New("insufficient balance or credits")
+	ErrRateLimited         = errors.New("rate limit exceeded")
+
+	// ErrUnknownProvider is returned by Registry.New for an unregistered name
+	ErrUnknownProvider = errors.New("unknown provider")
+)
+
+// APIError is a provider's API answering with an error status
+type APIError struct {
+	Provider   string // display name, such as "Claude"
+	StatusCode int
+	Body       string        // the response body, as returned
+	RetryAfter time.Duration // for 429, how long the API said to wait; 0 if it didn't
+}
+
+func (e *APIError) Error() string {
+	switch e.StatusCode {
+	case 401:
+		return fmt.Sprintf("Invalid API key for %s", e.Provider)
+	case 402:
+		return fmt.Sprintf("Insufficient balance/credits for %s. Please add funds to your account", e.Provider)
+	case 429:
+		if e.RetryAfter > 0 {
+			return fmt.Sprintf("Rate limit exceeded for %s. Try again in %s", e.Provider, max(e.RetryAfter.Round(time.Second), time.Second))
+		}
+		return fmt.Sprintf("Rate limit exceeded for %s. Please wait and try again", e.Provider)
+	}
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// Unwrap returns the matching Err value for 401, 402, and 429 responses
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case 401:
+		return ErrInvalidAPIKey
+	case 402:
+		return ErrInsufficientCredits
+	case 429:
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// HandleAPIError returns an *APIError for an error response
+func HandleAPIError(statusCode int, body []byte, providerName string) error {
+	return &APIError{Provider: providerName, StatusCode: statusCode, Body: string(body)}
+}
+
+// responseError is HandleAPIError with the wait from a 429's headers
+func responseError(resp *http.Response, body []byte, providerName string) error {
+	err := &APIError{Provider: providerName, StatusCode: resp.StatusCode, Body: string(body)}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		err.RetryAfter = retryDelay(resp.Header)
+	}
+	return err
+}
@@ -1,19 +1,27 @@
 package provider
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"strings"
 
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
 )
 
+// GeminiProvider queries Google's Gemini models
 type GeminiProvider struct {
 	apiKey string
 	model  string
+	settings
 }
 
+// NewGeminiProvider returns a provider for model, or the first built-in model
+// when model is empty
 func NewGeminiProvider(apiKey, model string) *GeminiProvider {
 	// If no model specified, it will be set to first available from fallback list
 	// when the provider is actually used
@@ -23,191 +31,200 @@ func NewGeminiProvider(apiKey, model string) *GeminiProvider {
 	}
 }
 
-func (g *GeminiProvider) QueryStream(prompt string, writer io.Writer) error {
-	ctx := context.Background()
+// newClient returns an SDK client, for listing models, whose requests go
+// over the shared transport, through the SetWrapTransport function like
+// other providers'. The SDK ignores the API key option for REST calls once
+// given an HTTP client, so the transport adds the key itself.
+func (g *GeminiProvider) newClient(ctx context.Context) (*genai.Client, error) {
+	return genai.NewClient(ctx,
+		option.WithAPIKey(g.apiKey),
+		option.WithHTTPClient(&http.Client{Transport: googleKeyTransport{apiKey: g.apiKey}}),
+	)
+}
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(g.apiKey))
-	if err != nil {
-		return fmt.Errorf("failed to create Gemini client: %w", err)
-	}
-	defer client.Close()
+// geminiPart, geminiContent, and geminiRequest are the parts of a
+// generateContent request ask uses
+type geminiPart struct {
+	Text string `json:"text"`
+}
 
-	// Normalize model name (remove "models/" prefix if present)
-	modelName := g.model
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
 
-	// If no model specified, use first available from fallback
-	if modelName == "" {
-		fallbackModels := getFallbackGeminiModels()
-		if len(fallbackModels) > 0 {
-			modelName = fallbackModels[0].ID
-		}
-	}
+type geminiSafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
 
-	if len(modelName) > 7 && modelName[:7] == "models/" {
-		modelName = modelName[7:]
-	}
+type geminiGenerationConfig struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+}
 
-	model := client.GenerativeModel(modelName)
+type geminiRequest struct {
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	Contents          []*geminiContent       `json:"contents"`
+	SafetySettings    []geminiSafetySetting  `json:"safetySettings"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
 
-	// Configure safety settings to be less restrictive
-	model.SafetySettings = []*genai.SafetySetting{
-		{
-			Category:  genai.HarmCategoryHarassment,
-			Threshold: genai.HarmBlockOnlyHigh,
-		},
-		{
-			Category:  genai.HarmCategoryHateSpeech,
-			Threshold: genai.HarmBlockOnlyHigh,
-		},
-		{
-			Category:  genai.HarmCategorySexuallyExplicit,
-			Threshold: genai.HarmBlockOnlyHigh,
-		},
-		{
-			Category:  genai.HarmCategoryDangerousContent,
-			Threshold: genai.HarmBlockOnlyHigh,
-		},
+// geminiSafetySettings only block content with a high probability of harm
+var geminiSafetySettings = []geminiSafetySetting{
+	{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_ONLY_HIGH"},
+	{Category: "HARM_CATEGORY_HATE_SPEECH", Threshold: "BLOCK_ONLY_HIGH"},
+	{Category: "HARM_CATEGORY_SEXUALLY_EXPLICIT", Threshold: "BLOCK_ONLY_HIGH"},
+	{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_ONLY_HIGH"},
+}
+
+func (g *GeminiProvider) QueryStream(ctx context.Context, prompt string, writer io.Writer) error {
+	return g.QueryStreamWithHistory(ctx, []Message{{Role: "user", Content: prompt}}, writer)
+}
+
+// QueryStreamWithHistory sends the conversation as one chat: earlier turns
+// are its history, with assistant turns in the model role, and it must end
+// with a user message. The answer is streamed as server-sent events over
+// the shared transport, like the other providers'.
+func (g *GeminiProvider) QueryStreamWithHistory(ctx context.Context, messages []Message, writer io.Writer) error {
+	system, contents, err := geminiContents(messages)
+	if err != nil {
+		return err
+	}
+	if contents[len(contents)-1].Role != "user" {
+		return fmt.Errorf("the conversation must end with a user message")
 	}
 
-	// Stream the response
-	iter := model.GenerateContentStream(ctx, genai.Text(prompt))
-	hasContent := false
+	opts := g.options()
+	reqBody := geminiRequest{
+		Contents:       contents,
+		SafetySettings: geminiSafetySettings,
+		GenerationConfig: geminiGenerationConfig{
+			Temperature:     opts.Temperature,
+			MaxOutputTokens: opts.MaxTokens,
+		},
+	}
+	if len(system) > 0 {
+		// Gemini takes the system prompt as a separate instruction
+		reqBody.SystemInstruction = &geminiContent{Parts: system}
+	}
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
 
-	for {
-		resp, err := iter.Next()
-		if err != nil {
-			// Check if we've reached the end of the stream
-			if err.Error() == "no more items in iterator" {
-				break
-			}
-			return fmt.Errorf("error during streaming: %w", err)
-		}
+	url := "https://generativelanguage.googleapis.com/v1beta/models/" + g.modelName() + ":streamGenerateContent?alt=sse"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
 
-		for _, cand := range resp.Candidates {
-			// Check if response was blocked
-			if cand.FinishReason != 0 && cand.FinishReason != 1 { // 0=UNSPECIFIED, 1=STOP (normal)
-				return fmt.Errorf("response blocked (reason: %v). This may be due to safety filters", cand.FinishReason)
-			}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", g.apiKey)
 
-			if cand.Content != nil {
-				for _, part := range cand.Content.Parts {
-					fmt.Fprint(writer, part)
-					hasContent = true
-				}
-			}
-		}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	if !hasContent {
-		return fmt.Errorf("no content received from model - response may have been filtered")
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return responseError(resp, body, "Gemini")
 	}
 
-	return nil
+	return copyGeminiStream(resp.Body, writer)
 }
 
-func (g *GeminiProvider) QueryStreamWithHistory(messages []Message, writer io.Writer) error {
-	ctx := context.Background()
-
-	client, err := genai.NewClient(ctx, option.WithAPIKey(g.apiKey))
-	if err != nil {
-		return fmt.Errorf("failed to create Gemini client: %w", err)
+// geminiContents splits messages into the system instruction and the chat
+// turns. Gemini wants turns to alternate between user and model, so
+// consecutive messages from one side are sent as one turn.
+func geminiContents(messages []Message) ([]geminiPart, []*geminiContent, error) {
+	var system []geminiPart
+	var turns []*geminiContent
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, geminiPart{Text: msg.Content})
+			continue
+		}
+		role := "user"
+		if msg.Role == "assistant" {
+			role = "model"
+		}
+		if n := len(turns); n > 0 && turns[n-1].Role == role {
+			turns[n-1].Parts = append(turns[n-1].Parts, geminiPart{Text: msg.Content})
+			continue
+		}
+		turns = append(turns, &geminiContent{Role: role, Parts: []geminiPart{{Text: msg.Content}}})
 	}
-	defer client.Close()
+	if len(turns) == 0 {
+		return nil, nil, fmt.Errorf("no messages to send")
+	}
+	return system, turns, nil
+}
 
-	// Normalize model name (remove "models/" prefix if present)
+// modelName returns the configured model without a "models/" prefix, or
+// the first built-in model when none is set
+func (g *GeminiProvider) modelName() string {
 	modelName := g.model
-
-	// If no model specified, use first available from fallback
 	if modelName == "" {
 		fallbackModels := getFallbackGeminiModels()
 		if len(fallbackModels) > 0 {
 			modelName = fallbackModels[0].ID
 		}
 	}
+	return strings.TrimPrefix(modelName, "models/")
+}
 
-	if len(modelName) > 7 && modelName[:7] == "models/" {
-		modelName = modelName[7:]
-	}
-
-	model := client.GenerativeModel(modelName)
-
-	// Configure safety settings to be less restrictive
-	model.SafetySettings = []*genai.SafetySetting{
-		{
-			Category:  genai.HarmCategoryHarassment,
-			Threshold: genai.HarmBlockOnlyHigh,
-		},
-		{
-			Category:  genai.HarmCategoryHateSpeech,
-			Threshold: genai.HarmBlockOnlyHigh,
-		},
-		{
-			Category:  genai.HarmCategorySexuallyExplicit,
-			Threshold: genai.HarmBlockOnlyHigh,
-		},
-		{
-			Category:  genai.HarmCategoryDangerousContent,
-			Threshold: genai.HarmBlockOnlyHigh,
-		},
-	}
-
-	// Start a chat session
-	cs := model.StartChat()
-
-	// Add history (all messages except the last one)
-	for i := 0; i < len(messages)-1; i++ {
-		msg := messages[i]
-		role := "user"
-		if msg.Role == "assistant" {
-			role = "model"
-		}
-		cs.History = append(cs.History, &genai.Content{
-			Parts: []genai.Part{genai.Text(msg.Content)},
-			Role:  role,
-		})
-	}
+// geminiStreamChunk is one event of a streamGenerateContent stream
+type geminiStreamChunk struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+}
 
-	// Send the last message (the current user prompt)
-	lastMessage := messages[len(messages)-1]
-	iter := cs.SendMessageStream(ctx, genai.Text(lastMessage.Content))
+// copyGeminiStream writes the text of a streamGenerateContent stream to w
+// as it arrives. Events that can't be read are skipped.
+func copyGeminiStream(body io.Reader, w io.Writer) error {
 	hasContent := false
-
-	for {
-		resp, err := iter.Next()
-		if err != nil {
-			// Check if we've reached the end of the stream
-			if err.Error() == "no more items in iterator" {
-				break
-			}
-			return fmt.Errorf("error during streaming: %w", err)
+	var werr error
+	err := readSSE(body, func(data []byte) bool {
+		var chunk geminiStreamChunk
+		if json.Unmarshal(data, &chunk) != nil {
+			return true
 		}
-
-		for _, cand := range resp.Candidates {
+		for _, cand := range chunk.Candidates {
 			// Check if response was blocked
-			if cand.FinishReason != 0 && cand.FinishReason != 1 { // 0=UNSPECIFIED, 1=STOP (normal)
-				return fmt.Errorf("response blocked (reason: %v). This may be due to safety filters", cand.FinishReason)
+			if cand.FinishReason != "" && cand.FinishReason != "STOP" {
+				werr = fmt.Errorf("response blocked (reason: %s). This may be due to safety filters", cand.FinishReason)
+				return false
 			}
-
-			if cand.Content != nil {
-				for _, part := range cand.Content.Parts {
-					fmt.Fprint(writer, part)
-					hasContent = true
+			for _, part := range cand.Content.Parts {
+				if _, werr = io.WriteString(w, part.Text); werr != nil {
+					return false
 				}
+				hasContent = hasContent || part.Text != ""
 			}
 		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if werr != nil {
+		return werr
 	}
-
 	if !hasContent {
 		return fmt.Errorf("no content received from model - response may have been filtered")
 	}
-
 	return nil
 }
 
-func (g *GeminiProvider) ListModels() ([]ModelInfo, error) {
-	ctx := context.Background()
-
-	client, err := genai.NewClient(ctx, option.WithAPIKey(g.apiKey))
+// ListModels returns the chat models the API lists, or a built-in list
+// when it can't be reached
+func (g *GeminiProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	client, err := g.newClient(ctx)
 	if err != nil {
 		// Fallback to hardcoded list if API call fails
 		return getFallbackGeminiModels(), nil
@@ -2,23 +2,91 @@ package provider
 
 import (
 	"crypto/tls"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
-// secureHTTPClient returns an HTTP client with explicit TLS verification
-// and reasonable timeouts for API calls.
-func secureHTTPClient() *http.Client {
-	return &http.Client{
-		Timeout: 120 * time.Second, // Overall request timeout
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				MinVersion:         tls.VersionTLS12,
-				InsecureSkipVerify: false, // Explicitly verify certificates
-			},
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ResponseHeaderTimeout: 60 * time.Second,
-		},
+// wrapTransport holds the function set by SetWrapTransport. It is atomic
+// because the daemon replaces it while requests are running.
+var wrapTransport atomic.Pointer[func(http.RoundTripper) http.RoundTripper]
+
+// SetWrapTransport sets a function that wraps the transport of every API
+// request, for example to record requests, or removes it when wrap is nil.
+func SetWrapTransport(wrap func(http.RoundTripper) http.RoundTripper) {
+	if wrap == nil {
+		wrapTransport.Store(nil)
+		return
+	}
+	wrapTransport.Store(&wrap)
+}
+
+// TransportWrapped reports whether SetWrapTransport has set a function
+func TransportWrapped() bool {
+	return wrapTransport.Load() != nil
+}
+
+// sharedTransport holds the connection pool for every request, so
+// sequential queries in a session reuse a warm TLS connection instead of
+// dialing again. Setting TLSClientConfig turns off HTTP/2 unless it is
+// asked for explicitly. Responses are compressed: the transport sends
+// Accept-Encoding: gzip and decodes the body, which shrinks large model
+// lists several times over. Setting Accept-Encoding on a request turns this
+// off for it, so don't.
+var sharedTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	TLSClientConfig: &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: false, // Explicitly verify certificates
+	},
+	ForceAttemptHTTP2:     true,
+	DisableCompression:    false, // see above
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   10,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 60 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+// HTTPClient shares the providers' connection pool with other requests,
+// such as webhooks and speech. It has no overall timeout, so bound each
+// request with its context.
+var HTTPClient = &http.Client{Transport: sharedTransport}
+
+// apiClient is the client for provider API calls
+var apiClient = &http.Client{
+	Timeout:   120 * time.Second, // Overall request timeout
+	Transport: apiTransport{},
+}
+
+// apiTransport sends requests over the shared transport, through the
+// function from SetWrapTransport when one is set, retrying short rate
+// limits. The function is looked up on each request, since it changes when
+// the daemon reloads its config.
+type apiTransport struct{}
+
+func (apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var rt http.RoundTripper = sharedTransport
+	if wrap := wrapTransport.Load(); wrap != nil {
+		rt = (*wrap)(sharedTransport)
 	}
+	return roundTripWithRetry(rt, req)
+}
+
+// googleKeyTransport sends the Gemini SDK's requests through apiTransport,
+// adding the API key the SDK leaves out when given its own HTTP client
+type googleKeyTransport struct {
+	apiKey string
+}
+
+func (t googleKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("x-goog-api-key", t.apiKey)
+	return apiTransport{}.RoundTrip(req)
 }
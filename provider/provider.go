@@ -1,31 +1,15 @@
-// Package provider defines the interface and implementations for AI model providers.
-// Each provider (Gemini, Claude, ChatGPT, DeepSeek) implements streaming queries
-// and dynamic model discovery.
 package provider
 
 import (
-	"fmt"
+	"context"
 	"io"
+	"sync"
 )
 
-// HandleAPIError returns a user-friendly error message for common API errors
-func HandleAPIError(statusCode int, body []byte, providerName string) error {
-	switch statusCode {
-	case 401:
-		return fmt.Errorf("[!] Invalid API key for %s. Check your config.yaml", providerName)
-	case 402:
-		return fmt.Errorf("[!] Insufficient balance/credits for %s. Please add funds to your account", providerName)
-	case 429:
-		return fmt.Errorf("[!] Rate limit exceeded for %s. Please wait and try again", providerName)
-	default:
-		return fmt.Errorf("API error (status %d): %s", statusCode, string(body))
-	}
-}
-
 // Message represents a single message in a conversation
 type Message struct {
-	Role    string // "user" or "assistant"
-	Content string
+	Role    string `json:"role"` // "system", "user", or "assistant"
+	Content string `json:"content"`
 }
 
 // ModelInfo contains information about an available model
@@ -35,14 +19,72 @@ type ModelInfo struct {
 	Description string
 }
 
-// Provider defines the interface for AI model providers
+// Options are optional generation settings. Zero values leave the
+// provider's defaults in place.
+type Options struct {
+	Temperature *float64 // nil for the model default
+	MaxTokens   int      // 0 for the provider default
+}
+
+// Configurable is implemented by providers that accept generation options
+type Configurable interface {
+	SetOptions(opts Options)
+}
+
+// settings holds a provider's generation options. A query reads them once
+// when it starts, so SetOptions doesn't change a request in flight.
+type settings struct {
+	mu   sync.Mutex
+	opts Options
+}
+
+// SetOptions sets generation options for subsequent requests
+func (s *settings) SetOptions(opts Options) {
+	s.mu.Lock()
+	s.opts = opts
+	s.mu.Unlock()
+}
+
+// options returns the current generation options
+func (s *settings) options() Options {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts
+}
+
+// Provider defines the interface for AI model providers. The built-in
+// providers are safe for concurrent use: one can serve several queries at
+// once, as in the daemon and compare mode, and SetOptions may be called
+// while they run. Custom providers must be too.
 type Provider interface {
-	// QueryStream sends a prompt and streams the response to the writer in real-time
-	QueryStream(prompt string, writer io.Writer) error
+	// QueryStream sends a prompt and streams the response to the writer in real-time.
+	// Cancelling ctx aborts the request.
+	QueryStream(ctx context.Context, prompt string, writer io.Writer) error
 
 	// QueryStreamWithHistory sends a prompt with conversation history and streams the response
-	QueryStreamWithHistory(messages []Message, writer io.Writer) error
+	QueryStreamWithHistory(ctx context.Context, messages []Message, writer io.Writer) error
+
+	// ListModels returns available models for this provider, or a built-in
+	// list when the provider's API can't be reached
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
 
-	// ListModels returns available models for this provider
-	ListModels() ([]ModelInfo, error)
+// FallbackModels returns the built-in list ListModels falls back to when a
+// provider's API can't be reached, or nil for an unknown provider
+func FallbackModels(name string) []ModelInfo {
+	switch name {
+	case "gemini":
+		return getFallbackGeminiModels()
+	case "claude":
+		return getFallbackClaudeModels()
+	case "chatgpt":
+		return getFallbackChatGPTModels()
+	case "deepseek":
+		return getFallbackDeepSeekModels()
+	case "mistral":
+		return getFallbackMistralModels()
+	case "qwen":
+		return getFallbackQwenModels()
+	}
+	return nil
 }
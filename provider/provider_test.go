@@ -0,0 +1,153 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// echoServer answers chat requests in the OpenAI, Anthropic, and Gemini
+// stream formats with the last message it was sent
+func echoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			geminiEcho(w, r)
+			return
+		}
+		var body struct {
+			Messages []Message `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Messages) == 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		text, _ := json.Marshal(body.Messages[len(body.Messages)-1].Content)
+		w.Header().Set("Content-Type", "text/event-stream")
+		if strings.HasSuffix(r.URL.Path, "/messages") {
+			fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":%s}}\n\n", text)
+			fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+			return
+		}
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%s}}]}\n\n", text)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+// geminiEcho answers a Gemini streaming request, checking for the API key
+// header
+func geminiEcho(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("x-goog-api-key") != "test-key" {
+		http.Error(w, `{"error":{"code":403,"message":"no API key"}}`, http.StatusForbidden)
+		return
+	}
+	var body struct {
+		Contents []struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"contents"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Contents) == 0 {
+		http.Error(w, `{"error":{"code":400,"message":"bad request"}}`, http.StatusBadRequest)
+		return
+	}
+	parts := body.Contents[len(body.Contents)-1].Parts
+	text, _ := json.Marshal(parts[len(parts)-1].Text)
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(w, "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":%s}]}}]}\n\n", text)
+	fmt.Fprint(w, "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"\"}]},\"finishReason\":\"STOP\"}]}\n\n")
+}
+
+// redirectTo wraps transports to send every API request to srv
+func redirectTo(srv *httptest.Server, calls *atomic.Int64) func(http.RoundTripper) http.RoundTripper {
+	target, _ := url.Parse(srv.URL)
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			req = req.Clone(req.Context())
+			req.URL.Scheme, req.URL.Host, req.Host = target.Scheme, target.Host, target.Host
+			return rt.RoundTrip(req)
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// TestConcurrentQueries shares each provider between goroutines that
+// query it while others change its options and the transport wrapper. Run
+// it with -race.
+func TestConcurrentQueries(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+	var calls atomic.Int64
+	wrap := redirectTo(srv, &calls)
+	SetWrapTransport(wrap)
+	defer SetWrapTransport(nil)
+
+	for _, name := range []string{"chatgpt", "claude", "deepseek", "gemini", "mistral", "qwen"} {
+		t.Run(name, func(t *testing.T) {
+			p, err := New(name, "test-key", "test-model")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			for i := range 8 {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					temp := float64(i) / 10
+					p.(Configurable).SetOptions(Options{Temperature: &temp, MaxTokens: 100 + i})
+					// Replacing the wrapper while requests run must be safe
+					SetWrapTransport(wrap)
+				}()
+				go func() {
+					defer wg.Done()
+					prompt := fmt.Sprintf("question %d", i)
+					var out strings.Builder
+					history := []Message{{Role: "user", Content: "earlier"}, {Role: "assistant", Content: "answer"}, {Role: "user", Content: prompt}}
+					if err := p.QueryStreamWithHistory(context.Background(), history, &out); err != nil {
+						t.Errorf("query %d: %v", i, err)
+						return
+					}
+					if out.String() != prompt {
+						t.Errorf("query %d got %q, want %q", i, out.String(), prompt)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+	if calls.Load() == 0 {
+		t.Fatal("no request went through the wrapped transport")
+	}
+}
+
+// TestGeminiWrappedTransport checks that Gemini requests go through the SetWrapTransport function, with the API key, like the other
+// providers', so audit records cover them
+func TestGeminiWrappedTransport(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+	var calls atomic.Int64
+	SetWrapTransport(redirectTo(srv, &calls))
+	defer SetWrapTransport(nil)
+
+	var out strings.Builder
+	if err := NewGeminiProvider("test-key", "test-model").QueryStream(context.Background(), "hello", &out); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() == 0 {
+		t.Fatal("the request didn't go through the wrapped transport")
+	}
+	if out.String() != "hello" {
+		t.Errorf("got %q, want the echoed prompt", out.String())
+	}
+}
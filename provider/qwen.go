@@ -1,22 +1,25 @@
 package provider
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 )
 
 const qwenAPIURL = "https://dashscope-intl.aliyuncs.com/compatible-mode/v1/chat/completions"
 
+// QwenProvider queries Alibaba's Qwen models
 type QwenProvider struct {
 	apiKey string
 	model  string
+	settings
 }
 
+// NewQwenProvider returns a provider for model, or the first built-in model
+// when model is empty
 func NewQwenProvider(apiKey, model string) *QwenProvider {
 	if model == "" {
 		fallbackModels := getFallbackQwenModels()
@@ -31,9 +34,11 @@ func NewQwenProvider(apiKey, model string) *QwenProvider {
 }
 
 type qwenRequest struct {
-	Model    string        `json:"model"`
-	Messages []qwenMessage `json:"messages"`
-	Stream   bool          `json:"stream"`
+	Model       string        `json:"model"`
+	Messages    []qwenMessage `json:"messages"`
+	Stream      bool          `json:"stream"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
 }
 
 type qwenMessage struct {
@@ -41,15 +46,8 @@ type qwenMessage struct {
 	Content string `json:"content"`
 }
 
-type qwenStreamResponse struct {
-	Choices []struct {
-		Delta struct {
-			Content string `json:"content"`
-		} `json:"delta"`
-	} `json:"choices"`
-}
-
-func (q *QwenProvider) QueryStream(prompt string, writer io.Writer) error {
+func (q *QwenProvider) QueryStream(ctx context.Context, prompt string, writer io.Writer) error {
+	opts := q.options()
 	reqBody := qwenRequest{
 		Model: q.model,
 		Messages: []qwenMessage{
@@ -58,7 +56,9 @@ func (q *QwenProvider) QueryStream(prompt string, writer io.Writer) error {
 				Content: prompt,
 			},
 		},
-		Stream: true,
+		Stream:      true,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -66,7 +66,7 @@ func (q *QwenProvider) QueryStream(prompt string, writer io.Writer) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", qwenAPIURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", qwenAPIURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -74,8 +74,7 @@ func (q *QwenProvider) QueryStream(prompt string, writer io.Writer) error {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+q.apiKey)
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -83,48 +82,25 @@ func (q *QwenProvider) QueryStream(prompt string, writer io.Writer) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return HandleAPIError(resp.StatusCode, body, "Qwen")
-	}
-
-	// Parse SSE stream
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var streamResp qwenStreamResponse
-			if err := json.Unmarshal([]byte(data), &streamResp); err == nil {
-				if len(streamResp.Choices) > 0 {
-					content := streamResp.Choices[0].Delta.Content
-					if content != "" {
-						fmt.Fprint(writer, content)
-					}
-				}
-			}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading stream: %w", err)
+		return responseError(resp, body, "Qwen")
 	}
 
-	return nil
+	return copyChatStream(resp.Body, writer)
 }
 
-func (q *QwenProvider) QueryStreamWithHistory(messages []Message, writer io.Writer) error {
+func (q *QwenProvider) QueryStreamWithHistory(ctx context.Context, messages []Message, writer io.Writer) error {
+	opts := q.options()
 	var qwenMessages []qwenMessage
 	for _, msg := range messages {
 		qwenMessages = append(qwenMessages, qwenMessage(msg))
 	}
 
 	reqBody := qwenRequest{
-		Model:    q.model,
-		Messages: qwenMessages,
-		Stream:   true,
+		Model:       q.model,
+		Messages:    qwenMessages,
+		Stream:      true,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -132,7 +108,7 @@ func (q *QwenProvider) QueryStreamWithHistory(messages []Message, writer io.Writ
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", qwenAPIURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", qwenAPIURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -140,8 +116,7 @@ func (q *QwenProvider) QueryStreamWithHistory(messages []Message, writer io.Writ
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+q.apiKey)
 
-	client := secureHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -149,39 +124,14 @@ func (q *QwenProvider) QueryStreamWithHistory(messages []Message, writer io.Writ
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return HandleAPIError(resp.StatusCode, body, "Qwen")
-	}
-
-	// Parse SSE stream
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var streamResp qwenStreamResponse
-			if err := json.Unmarshal([]byte(data), &streamResp); err == nil {
-				if len(streamResp.Choices) > 0 {
-					content := streamResp.Choices[0].Delta.Content
-					if content != "" {
-						fmt.Fprint(writer, content)
-					}
-				}
-			}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading stream: %w", err)
+		return responseError(resp, body, "Qwen")
 	}
 
-	return nil
+	return copyChatStream(resp.Body, writer)
 }
 
-func (q *QwenProvider) ListModels() ([]ModelInfo, error) {
+// ListModels returns the built-in list of Qwen models
+func (q *QwenProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
 	// Qwen doesn't have a public models list API, return fallback
 	return getFallbackQwenModels(), nil
 }
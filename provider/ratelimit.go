@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A 429 that says to wait at most maxRateLimitWait is retried after the
+// wait, up to maxRateLimitRetries times. Longer waits are returned as an
+// *APIError with RetryAfter set.
+const (
+	maxRateLimitWait    = 20 * time.Second
+	maxRateLimitRetries = 2
+)
+
+// rateLimitResets pairs the remaining-quota and reset headers providers
+// send: OpenAI-style x-ratelimit-* and Anthropic's anthropic-ratelimit-*
+var rateLimitResets = [][2]string{
+	{"x-ratelimit-remaining-requests", "x-ratelimit-reset-requests"},
+	{"x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens"},
+	{"x-ratelimit-remaining", "x-ratelimit-reset"},
+	{"anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-reset"},
+	{"anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-reset"},
+	{"anthropic-ratelimit-input-tokens-remaining", "anthropic-ratelimit-input-tokens-reset"},
+	{"anthropic-ratelimit-output-tokens-remaining", "anthropic-ratelimit-output-tokens-reset"},
+}
+
+// retryDelay returns how long a rate-limited response says to wait, or 0
+// when it doesn't say. Retry-After wins; otherwise it is the latest reset
+// of the limits that ran out, or of any limit if none is reported as out.
+func retryDelay(h http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(h.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	if v := strings.TrimSpace(h.Get("Retry-After")); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			return max(time.Duration(secs*float64(time.Second)), 0)
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(time.Until(t), 0)
+		}
+	}
+	var exhausted, latest time.Duration
+	for _, pair := range rateLimitResets {
+		d := parseReset(h.Get(pair[1]))
+		latest = max(latest, d)
+		if strings.TrimSpace(h.Get(pair[0])) == "0" {
+			exhausted = max(exhausted, d)
+		}
+	}
+	if exhausted > 0 {
+		return exhausted
+	}
+	return latest
+}
+
+// parseReset reads a reset header: a duration such as "6m0s" (OpenAI), a
+// timestamp (Anthropic), seconds to wait, or a Unix time
+func parseReset(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return max(d, 0)
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil {
+		if n > 1e9 { // a Unix time rather than a delay
+			return max(time.Until(time.Unix(int64(n), 0)), 0)
+		}
+		return max(time.Duration(n*float64(time.Second)), 0)
+	}
+	return 0
+}
+
+// roundTripWithRetry sends req, waiting and sending it again when it is
+// rate limited for a short while. The wait ends early if the request's
+// context is cancelled.
+func roundTripWithRetry(rt http.RoundTripper, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := rt.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt == maxRateLimitRetries {
+			return resp, err
+		}
+		wait := retryDelay(resp.Header)
+		if wait <= 0 || wait > maxRateLimitWait || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		next := req.Clone(req.Context())
+		if req.GetBody != nil {
+			if next.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		req = next
+	}
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Factory creates a provider for an API key and model. An empty model
+// selects the provider's default.
+type Factory func(apiKey, model string) Provider
+
+// Registry maps provider names to factories
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{factories: map[string]Factory{}}
+}
+
+// Register adds or replaces the factory for name
+func (r *Registry) Register(name string, f Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = f
+}
+
+// New creates the named provider, or returns an error wrapping
+// ErrUnknownProvider
+func (r *Registry) New(name, apiKey, model string) (Provider, error) {
+	r.mu.RLock()
+	f, ok := r.factories[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return f(apiKey, model), nil
+}
+
+// Names returns the registered provider names, sorted
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// DefaultRegistry holds the built-in providers
+var DefaultRegistry = NewRegistry()
+
+func init() {
+	DefaultRegistry.Register("gemini", func(apiKey, model string) Provider { return NewGeminiProvider(apiKey, model) })
+	DefaultRegistry.Register("claude", func(apiKey, model string) Provider { return NewClaudeProvider(apiKey, model) })
+	DefaultRegistry.Register("chatgpt", func(apiKey, model string) Provider { return NewChatGPTProvider(apiKey, model) })
+	DefaultRegistry.Register("deepseek", func(apiKey, model string) Provider { return NewDeepSeekProvider(apiKey, model) })
+	DefaultRegistry.Register("mistral", func(apiKey, model string) Provider { return NewMistralProvider(apiKey, model) })
+	DefaultRegistry.Register("qwen", func(apiKey, model string) Provider { return NewQwenProvider(apiKey, model) })
+}
+
+// New creates a built-in provider by name: gemini, claude, chatgpt,
+// deepseek, mistral, or qwen
+func New(name, apiKey, model string) (Provider, error) {
+	return DefaultRegistry.New(name, apiKey, model)
+}
@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+var (
+	sseData = []byte("data:")
+	sseDone = []byte("[DONE]")
+)
+
+// readSSE calls onData with the payload of each data line of a server-sent
+// event stream, until the stream ends, [DONE] arrives, or onData returns
+// false. The payload is only valid until onData returns. Lines are read
+// in place from one buffer, so a long answer doesn't allocate per event.
+func readSSE(r io.Reader, onData func(data []byte) bool) error {
+	br := bufio.NewReaderSize(r, 16<<10)
+	var long []byte // a line longer than the buffer, put together
+	for {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			long = append(long, line...)
+			continue
+		}
+		if len(long) > 0 {
+			long = append(long, line...)
+			line, long = long, long[:0]
+		}
+		if data, ok := bytes.CutPrefix(bytes.TrimRight(line, "\r\n"), sseData); ok {
+			data = bytes.TrimPrefix(data, []byte(" "))
+			if bytes.Equal(data, sseDone) || !onData(data) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading stream: %w", err)
+		}
+	}
+}
+
+// chatStreamChunk is one event of an OpenAI-style chat completion stream,
+// which ChatGPT, DeepSeek, Mistral, and Qwen all send
+type chatStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// copyChatStream writes the text of an OpenAI-style chat completion
+// stream to w as it arrives. Events that can't be read are skipped.
+func copyChatStream(body io.Reader, w io.Writer) error {
+	var chunk chatStreamChunk
+	var werr error
+	err := readSSE(body, func(data []byte) bool {
+		// Reuse the slice, but don't let an event inherit the last one's text
+		clear(chunk.Choices[:cap(chunk.Choices)])
+		chunk.Choices = chunk.Choices[:0]
+		if json.Unmarshal(data, &chunk) == nil && len(chunk.Choices) > 0 {
+			if content := chunk.Choices[0].Delta.Content; content != "" {
+				_, werr = io.WriteString(w, content)
+			}
+		}
+		return werr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return werr
+}
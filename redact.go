@@ -0,0 +1,151 @@
+// Package main provides scanning of outgoing prompts for secrets.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// RedactConfig controls what happens when a prompt or attached file
+// contains something that looks like a secret
+type RedactConfig struct {
+	Mode     string            `yaml:"mode,omitempty"`     // off (default), mask, or block
+	Patterns map[string]string `yaml:"patterns,omitempty"` // extra name: regular expression
+}
+
+// builtinSecretPatterns are always checked when redaction is on
+var builtinSecretPatterns = map[string]string{
+	"aws-access-key": `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	"aws-secret-key": `(?i)aws_secret_access_key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`,
+	"private-key":    `-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+	"bearer-token":   `(?i)\bbearer\s+[A-Za-z0-9._~+/=-]{16,}`,
+	"api-key":        `\bsk-[A-Za-z0-9_-]{20,}`,
+	"google-api-key": `\bAIza[0-9A-Za-z_-]{35}`,
+	"github-token":   `\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`,
+	"slack-token":    `\bxox[abprs]-[A-Za-z0-9-]{10,}`,
+}
+
+// secretPattern is a named regular expression for one kind of secret
+type secretPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// secretScanner finds secrets in outgoing text
+type secretScanner struct {
+	mask     bool // replace secrets instead of asking before sending
+	patterns []secretPattern
+}
+
+// outboundSecrets is set from the redact config; nil when redaction is off.
+// It is atomic because the daemon replaces it while requests run.
+var outboundSecrets atomic.Pointer[secretScanner]
+
+// newSecretScanner compiles the built-in and configured patterns. It
+// returns nil when redaction is off.
+func newSecretScanner(config RedactConfig) (*secretScanner, error) {
+	switch config.Mode {
+	case "", "off":
+		return nil, nil
+	case "mask", "block":
+	default:
+		return nil, fmt.Errorf("unknown redact mode '%s' (use off, mask, or block)", config.Mode)
+	}
+
+	all := make(map[string]string, len(builtinSecretPatterns)+len(config.Patterns))
+	for name, expr := range builtinSecretPatterns {
+		all[name] = expr
+	}
+	for name, expr := range config.Patterns {
+		all[name] = expr
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sc := &secretScanner{mask: config.Mode == "mask"}
+	for _, name := range names {
+		re, err := regexp.Compile(all[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern '%s': %v", name, err)
+		}
+		sc.patterns = append(sc.patterns, secretPattern{name, re})
+	}
+	return sc, nil
+}
+
+// scan returns the text with secrets masked and a summary of what was
+// found, such as "aws-access-key ×2, private-key"
+func (sc *secretScanner) scan(text string) (string, string) {
+	var found []string
+	for _, p := range sc.patterns {
+		n := 0
+		text = p.re.ReplaceAllStringFunc(text, func(string) string {
+			n++
+			return "[REDACTED " + p.name + "]"
+		})
+		switch {
+		case n == 1:
+			found = append(found, p.name)
+		case n > 1:
+			found = append(found, fmt.Sprintf("%s ×%d", p.name, n))
+		}
+	}
+	return text, strings.Join(found, ", ")
+}
+
+// screenSecrets checks text about to be sent to a provider. In mask mode
+// secrets are replaced; in block mode confirm is asked whether to send the
+// text unchanged. It returns the text to send, or false to send nothing.
+func screenSecrets(text string, confirm func(prompt string) bool) (string, bool) {
+	scanner := outboundSecrets.Load()
+	if scanner == nil {
+		return text, true
+	}
+	masked, found := scanner.scan(text)
+	if found == "" {
+		return text, true
+	}
+	if machineMode {
+		if scanner.mask {
+			fmt.Fprintf(os.Stderr, "ask: masked before sending: %s\n", found)
+			return masked, true
+		}
+		return "", false
+	}
+	if scanner.mask {
+		fmt.Fprintf(os.Stderr, "%s⚠ Masked before sending: %s%s\n", yellow, found, reset)
+		return masked, true
+	}
+	fmt.Fprintf(os.Stderr, "%s%s⚠ This looks like it contains secrets: %s%s\n", bold, yellow, found, reset)
+	if confirm(fmt.Sprintf("%sSend it anyway? [y/N]: %s", yellow, reset)) {
+		return text, true
+	}
+	return "", false
+}
+
+// confirmStdin asks a yes/no question on standard input, defaulting to no
+func confirmStdin(prompt string) bool {
+	fmt.Fprint(os.Stderr, prompt)
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+	return answer == "y" || answer == "yes"
+}
+
+// confirm asks a yes/no question at the session prompt, defaulting to no
+func (s *Session) confirm(prompt string) bool {
+	line, ok := s.readLine(prompt)
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return ok && (answer == "y" || answer == "yes")
+}
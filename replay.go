@@ -0,0 +1,315 @@
+// Package main provides `ask replay`, which re-runs a saved conversation's
+// questions against another model and shows both answers side by side.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+// isReplayCommand reports whether the arguments invoke `ask replay`. A
+// model, provider, or profile flag is required, so "ask replay the last
+// game" stays an ordinary prompt.
+func isReplayCommand(args []string) bool {
+	if len(args) < 2 || args[0] != "replay" {
+		return false
+	}
+	for _, arg := range args[1:] {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && (name == "m" || name == "model" || name == "p" || name == "provider" || name == "P" || name == "profile") {
+			return true
+		}
+	}
+	return false
+}
+
+// replayTurn is one question with the original answer and the replayed one
+type replayTurn struct {
+	prompt   string
+	original SessionMessage
+	replayed string
+	err      error
+}
+
+// loadReplaySource finds a saved session by name, or a one-shot history
+// entry by id
+func loadReplaySource(arg string) (*SavedSession, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := readSessions(db, "WHERE name = ?", arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 1 {
+		return sessions[0], nil
+	}
+	if id, err := strconv.Atoi(strings.TrimPrefix(arg, "#")); err == nil {
+		var providerName, modelName, prompt, response string
+		var created int64
+		err := db.QueryRow("SELECT provider, model, prompt, response, created_at FROM history WHERE id = ?", id).
+			Scan(&providerName, &modelName, &prompt, &response, &created)
+		if err == nil {
+			t := fromMillis(created)
+			return &SavedSession{
+				Name:     fmt.Sprintf("History #%d", id),
+				Provider: providerName,
+				Model:    modelName,
+				Messages: []SessionMessage{
+					{Role: "user", Content: prompt, Time: t},
+					{Role: "assistant", Content: response, Model: providerName + "/" + modelName, Time: t},
+				},
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("no saved session or history entry '%s'", arg)
+}
+
+// runReplayCommand replays every question of a conversation against
+// another model. Each question is sent with the replayed model's own
+// earlier answers, so the result is the conversation that model would have
+// had.
+func runReplayCommand(args []string) error {
+	var providerFlag, modelFlag, profileFlag, format, output string
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.StringVar(&modelFlag, "model", "", "Model to replay with")
+	fs.StringVar(&modelFlag, "m", "", "Model (short for -model)")
+	fs.StringVar(&providerFlag, "provider", "", "Provider to replay with")
+	fs.StringVar(&providerFlag, "p", "", "Provider (short for -provider)")
+	fs.StringVar(&profileFlag, "profile", "", "Profile to replay with")
+	fs.StringVar(&profileFlag, "P", "", "Profile (short for -profile)")
+	fs.StringVar(&format, "format", "", "Output `format`: columns (default on a terminal) or markdown")
+	fs.StringVar(&output, "o", "", "Write a Markdown transcript to `file`")
+
+	// Flags may come before or after the conversation
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rest := fs.Args()
+	if len(rest) > 0 {
+		if err := fs.Parse(rest[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() > 0 {
+			return fmt.Errorf("unexpected argument '%s'", fs.Arg(0))
+		}
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("usage: ask replay <session|history id> -m <model> [--format columns|markdown] [-o file]")
+	}
+	if format == "" {
+		format = "columns"
+		if output != "" || !readline.IsTerminal(int(os.Stdout.Fd())) {
+			format = "markdown"
+		}
+	}
+	if format != "columns" && format != "markdown" {
+		return fmt.Errorf("unknown format '%s' (use columns or markdown)", format)
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	markdownTheme = config.Theme
+	openRequestLog(config)
+	openAudit(config)
+	if err := openScreening(config); err != nil {
+		return err
+	}
+	source, err := loadReplaySource(rest[0])
+	if err != nil {
+		return err
+	}
+	providerName, modelName, err := ResolveModelAndProvider(providerFlag, modelFlag, profileFlag, config)
+	if err != nil {
+		return err
+	}
+	p, modelName, err := newSessionProvider(providerName, modelName)
+	if err != nil {
+		return err
+	}
+	spec := providerName + "/" + modelName
+
+	var turns []*replayTurn
+	for i, msg := range source.Messages {
+		if msg.Role != "user" {
+			continue
+		}
+		turn := &replayTurn{prompt: msg.Content}
+		if i+1 < len(source.Messages) && source.Messages[i+1].Role == "assistant" {
+			turn.original = source.Messages[i+1]
+		}
+		turns = append(turns, turn)
+	}
+	if len(turns) == 0 {
+		return fmt.Errorf("'%s' has no questions to replay", rest[0])
+	}
+	if _, err := checkBudget(config.Budget, budgetOK); err != nil {
+		return err
+	}
+
+	// The replayed model sees its own answers, not the original ones
+	var history []SessionMessage
+	for n, turn := range turns {
+		prompt, ok := screenOutgoing(turn.prompt, confirmStdin)
+		if !ok {
+			turn.err = fmt.Errorf("not sent")
+			turns = turns[:n+1]
+			break
+		}
+		history = append(history, SessionMessage{Role: "user", Content: prompt})
+		msgs := toProviderMessages(source.System, history)
+
+		out := &liveWriter{quiet: true}
+		stop := func() {}
+		if readline.IsTerminal(int(os.Stdout.Fd())) {
+			stop = startSpinner(fmt.Sprintf("Replaying %d/%d with %s", n+1, len(turns), spec), "", &out.received)
+		}
+		start := time.Now()
+		err := p.QueryStreamWithHistory(context.Background(), msgs, out)
+		stop()
+		if err != nil {
+			// Later questions would build on a missing answer, so stop here
+			countError(err)
+			turn.err = err
+			turns = turns[:n+1]
+			break
+		}
+		turn.replayed = out.buf.String()
+		saveUsage("replay", newTurnUsage(spec, msgs, turn.replayed, time.Since(start)))
+		history = append(history, SessionMessage{Role: "assistant", Content: turn.replayed, Model: spec})
+	}
+
+	if output != "" {
+		if err := os.WriteFile(output, []byte(replayMarkdown(source, spec, turns)), 0600); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote replay of %d questions to %s\n", len(turns), output)
+	} else if format == "markdown" {
+		fmt.Print(replayMarkdown(source, spec, turns))
+	} else {
+		printReplayColumns(source, spec, turns)
+	}
+	if last := turns[len(turns)-1]; last.err != nil {
+		return fmt.Errorf("replay stopped at question %d: %v", len(turns), last.err)
+	}
+	return nil
+}
+
+// originalModel names the model behind an original answer
+func originalModel(source *SavedSession, msg SessionMessage) string {
+	if msg.Model != "" {
+		return msg.Model
+	}
+	if source.Provider != "" {
+		return source.Provider + "/" + source.Model
+	}
+	return "original"
+}
+
+// replayMarkdown formats a replay as a Markdown transcript, each question
+// followed by both answers
+func replayMarkdown(source *SavedSession, spec string, turns []*replayTurn) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Replay of %s\n\n", exportTitle(source))
+	fmt.Fprintf(&b, "- Replayed with: %s\n", spec)
+	fmt.Fprintf(&b, "- Replayed: %s\n\n", time.Now().Format("2006-01-02 15:04:05"))
+	for i, turn := range turns {
+		b.WriteString("---\n\n")
+		fmt.Fprintf(&b, "## Question %d\n\n%s\n\n", i+1, strings.TrimSpace(turn.prompt))
+		if turn.original.Content != "" {
+			fmt.Fprintf(&b, "### %s (original)\n\n%s\n\n", originalModel(source, turn.original), strings.TrimSpace(turn.original.Content))
+		}
+		if turn.err != nil {
+			fmt.Fprintf(&b, "### %s (replay)\n\n*Error: %v*\n\n", spec, turn.err)
+		} else {
+			fmt.Fprintf(&b, "### %s (replay)\n\n%s\n\n", spec, strings.TrimSpace(turn.replayed))
+		}
+	}
+	return b.String()
+}
+
+// printReplayColumns prints each question across the terminal, with the
+// original and replayed answers below it in two columns
+func printReplayColumns(source *SavedSession, spec string, turns []*replayTurn) {
+	width := 100
+	if w, _, err := readline.GetSize(int(os.Stdout.Fd())); err == nil && w > 40 {
+		width = w
+	}
+	col := (width - 3) / 2
+
+	for i, turn := range turns {
+		fmt.Printf("\n%s%s› Question %d%s %s\n", bold, cyan, i+1, reset, strings.Join(strings.Fields(turn.prompt), " "))
+		replayed := turn.replayed
+		if turn.err != nil {
+			replayed = "Error: " + turn.err.Error()
+		}
+		left := wrapColumn(turn.original.Content, col)
+		right := wrapColumn(replayed, col)
+		fmt.Printf("%s%s%-*s%s │ %s%s%s%s\n", bold, green, col, truncateRunes(originalModel(source, turn.original), col), reset,
+			bold, magenta, truncateRunes(spec, col), reset)
+		fmt.Printf("%s%s┼%s%s\n", dim, strings.Repeat("─", col+1), strings.Repeat("─", col+1), reset)
+		for row := 0; row < max(len(left), len(right)); row++ {
+			var l, r string
+			if row < len(left) {
+				l = left[row]
+			}
+			if row < len(right) {
+				r = right[row]
+			}
+			fmt.Printf("%s%s │ %s\n", l, strings.Repeat(" ", col-len([]rune(l))), r)
+		}
+	}
+	fmt.Println()
+}
+
+// wrapColumn word-wraps text into lines of at most width characters,
+// splitting words longer than a line
+func wrapColumn(text string, width int) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.TrimSpace(strings.ReplaceAll(text, "\t", "    ")), "\n") {
+		indent := para[:len(para)-len(strings.TrimLeft(para, " "))]
+		if len(indent) > width/2 {
+			indent = ""
+		}
+		line := indent
+		for _, word := range strings.Fields(para) {
+			for len([]rune(word)) > width-len(indent) {
+				if line != indent {
+					lines = append(lines, line)
+				}
+				r := []rune(word)
+				lines = append(lines, indent+string(r[:width-len(indent)]))
+				word, line = string(r[width-len(indent):]), indent
+			}
+			switch {
+			case line == indent:
+				line += word
+			case len([]rune(line))+1+len([]rune(word)) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = indent + word
+			}
+		}
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	return lines
+}
+
+// truncateRunes shortens s to at most n characters
+func truncateRunes(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,202 @@
+// Package main provides the optional JSON Lines log of provider requests.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// requestLogger appends one JSON line per request to the configured file.
+// Only metadata is written: never prompts, answers, or headers.
+type requestLogger struct {
+	mu      sync.Mutex
+	path    string
+	secrets []string // configured API keys, scrubbed from error messages
+}
+
+// requestLog is set from log_file in the config; nil when logging is off.
+// It is atomic because the daemon replaces it while requests run.
+var requestLog atomic.Pointer[requestLogger]
+
+// requestLogEntry is one line of the request log
+type requestLogEntry struct {
+	Time             time.Time `json:"time"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	Status           string    `json:"status"` // ok, error, or cancelled
+	Error            string    `json:"error,omitempty"`
+	LatencyMs        int64     `json:"latency_ms"`
+	Messages         int       `json:"messages"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	PromptHash       string    `json:"prompt_hash"` // start of the SHA-256 of the last user message
+}
+
+// secretPatterns match credentials that could appear in error messages
+// even if they aren't in the config, such as keys echoed back by an API
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_-]{16,}`),
+	regexp.MustCompile(`AIza[0-9A-Za-z_-]{30,}`),
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`(?i)((?:api[_-]?key|key|token)=)[^&\s"]+`),
+}
+
+// openRequestLog turns on request logging when log_file is configured,
+// and off when it isn't
+func openRequestLog(config *Config) {
+	if config.LogFile == "" {
+		requestLog.Store(nil)
+		return
+	}
+	path := config.LogFile
+	if strings.HasPrefix(path, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(homeDir, path[2:])
+		}
+	}
+
+	requestLog.Store(&requestLogger{path: path, secrets: configuredSecrets(config)})
+}
+
+// configuredSecrets returns the API keys in the config, for scrubbing
+func configuredSecrets(config *Config) []string {
+	var secrets []string
+	for _, pc := range config.Providers {
+		if len(pc.APIKey) >= 8 {
+			secrets = append(secrets, pc.APIKey)
+		}
+	}
+	return secrets
+}
+
+// redactSecrets removes the given secrets and anything that looks like a
+// credential from s
+func redactSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "[REDACTED]")
+	}
+	for _, re := range secretPatterns {
+		if re.NumSubexp() > 0 {
+			s = re.ReplaceAllString(s, "${1}[REDACTED]")
+		} else {
+			s = re.ReplaceAllString(s, "[REDACTED]")
+		}
+	}
+	return s
+}
+
+// write appends an entry. Errors are ignored so logging never breaks a request.
+func (l *requestLogger) write(entry requestLogEntry) {
+	entry.Error = redactSecrets(entry.Error, l.secrets)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(data, '\n'))
+}
+
+// loggedProvider wraps a provider to log each request it makes
+type loggedProvider struct {
+	provider.Provider
+	name, model string
+	log         *requestLogger
+}
+
+// withRequestLog wraps p for logging when a log file is configured
+func withRequestLog(p provider.Provider, name, model string) provider.Provider {
+	log := requestLog.Load()
+	if log == nil || p == nil {
+		return p
+	}
+	return &loggedProvider{Provider: p, name: name, model: model, log: log}
+}
+
+func (p *loggedProvider) QueryStream(ctx context.Context, prompt string, writer io.Writer) error {
+	msgs := []provider.Message{{Role: "user", Content: prompt}}
+	return p.logged(ctx, msgs, writer, func(w io.Writer) error {
+		return p.Provider.QueryStream(ctx, prompt, w)
+	})
+}
+
+func (p *loggedProvider) QueryStreamWithHistory(ctx context.Context, messages []provider.Message, writer io.Writer) error {
+	return p.logged(ctx, messages, writer, func(w io.Writer) error {
+		return p.Provider.QueryStreamWithHistory(ctx, messages, w)
+	})
+}
+
+// SetOptions passes generation settings through to the wrapped provider
+func (p *loggedProvider) SetOptions(opts provider.Options) {
+	if c, ok := p.Provider.(provider.Configurable); ok {
+		c.SetOptions(opts)
+	}
+}
+
+// logged runs a request, counting its output, and logs the outcome
+func (p *loggedProvider) logged(ctx context.Context, msgs []provider.Message, writer io.Writer, query func(io.Writer) error) error {
+	start := time.Now()
+	out := &countingWriter{w: writer}
+	err := query(out)
+
+	entry := requestLogEntry{
+		Time:             start,
+		Provider:         p.name,
+		Model:            p.model,
+		Status:           "ok",
+		LatencyMs:        time.Since(start).Milliseconds(),
+		Messages:         len(msgs),
+		CompletionTokens: tokensForBytes(out.n),
+	}
+	var lastPrompt string
+	for _, m := range msgs {
+		entry.PromptTokens += estimateTokens(m.Content)
+		if m.Role == "user" {
+			lastPrompt = m.Content
+		}
+	}
+	sum := sha256.Sum256([]byte(lastPrompt))
+	entry.PromptHash = hex.EncodeToString(sum[:])[:16]
+	switch {
+	case errors.Is(err, context.Canceled) || ctx.Err() != nil:
+		entry.Status = "cancelled"
+	case err != nil:
+		entry.Status, entry.Error = "error", err.Error()
+	}
+	p.log.write(entry)
+	return err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,54 @@
+// Package main provides the retention policy that purges old sessions and
+// history.
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// purgeOlderThan deletes one-shot history, saved sessions, and cached
+// answers last touched before cutoff, returning how many history entries
+// and sessions went. Pins are kept; they were saved on purpose.
+func purgeOlderThan(cutoff time.Time) (int, int, error) {
+	db, err := openStore()
+	if err != nil {
+		return 0, 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM history WHERE created_at < ?", toMillis(cutoff))
+	if err != nil {
+		return 0, 0, err
+	}
+	history, _ := res.RowsAffected()
+	res, err = tx.Exec("DELETE FROM sessions WHERE updated_at < ?", toMillis(cutoff))
+	if err != nil {
+		return 0, 0, err
+	}
+	sessions, _ := res.RowsAffected()
+	if _, err := tx.Exec("DELETE FROM response_cache WHERE created_at < ?", toMillis(cutoff)); err != nil {
+		return 0, 0, err
+	}
+	return int(history), int(sessions), tx.Commit()
+}
+
+// autoPurge applies history_retention_days, at most once a day. Errors are
+// ignored; the next run tries again.
+func autoPurge(config *Config) {
+	if config.HistoryRetentionDays <= 0 {
+		return
+	}
+	if last, err := strconv.ParseInt(metaValue("retention_purged_at"), 10, 64); err == nil &&
+		time.Since(fromMillis(last)) < 24*time.Hour {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -config.HistoryRetentionDays)
+	if _, _, err := purgeOlderThan(cutoff); err == nil {
+		setMetaValue("retention_purged_at", strconv.FormatInt(toMillis(time.Now()), 10))
+	}
+}
@@ -0,0 +1,295 @@
+// Package main provides the code sandbox behind /run and the agent's
+// run_code tool: Python or Go snippets run in a container without network
+// access, or, only when configured with runtime: process, in a
+// resource-limited process that isn't isolated from the host.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SandboxConfig turns on code execution and sets its limits
+type SandboxConfig struct {
+	Enabled  bool   `yaml:"enabled,omitempty"`
+	Runtime  string `yaml:"runtime,omitempty"`   // auto (default: docker or podman), docker, podman, or process
+	Timeout  string `yaml:"timeout,omitempty"`   // wall clock limit per run, default 10s
+	MemoryMB int    `yaml:"memory_mb,omitempty"` // default 256
+}
+
+// Sandbox defaults
+const (
+	defaultSandboxTimeout = 10 * time.Second
+	defaultSandboxMemory  = 256
+	maxSandboxOutput      = 20_000
+	sandboxProcesses      = 64 // processes a run may start, on top of the user's
+)
+
+// sandboxLanguage says how to run one language
+type sandboxLanguage struct {
+	file    string   // the snippet is saved under this name
+	image   string   // container image
+	command []string // run inside the snippet's directory
+}
+
+// sandboxLanguages are the languages snippets can be written in
+var sandboxLanguages = map[string]sandboxLanguage{
+	"python": {file: "main.py", image: "python:3.12-alpine", command: []string{"python3", "main.py"}},
+	"go":     {file: "main.go", image: "golang:1.23-alpine", command: []string{"go", "run", "main.go"}},
+}
+
+// sandboxLanguageName maps a code fence language to a sandbox language
+func sandboxLanguageName(lang string) (string, bool) {
+	switch strings.ToLower(lang) {
+	case "python", "python3", "py":
+		return "python", true
+	case "go", "golang":
+		return "go", true
+	}
+	return "", false
+}
+
+// timeout returns the wall clock limit per run
+func (c SandboxConfig) timeout() time.Duration {
+	if d, err := time.ParseDuration(c.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultSandboxTimeout
+}
+
+// memoryMB returns the memory limit per run
+func (c SandboxConfig) memoryMB() int {
+	if c.MemoryMB > 0 {
+		return c.MemoryMB
+	}
+	return defaultSandboxMemory
+}
+
+// runtime picks the configured runtime, or for auto the first container
+// runtime installed. A plain process is never picked automatically, since
+// it can read the user's files and reach the network.
+func (c SandboxConfig) runtime() (string, error) {
+	switch c.Runtime {
+	case "", "auto":
+		for _, name := range []string{"docker", "podman"} {
+			if _, err := exec.LookPath(name); err == nil {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("the sandbox needs docker or podman; set sandbox.runtime: process to run snippets as plain processes, without isolation from your files or the network")
+	case "docker", "podman":
+		if _, err := exec.LookPath(c.Runtime); err != nil {
+			return "", fmt.Errorf("sandbox runtime %s is not installed", c.Runtime)
+		}
+		return c.Runtime, nil
+	case "process":
+		return "process", nil
+	}
+	return "", fmt.Errorf("unknown sandbox runtime '%s' (use auto, docker, podman, or process)", c.Runtime)
+}
+
+// sandboxResult is what a run printed and how it ended
+type sandboxResult struct {
+	Runtime  string
+	Output   string
+	ExitCode int
+	TimedOut bool
+}
+
+// String describes the run for the model
+func (r *sandboxResult) String() string {
+	status := fmt.Sprintf("exit status %d", r.ExitCode)
+	if r.TimedOut {
+		status = "killed: time limit reached"
+	}
+	return fmt.Sprintf("%s\n[%s]", clipToolOutput(r.Output), status)
+}
+
+// runSandboxed runs a snippet with the configured limits, in an empty
+// directory with a clean environment. In a container only the snippet is
+// visible to it; the process runtime can still read files by absolute path
+// and reach the network.
+func runSandboxed(ctx context.Context, cfg SandboxConfig, language, code string) (*sandboxResult, error) {
+	if !cfg.Enabled {
+		return nil, fmt.Errorf("code execution is off; set sandbox.enabled: true in config")
+	}
+	name, ok := sandboxLanguageName(language)
+	if !ok {
+		return nil, fmt.Errorf("can't run %q code; the sandbox runs python and go", language)
+	}
+	lang := sandboxLanguages[name]
+	runtime, err := cfg.runtime()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "ask-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, lang.file), []byte(code), 0644); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout())
+	defer cancel()
+	var cmd *exec.Cmd
+	container := ""
+	if runtime == "process" {
+		cmd = processCommand(ctx, cfg, name, lang, dir)
+	} else {
+		container = "ask-sandbox-" + randomHex(6)
+		cmd = containerCommand(ctx, cfg, runtime, container, lang, dir)
+	}
+
+	out, err := cmd.CombinedOutput()
+	result := &sandboxResult{Runtime: runtime, Output: string(out)}
+	if len(result.Output) > maxSandboxOutput {
+		result.Output = result.Output[:maxSandboxOutput] + "\n[output truncated]"
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		result.TimedOut = true
+		if container != "" {
+			// Stopping the CLI leaves the container running
+			exec.Command(runtime, "kill", container).Run()
+		}
+		return result, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return nil, fmt.Errorf("running the sandbox: %v", err)
+	}
+	return result, nil
+}
+
+// containerCommand runs the snippet in a throwaway container with no
+// network, a read-only root, and caps on memory, CPU, and processes
+func containerCommand(ctx context.Context, cfg SandboxConfig, runtime, name string, lang sandboxLanguage, dir string) *exec.Cmd {
+	args := []string{"run", "--rm", "--name", name,
+		"--network", "none",
+		"--memory", fmt.Sprintf("%dm", cfg.memoryMB()),
+		"--cpus", "1",
+		"--pids-limit", "64",
+		"--read-only",
+		"--tmpfs", "/tmp:exec,size=256m",
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"-e", "HOME=/tmp", "-e", "GOCACHE=/tmp/gocache", "-e", "GOPATH=/tmp/go", "-e", "GOTOOLCHAIN=local",
+		"-v", dir + ":/work:ro", "-w", "/work",
+		lang.image,
+	}
+	return exec.CommandContext(ctx, runtime, append(args, lang.command...)...)
+}
+
+// processCommand runs the snippet directly, limited by ulimit: CPU time,
+// file size, open files, processes, and memory. The process limit counts
+// all of the user's processes, so it is set to those already running plus
+// a margin. Go snippets are built first and only the binary gets the file
+// size and memory limits, the latter on its data segment since Go reserves
+// more address space than it uses; the compiler needs more than a snippet
+// should.
+func processCommand(ctx context.Context, cfg SandboxConfig, name string, lang sandboxLanguage, dir string) *exec.Cmd {
+	cpu := int(cfg.timeout().Seconds()) + 1
+	procs := fmt.Sprintf(`$(( $(ps -U "$(id -u)" -o pid= | wc -l) + %d ))`, sandboxProcesses)
+	// dash spells the process limit -p, bash and zsh -u
+	limits := fmt.Sprintf("ulimit -t %d; ulimit -n 64; n=%s; ulimit -u $n 2>/dev/null || ulimit -p $n", cpu, procs)
+	script := limits + fmt.Sprintf(`; ulimit -f 20480; ulimit -v %d; exec "$@"`, cfg.memoryMB()*1024)
+	args := append([]string{"-c", script, "sh"}, lang.command...)
+	if name == "go" {
+		script = limits + fmt.Sprintf("; go build -o ./snippet %s || exit; ulimit -f 20480; ulimit -d %d; exec ./snippet", lang.file, cfg.memoryMB()*1024)
+		args = []string{"-c", script}
+	}
+	cmd := exec.CommandContext(ctx, "sh", args...)
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		"LANG=C.UTF-8",
+		"GOCACHE=" + goBuildCache(dir),
+		"GOPATH=" + filepath.Join(dir, ".go"),
+		"GOTOOLCHAIN=local",
+		"GOFLAGS=",
+	}
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// goBuildCache keeps a build cache of the sandbox's own, apart from the
+// user's, so snippets don't rebuild the standard library on every run but
+// can't tamper with what the user builds
+func goBuildCache(dir string) string {
+	if cache, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(cache, "ask", "sandbox-go-build")
+	}
+	return filepath.Join(dir, ".gocache")
+}
+
+// runCodeCommand runs a python or go block from the last answer in the
+// sandbox and sends its output to the model, which carries on from there
+func (s *Session) runCodeCommand(args []string) {
+	if !s.sandbox.Enabled {
+		fmt.Printf("\n%sCode execution is off. Set sandbox.enabled: true in config to use /run.%s\n", dim, reset)
+		return
+	}
+	answer, ok := s.lastAnswer()
+	var runnable []codeBlock
+	for _, b := range extractFencedBlocks(answer) {
+		if _, ok := sandboxLanguageName(b.lang); ok {
+			runnable = append(runnable, b)
+		}
+	}
+	if !ok || len(runnable) == 0 {
+		fmt.Printf("\n%sThe last answer has no python or go code block to run.%s\n", dim, reset)
+		return
+	}
+	block := runnable[len(runnable)-1]
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > len(runnable) {
+			fmt.Printf("\n%sUsage: /run [n], where n is 1 to %d%s\n", dim, len(runnable), reset)
+			return
+		}
+		block = runnable[n-1]
+	}
+
+	runtime, err := s.sandbox.runtime()
+	if err != nil {
+		fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+		return
+	}
+	fmt.Printf("\n%s▶ Running the %s snippet (%s sandbox, %s limit)…%s\n", magenta, block.lang, runtime, s.sandbox.timeout(), reset)
+	ctx, done := s.beginRequest()
+	result, err := runSandboxed(ctx, s.sandbox, block.lang, block.code)
+	done()
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Printf("%s⏹ Cancelled%s\n", yellow, reset)
+		} else {
+			fmt.Printf("%s✗ %v%s\n", red, err, reset)
+		}
+		return
+	}
+	fmt.Printf("%s%s%s\n", dim, lastLines(result.String(), 20), reset)
+
+	s.send(fmt.Sprintf("Output of running the %s snippet:\n```\n%s\n```", block.lang, result), s.provider, s.providerName, s.modelName)
+}
+
+// randomHex returns n random bytes as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
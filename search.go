@@ -0,0 +1,232 @@
+// Package main provides searching across session transcripts and history.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// snippetRadius is how much context is shown on each side of a match
+const snippetRadius = 40
+
+// searchMatch is one message matching a search query
+type searchMatch struct {
+	index   int // position in the transcript
+	role    string
+	snippet string // context around the first match, highlighted
+}
+
+// searchMessages finds messages containing query (case-insensitive)
+func searchMessages(messages []SessionMessage, query string) []searchMatch {
+	var matches []searchMatch
+	for i, msg := range messages {
+		if snippet, ok := matchSnippet(msg.Content, query); ok {
+			matches = append(matches, searchMatch{index: i, role: msg.Role, snippet: snippet})
+		}
+	}
+	return matches
+}
+
+// matchSnippet returns a one-line excerpt around the first occurrence of
+// query with the match highlighted
+func matchSnippet(content, query string) (string, bool) {
+	lower := strings.ToLower(content)
+	q := strings.ToLower(query)
+	pos := strings.Index(lower, q)
+	if q == "" || pos == -1 || len(lower) != len(content) {
+		// Lowercasing changed byte offsets (rare non-ASCII case); fall back to exact match
+		pos = strings.Index(content, query)
+		if query == "" || pos == -1 {
+			return "", false
+		}
+	}
+
+	start := max(pos-snippetRadius, 0)
+	end := min(pos+len(query)+snippetRadius, len(content))
+	// Avoid splitting multi-byte characters
+	for start > 0 && !isRuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !isRuneStart(content[end]) {
+		end++
+	}
+
+	flat := func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	snippet := flat(content[start:pos]) + " " + bold + yellow + content[pos:pos+len(query)] + reset + " " + flat(content[pos+len(query):end])
+	snippet = strings.TrimSpace(snippet)
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(content) {
+		snippet += "…"
+	}
+	return snippet, true
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// printSearchResults searches the current conversation and all saved sessions
+func (s *Session) printSearchResults(query string) {
+	s.mu.Lock()
+	current := searchMessages(s.messages, query)
+	s.mu.Unlock()
+
+	total := len(current)
+	if len(current) > 0 {
+		fmt.Printf("\n%sCurrent session%s\n", bold, reset)
+		for _, m := range current {
+			fmt.Printf("  %s#%d %s%s  %s\n", dim, m.index+1, m.role, reset, m.snippet)
+		}
+	}
+
+	sessions, err := listSessions()
+	if err != nil {
+		fmt.Printf("\n%s✗ Error listing sessions: %v%s\n", red, err, reset)
+	}
+	for _, saved := range sessions {
+		if saved.Name == s.name {
+			continue // already searched as the current session
+		}
+		matches := searchMessages(saved.Messages, query)
+		if len(matches) == 0 {
+			continue
+		}
+		total += len(matches)
+		fmt.Printf("\n%s%s%s %s(/load %s)%s\n", bold, saved.Name, reset, dim, saved.Name, reset)
+		for _, m := range matches {
+			fmt.Printf("  %s#%d %s%s  %s\n", dim, m.index+1, m.role, reset, m.snippet)
+		}
+	}
+
+	if total == 0 {
+		fmt.Printf("\n%sNo matches for '%s'%s\n", dim, query, reset)
+		return
+	}
+	fmt.Printf("\n%s%d match(es)%s\n", dim, total, reset)
+}
+
+// storedMatch is a message or one-shot answer found in the database
+type storedMatch struct {
+	session string // session name, or "" for a history entry
+	id      int64  // message number in the session, or history entry id
+	role    string // for session messages
+	model   string // provider/model, for history entries
+	snippet string
+	time    time.Time
+}
+
+// ftsQuery turns free text into an FTS5 query matching entries that
+// contain every word, so punctuation in the text is never query syntax
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// searchStore runs a full-text search over saved sessions and one-shot
+// history, returning at most limit matches of each, best first. A tag, if
+// given, limits both to what carries it.
+func searchStore(text, tag string, limit int) ([]storedMatch, []storedMatch, error) {
+	query := ftsQuery(text)
+	if query == "" {
+		return nil, nil, fmt.Errorf("nothing to search for")
+	}
+	db, err := openStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	flat := func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	sessionTagged, historyTagged := "", ""
+	params := []any{bold + yellow, reset, query}
+	if tag != "" {
+		sessionTagged = " AND s.id IN (SELECT item_id FROM tags WHERE kind = 'session' AND tag = ?)"
+		historyTagged = " AND h.id IN (SELECT item_id FROM tags WHERE kind = 'history' AND tag = ?)"
+		params = append(params, tag)
+	}
+	params = append(params, limit)
+
+	rows, err := db.Query(`SELECT s.name, m.seq, m.role, snippet(messages_fts, 0, ?, ?, '…', 16), m.created_at
+		FROM messages_fts
+		JOIN messages m ON m.id = messages_fts.rowid
+		JOIN sessions s ON s.id = m.session_id
+		WHERE messages_fts MATCH ?`+sessionTagged+` ORDER BY rank LIMIT ?`, params...)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sessions []storedMatch
+	for rows.Next() {
+		var m storedMatch
+		var created int64
+		if err := rows.Scan(&m.session, &m.id, &m.role, &m.snippet, &created); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		m.id++
+		m.snippet, m.time = flat(m.snippet), fromMillis(created)
+		sessions = append(sessions, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = db.Query(`SELECT h.id, h.provider || '/' || h.model, snippet(history_fts, -1, ?, ?, '…', 16), h.created_at
+		FROM history_fts
+		JOIN history h ON h.id = history_fts.rowid
+		WHERE history_fts MATCH ?`+historyTagged+` ORDER BY rank LIMIT ?`, params...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var history []storedMatch
+	for rows.Next() {
+		var m storedMatch
+		var created int64
+		if err := rows.Scan(&m.id, &m.model, &m.snippet, &created); err != nil {
+			return nil, nil, err
+		}
+		m.snippet, m.time = flat(m.snippet), fromMillis(created)
+		history = append(history, m)
+	}
+	return sessions, history, rows.Err()
+}
+
+// printStoredMatches shows full-text search results for `ask history search`
+func printStoredMatches(text, tag string, limit int) error {
+	sessions, history, err := searchStore(text, tag, limit)
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 && len(history) == 0 {
+		fmt.Printf("No matches for '%s'\n", text)
+		return nil
+	}
+
+	if len(sessions) > 0 {
+		fmt.Printf("%sSessions%s\n", bold, reset)
+		for _, m := range sessions {
+			fmt.Printf("  %s%s #%d %s%s  %s\n", cyan, m.session, m.id, m.role, reset, m.snippet)
+			fmt.Printf("  %s%s · ask -s --resume %s%s\n", dim, m.time.Format("2006-01-02 15:04"), m.session, reset)
+		}
+	}
+	if len(history) > 0 {
+		if len(sessions) > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%sOne-shot history%s\n", bold, reset)
+		for _, m := range history {
+			fmt.Printf("  %s#%d %s%s  %s\n", cyan, m.id, m.model, reset, m.snippet)
+			fmt.Printf("  %s%s · ask history show %d%s\n", dim, m.time.Format("2006-01-02 15:04"), m.id, reset)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,500 @@
+// Package main provides `ask serve`, a local OpenAI-compatible endpoint
+// backed by the providers in the ask config.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// isServeCommand reports whether the arguments invoke `ask serve`. Only
+// flags may follow, so "ask serve dinner at 7" stays an ordinary prompt.
+func isServeCommand(args []string) bool {
+	return len(args) > 0 && args[0] == "serve" && (len(args) == 1 || strings.HasPrefix(args[1], "-"))
+}
+
+// maxServeBody limits the size of a request body
+const maxServeBody = 8 << 20
+
+// chatRequest is the part of an OpenAI chat completion request ask uses
+type chatRequest struct {
+	Model               string        `json:"model"`
+	Messages            []chatMessage `json:"messages"`
+	Stream              bool          `json:"stream"`
+	Temperature         *float64      `json:"temperature"`
+	MaxTokens           int           `json:"max_tokens"`
+	MaxCompletionTokens int           `json:"max_completion_tokens"`
+}
+
+// chatMessage has content as a string or a list of text parts
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// text returns the message's content, rejecting anything but text
+func (m chatMessage) text() (string, error) {
+	var s string
+	if err := json.Unmarshal(m.Content, &s); err == nil {
+		return s, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", fmt.Errorf("message content must be a string or a list of parts")
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", fmt.Errorf("content of type '%s' is not supported", part.Type)
+		}
+		b.WriteString(part.Text)
+	}
+	return b.String(), nil
+}
+
+// apiError is an error in the shape OpenAI clients expect
+type apiError struct {
+	status  int
+	kind    string
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string { return e.message }
+
+// server handles requests with the config loaded at startup
+type server struct {
+	config      *Config
+	spec        string // provider/model used when a request names none
+	token       string // required bearer token
+	loopback    bool   // listening on loopback only, so Host must name it
+	mu          sync.Mutex
+	budgetShown budgetLevel
+}
+
+// runServeCommand listens for OpenAI-style requests until interrupted
+func runServeCommand(args []string) error {
+	var providerFlag, modelFlag, profileFlag, host string
+	var port int
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.IntVar(&port, "port", 8090, "Port to listen on")
+	fs.StringVar(&host, "host", "127.0.0.1", "Address to listen on")
+	fs.StringVar(&modelFlag, "model", "", "Model for requests that don't name one")
+	fs.StringVar(&modelFlag, "m", "", "Model (short for -model)")
+	fs.StringVar(&providerFlag, "provider", "", "Provider for requests that don't name a model")
+	fs.StringVar(&providerFlag, "p", "", "Provider (short for -provider)")
+	fs.StringVar(&profileFlag, "profile", "", "Profile for requests that don't name a model")
+	fs.StringVar(&profileFlag, "P", "", "Profile (short for -profile)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument '%s'", fs.Arg(0))
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		return err
+	}
+	providerName, modelName, err := ResolveModelAndProvider(providerFlag, modelFlag, profileFlag, config)
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Providers[providerName]; !ok {
+		return fmt.Errorf("provider '%s' not found in config", providerName)
+	}
+	if modelName == "" {
+		modelName = defaultModels[providerName]
+	}
+
+	// Without a token, any web page could post to the endpoint and spend
+	// the keys, so one is made up for the run
+	token, generated := os.Getenv("ASK_SERVE_TOKEN"), false
+	if token == "" {
+		token, generated = randomHex(16), true
+	}
+	ip := net.ParseIP(host)
+	loopback := host == "localhost" || (ip != nil && ip.IsLoopback())
+	s := &server{config: config, spec: providerName + "/" + modelName, token: token, loopback: loopback}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.authorized(s.chatCompletions))
+	mux.HandleFunc("GET /v1/models", s.authorized(s.models))
+	srv := &http.Server{
+		Addr:              net.JoinHostPort(host, strconv.Itoa(port)),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Serving %s at http://%s/v1 %s(Ctrl-C to stop)%s\n", s.spec, ln.Addr(), dim, reset)
+	if generated {
+		fmt.Fprintf(os.Stderr, "API key for this run: %s %s(set ASK_SERVE_TOKEN to choose one)%s\n", token, dim, reset)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// authorized requires the bearer token and refuses what only a browser
+// would send: requests with an Origin, bodies that aren't JSON, and, on
+// loopback, a Host other than the loopback address a DNS rebinding attack
+// would replace
+func (s *server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" || (s.loopback && !loopbackHost(r.Host)) {
+			writeAPIError(w, &apiError{http.StatusForbidden, "invalid_request_error", "forbidden", "requests from browsers are not accepted"})
+			return
+		}
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			writeAPIError(w, &apiError{http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "missing or wrong bearer token"})
+			return
+		}
+		if r.Method == http.MethodPost {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				writeAPIError(w, &apiError{http.StatusUnsupportedMediaType, "invalid_request_error", "unsupported_media_type", "Content-Type must be application/json"})
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// loopbackHost reports whether a Host header names this machine's loopback
+// address, with or without a port
+func loopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// writeAPIError sends an error response
+func writeAPIError(w http.ResponseWriter, e *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"message": e.message, "type": e.kind, "code": e.code},
+	})
+}
+
+// resolve picks the provider and model for a request's model field, which
+// may be empty, a profile, a model name, or provider/model
+func (s *server) resolve(model string) (string, string, error) {
+	if model == "" {
+		providerName, modelName := ParseModelSpec(s.spec)
+		return providerName, modelName, nil
+	}
+	profile := ""
+	if _, ok := s.config.Profiles[model]; ok {
+		profile, model = model, ""
+	}
+	providerName, modelName, err := ResolveModelAndProvider("", model, profile, s.config)
+	if err != nil {
+		return "", "", err
+	}
+	pc, ok := s.config.Providers[providerName]
+	if !ok {
+		return "", "", fmt.Errorf("no configured provider for model '%s'", model)
+	}
+	if modelName == "" {
+		modelName = pc.Model
+	}
+	if modelName == "" {
+		modelName = defaultModels[providerName]
+	}
+	return providerName, modelName, nil
+}
+
+// prepare turns a request into provider messages and options
+func (s *server) prepare(req *chatRequest) ([]provider.Message, provider.Options, error) {
+	var msgs []provider.Message
+	for _, m := range req.Messages {
+		role := m.Role
+		switch role {
+		case "developer":
+			role = "system"
+		case "system", "user", "assistant":
+		default:
+			return nil, provider.Options{}, fmt.Errorf("messages with role '%s' are not supported", m.Role)
+		}
+		content, err := m.text()
+		if err != nil {
+			return nil, provider.Options{}, err
+		}
+		// Block mode can't ask anyone, so it refuses
+		content, ok := screenOutgoing(content, func(string) bool { return false })
+		if !ok {
+			return nil, provider.Options{}, fmt.Errorf("the request looks like it contains secrets and redact mode is block, or moderation blocked it")
+		}
+		msgs = append(msgs, provider.Message{Role: role, Content: content})
+	}
+	if len(msgs) == 0 {
+		return nil, provider.Options{}, fmt.Errorf("messages must not be empty")
+	}
+	opts := provider.Options{Temperature: req.Temperature, MaxTokens: req.MaxTokens}
+	if req.MaxCompletionTokens > 0 {
+		opts.MaxTokens = req.MaxCompletionTokens
+	}
+	return msgs, opts, nil
+}
+
+// checkBudget refuses requests over a blocking budget, warning on the
+// server's stderr when a new level is reached
+func (s *server) checkBudget() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	level, err := checkBudget(s.config.Budget, s.budgetShown)
+	s.budgetShown = max(s.budgetShown, level)
+	return err
+}
+
+// chatCompletions answers POST /v1/chat/completions
+func (s *server) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var req chatRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxServeBody)).Decode(&req); err != nil {
+		writeAPIError(w, &apiError{http.StatusBadRequest, "invalid_request_error", "", "invalid JSON: " + err.Error()})
+		return
+	}
+	providerName, modelName, err := s.resolve(req.Model)
+	if err != nil {
+		writeAPIError(w, &apiError{http.StatusNotFound, "invalid_request_error", "model_not_found", err.Error()})
+		return
+	}
+	spec := providerName + "/" + modelName
+	msgs, opts, err := s.prepare(&req)
+	if err != nil {
+		writeAPIError(w, &apiError{http.StatusBadRequest, "invalid_request_error", "", err.Error()})
+		return
+	}
+
+	reply := &completionWriter{w: w, stream: req.Stream, id: newCompletionID(), model: spec, created: time.Now().Unix()}
+	status := "ok"
+	defer func() {
+		fmt.Fprintf(os.Stderr, "%s %s%-32s%s %s %s\n", start.Format("15:04:05"), cyan, spec, reset, status, time.Since(start).Round(time.Millisecond))
+	}()
+
+	var key string
+	if s.config.Cache.Enabled {
+		key = cacheKey(providerName, modelName, opts, msgs)
+		if response, _, ok := cachedAnswer(key, s.config.Cache.ttl()); ok {
+			countEvent("serve:cached")
+			status = "cached"
+			reply.Write([]byte(response))
+			reply.finish(msgs)
+			return
+		}
+	}
+
+	if err := s.checkBudget(); err != nil {
+		status = "over budget"
+		writeAPIError(w, &apiError{http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota", err.Error()})
+		return
+	}
+	pc := s.config.Providers[providerName]
+	p := createProvider(providerName, pc.APIKey, modelName)
+	if p == nil {
+		writeAPIError(w, &apiError{http.StatusNotFound, "invalid_request_error", "model_not_found", "unknown provider: " + providerName})
+		return
+	}
+	if c, ok := p.(provider.Configurable); ok {
+		c.SetOptions(opts)
+	}
+
+	countEvent("serve")
+	if err := p.QueryStreamWithHistory(r.Context(), msgs, reply); err != nil {
+		countError(err)
+		status = "error: " + err.Error()
+		if !reply.started {
+			status, code := http.StatusBadGateway, ""
+			if errors.Is(err, provider.ErrRateLimited) {
+				status, code = http.StatusTooManyRequests, "rate_limit_exceeded"
+				var apiErr *provider.APIError
+				if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int((apiErr.RetryAfter+time.Second-1)/time.Second)))
+				}
+			}
+			writeAPIError(w, &apiError{status, "api_error", code, err.Error()})
+		} else if reply.stream {
+			reply.event(map[string]any{"error": map[string]any{"message": err.Error(), "type": "api_error"}})
+		}
+		return
+	}
+	response := reply.buf.String()
+	saveUsage("serve", newTurnUsage(spec, msgs, response, time.Since(start)))
+	if key != "" && response != "" {
+		storeAnswer(key, response, s.config.Cache.ttl())
+	}
+	reply.finish(msgs)
+}
+
+// newCompletionID returns an id for a response
+func newCompletionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+// completionWriter collects a provider's answer, sending it on as
+// server-sent events when the client asked for a stream
+type completionWriter struct {
+	w       http.ResponseWriter
+	stream  bool
+	id      string
+	model   string
+	created int64
+	buf     strings.Builder
+	started bool // the response status has been sent
+}
+
+func (c *completionWriter) Write(p []byte) (int, error) {
+	c.buf.Write(p)
+	if !c.stream {
+		return len(p), nil
+	}
+	delta := map[string]any{"content": string(p)}
+	if !c.started {
+		c.started = true
+		c.w.Header().Set("Content-Type", "text/event-stream")
+		c.w.Header().Set("Cache-Control", "no-cache")
+		delta["role"] = "assistant"
+	}
+	return len(p), c.event(c.chunk(delta, nil))
+}
+
+// chunk builds a streamed chat.completion.chunk
+func (c *completionWriter) chunk(delta map[string]any, finish any) map[string]any {
+	return map[string]any{
+		"id":      c.id,
+		"object":  "chat.completion.chunk",
+		"created": c.created,
+		"model":   c.model,
+		"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finish}},
+	}
+}
+
+// event sends one server-sent event
+func (c *completionWriter) event(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// finish ends a stream, or sends the whole completion
+func (c *completionWriter) finish(msgs []provider.Message) {
+	if c.stream {
+		if !c.started {
+			c.Write(nil)
+		}
+		c.event(c.chunk(map[string]any{}, "stop"))
+		io.WriteString(c.w, "data: [DONE]\n\n")
+		return
+	}
+	u := newTurnUsage(c.model, msgs, c.buf.String(), 0)
+	c.w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(c.w).Encode(map[string]any{
+		"id":      c.id,
+		"object":  "chat.completion",
+		"created": c.created,
+		"model":   c.model,
+		"choices": []any{map[string]any{
+			"index":         0,
+			"message":       map[string]any{"role": "assistant", "content": c.buf.String()},
+			"finish_reason": "stop",
+		}},
+		"usage": map[string]int{
+			"prompt_tokens":     u.promptTokens,
+			"completion_tokens": u.completionTokens,
+			"total_tokens":      u.promptTokens + u.completionTokens,
+		},
+	})
+}
+
+// models answers GET /v1/models with the profiles and the models of every
+// configured provider
+func (s *server) models(w http.ResponseWriter, r *http.Request) {
+	type model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		OwnedBy string `json:"owned_by"`
+	}
+	var data []model
+	for _, name := range slices.Sorted(maps.Keys(s.config.Profiles)) {
+		data = append(data, model{name, "model", "ask"})
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.config.Providers)) {
+		pc := s.config.Providers[name]
+		if isPlaceholderKey(pc.APIKey) {
+			continue
+		}
+		p := createProvider(name, pc.APIKey, pc.Model)
+		if p == nil {
+			continue
+		}
+		models, err := listModelsCached(r.Context(), name, pc.APIKey, p, false)
+		if err != nil || len(models) == 0 {
+			models = provider.FallbackModels(name)
+		}
+		for _, m := range models {
+			data = append(data, model{name + "/" + m.ID, "model", name})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": data})
+}
@@ -1,19 +1,20 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/signal"
 	"os/user"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
-	"ask/provider"
+	"github.com/metolius25/ask/provider"
 
-	"github.com/charmbracelet/glamour"
+	"github.com/chzyer/readline"
 )
 
 // ANSI codes
@@ -22,6 +23,7 @@ const (
 	bold      = "\033[1m"
 	dim       = "\033[2m"
 	italic    = "\033[3m"
+	strike    = "\033[9m"
 	cyan      = "\033[36m"
 	green     = "\033[32m"
 	yellow    = "\033[33m"
@@ -37,16 +39,44 @@ var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "
 
 // Session holds the conversation state
 type Session struct {
-	provider     provider.Provider
-	providerName string
-	modelName    string
-	username     string
-	messages     []provider.Message
-	mu           sync.Mutex
+	provider      provider.Provider
+	providerName  string
+	modelName     string
+	username      string
+	messages      []SessionMessage
+	name          string        // set once the session is saved or loaded
+	createdAt     time.Time     // creation time of the saved session
+	attachments   []*Attachment // files to send with the next message
+	modelCache    map[string][]provider.ModelInfo
+	contextConfig ContextConfig
+	notifyConfig  NotifyConfig
+	budget        BudgetConfig
+	sandbox       SandboxConfig // limits for /run
+	writeFiles    bool          // /write is on: the model may propose files
+	allowedPaths  []string      // files written without asking, from /allow
+	voice         *voice        // reads answers aloud with --speak
+	ocrLanguages  string        // tesseract languages for attached images
+	budgetShown   budgetLevel   // highest budget warning shown this session
+	persona       string        // active persona name
+	systemPrompt  string
+	parent        string   // session this one was forked from
+	tags          []string // saved with the session
+	rl            *readline.Instance
+	cancel        context.CancelFunc // aborts the in-flight request
+	cancelled     bool               // the in-flight request was cancelled
+	interrupted   bool               // Ctrl+C was pressed at the prompt
+	failedInput   string             // last message whose request failed, for /retry
+	usage         []turnUsage        // requests made during this session
+	dualProvider  provider.Provider  // second model for /dual, if on
+	dualSpec      string
+	retried       *retriedAnswer   // answer replaced by the last /retry, for /diff
+	options       provider.Options // generation settings from /set
+	noStream      bool             // render answers only once complete
+	mu            sync.Mutex
 }
 
-// RunSessionREPL starts an interactive session
-func RunSessionREPL(p provider.Provider, providerName, modelName string) error {
+// RunSessionREPL starts an interactive session, optionally resuming a saved one
+func RunSessionREPL(p provider.Provider, providerName, modelName string, resumed *SavedSession) error {
 	// Get system username
 	username := "you"
 	if u, err := user.Current(); err == nil && u.Username != "" {
@@ -58,96 +88,298 @@ func RunSessionREPL(p provider.Provider, providerName, modelName string) error {
 		providerName: providerName,
 		modelName:    modelName,
 		username:     username,
-		messages:     []provider.Message{},
+		messages:     []SessionMessage{},
 	}
 
-	// Handle Ctrl+C gracefully
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		fmt.Printf("\n\n%s👋 Goodbye!%s\n\n", yellow, reset)
-		os.Exit(0)
-	}()
+	if config, err := LoadConfigSafe(); err == nil {
+		session.contextConfig = config.Context
+		session.notifyConfig = config.Notify
+		session.budget = config.Budget
+		session.sandbox = config.Sandbox
+		session.ocrLanguages = config.OCRLanguages
+		if speakAnswers {
+			session.voice = newVoice(config)
+		}
+	}
+
+	defer session.discardAutosave()
+
+	if resumed != nil {
+		session.messages = append(session.messages, resumed.Messages...)
+		session.name = resumed.Name
+		session.createdAt = resumed.CreatedAt
+		session.persona = resumed.Persona
+		session.systemPrompt = resumed.System
+		session.parent = resumed.Parent
+		session.tags = resumed.Tags
+	}
+
+	// Ctrl+C cancels a running request; otherwise it exits
+	session.handleInterrupts()
 
 	// Print header
 	session.printHeader()
+	if resumed != nil && resumed.Name != "" {
+		fmt.Printf("%s✓ Resumed '%s' (%d messages)%s\n", green, resumed.Name, len(resumed.Messages), reset)
+	} else if resumed != nil {
+		fmt.Printf("%s✓ Restored unsaved session (%d messages)%s\n", green, len(resumed.Messages), reset)
+	}
+	session.autosave()
 
-	scanner := bufio.NewScanner(os.Stdin)
+	// Line editing with persistent prompt history
+	rlConfig := &readline.Config{
+		DisableAutoSaveHistory: true, // only prompts are saved, not picker input
+		HistorySearchFold:      true,
+		AutoComplete:           &sessionCompleter{session: session},
+	}
+	// An encrypted database shouldn't leave the prompts in a plain file
+	if dir, err := configDir(); err == nil && !storeEncrypted() && os.MkdirAll(dir, 0700) == nil {
+		rlConfig.HistoryFile = filepath.Join(dir, "history")
+	}
+	rl, err := readline.NewEx(rlConfig)
+	if err != nil {
+		return fmt.Errorf("failed to initialize line editor: %w", err)
+	}
+	defer rl.Close()
+	session.rl = rl
 
 	for {
-		// Prompt with username
-		prompt := fmt.Sprintf("%s%s%s › %s", bold, cyan, session.username, reset)
-		fmt.Print(prompt)
-		os.Stdout.Sync() // Force flush to ensure visibility before blocking
-
-		if !scanner.Scan() {
+		// Prompt with username and context usage
+		prompt := fmt.Sprintf("%s%s%s%s%s › %s", bold, cyan, session.username, session.usageIndicator(), session.attachmentChips(), reset)
+		line, ok := session.readPrompt(prompt)
+		if !ok {
 			break
 		}
 
-		input := strings.TrimSpace(scanner.Text())
+		input := strings.TrimSpace(line)
 		if input == "" {
 			continue
 		}
+		// History is line based, so multi-line prompts are saved on one line
+		session.rl.SaveHistory(strings.ReplaceAll(input, "\n", " "))
 
-		// Handle commands
+		// Handle commands; a lone ? is a shortcut for /help and a lone /
+		// opens the command palette
+		if input == "?" {
+			input = "/help"
+		}
+		if input == "/" {
+			if input = session.commandPalette(); input == "" {
+				continue
+			}
+		}
+		if input == "r" && session.failedInput != "" {
+			input = "/retry" // resend the message that just failed
+		}
 		if strings.HasPrefix(input, "/") {
 			if session.handleCommand(input) {
 				break // exit requested
 			}
+			session.autosave()
 			continue
 		}
 
-		// Add user message
-		session.mu.Lock()
-		session.messages = append(session.messages, provider.Message{
-			Role:    "user",
-			Content: input,
-		})
-		msgs := make([]provider.Message, len(session.messages))
-		copy(msgs, session.messages)
-		session.mu.Unlock()
+		session.send(input, session.provider, session.providerName, session.modelName)
+		session.autosave()
+	}
 
-		// Query with spinner
-		response, err := session.queryWithSpinner(msgs)
-		if err != nil {
-			errStr := err.Error()
-			// Detect model not found errors (404, invalid model, etc.)
-			if strings.Contains(errStr, "404") || strings.Contains(errStr, "not found") ||
-				strings.Contains(errStr, "does not exist") || strings.Contains(errStr, "Invalid model") ||
-				strings.Contains(errStr, "invalid_model") {
-				fmt.Printf("\n%s✗ Model '%s' not found%s\n", red, session.modelName, reset)
-				fmt.Printf("%s  Use 'ask --list-models' to see available models, or /model %s for default%s\n", dim, session.providerName, reset)
-			} else {
-				fmt.Printf("\n%s✗ Error: %v%s\n", red, err, reset)
-			}
-			// Remove failed message
-			session.mu.Lock()
-			if len(session.messages) > 0 {
-				session.messages = session.messages[:len(session.messages)-1]
+	return nil
+}
+
+// send adds input as a user message, with any pending attachments, and
+// gets the answer. If it fails the message is dropped from the history and
+// kept for /retry.
+func (s *Session) send(input string, p provider.Provider, providerName, modelName string) error {
+	attachments := s.attachments
+	fitted, ok := s.fitAttachments(input, attachments)
+	if !ok {
+		fmt.Printf("%s  Not sent; the attachments are still queued. Press ↑ to edit%s\n\n", dim, reset)
+		return nil
+	}
+	content, ok := screenOutgoing(withAttachments(input, fitted), s.confirm)
+	if !ok {
+		fmt.Printf("%s  Not sent. Press ↑ to edit%s\n\n", dim, reset)
+		return nil
+	}
+	s.attachments = nil
+	s.mu.Lock()
+	s.retried = nil
+	if s.persona != transcriptPersona(s.messages) {
+		s.messages = append(s.messages, SessionMessage{Role: "system", Content: personaNote(s.persona), Time: time.Now()})
+	}
+	s.messages = append(s.messages, SessionMessage{
+		Role:    "user",
+		Content: content,
+		Time:    time.Now(),
+	})
+	s.mu.Unlock()
+	s.autosave() // keep the question if we crash while waiting
+
+	s.manageContext(p, modelName)
+
+	err := s.respond(p, providerName, modelName)
+	if err != nil {
+		// Remove failed message, keeping attachments for the next attempt
+		s.mu.Lock()
+		if len(s.messages) > 0 {
+			s.messages = s.messages[:len(s.messages)-1]
+		}
+		s.mu.Unlock()
+		s.attachments = attachments
+		s.failedInput = input
+		fmt.Printf("%s  Press r then Enter (or /retry) to resend, ↑ to edit%s\n\n", dim, reset)
+		return err
+	}
+	s.failedInput = ""
+	return nil
+}
+
+// readLine prints a prompt and reads one line of input. Returns false on
+// EOF (Ctrl+D). Ctrl+C discards the line; pressing it twice in a row exits.
+func (s *Session) readLine(prompt string) (string, bool) {
+	s.rl.SetPrompt(prompt)
+	line, err := s.rl.Readline()
+	if err == readline.ErrInterrupt {
+		if s.interrupted {
+			s.discardAutosave()
+			fmt.Printf("\n%s👋 Goodbye!%s\n\n", yellow, reset)
+			os.Exit(0)
+		}
+		s.interrupted = true
+		fmt.Printf("%s(Press Ctrl+C again to exit)%s\n", dim, reset)
+		return "", true
+	}
+	s.interrupted = false
+	if err != nil {
+		return "", false
+	}
+	return line, true
+}
+
+// readPrompt reads a message that may span several lines: a line ending in
+// a backslash continues on the next, and input starting with """ runs until
+// a closing """. Ctrl+C abandons a partly entered message.
+func (s *Session) readPrompt(prompt string) (string, bool) {
+	line, ok := s.readLine(prompt)
+	if !ok {
+		return "", false
+	}
+
+	continuation := fmt.Sprintf("%s  … %s", dim, reset)
+	var lines []string
+
+	if rest, found := strings.CutPrefix(strings.TrimSpace(line), `"""`); found {
+		line = rest
+		for !strings.HasSuffix(strings.TrimSpace(line), `"""`) {
+			lines = append(lines, line)
+			if line, ok = s.readLine(continuation); !ok || s.interrupted {
+				return "", ok
 			}
-			session.mu.Unlock()
-			continue
 		}
+		lines = append(lines, strings.TrimSuffix(strings.TrimSpace(line), `"""`))
+		return strings.Join(lines, "\n"), true
+	}
+
+	for strings.HasSuffix(line, "\\") {
+		lines = append(lines, strings.TrimSuffix(line, "\\"))
+		if line, ok = s.readLine(continuation); !ok || s.interrupted {
+			return "", ok
+		}
+	}
+	lines = append(lines, line)
+	return strings.Join(lines, "\n"), true
+}
 
-		// Add assistant response
-		session.mu.Lock()
-		session.messages = append(session.messages, provider.Message{
+// respond queries the given provider with the current history, then records
+// and renders the answer. Errors are reported to the user before returning.
+func (s *Session) respond(p provider.Provider, providerName, modelName string) error {
+	if err := s.checkBudget(); err != nil {
+		fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+		return err
+	}
+
+	s.mu.Lock()
+	msgs := toProviderMessages(s.system(), s.messages)
+	s.mu.Unlock()
+
+	// Stream the answer under the assistant "prompt" (model name)
+	header := fmt.Sprintf("\n%s%s%s › %s\n", bold, green, modelName, reset)
+	var dual *dualRun
+	if s.dualProvider != nil {
+		dual = s.startDual(msgs)
+	}
+	response, err := s.streamReply(p, providerName+"/"+modelName, msgs, header)
+	if errors.Is(err, context.Canceled) && response != "" {
+		// Keep what arrived, marked partial, so the conversation matches the screen
+		countError(err)
+		s.mu.Lock()
+		s.messages = append(s.messages, SessionMessage{
 			Role:    "assistant",
 			Content: response,
+			Model:   providerName + "/" + modelName,
+			Time:    time.Now(),
+			Partial: true,
 		})
-		session.mu.Unlock()
+		s.mu.Unlock()
+		if dual != nil {
+			s.finishDual(dual, msgs, err)
+		}
+		fmt.Printf("%s⏹ Cancelled; the partial answer is kept. /retry to regenerate it%s\n\n", yellow, reset)
+		return nil
+	}
+	if err != nil {
+		printQueryError(err, providerName, modelName)
+		if dual != nil {
+			s.finishDual(dual, msgs, err)
+		}
+		return err
+	}
 
-		// Assistant "prompt" (model name)
-		fmt.Printf("\n%s%s%s › %s\n", bold, green, session.modelName, reset)
-		renderMarkdownToTerminal(response)
+	// Add assistant response
+	s.mu.Lock()
+	s.messages = append(s.messages, SessionMessage{
+		Role:    "assistant",
+		Content: response,
+		Model:   providerName + "/" + modelName,
+		Time:    time.Now(),
+	})
+	s.mu.Unlock()
 
-		// Add spacing before next user prompt
-		fmt.Println()
-		fmt.Println()
+	if dual != nil {
+		s.finishDual(dual, msgs, nil)
+	}
+	if s.writeFiles {
+		s.applyFileProposals(response)
+	}
+	if s.voice != nil {
+		s.speak(response)
 	}
 
-	return scanner.Err()
+	// Add spacing before next user prompt
+	fmt.Println()
+	fmt.Println()
+	return nil
+}
+
+// printQueryError explains a failed query, with a hint for unknown models
+func printQueryError(err error, providerName, modelName string) {
+	countError(err)
+	if errors.Is(err, context.Canceled) {
+		fmt.Printf("%s⏹ Cancelled%s\n\n", yellow, reset)
+		return
+	}
+	errStr := err.Error()
+	// Detect model not found errors (404, invalid model, etc.)
+	if strings.Contains(errStr, "404") || strings.Contains(errStr, "not found") ||
+		strings.Contains(errStr, "does not exist") || strings.Contains(errStr, "Invalid model") ||
+		strings.Contains(errStr, "invalid_model") {
+		fmt.Printf("\n%s✗ Model '%s' not found%s\n", red, modelName, reset)
+		fmt.Printf("%s  Use 'ask --list-models' to see available models, or /model %s for default%s\n", dim, providerName, reset)
+	} else if errors.Is(err, provider.ErrInvalidAPIKey) {
+		fmt.Printf("\n%s✗ Error: %v. Check your config.yaml%s\n", red, err, reset)
+	} else {
+		fmt.Printf("\n%s✗ Error: %v%s\n", red, err, reset)
+	}
 }
 
 func (s *Session) printHeader() {
@@ -174,56 +406,38 @@ func (s *Session) printHeader() {
 	fmt.Printf("%s│%s  %s%s%s%s%s│%s\n", gray, reset, bold+magenta, displayName, reset, strings.Repeat(" ", innerWidth-len(displayName)-2), gray, reset)
 	fmt.Printf("%s│%s  %sSession Mode%s%s%s│%s\n", gray, reset, dim, reset, strings.Repeat(" ", innerWidth-14), gray, reset)
 	fmt.Printf("%s╰%s╯%s\n", gray, strings.Repeat("─", innerWidth), reset)
-	fmt.Printf("\n%s  /help • /model • /clear • /exit%s\n", dim, reset)
+	fmt.Printf("\n%s  ? for help • /model • /clear • /exit%s\n", dim, reset)
 }
 
-func (s *Session) queryWithSpinner(msgs []provider.Message) (string, error) {
-	type result struct {
-		response string
-		err      error
-	}
-	resultChan := make(chan result, 1)
-
-	// Start query in goroutine
-	go func() {
-		var buf strings.Builder
-		err := s.provider.QueryStreamWithHistory(msgs, &buf)
-		resultChan <- result{response: buf.String(), err: err}
-	}()
-
-	// Spinner animation
-	done := make(chan struct{})
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	go func() {
-		defer wg.Done()
-		i := 0
-		for {
-			select {
-			case <-done:
-				fmt.Print(clearLine)
-				return
-			default:
-				frame := spinnerFrames[i%len(spinnerFrames)]
-				fmt.Printf("\r%s%s %sThinking...%s", yellow, frame, dim, reset)
-				i++
-				time.Sleep(80 * time.Millisecond)
-			}
-		}
-	}()
-
-	// Wait for result
-	res := <-resultChan
-	close(done)
-	wg.Wait() // Deterministically wait for spinner to clear line
+// queryWithSpinner runs a query to completion behind a spinner showing label,
+// for requests whose output isn't shown directly
+func (s *Session) queryWithSpinner(p provider.Provider, spec, label string, msgs []provider.Message) (string, error) {
+	if err := s.checkBudget(); err != nil {
+		return "", err
+	}
+	ctx, done := s.beginRequest()
+	defer done()
 
-	return res.response, res.err
+	s.applyOptions(p)
+	start := time.Now()
+	w := &liveWriter{quiet: true}
+	stop := startSpinner(label, "(Esc to cancel)", &w.received)
+	err := p.QueryStreamWithHistory(ctx, msgs, w)
+	stop()
+	response := w.buf.String()
+	if ctx.Err() != nil {
+		err = context.Canceled
+	}
+	if err == nil || response != "" {
+		s.recordUsage(spec, msgs, response, time.Since(start))
+	}
+	return response, err
 }
 
 func (s *Session) handleCommand(input string) bool {
 	parts := strings.Fields(input)
 	cmd := strings.ToLower(parts[0])
+	countCommand(cmd)
 
 	switch cmd {
 	case "/exit", "/quit", "/q":
@@ -232,81 +446,347 @@ func (s *Session) handleCommand(input string) bool {
 
 	case "/clear", "/c":
 		s.mu.Lock()
-		s.messages = []provider.Message{}
+		s.messages = []SessionMessage{}
 		s.mu.Unlock()
+		s.failedInput = ""
 		// Clear screen and reprint header
 		fmt.Print("\033[2J\033[H") // clear screen, move cursor to top
 		s.printHeader()
 		fmt.Printf("%s✓ Conversation cleared%s\n", dim, reset)
 
 	case "/model", "/m":
+		var spec string
+		if len(parts) >= 2 {
+			spec = parts[1]
+		} else if spec = s.pickModel(); spec == "" {
+			return false
+		}
+
+		newProvider, newModel := s.resolveModelSpec(spec)
+		if err := s.switchProvider(newProvider, newModel); err != nil {
+			fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+			return false
+		}
+		fmt.Printf("\n%s✓ Switched to %s/%s%s\n", green, s.providerName, s.modelName, reset)
+
+	case "/compare":
 		if len(parts) < 2 {
-			fmt.Printf("\n%sUsage: /model <name> or /model <provider> or /model <provider/model>%s\n", dim, reset)
+			fmt.Printf("\n%sUsage: /compare <model> (e.g., /compare gemini-2.5-pro)%s\n", dim, reset)
+			return false
+		}
+		s.compare(parts[1])
+
+	case "/undo", "/u":
+		s.mu.Lock()
+		// Persona notes go with the exchange after them; send records them again
+		n := len(s.messages)
+		for n > 0 && s.messages[n-1].Role == "system" {
+			n--
+		}
+		if n > 0 && s.messages[n-1].Role == "assistant" {
+			n--
+		}
+		if n > 0 && s.messages[n-1].Role == "user" {
+			n--
+		}
+		for n > 0 && s.messages[n-1].Role == "system" {
+			n--
+		}
+		removed := len(s.messages) - n
+		s.messages = s.messages[:n]
+		remaining := len(s.messages)
+		s.mu.Unlock()
+
+		if removed == 0 {
+			fmt.Printf("\n%sNothing to undo%s\n", dim, reset)
+			return false
+		}
+		fmt.Printf("\n%s✓ Removed last exchange (%d messages left)%s\n", dim, remaining, reset)
+
+	case "/retry", "/r":
+		// Resend a message that failed, with the current model unless one is given
+		if s.failedInput != "" {
+			p, providerName, modelName := s.provider, s.providerName, s.modelName
+			if len(parts) >= 2 {
+				var err error
+				providerName, modelName = s.resolveModelSpec(parts[1])
+				p, modelName, err = newSessionProvider(providerName, modelName)
+				if err != nil {
+					fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+					return false
+				}
+			}
+			s.send(s.failedInput, p, providerName, modelName)
 			return false
 		}
 
-		modelSpec := parts[1]
-		newProvider, newModel := ParseModelSpec(modelSpec)
+		s.mu.Lock()
+		var previous *SessionMessage
+		if n := len(s.messages); n > 0 && s.messages[n-1].Role == "assistant" {
+			last := s.messages[n-1]
+			previous = &last
+			s.messages = s.messages[:n-1]
+		}
+		hasPrompt := len(s.messages) > 0 && s.messages[len(s.messages)-1].Role == "user"
+		s.mu.Unlock()
 
-		// If no provider detected, try to resolve from model name
-		if newProvider == "" {
-			newProvider = ResolveProviderFromModel(newModel)
+		// Put the original answer back if there's nothing to retry or the retry fails
+		restore := func() {
+			if previous != nil {
+				s.mu.Lock()
+				s.messages = append(s.messages, *previous)
+				s.mu.Unlock()
+			}
 		}
 
-		// If still no provider but the spec matches a known provider name, use it
-		knownProviders := []string{"gemini", "claude", "chatgpt", "deepseek", "mistral", "qwen"}
-		for _, kp := range knownProviders {
-			if newModel == kp {
-				newProvider = kp
-				newModel = "" // Will use default
-				break
+		if !hasPrompt {
+			restore()
+			fmt.Printf("\n%sNothing to retry%s\n", dim, reset)
+			return false
+		}
+
+		// Retry with the current model unless one is given for this attempt
+		p, providerName, modelName := s.provider, s.providerName, s.modelName
+		if len(parts) >= 2 {
+			var err error
+			providerName, modelName = s.resolveModelSpec(parts[1])
+			p, modelName, err = newSessionProvider(providerName, modelName)
+			if err != nil {
+				restore()
+				fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+				return false
 			}
 		}
 
-		if newProvider == "" {
-			newProvider = s.providerName
+		if err := s.respond(p, providerName, modelName); err != nil {
+			restore()
+		} else if previous != nil {
+			s.mu.Lock()
+			s.retried = &retriedAnswer{previous: *previous, index: len(s.messages) - 1}
+			s.mu.Unlock()
+			fmt.Printf("%s  /diff compares this with the previous answer%s\n", dim, reset)
+		}
+
+	case "/copy", "/y":
+		s.copyCommand(parts[1:])
+
+	case "/fav":
+		s.favCommand(parts[1:])
+
+	case "/tag":
+		s.tagCommand(parts[1:])
+
+	case "/pin":
+		s.pinCommand(parts[1:])
+
+	case "/diff":
+		s.diffCommand()
+
+	case "/run":
+		s.runCodeCommand(parts[1:])
+
+	case "/write":
+		s.writeCommand(parts[1:])
+
+	case "/allow":
+		s.allowCommand(parts[1:])
+
+	case "/file", "/f", "/attach":
+		arg := strings.TrimSpace(input[len(parts[0]):])
+		if cmd == "/attach" && arg != "clear" && !strings.HasPrefix(arg, "~/") {
+			// Anything that isn't a file opens the picker, filtered by it
+			if _, err := os.Stat(arg); err != nil {
+				if arg = s.pickFile(arg); arg == "" {
+					return false
+				}
+			}
+		}
+		switch arg {
+		case "":
+			if len(s.attachments) == 0 {
+				fmt.Printf("\n%sUsage: /file <path> (attached to your next message)%s\n", dim, reset)
+				return false
+			}
+			fmt.Printf("\n%s  Attached to next message:\n", dim)
+			for _, a := range s.attachments {
+				if a.OCR {
+					fmt.Printf("    %s (text read with OCR, %s)\n", a.Path, formatBytes(int64(len(a.Content))))
+				} else {
+					fmt.Printf("    %s (%s)\n", a.Path, formatBytes(int64(len(a.Content))))
+				}
+			}
+			fmt.Printf("  Use /file clear to remove them%s\n", reset)
+		case "clear":
+			s.attachments = nil
+			fmt.Printf("\n%s✓ Attachments cleared%s\n", dim, reset)
+		default:
+			a, err := loadAttachment(arg, s.ocrLanguages)
+			if err != nil {
+				fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+				return false
+			}
+			s.attachments = append(s.attachments, a)
+
+			tokens := estimateTokens(a.Content)
+			if a.OCR {
+				fmt.Printf("\n%s✓ Attached the text in %s (read with OCR, ~%d tokens) to your next message%s\n", green, a.Path, tokens, reset)
+				return false
+			}
+			fmt.Printf("\n%s✓ Attached %s (%s, ~%d tokens) to your next message%s\n", green, a.Path, formatBytes(int64(len(a.Content))), tokens, reset)
+			if tokens > largeAttachmentToken {
+				fmt.Printf("%s! This is a large file and will use a big part of the context window%s\n", yellow, reset)
+			}
+		}
+
+	case "/persona":
+		if len(parts) < 2 {
+			s.printPersonas()
+			return false
+		}
+		s.setPersona(parts[1])
+
+	case "/search":
+		query := strings.TrimSpace(input[len(parts[0]):])
+		if query == "" {
+			fmt.Printf("\n%sUsage: /search <text>%s\n", dim, reset)
+			return false
+		}
+		s.printSearchResults(query)
+
+	case "/summarize":
+		s.mu.Lock()
+		count := len(s.messages)
+		s.mu.Unlock()
+		if count < 2 {
+			fmt.Printf("\n%sNothing to summarize yet%s\n", dim, reset)
+			return false
 		}
 
-		config, err := LoadConfigSafe()
+		reclaimed, err := s.summarizeHistory(s.provider, count)
 		if err != nil {
-			fmt.Printf("\n%s✗ Error loading config: %v%s\n", red, err, reset)
+			fmt.Printf("\n%s✗ Could not summarize: %v%s\n", red, err, reset)
 			return false
 		}
+		fmt.Printf("\n%s✓ Replaced %d messages with a summary, reclaiming ~%s tokens%s\n", green, count, formatTokenCount(max(reclaimed, 0)), reset)
+
+	case "/tokens", "/t":
+		s.printTokenUsage()
 
-		pc, exists := config.Providers[newProvider]
-		if !exists {
-			fmt.Printf("\n%s✗ Provider '%s' not configured%s\n", red, newProvider, reset)
+	case "/stats":
+		s.printStats()
+
+	case "/dual":
+		spec := ""
+		if len(parts) >= 2 {
+			spec = parts[1]
+		}
+		s.setDual(spec)
+
+	case "/set":
+		if len(parts) < 2 {
+			s.printSettings()
 			return false
 		}
+		rest := strings.TrimSpace(input[len(parts[0]):])
+		s.setOption(strings.ToLower(parts[1]), strings.TrimSpace(rest[len(parts[1]):]))
 
-		// If no model specified, use provider's default from config or fallback
-		if newModel == "" {
-			if pc.Model != "" {
-				newModel = pc.Model
-			} else if dm, ok := defaultModels[newProvider]; ok {
-				newModel = dm
-			}
+	case "/transcript", "/view":
+		s.viewTranscript("")
+
+	case "/find":
+		query := strings.TrimSpace(input[len(parts[0]):])
+		if query == "" {
+			fmt.Printf("\n%sUsage: /find <text>%s\n", dim, reset)
+			return false
+		}
+		s.mu.Lock()
+		found := len(searchMessages(s.messages, query)) > 0
+		s.mu.Unlock()
+		if !found {
+			fmt.Printf("\n%sNo matches for '%s' in this session%s\n", dim, query, reset)
+			return false
 		}
+		s.viewTranscript(query)
 
-		newProviderInstance := createProvider(newProvider, pc.APIKey, newModel)
-		if newProviderInstance == nil {
-			fmt.Printf("\n%s✗ Unknown provider: %s%s\n", red, newProvider, reset)
+	case "/save":
+		name := s.name
+		if len(parts) >= 2 {
+			name = parts[1]
+		}
+		if name == "" {
+			fmt.Printf("\n%sUsage: /save <name>%s\n", dim, reset)
 			return false
 		}
 
-		s.provider = newProviderInstance
-		s.providerName = newProvider
-		s.modelName = newModel
-		fmt.Printf("\n%s✓ Switched to %s/%s%s\n", green, newProvider, newModel, reset)
+		saved := s.snapshot()
+		saved.Name = name
+		if name != s.name {
+			saved.CreatedAt = time.Time{} // saving under a new name starts a new session
+		}
+
+		if err := saveSession(saved); err != nil {
+			fmt.Printf("\n%s✗ Error saving session: %v%s\n", red, err, reset)
+			return false
+		}
+		s.name = saved.Name
+		s.createdAt = saved.CreatedAt
+		fmt.Printf("\n%s✓ Session saved as '%s'%s\n", green, saved.Name, reset)
+
+	case "/fork":
+		if len(parts) < 2 {
+			fmt.Printf("\n%sUsage: /fork <name>%s\n", dim, reset)
+			return false
+		}
+		s.fork(parts[1])
+
+	case "/export":
+		if len(parts) < 2 {
+			fmt.Printf("\n%sUsage: /export <md|json|html> [file]%s\n", dim, reset)
+			return false
+		}
+		path := ""
+		if len(parts) >= 3 {
+			path = parts[2]
+		}
+
+		outPath, err := exportSession(s.snapshot(), parts[1], path)
+		if err != nil {
+			fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+			return false
+		}
+		fmt.Printf("\n%s✓ Exported to %s%s\n", green, outPath, reset)
+
+	case "/load":
+		sessions, err := listSessions()
+		if err != nil {
+			fmt.Printf("\n%s✗ Error listing sessions: %v%s\n", red, err, reset)
+			return false
+		}
+		if len(sessions) == 0 {
+			fmt.Printf("\n%sNo saved sessions. Use /save <name> to save one.%s\n", dim, reset)
+			return false
+		}
+
+		arg := ""
+		if len(parts) >= 2 {
+			arg = parts[1]
+		} else if arg = s.pickSession(sessions); arg == "" {
+			return false
+		}
+
+		saved, err := findSession(sessions, arg)
+		if err != nil {
+			fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+			return false
+		}
+		if err := s.restore(saved); err != nil {
+			fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+			return false
+		}
+		fmt.Printf("\n%s✓ Loaded '%s' (%d messages, %s/%s)%s\n", green, saved.Name, len(saved.Messages), s.providerName, s.modelName, reset)
 
 	case "/help", "/h", "/?":
-		fmt.Printf("\n%s", dim)
-		fmt.Println("  Commands:")
-		fmt.Println("    /help, /h    Show this help")
-		fmt.Println("    /model, /m   Switch model (e.g., /model gpt-4o)")
-		fmt.Println("    /clear, /c   Clear conversation history")
-		fmt.Println("    /exit, /q    Exit session")
-		fmt.Printf("%s\n", reset)
+		printHelp()
 
 	default:
 		fmt.Printf("\n%s✗ Unknown command: %s%s\n", red, cmd, reset)
@@ -315,18 +795,495 @@ func (s *Session) handleCommand(input string) bool {
 	return false
 }
 
-func renderMarkdownToTerminal(content string) {
-	r, err := glamour.NewTermRenderer(
-		glamour.WithAutoStyle(),
-		glamour.WithWordWrap(100),
-	)
+// compare replays the last user message, with the history before it, against
+// another model and shows both answers without changing the conversation
+func (s *Session) compare(spec string) {
+	s.mu.Lock()
+	last := -1
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].Role == "user" {
+			last = i
+			break
+		}
+	}
+	var msgs []provider.Message
+	var original *SessionMessage
+	if last >= 0 {
+		msgs = toProviderMessages(s.system(), s.messages[:last+1])
+		if last+1 < len(s.messages) {
+			answer := s.messages[last+1]
+			original = &answer
+		}
+	}
+	s.mu.Unlock()
+
+	if last < 0 {
+		fmt.Printf("\n%sNothing to compare yet. Ask something first.%s\n", dim, reset)
+		return
+	}
+
+	providerName, modelName := s.resolveModelSpec(spec)
+	p, modelName, err := newSessionProvider(providerName, modelName)
 	if err != nil {
-		fmt.Println(content)
+		fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+		return
+	}
+
+	if original != nil {
+		fmt.Printf("\n%s%s[A] %s › %s\n", bold, green, original.Model, reset)
+		renderMarkdownToTerminal(original.Content)
+	}
+
+	if err := s.checkBudget(); err != nil {
+		fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+		return
+	}
+	header := fmt.Sprintf("\n%s%s[B] %s/%s › %s\n", bold, magenta, providerName, modelName, reset)
+	if _, err := s.streamReply(p, providerName+"/"+modelName, msgs, header); err != nil {
+		printQueryError(err, providerName, modelName)
+		return
+	}
+	fmt.Printf("%s  Comparison only; the conversation still uses %s/%s%s\n\n", dim, s.providerName, s.modelName, reset)
+}
+
+// contextTokens estimates the tokens the next request will use, including
+// pending attachments
+func (s *Session) contextTokens() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := estimateTokens(s.system())
+	for _, msg := range s.messages {
+		total += estimateTokens(msg.Content)
+	}
+	for _, a := range s.attachments {
+		total += estimateTokens(a.Content)
+	}
+	return total
+}
+
+// usageIndicator returns a compact context usage marker for the prompt
+func (s *Session) usageIndicator() string {
+	used := s.contextTokens()
+	if used == 0 {
+		return ""
+	}
+	limit := contextWindow(s.modelName)
+	color := reset + dim
+	if used*100/limit >= 80 {
+		color = reset + yellow
+	}
+	return fmt.Sprintf(" %s[%s/%s]%s%s", color, formatTokenCount(used), formatTokenCount(limit), bold, cyan)
+}
+
+// printTokenUsage shows how much of the model's context window is in use
+func (s *Session) printTokenUsage() {
+	s.mu.Lock()
+	var history, attached int
+	for _, msg := range s.messages {
+		history += estimateTokens(msg.Content)
+	}
+	for _, a := range s.attachments {
+		attached += estimateTokens(a.Content)
+	}
+	count := len(s.messages)
+	s.mu.Unlock()
+
+	used := history + attached
+	limit := contextWindow(s.modelName)
+	percent := float64(used) * 100 / float64(limit)
+
+	const barWidth = 30
+	filled := min(int(percent*barWidth/100), barWidth)
+	barColor := green
+	if percent >= 80 {
+		barColor = yellow
+	}
+	if percent >= 100 {
+		barColor = red
+	}
+
+	fmt.Printf("\n%s", dim)
+	fmt.Printf("  History:     ~%s tokens (%d messages)\n", formatTokenCount(history), count)
+	if attached > 0 {
+		fmt.Printf("  Attachments: ~%s tokens\n", formatTokenCount(attached))
+	}
+	fmt.Printf("  Context:     %s tokens (%s)\n", formatTokenCount(limit), s.modelName)
+	fmt.Printf("%s  %s%s%s%s %.1f%%%s\n", reset, barColor, strings.Repeat("█", filled), gray, strings.Repeat("░", barWidth-filled), percent, reset)
+	fmt.Printf("%s  Token counts are estimates (~4 characters per token)%s\n", dim, reset)
+}
+
+// setPersona activates a persona from config, or clears it with "off"
+func (s *Session) setPersona(name string) {
+	if name == "off" || name == "none" {
+		if s.persona == "" && s.systemPrompt == "" {
+			fmt.Printf("\n%sNo persona active%s\n", dim, reset)
+			return
+		}
+		s.persona = ""
+		s.systemPrompt = ""
+		fmt.Printf("\n%s⚙ system: persona cleared, no system prompt%s\n", magenta, reset)
+		return
+	}
+
+	config, err := LoadConfigSafe()
+	if err != nil {
+		fmt.Printf("\n%s✗ Error loading config: %v%s\n", red, err, reset)
+		return
+	}
+	persona, ok := config.Personas[name]
+	if !ok {
+		fmt.Printf("\n%s✗ Unknown persona '%s'%s\n", red, name, reset)
+		return
+	}
+
+	if persona.Model != "" {
+		providerName, modelName := s.resolveModelSpec(persona.Model)
+		if err := s.switchProvider(providerName, modelName); err != nil {
+			fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+			return
+		}
+	}
+
+	s.persona = name
+	s.systemPrompt = strings.TrimSpace(persona.SystemPrompt)
+	fmt.Printf("\n%s⚙ system: now acting as '%s'", magenta, name)
+	if persona.Model != "" {
+		fmt.Printf(" on %s/%s", s.providerName, s.modelName)
+	}
+	fmt.Printf("%s\n", reset)
+}
+
+// personaNotePrefix starts the system messages that record in the
+// transcript which persona the following answers came from
+const personaNotePrefix = "Persona: "
+
+// personaNote is the transcript's record of a switch to persona, or of
+// clearing it when persona is empty
+func personaNote(persona string) string {
+	if persona == "" {
+		return personaNotePrefix + "none"
+	}
+	return personaNotePrefix + persona
+}
+
+// transcriptPersona returns the persona the transcript last recorded
+func transcriptPersona(messages []SessionMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "system" {
+			continue
+		}
+		if name, ok := strings.CutPrefix(messages[i].Content, personaNotePrefix); ok {
+			if name == "none" {
+				return ""
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+// printPersonas lists personas defined in config
+func (s *Session) printPersonas() {
+	config, err := LoadConfigSafe()
+	if err != nil {
+		fmt.Printf("\n%s✗ Error loading config: %v%s\n", red, err, reset)
+		return
+	}
+	if len(config.Personas) == 0 {
+		fmt.Printf("\n%sNo personas defined. Add them under 'personas:' in config.yaml%s\n", dim, reset)
+		return
+	}
+
+	fmt.Printf("\n%s  Personas:\n", dim)
+	for _, name := range personaNames(config) {
+		marker := ""
+		if name == s.persona {
+			marker = " (active)"
+		}
+		fmt.Printf("    %s%s\n", name, marker)
+	}
+	fmt.Printf("  Usage: /persona <name> or /persona off%s\n", reset)
+}
+
+// lastAnswer returns the most recent assistant message
+func (s *Session) lastAnswer() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].Role == "assistant" {
+			return s.messages[i].Content, true
+		}
+	}
+	return "", false
+}
+
+// copyCommand copies the last answer, or message #n (# alone picks one from
+// a list), optionally narrowed to its code blocks or a single block
+func (s *Session) copyCommand(args []string) {
+	text, what, source := "", "answer", "the last answer"
+	if len(args) > 0 && strings.HasPrefix(args[0], "#") {
+		n, ok := s.selectMessage(strings.TrimPrefix(args[0], "#"))
+		if !ok {
+			return
+		}
+		s.mu.Lock()
+		text = s.messages[n-1].Content
+		s.mu.Unlock()
+		what = fmt.Sprintf("message #%d", n)
+		source = what
+		args = args[1:]
+	} else {
+		answer, ok := s.lastAnswer()
+		if !ok {
+			fmt.Printf("\n%sNo answer to copy yet%s\n", dim, reset)
+			return
+		}
+		text = answer
+	}
+
+	if len(args) >= 1 && strings.ToLower(args[0]) == "code" {
+		blocks := extractCodeBlocks(text)
+		if len(blocks) == 0 {
+			fmt.Printf("\n%sNo code blocks in %s%s\n", dim, source, reset)
+			return
+		}
+		text = strings.Join(blocks, "\n\n")
+		what = fmt.Sprintf("%d code block(s)", len(blocks))
+
+		// Pick a single block by number, or the last one
+		if len(args) >= 2 {
+			n := len(blocks)
+			if args[1] != "last" {
+				var err error
+				if n, err = strconv.Atoi(args[1]); err != nil || n < 1 || n > len(blocks) {
+					fmt.Printf("\n%s✗ Pick a code block between 1 and %d, or 'last'%s\n", red, len(blocks), reset)
+					return
+				}
+			}
+			text = blocks[n-1]
+			what = fmt.Sprintf("code block %d of %d", n, len(blocks))
+		}
+	}
+
+	method, err := copyToClipboard(text)
+	if err != nil {
+		fmt.Printf("\n%s✗ Copy failed: %v%s\n", red, err, reset)
+		return
+	}
+	fmt.Printf("\n%s✓ Copied %s to clipboard (via %s)%s\n", green, what, method, reset)
+}
+
+// selectMessage resolves a 1-based message number. An empty arg lists the
+// messages with a one-line preview and asks for a number.
+func (s *Session) selectMessage(arg string) (int, bool) {
+	s.mu.Lock()
+	messages := append([]SessionMessage{}, s.messages...)
+	s.mu.Unlock()
+	if len(messages) == 0 {
+		fmt.Printf("\n%sNo messages yet%s\n", dim, reset)
+		return 0, false
+	}
+
+	if arg == "" {
 		fmt.Println()
+		for i, msg := range messages {
+			preview := strings.Join(strings.Fields(msg.Content), " ")
+			if r := []rune(preview); len(r) > 70 {
+				preview = string(r[:69]) + "…"
+			}
+			fmt.Printf("  %s%3d. %-9s%s %s\n", dim, i+1, msg.Role, reset, preview)
+		}
+		line, ok := s.readLine(fmt.Sprintf("%s  Message number, Enter to cancel › %s", dim, reset))
+		if arg = strings.TrimSpace(line); !ok || arg == "" {
+			return 0, false
+		}
+	}
+
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(messages) {
+		fmt.Printf("\n%s✗ Pick a message between 1 and %d%s\n", red, len(messages), reset)
+		return 0, false
+	}
+	return n, true
+}
+
+// snapshot captures the current conversation for saving or exporting
+func (s *Session) snapshot() *SavedSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &SavedSession{
+		Name:      s.name,
+		Provider:  s.providerName,
+		Model:     s.modelName,
+		Persona:   s.persona,
+		System:    s.systemPrompt,
+		Parent:    s.parent,
+		Tags:      append([]string{}, s.tags...),
+		Messages:  append([]SessionMessage{}, s.messages...),
+		CreatedAt: s.createdAt,
+	}
+}
+
+// resolveModelSpec parses a /model style spec with the session's provider
+// as the fallback
+func (s *Session) resolveModelSpec(spec string) (string, string) {
+	return parseModelChoice(spec, s.providerName)
+}
+
+// parseModelChoice parses a /model style spec ("gpt-4o", "claude",
+// "claude/claude-3-5-haiku-20241022") into a provider and model name. An empty
+// model means the provider's default; fallback is used when the provider
+// can't be told from the spec.
+func parseModelChoice(spec, fallback string) (string, string) {
+	newProvider, newModel := ParseModelSpec(spec)
+
+	// If no provider detected, try to resolve from model name
+	if newProvider == "" {
+		newProvider = ResolveProviderFromModel(newModel)
+	}
+
+	// If still no provider but the spec matches a known provider name, use it
+	knownProviders := []string{"gemini", "claude", "chatgpt", "deepseek", "mistral", "qwen"}
+	for _, kp := range knownProviders {
+		if newModel == kp {
+			newProvider = kp
+			newModel = "" // Will use default
+			break
+		}
+	}
+
+	if newProvider == "" {
+		newProvider = fallback
+	}
+	return newProvider, newModel
+}
+
+// newSessionProvider creates a provider from config, falling back to the
+// provider's default model when model is empty. Returns the model used.
+func newSessionProvider(providerName, model string) (provider.Provider, string, error) {
+	config, err := LoadConfigSafe()
+	if err != nil {
+		return nil, "", fmt.Errorf("error loading config: %w", err)
+	}
+
+	pc, exists := config.Providers[providerName]
+	if !exists {
+		return nil, "", fmt.Errorf("provider '%s' not configured", providerName)
+	}
+
+	// If no model specified, use provider's default from config or fallback
+	if model == "" {
+		if pc.Model != "" {
+			model = pc.Model
+		} else if dm, ok := defaultModels[providerName]; ok {
+			model = dm
+		}
+	}
+
+	p := createProvider(providerName, pc.APIKey, model)
+	if p == nil {
+		return nil, "", fmt.Errorf("unknown provider: %s", providerName)
+	}
+	return p, model, nil
+}
+
+// fork saves the current conversation, then continues in a copy saved under
+// a new name so the original thread stays untouched
+func (s *Session) fork(name string) {
+	if err := validateSessionName(name); err != nil {
+		fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+		return
+	}
+	if name == s.name {
+		fmt.Printf("\n%s✗ Pick a different name than the current session%s\n", red, reset)
+		return
+	}
+	if _, err := loadSession(name); err == nil {
+		fmt.Printf("\n%s✗ Session '%s' already exists%s\n", red, name, reset)
+		return
+	}
+
+	// Preserve the original thread, naming it if it was never saved
+	original := s.snapshot()
+	if original.Name == "" {
+		original.Name = "session-" + time.Now().Format("20060102-150405")
+	}
+	if err := saveSession(original); err != nil {
+		fmt.Printf("\n%s✗ Error saving original session: %v%s\n", red, err, reset)
+		return
+	}
+
+	forked := s.snapshot()
+	forked.Name = name
+	forked.CreatedAt = time.Time{}
+	forked.Parent = original.Name
+	if err := saveSession(forked); err != nil {
+		fmt.Printf("\n%s✗ Error saving fork: %v%s\n", red, err, reset)
 		return
 	}
 
-	out, err := r.Render(content)
+	s.name = forked.Name
+	s.createdAt = forked.CreatedAt
+	s.parent = forked.Parent
+	fmt.Printf("\n%s✓ Forked '%s' into '%s'%s\n", green, original.Name, name, reset)
+	fmt.Printf("%s  You're now in '%s'. Use /load %s to return to the original.%s\n", dim, name, original.Name, reset)
+}
+
+// switchProvider replaces the active provider and model
+func (s *Session) switchProvider(providerName, model string) error {
+	p, model, err := newSessionProvider(providerName, model)
+	if err != nil {
+		return err
+	}
+
+	s.provider = p
+	s.providerName = providerName
+	s.modelName = model
+	return nil
+}
+
+// restore replaces the conversation with a saved session and switches to its model
+func (s *Session) restore(saved *SavedSession) error {
+	if saved.Provider != "" {
+		if err := s.switchProvider(saved.Provider, saved.Model); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.messages = append([]SessionMessage{}, saved.Messages...)
+	s.mu.Unlock()
+	s.name = saved.Name
+	s.createdAt = saved.CreatedAt
+	s.persona = saved.Persona
+	s.systemPrompt = saved.System
+	s.parent = saved.Parent
+	s.tags = saved.Tags
+	return nil
+}
+
+// pickSession lists saved sessions, newest first, and returns the chosen
+// name or number, or "" if the user cancels
+func (s *Session) pickSession(sessions []*SavedSession) string {
+	fmt.Printf("\n%sSaved sessions:%s\n", bold, reset)
+	for i, saved := range sessions {
+		marker := ""
+		if saved.Name == s.name {
+			marker = fmt.Sprintf(" %s(current)%s", green, reset)
+		}
+		fmt.Printf("  %s%3d.%s %-20s %s%s/%s, %d messages, %s%s%s%s\n", dim, i+1, reset, saved.Name, dim, saved.Provider, saved.Model,
+			len(saved.Messages), saved.UpdatedAt.Format("2006-01-02 15:04"), reset, tagLabel(saved.Tags), marker)
+	}
+
+	line, ok := s.readLine(fmt.Sprintf("%s  Number or name to open, Enter to cancel › %s", dim, reset))
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(line)
+}
+
+func renderMarkdownToTerminal(content string) {
+	out, err := renderANSI(content, renderWidth)
 	if err != nil {
 		fmt.Println(content)
 		fmt.Println()
@@ -0,0 +1,166 @@
+// Package main provides persistence for named interactive sessions.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// SavedSession is the on-disk representation of a named session
+type SavedSession struct {
+	Name      string           `json:"name"`
+	Provider  string           `json:"provider"`
+	Model     string           `json:"model"`
+	Persona   string           `json:"persona,omitempty"`
+	System    string           `json:"system_prompt,omitempty"`
+	Parent    string           `json:"forked_from,omitempty"`
+	Tags      []string         `json:"tags,omitempty"`
+	Messages  []SessionMessage `json:"messages"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// SessionMessage is a conversation message along with when it was sent and,
+// for assistant messages, which provider/model produced it
+type SessionMessage struct {
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Model   string    `json:"model,omitempty"`
+	Time    time.Time `json:"time,omitempty"`
+	Partial bool      `json:"partial,omitempty"` // cut short by Ctrl+C or Esc
+}
+
+// toProviderMessages strips session metadata for sending to a provider,
+// leading with the system prompt when one is set. System messages in the
+// transcript only record persona switches, so they aren't sent.
+func toProviderMessages(system string, messages []SessionMessage) []provider.Message {
+	msgs := make([]provider.Message, 0, len(messages)+1)
+	if system != "" {
+		msgs = append(msgs, provider.Message{Role: "system", Content: system})
+	}
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			continue
+		}
+		msgs = append(msgs, provider.Message{Role: msg.Role, Content: msg.Content})
+	}
+	return msgs
+}
+
+// validateSessionName rejects names that can't be used as session names
+func validateSessionName(name string) error {
+	if name == "" {
+		return fmt.Errorf("session name cannot be empty")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid session name '%s'", name)
+	}
+	return nil
+}
+
+// saveSession writes a session to the database, replacing any session with
+// the same name
+func saveSession(saved *SavedSession) error {
+	if err := validateSessionName(saved.Name); err != nil {
+		return err
+	}
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	saved.UpdatedAt = time.Now()
+	if saved.CreatedAt.IsZero() {
+		saved.CreatedAt = saved.UpdatedAt
+	}
+	if err := writeSession(db, saved); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// loadSession reads a named session from the database
+func loadSession(name string) (*SavedSession, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := readSessions(db, "WHERE name = ?", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session '%s': %w", name, err)
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("session '%s' not found", name)
+	}
+	return sessions[0], nil
+}
+
+// listSessions returns all saved sessions, most recently updated first
+func listSessions() ([]*SavedSession, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	return readSessions(db, "")
+}
+
+// resolveResumeTarget finds the session for --resume. The argument may be a
+// session name or its index in the list; when empty, the user picks one.
+// Returns nil without error if the user cancels the picker.
+func resolveResumeTarget(arg string) (*SavedSession, error) {
+	sessions, err := listSessions()
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("no saved sessions to resume (use /save <name> in a session)")
+	}
+
+	if arg == "" {
+		arg = pickSavedSession(sessions)
+		if arg == "" {
+			return nil, nil
+		}
+	}
+
+	return findSession(sessions, arg)
+}
+
+// findSession looks up a session by name or by its 1-based position in
+// sessions. Names take precedence over list indexes.
+func findSession(sessions []*SavedSession, arg string) (*SavedSession, error) {
+	for _, saved := range sessions {
+		if saved.Name == arg {
+			return saved, nil
+		}
+	}
+	if num, err := strconv.Atoi(arg); err == nil && num > 0 && num <= len(sessions) {
+		return sessions[num-1], nil
+	}
+	return nil, fmt.Errorf("session '%s' not found", arg)
+}
+
+// pickSavedSession prints a numbered list of sessions and reads a choice
+func pickSavedSession(sessions []*SavedSession) string {
+	fmt.Println()
+	fmt.Println("  Saved sessions:")
+	fmt.Println()
+	for i, saved := range sessions {
+		fmt.Printf("  %2d. %-20s %s/%s, %d messages, %s%s\n", i+1, saved.Name, saved.Provider, saved.Model,
+			len(saved.Messages), saved.UpdatedAt.Format("2006-01-02 15:04"), tagLabel(saved.Tags))
+	}
+	fmt.Println()
+	fmt.Printf("  Resume [1-%d] (Enter to cancel): ", len(sessions))
+
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		return ""
+	}
+	return strings.TrimSpace(scanner.Text())
+}
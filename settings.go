@@ -0,0 +1,114 @@
+// Package main provides per-session generation settings.
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// applyOptions passes the session's generation settings to a provider
+func (s *Session) applyOptions(p provider.Provider) {
+	if c, ok := p.(provider.Configurable); ok {
+		s.mu.Lock()
+		c.SetOptions(s.options)
+		s.mu.Unlock()
+	}
+}
+
+// printSettings shows the current session settings
+func (s *Session) printSettings() {
+	s.mu.Lock()
+	opts, stream, system := s.options, !s.noStream, s.systemPrompt
+	s.mu.Unlock()
+
+	temperature := "default"
+	if opts.Temperature != nil {
+		temperature = strconv.FormatFloat(*opts.Temperature, 'f', -1, 64)
+	}
+	maxTokens := "default"
+	if opts.MaxTokens > 0 {
+		maxTokens = strconv.Itoa(opts.MaxTokens)
+	}
+	streaming := "on"
+	if !stream {
+		streaming = "off"
+	}
+	system = strings.Join(strings.Fields(system), " ")
+	if system == "" {
+		system = "none"
+	} else if r := []rune(system); len(r) > 60 {
+		system = string(r[:59]) + "…"
+	}
+
+	fmt.Printf("\n%sSession settings%s\n", bold, reset)
+	fmt.Printf("  temperature   %s\n", temperature)
+	fmt.Printf("  max_tokens    %s\n", maxTokens)
+	fmt.Printf("  stream        %s\n", streaming)
+	fmt.Printf("  system        %s\n", system)
+	fmt.Printf("%s  Change with /set <name> <value>, or 'default' to reset%s\n", dim, reset)
+}
+
+// setOption changes one session setting from /set
+func (s *Session) setOption(name, value string) {
+	if value == "" {
+		fmt.Printf("\n%sUsage: /set %s <value>%s\n", dim, name, reset)
+		return
+	}
+	useDefault := strings.EqualFold(value, "default") || name == "system" && strings.EqualFold(value, "off")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch name {
+	case "temperature", "temp":
+		if useDefault {
+			s.options.Temperature = nil
+			break
+		}
+		t, err := strconv.ParseFloat(value, 64)
+		if err != nil || t < 0 || t > 2 {
+			fmt.Printf("\n%s✗ Temperature must be a number between 0 and 2%s\n", red, reset)
+			return
+		}
+		s.options.Temperature = &t
+	case "max_tokens", "max-tokens", "maxtokens":
+		if useDefault {
+			s.options.MaxTokens = 0
+			break
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			fmt.Printf("\n%s✗ max_tokens must be a positive number%s\n", red, reset)
+			return
+		}
+		s.options.MaxTokens = n
+	case "stream":
+		switch strings.ToLower(value) {
+		case "on", "true", "default":
+			s.noStream = false
+		case "off", "false":
+			s.noStream = true
+		default:
+			fmt.Printf("\n%s✗ stream must be on or off%s\n", red, reset)
+			return
+		}
+	case "system":
+		if useDefault {
+			s.systemPrompt = ""
+		} else {
+			s.systemPrompt = value
+		}
+		s.persona = "" // a hand-written prompt replaces the persona's
+		value = "the given prompt"
+		if useDefault {
+			value = "none"
+		}
+	default:
+		fmt.Printf("\n%s✗ Unknown setting '%s' (temperature, max_tokens, stream, system)%s\n", red, name, reset)
+		return
+	}
+	fmt.Printf("\n%s✓ %s set to %s for this session%s\n", green, name, value, reset)
+}
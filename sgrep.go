@@ -0,0 +1,327 @@
+// Package main provides `ask sgrep`, a search by meaning: the model turns
+// the question into likely identifiers and keywords, the files are searched
+// for them, and the model ranks the best passages with a short explanation.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// isSgrepCommand reports whether the arguments invoke `ask sgrep`
+func isSgrepCommand(args []string) bool {
+	return len(args) >= 2 && args[0] == "sgrep"
+}
+
+// Limits on what sgrep reads and sends
+const (
+	defaultSgrepResults = 10
+	maxSgrepFileBytes   = 1 << 20
+	maxSgrepSnippets    = 40
+	maxSgrepTokens      = 12_000 // of passages sent for ranking
+	sgrepWindow         = 6      // lines either side of a hit
+	maxSnippetsPerFile  = 3
+)
+
+// sgrepTermsPrompt asks for the words code about the question would contain
+const sgrepTermsPrompt = `A developer is searching a codebase for: %s
+
+List 8 to 15 words likely to appear in the code or docs that answer this:
+identifiers in their usual forms (retryRequest, retry_request, Retry),
+library and function names, and plain keywords. Reply with a JSON array of
+strings only.`
+
+// sgrepRankPrompt asks for the passages that answer the question, ranked
+const sgrepRankPrompt = `A developer is searching a codebase for: %s
+
+Below are passages from it, each headed by its file and line numbers. Pick at
+most %d that best answer the search, most relevant first, and skip any that
+don't. Reply with a JSON array only, each item like
+{"file": "path/to/file.go", "line": 42, "why": "one short sentence on what is there"},
+where line is the single line that best marks the spot.
+
+%s`
+
+// sgrepResult is one ranked location
+type sgrepResult struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Why  string `json:"why"`
+}
+
+// sgrepSnippet is a passage around keyword hits
+type sgrepSnippet struct {
+	file       string
+	start, end int // 1-based, inclusive
+	score      int
+}
+
+// runSgrepCommand searches the working directory, or the given paths, for
+// the passages that answer a question
+func runSgrepCommand(args []string) error {
+	var providerFlag, modelFlag, profileFlag string
+	limit := defaultSgrepResults
+	asJSON := false
+	fs := flag.NewFlagSet("sgrep", flag.ContinueOnError)
+	fs.StringVar(&modelFlag, "m", "", "Model to search with")
+	fs.StringVar(&providerFlag, "p", "", "Provider to search with")
+	fs.StringVar(&profileFlag, "P", "", "Profile to search with")
+	fs.IntVar(&limit, "n", defaultSgrepResults, "Most results to show")
+	fs.BoolVar(&asJSON, "json", false, "Print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: ask sgrep [-n 10] [--json] \"what you're looking for\" [path...]")
+	}
+	query := fs.Arg(0)
+	paths := fs.Args()[1:]
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	if limit < 1 {
+		return fmt.Errorf("-n must be at least 1")
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		return err
+	}
+	root, err := workingDir()
+	if err != nil {
+		return err
+	}
+	tools := &toolRunner{root: root, ctx: context.Background()}
+
+	// Search terms: the model's guesses plus the question's own words
+	answer, err := queryOnce(config, providerFlag, modelFlag, profileFlag, fmt.Sprintf(sgrepTermsPrompt, query), "sgrep")
+	if err != nil {
+		return err
+	}
+	var guessed []string
+	parseJSONAnswer(answer, &guessed)
+	terms := sgrepTerms(query, guessed)
+	if len(terms) == 0 {
+		return fmt.Errorf("no words to search for in '%s'", query)
+	}
+
+	var files []string
+	for _, p := range paths {
+		dir, err := tools.resolve(p)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+			files = append(files, dir)
+			continue
+		}
+		found, err := tools.walkFiles(dir)
+		if err != nil {
+			return err
+		}
+		files = append(files, found...)
+	}
+	snippets, lines := findSnippets(files, terms)
+	if len(snippets) == 0 {
+		if !asJSON {
+			fmt.Fprintf(os.Stderr, "%sNothing found for %s%s\n", dim, strings.Join(terms, ", "), reset)
+		}
+		return nil
+	}
+
+	var passages strings.Builder
+	for _, sn := range snippets {
+		fmt.Fprintf(&passages, "%s:%d-%d\n", tools.relative(sn.file), sn.start, sn.end)
+		for n := sn.start; n <= sn.end; n++ {
+			fmt.Fprintf(&passages, "%5d  %s\n", n, lines[sn.file][n-1])
+		}
+		passages.WriteString("\n")
+	}
+	answer, err = queryOnce(config, providerFlag, modelFlag, profileFlag, fmt.Sprintf(sgrepRankPrompt, query, limit, passages.String()), "sgrep")
+	if err != nil {
+		return err
+	}
+	var results []sgrepResult
+	if err := parseJSONAnswer(answer, &results); err != nil {
+		return fmt.Errorf("couldn't read the ranking: %v", err)
+	}
+
+	// Keep only places that were actually shown to the model
+	shown := map[string]bool{}
+	for _, sn := range snippets {
+		shown[tools.relative(sn.file)] = true
+	}
+	kept := results[:0]
+	for _, r := range results {
+		if shown[r.File] && len(kept) < limit {
+			kept = append(kept, r)
+		}
+	}
+
+	if asJSON {
+		if kept == nil {
+			kept = []sgrepResult{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(kept)
+	}
+	if len(kept) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo passage answers that; try other words%s\n", dim, reset)
+		return nil
+	}
+	for _, r := range kept {
+		fmt.Printf("%s%s:%d%s  %s\n", cyan, r.File, r.Line, reset, r.Why)
+	}
+	return nil
+}
+
+// sgrepStopWords are left out of the question's own words
+var sgrepStopWords = map[string]bool{
+	"where": true, "what": true, "which": true, "when": true, "does": true, "there": true,
+	"this": true, "that": true, "with": true, "from": true, "into": true, "have": true,
+	"code": true, "find": true, "show": true, "handle": true, "handles": true, "the": true,
+}
+
+// sgrepWord splits a question into words
+var sgrepWord = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// sgrepTerms merges the model's guesses with the question's longer words,
+// lowercased and without duplicates
+func sgrepTerms(query string, guessed []string) []string {
+	seen := map[string]bool{}
+	var terms []string
+	add := func(t string) {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if len(t) >= 3 && !seen[t] && !sgrepStopWords[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	for _, t := range guessed {
+		add(t)
+	}
+	for _, w := range sgrepWord.FindAllString(query, -1) {
+		if len(w) >= 4 {
+			add(w)
+		}
+	}
+	return terms
+}
+
+// findSnippets scores passages by how many different terms they contain
+// and returns the best, within the limits, along with the files' lines
+func findSnippets(files []string, terms []string) ([]sgrepSnippet, map[string][]string) {
+	var all []sgrepSnippet
+	lines := map[string][]string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil || len(data) > maxSgrepFileBytes || bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1 {
+			continue
+		}
+		fileLines := strings.Split(string(data), "\n")
+		hits := make([][]int, len(fileLines))
+		found := false
+		for i, line := range fileLines {
+			lower := strings.ToLower(line)
+			for t, term := range terms {
+				if strings.Contains(lower, term) {
+					hits[i] = append(hits[i], t)
+					found = true
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+		pathBonus := 0
+		for _, term := range terms {
+			if strings.Contains(strings.ToLower(filepath.Base(file)), term) {
+				pathBonus++
+			}
+		}
+
+		var candidates []sgrepSnippet
+		for i := range fileLines {
+			if len(hits[i]) == 0 {
+				continue
+			}
+			start, end := max(0, i-sgrepWindow), min(len(fileLines)-1, i+sgrepWindow)
+			distinct := map[int]bool{}
+			hitLines := 0
+			for j := start; j <= end; j++ {
+				if len(hits[j]) > 0 {
+					hitLines++
+				}
+				for _, t := range hits[j] {
+					distinct[t] = true
+				}
+			}
+			candidates = append(candidates, sgrepSnippet{file: file, start: start + 1, end: end + 1, score: len(distinct)*3 + hitLines + pathBonus})
+		}
+		sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].score > candidates[b].score })
+		var chosen []sgrepSnippet
+		for _, c := range candidates {
+			overlaps := false
+			for _, k := range chosen {
+				if c.start <= k.end && k.start <= c.end {
+					overlaps = true
+					break
+				}
+			}
+			if !overlaps {
+				chosen = append(chosen, c)
+				if len(chosen) == maxSnippetsPerFile {
+					break
+				}
+			}
+		}
+		all = append(all, chosen...)
+		lines[file] = fileLines
+	}
+
+	sort.SliceStable(all, func(a, b int) bool { return all[a].score > all[b].score })
+	var kept []sgrepSnippet
+	tokens := 0
+	for _, sn := range all {
+		if len(kept) == maxSgrepSnippets {
+			break
+		}
+		size := 0
+		for n := sn.start; n <= sn.end; n++ {
+			size += estimateTokens(lines[sn.file][n-1]) + 2
+		}
+		if tokens+size > maxSgrepTokens {
+			continue
+		}
+		kept = append(kept, sn)
+		tokens += size
+	}
+	return kept, lines
+}
+
+// parseJSONAnswer decodes the JSON in a model's answer, ignoring a code
+// fence or words around it
+func parseJSONAnswer(answer string, v any) error {
+	answer = strings.TrimSpace(answer)
+	if m := codeFence.FindStringSubmatch(answer); m != nil {
+		answer = m[1]
+	}
+	start := strings.IndexAny(answer, "[{")
+	end := strings.LastIndexAny(answer, "]}")
+	if start == -1 || end < start {
+		return fmt.Errorf("no JSON in the answer")
+	}
+	return json.Unmarshal([]byte(answer[start:end+1]), v)
+}
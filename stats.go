@@ -0,0 +1,191 @@
+// Package main provides per-session usage statistics.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// turnUsage records one request made during the session
+type turnUsage struct {
+	model            string // provider/model
+	promptTokens     int
+	completionTokens int
+	latency          time.Duration
+}
+
+// Approximate list prices in USD per million tokens by model name prefix,
+// most specific first
+var modelPrices = []struct {
+	prefix        string
+	input, output float64
+}{
+	{"gpt-4o-mini", 0.15, 0.60},
+	{"gpt-4o", 2.50, 10},
+	{"gpt-4.1-nano", 0.10, 0.40},
+	{"gpt-4.1-mini", 0.40, 1.60},
+	{"gpt-4.1", 2, 8},
+	{"gpt-4-turbo", 10, 30},
+	{"gpt-4", 30, 60},
+	{"gpt-3.5", 0.50, 1.50},
+	{"o1-mini", 1.10, 4.40},
+	{"o1", 15, 60},
+	{"o3-mini", 1.10, 4.40},
+	{"o3", 2, 8},
+	{"o4-mini", 1.10, 4.40},
+	{"claude-3-haiku", 0.25, 1.25},
+	{"claude-3-5-haiku", 0.80, 4},
+	{"claude-3-opus", 15, 75},
+	{"claude-opus", 15, 75},
+	{"claude", 3, 15},
+	{"gemini-2.5-pro", 1.25, 10},
+	{"gemini-2.5-flash", 0.30, 2.50},
+	{"gemini-2.0-flash", 0.10, 0.40},
+	{"gemini-1.5-pro", 1.25, 5},
+	{"gemini-1.5-flash", 0.075, 0.30},
+	{"deepseek-reasoner", 0.55, 2.19},
+	{"deepseek", 0.27, 1.10},
+	{"mistral-large", 2, 6},
+	{"pixtral-large", 2, 6},
+	{"mistral-small", 0.20, 0.60},
+	{"codestral", 0.30, 0.90},
+	{"ministral", 0.10, 0.10},
+	{"qwen-max", 1.60, 6.40},
+	{"qwen-plus", 0.40, 1.20},
+	{"qwen-turbo", 0.05, 0.20},
+}
+
+// estimateCost returns the cost in USD of a request, or false if the
+// model's price is unknown
+func estimateCost(spec string, promptTokens, completionTokens int) (float64, bool) {
+	model := spec
+	if i := strings.IndexByte(model, '/'); i != -1 {
+		model = model[i+1:]
+	}
+	model = strings.ToLower(strings.TrimPrefix(model, "models/"))
+	for _, p := range modelPrices {
+		if strings.HasPrefix(model, p.prefix) {
+			return (float64(promptTokens)*p.input + float64(completionTokens)*p.output) / 1e6, true
+		}
+	}
+	return 0, false
+}
+
+// newTurnUsage estimates the tokens of a completed request
+func newTurnUsage(spec string, msgs []provider.Message, response string, latency time.Duration) turnUsage {
+	prompt := 0
+	for _, m := range msgs {
+		prompt += estimateTokens(m.Content)
+	}
+	return turnUsage{
+		model:            spec,
+		promptTokens:     prompt,
+		completionTokens: estimateTokens(response),
+		latency:          latency,
+	}
+}
+
+// recordUsage adds a completed request to the session statistics and the
+// usage ledger
+func (s *Session) recordUsage(spec string, msgs []provider.Message, response string, latency time.Duration) {
+	u := newTurnUsage(spec, msgs, response, latency)
+	s.mu.Lock()
+	s.usage = append(s.usage, u)
+	name := s.name
+	s.mu.Unlock()
+	saveUsage(name, u)
+}
+
+// usageTotals aggregates turns for display
+type usageTotals struct {
+	requests         int
+	promptTokens     int
+	completionTokens int
+	latency          time.Duration
+	cost             float64
+	unpriced         bool // some requests used a model without a known price
+}
+
+func (t *usageTotals) add(u turnUsage) {
+	t.requests++
+	t.promptTokens += u.promptTokens
+	t.completionTokens += u.completionTokens
+	t.latency += u.latency
+	if cost, ok := estimateCost(u.model, u.promptTokens, u.completionTokens); ok {
+		t.cost += cost
+	} else {
+		t.unpriced = true
+	}
+}
+
+func (t usageTotals) avgLatency() time.Duration {
+	if t.requests == 0 {
+		return 0
+	}
+	return (t.latency / time.Duration(t.requests)).Round(100 * time.Millisecond)
+}
+
+func (t usageTotals) costLabel() string {
+	if t.cost == 0 && t.unpriced {
+		return "unknown"
+	}
+	return fmt.Sprintf("$%.4f", t.cost)
+}
+
+// printStats shows token usage, estimated cost, and latency for this session
+func (s *Session) printStats() {
+	s.mu.Lock()
+	usage := append([]turnUsage{}, s.usage...)
+	s.mu.Unlock()
+
+	if len(usage) == 0 {
+		fmt.Printf("\n%sNo requests yet this session.%s\n", dim, reset)
+		return
+	}
+
+	var total usageTotals
+	perModel := make(map[string]*usageTotals)
+	for _, u := range usage {
+		total.add(u)
+		if perModel[u.model] == nil {
+			perModel[u.model] = &usageTotals{}
+		}
+		perModel[u.model].add(u)
+	}
+
+	fmt.Printf("\n%sSession stats%s %s(token counts are estimates)%s\n", bold, reset, dim, reset)
+	fmt.Printf("  Requests      %d\n", total.requests)
+	fmt.Printf("  Prompt        ~%s tokens\n", formatTokenCount(total.promptTokens))
+	fmt.Printf("  Completion    ~%s tokens\n", formatTokenCount(total.completionTokens))
+	fmt.Printf("  Est. cost     %s", total.costLabel())
+	if total.unpriced && total.cost > 0 {
+		fmt.Printf(" %s(excludes models without known prices)%s", dim, reset)
+	}
+	fmt.Println()
+	fmt.Printf("  Avg latency   %s\n", total.avgLatency())
+
+	if len(perModel) < 2 {
+		return
+	}
+
+	models := make([]string, 0, len(perModel))
+	for m := range perModel {
+		models = append(models, m)
+	}
+	sort.Strings(models)
+
+	fmt.Printf("\n  %s%-36s %5s %8s %11s %10s %8s%s\n", dim, "Model", "Reqs", "Prompt", "Completion", "Cost", "Latency", reset)
+	for _, m := range models {
+		t := perModel[m]
+		name := m
+		if len(name) > 36 {
+			name = name[:33] + "..."
+		}
+		fmt.Printf("  %-36s %5d %8s %11s %10s %8s\n", name, t.requests,
+			formatTokenCount(t.promptTokens), formatTokenCount(t.completionTokens), t.costLabel(), t.avgLatency())
+	}
+}
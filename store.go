@@ -0,0 +1,399 @@
+// Package main provides the SQLite database behind saved sessions, one-shot
+// history, usage records, and autosaves, with a full-text index of
+// sessions and history.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// migrations create and update the schema. The database's user_version is
+// the number of migrations applied; append new ones, never edit old ones.
+var migrations = []string{
+	`CREATE TABLE sessions (
+		id            INTEGER PRIMARY KEY,
+		name          TEXT NOT NULL UNIQUE,
+		provider      TEXT NOT NULL DEFAULT '',
+		model         TEXT NOT NULL DEFAULT '',
+		persona       TEXT NOT NULL DEFAULT '',
+		system_prompt TEXT NOT NULL DEFAULT '',
+		forked_from   TEXT NOT NULL DEFAULT '',
+		created_at    INTEGER NOT NULL,
+		updated_at    INTEGER NOT NULL
+	);
+	CREATE TABLE messages (
+		id         INTEGER PRIMARY KEY,
+		session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		seq        INTEGER NOT NULL,
+		role       TEXT NOT NULL,
+		content    TEXT NOT NULL,
+		model      TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX messages_session ON messages(session_id, seq);
+	CREATE TABLE usage (
+		id                INTEGER PRIMARY KEY,
+		session           TEXT NOT NULL DEFAULT '',
+		model             TEXT NOT NULL,
+		prompt_tokens     INTEGER NOT NULL,
+		completion_tokens INTEGER NOT NULL,
+		latency_ms        INTEGER NOT NULL,
+		created_at        INTEGER NOT NULL
+	);
+	CREATE INDEX usage_created ON usage(created_at);
+	CREATE TABLE autosave (
+		pid        INTEGER PRIMARY KEY,
+		data       TEXT NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE TABLE meta (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);`,
+	`CREATE TABLE history (
+		id         INTEGER PRIMARY KEY,
+		provider   TEXT NOT NULL,
+		model      TEXT NOT NULL,
+		prompt     TEXT NOT NULL,
+		response   TEXT NOT NULL,
+		latency_ms INTEGER NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX history_created ON history(created_at);`,
+	`CREATE VIRTUAL TABLE history_fts USING fts5(prompt, response, content='history', content_rowid='id');
+	CREATE TRIGGER history_fts_insert AFTER INSERT ON history BEGIN
+		INSERT INTO history_fts(rowid, prompt, response) VALUES (new.id, new.prompt, new.response);
+	END;
+	CREATE TRIGGER history_fts_delete AFTER DELETE ON history BEGIN
+		INSERT INTO history_fts(history_fts, rowid, prompt, response) VALUES ('delete', old.id, old.prompt, old.response);
+	END;
+	INSERT INTO history_fts(history_fts) VALUES ('rebuild');
+	CREATE VIRTUAL TABLE messages_fts USING fts5(content, content='messages', content_rowid='id');
+	CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content);
+	END;
+	CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	END;
+	INSERT INTO messages_fts(messages_fts) VALUES ('rebuild');`,
+	`ALTER TABLE usage ADD COLUMN provider TEXT NOT NULL DEFAULT '';
+	ALTER TABLE usage ADD COLUMN cost REAL;
+	UPDATE usage SET provider = substr(model, 1, instr(model, '/') - 1), model = substr(model, instr(model, '/') + 1)
+		WHERE instr(model, '/') > 0;`,
+	`CREATE TABLE response_cache (
+		key        TEXT PRIMARY KEY,
+		response   TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);`,
+	`CREATE TABLE favorites (
+		id         INTEGER PRIMARY KEY,
+		prompt     TEXT NOT NULL UNIQUE,
+		created_at INTEGER NOT NULL
+	);`,
+	`CREATE TABLE telemetry (
+		day   TEXT NOT NULL,
+		event TEXT NOT NULL,
+		count INTEGER NOT NULL,
+		PRIMARY KEY (day, event)
+	);`,
+	`CREATE TABLE model_cache (
+		provider   TEXT PRIMARY KEY,
+		key_hash   TEXT NOT NULL,
+		models     TEXT NOT NULL,
+		fetched_at INTEGER NOT NULL
+	);`,
+	`CREATE TABLE tags (
+		kind    TEXT NOT NULL,
+		item_id INTEGER NOT NULL,
+		tag     TEXT NOT NULL,
+		PRIMARY KEY (kind, item_id, tag)
+	);
+	CREATE INDEX tags_tag ON tags(tag);
+	CREATE TRIGGER history_tags_delete AFTER DELETE ON history BEGIN
+		DELETE FROM tags WHERE kind = 'history' AND item_id = old.id;
+	END;
+	CREATE TRIGGER session_tags_delete AFTER DELETE ON sessions BEGIN
+		DELETE FROM tags WHERE kind = 'session' AND item_id = old.id;
+	END;`,
+	`CREATE TABLE pins (
+		id         INTEGER PRIMARY KEY,
+		prompt     TEXT NOT NULL,
+		response   TEXT NOT NULL,
+		model      TEXT NOT NULL DEFAULT '',
+		source     TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		UNIQUE (prompt, response)
+	);`,
+	`ALTER TABLE history ADD COLUMN partial INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN partial INTEGER NOT NULL DEFAULT 0;`,
+}
+
+var (
+	storeOnce sync.Once
+	storeDB   *sql.DB
+	storeErr  error
+)
+
+// storePath returns the location of the database
+func storePath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ask.db"), nil
+}
+
+// openStore opens the database on first use, bringing its schema up to date
+// and importing sessions saved as JSON files by older versions
+func openStore() (*sql.DB, error) {
+	storeOnce.Do(func() {
+		path, err := storePath()
+		if err != nil {
+			storeErr = err
+			return
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			storeErr = err
+			return
+		}
+		if storeEncrypted() {
+			db, err := openEncryptedDB(path + ".enc")
+			if err != nil {
+				storeErr = err
+				return
+			}
+			if err := migrate(db); err != nil {
+				db.Close()
+				storeErr = fmt.Errorf("failed to set up database %s.enc: %w", path, err)
+				return
+			}
+			storeDB = db
+			return
+		}
+		// Create the file private; SQLite gives its journal files the same mode
+		if f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600); err == nil {
+			f.Close()
+		}
+
+		// Several ask processes may share the database
+		dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+		db, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			storeErr = fmt.Errorf("failed to open database: %w", err)
+			return
+		}
+		db.SetMaxOpenConns(1)
+
+		if err := migrate(db); err != nil {
+			db.Close()
+			storeErr = fmt.Errorf("failed to set up database %s: %w", path, err)
+			return
+		}
+		importJSONSessions(db)
+		storeDB = db
+	})
+	return storeDB, storeErr
+}
+
+// migrate applies the migrations the database hasn't seen yet
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importJSONSessions copies sessions from ~/.config/ask/sessions/*.json into
+// the database, once. The files are left in place.
+func importJSONSessions(db *sql.DB) {
+	var done string
+	if db.QueryRow("SELECT value FROM meta WHERE key = 'json_sessions_imported'").Scan(&done) == nil {
+		return
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "sessions", "*.json"))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var saved SavedSession
+		if json.Unmarshal(data, &saved) != nil {
+			continue
+		}
+		if saved.Name == "" {
+			saved.Name = strings.TrimSuffix(filepath.Base(file), ".json")
+		}
+		var exists int
+		if db.QueryRow("SELECT 1 FROM sessions WHERE name = ?", saved.Name).Scan(&exists) == nil {
+			continue
+		}
+		writeSession(db, &saved)
+	}
+	db.Exec("INSERT OR REPLACE INTO meta (key, value) VALUES ('json_sessions_imported', ?)", time.Now().Format(time.RFC3339))
+}
+
+// Times are stored as Unix milliseconds, with 0 for unknown
+func toMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func fromMillis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// writeSession inserts or replaces a session and its messages by name
+func writeSession(db *sql.DB, saved *SavedSession) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`INSERT INTO sessions (name, provider, model, persona, system_prompt, forked_from, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET provider = excluded.provider, model = excluded.model,
+			persona = excluded.persona, system_prompt = excluded.system_prompt,
+			forked_from = excluded.forked_from, created_at = excluded.created_at, updated_at = excluded.updated_at
+		RETURNING id`,
+		saved.Name, saved.Provider, saved.Model, saved.Persona, saved.System, saved.Parent,
+		toMillis(saved.CreatedAt), toMillis(saved.UpdatedAt)).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM messages WHERE session_id = ?", id); err != nil {
+		return err
+	}
+	for i, msg := range saved.Messages {
+		_, err := tx.Exec("INSERT INTO messages (session_id, seq, role, content, model, partial, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			id, i, msg.Role, msg.Content, msg.Model, msg.Partial, toMillis(msg.Time))
+		if err != nil {
+			return err
+		}
+	}
+	if err := writeTags(tx, tagSession, id, saved.Tags); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// readSessions loads sessions matching the where clause, most recently
+// updated first, with their messages
+func readSessions(db *sql.DB, where string, args ...any) ([]*SavedSession, error) {
+	rows, err := db.Query(`SELECT id, name, provider, model, persona, system_prompt, forked_from, created_at, updated_at
+		FROM sessions `+where+` ORDER BY updated_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*SavedSession
+	byID := make(map[int64]*SavedSession)
+	for rows.Next() {
+		var id, created, updated int64
+		saved := &SavedSession{Messages: []SessionMessage{}}
+		if err := rows.Scan(&id, &saved.Name, &saved.Provider, &saved.Model, &saved.Persona,
+			&saved.System, &saved.Parent, &created, &updated); err != nil {
+			return nil, err
+		}
+		saved.CreatedAt, saved.UpdatedAt = fromMillis(created), fromMillis(updated)
+		sessions = append(sessions, saved)
+		byID[id] = saved
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+
+	msgRows, err := db.Query(`SELECT m.session_id, m.role, m.content, m.model, m.partial, m.created_at
+		FROM messages m WHERE m.session_id IN (SELECT id FROM sessions `+where+`) ORDER BY m.session_id, m.seq`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer msgRows.Close()
+	for msgRows.Next() {
+		var id, created int64
+		var msg SessionMessage
+		if err := msgRows.Scan(&id, &msg.Role, &msg.Content, &msg.Model, &msg.Partial, &created); err != nil {
+			return nil, err
+		}
+		msg.Time = fromMillis(created)
+		if saved := byID[id]; saved != nil {
+			saved.Messages = append(saved.Messages, msg)
+		}
+	}
+	if err := msgRows.Err(); err != nil {
+		return nil, err
+	}
+
+	tags, err := readTags(db, tagSession)
+	if err != nil {
+		return nil, err
+	}
+	for id, saved := range byID {
+		saved.Tags = tags[id]
+	}
+	return sessions, nil
+}
+
+// saveUsage records a completed request in the usage ledger, with its
+// estimated cost at current prices. Errors are ignored so a database
+// problem never interrupts a conversation.
+func saveUsage(session string, u turnUsage) {
+	db, err := openStore()
+	if err != nil {
+		return
+	}
+	providerName, modelName, found := strings.Cut(u.model, "/")
+	if !found {
+		providerName, modelName = "", u.model
+	}
+	var cost *float64
+	if c, ok := estimateCost(u.model, u.promptTokens, u.completionTokens); ok {
+		cost = &c
+	}
+	db.Exec(`INSERT INTO usage (session, provider, model, prompt_tokens, completion_tokens, latency_ms, cost, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		session, providerName, modelName, u.promptTokens, u.completionTokens, u.latency.Milliseconds(), cost, toMillis(time.Now()))
+}
@@ -0,0 +1,541 @@
+// Package main provides at-rest encryption of the database and the
+// `ask db` command that turns it on and off.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// An encrypted database file is storeMagic, a salt, a nonce, and the
+// database image sealed with AES-256-GCM
+const storeMagic = "ASKDBEN1"
+
+// dbCommands are the actions of `ask db`
+var dbCommands = map[string]bool{"encrypt": true, "decrypt": true, "status": true}
+
+// isDBCommand reports whether the arguments invoke `ask db`
+func isDBCommand(args []string) bool {
+	return len(args) >= 2 && args[0] == "db" && dbCommands[args[1]]
+}
+
+// encryptedStorePath returns the location of the encrypted database
+func encryptedStorePath() (string, error) {
+	path, err := storePath()
+	if err != nil {
+		return "", err
+	}
+	return path + ".enc", nil
+}
+
+// storeEncrypted reports whether the database is kept encrypted
+func storeEncrypted() bool {
+	path, err := encryptedStorePath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// fileStamp identifies a version of the encrypted file
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// encryptedStore reads and writes the encrypted database file. The
+// database itself lives in memory; every change is sealed and written
+// back, and a lock file keeps ask processes from overwriting each other.
+type encryptedStore struct {
+	path string
+	lock *os.File
+	salt []byte
+	gcm  cipher.AEAD
+}
+
+// openEncryptedStore derives the key for the file at path from the
+// passphrase in ASK_DB_PASSPHRASE, the OS keyring, or the terminal
+func openEncryptedStore(path string) (*encryptedStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(storeMagic)) || len(data) < len(storeMagic)+syncSaltSize+12 {
+		return nil, fmt.Errorf("%s is not an encrypted ask database", path)
+	}
+	salt := data[len(storeMagic) : len(storeMagic)+syncSaltSize]
+
+	passphrase := os.Getenv("ASK_DB_PASSPHRASE")
+	if passphrase == "" {
+		passphrase, _ = keyringGet()
+	}
+	if passphrase == "" {
+		if passphrase, err = readPassphrase("ASK_DB_PASSPHRASE", "Database passphrase", false); err != nil {
+			return nil, err
+		}
+	}
+	gcm, err := syncCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	s := &encryptedStore{path: path, salt: salt, gcm: gcm}
+	if _, err := s.open(data); err != nil {
+		return nil, err
+	}
+	if s.lock, err = os.OpenFile(strings.TrimSuffix(path, ".enc")+".lock", os.O_RDWR|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open decrypts the contents of the file
+func (s *encryptedStore) open(data []byte) ([]byte, error) {
+	data = data[len(storeMagic)+syncSaltSize:]
+	n := s.gcm.NonceSize()
+	if len(data) < n {
+		return nil, fmt.Errorf("%s is damaged", s.path)
+	}
+	plain, err := s.gcm.Open(nil, data[:n], data[n:], []byte(storeMagic))
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt the database: wrong passphrase, or the file is damaged")
+	}
+	return plain, nil
+}
+
+// stamp returns the current version of the file
+func (s *encryptedStore) stamp() fileStamp {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{info.Size(), info.ModTime()}
+}
+
+// read decrypts the file, returning the database image and its version
+func (s *encryptedStore) read() ([]byte, fileStamp, error) {
+	stamp := s.stamp()
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, stamp, err
+	}
+	plain, err := s.open(data)
+	return plain, stamp, err
+}
+
+// write seals a database image with a fresh nonce and replaces the file
+func (s *encryptedStore) write(plain []byte) (fileStamp, error) {
+	if err := writeEncryptedFile(s.path, s.gcm, s.salt, plain); err != nil {
+		return fileStamp{}, err
+	}
+	return s.stamp(), nil
+}
+
+// writeEncryptedFile seals plain into path, replacing it atomically
+func writeEncryptedFile(path string, gcm cipher.AEAD, salt, plain []byte) error {
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	out.WriteString(storeMagic)
+	out.Write(salt)
+	out.Write(nonce)
+	out.Write(gcm.Seal(nil, nonce, plain, []byte(storeMagic)))
+
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(out.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sqliteConn is what encryptedConn needs from a SQLite connection
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ExecerContext
+	driver.QueryerContext
+	Serialize() ([]byte, error)
+	Deserialize([]byte) error
+}
+
+// encryptedConnector opens in-memory connections loaded from the store
+type encryptedConnector struct {
+	store *encryptedStore
+}
+
+func (c *encryptedConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := (&sqlite.Driver{}).Open("file::memory:?_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	ec := &encryptedConn{conn: conn.(sqliteConn), store: c.store}
+	if err := lockFile(c.store.lock, false); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer unlockFile(c.store.lock)
+	if err := ec.load(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ec, nil
+}
+
+func (c *encryptedConnector) Driver() driver.Driver {
+	return &sqlite.Driver{}
+}
+
+// encryptedConn reloads the database when another process has changed the
+// file, and writes it back after every change
+type encryptedConn struct {
+	conn   sqliteConn
+	store  *encryptedStore
+	loaded fileStamp
+	inTx   bool
+}
+
+// load replaces the in-memory database with the file's contents
+func (c *encryptedConn) load() error {
+	plain, stamp, err := c.store.read()
+	if err != nil {
+		return err
+	}
+	// An image of a WAL database can't be opened in memory; mark it as
+	// using a rollback journal
+	if len(plain) > 19 && plain[18] == 2 {
+		plain[18], plain[19] = 1, 1
+	}
+	if err := c.conn.Deserialize(plain); err != nil {
+		return err
+	}
+	c.loaded = stamp
+	return nil
+}
+
+// refresh loads the file again if another process changed it
+func (c *encryptedConn) refresh() error {
+	if c.store.stamp() == c.loaded {
+		return nil
+	}
+	return c.load()
+}
+
+// persist writes the in-memory database back to the file
+func (c *encryptedConn) persist() error {
+	plain, err := c.conn.Serialize()
+	if err != nil {
+		return err
+	}
+	c.loaded, err = c.store.write(plain)
+	return err
+}
+
+func (c *encryptedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.conn.Prepare(query)
+}
+
+func (c *encryptedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *encryptedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx holds the lock until the transaction ends
+func (c *encryptedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := lockFile(c.store.lock, true); err != nil {
+		return nil, err
+	}
+	if err := c.refresh(); err != nil {
+		unlockFile(c.store.lock)
+		return nil, err
+	}
+	tx, err := c.conn.BeginTx(ctx, opts)
+	if err != nil {
+		unlockFile(c.store.lock)
+		return nil, err
+	}
+	c.inTx = true
+	return &encryptedTx{tx: tx, conn: c}, nil
+}
+
+func (c *encryptedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.inTx {
+		return c.conn.ExecContext(ctx, query, args)
+	}
+	if err := lockFile(c.store.lock, true); err != nil {
+		return nil, err
+	}
+	defer unlockFile(c.store.lock)
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	res, err := c.conn.ExecContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return res, c.persist()
+}
+
+func (c *encryptedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !c.inTx {
+		if err := lockFile(c.store.lock, false); err != nil {
+			return nil, err
+		}
+		err := c.refresh()
+		unlockFile(c.store.lock)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.conn.QueryContext(ctx, query, args)
+}
+
+// encryptedTx writes the database back when it commits
+type encryptedTx struct {
+	tx   driver.Tx
+	conn *encryptedConn
+}
+
+func (t *encryptedTx) Commit() error {
+	defer t.end()
+	if err := t.tx.Commit(); err != nil {
+		return err
+	}
+	return t.conn.persist()
+}
+
+func (t *encryptedTx) Rollback() error {
+	defer t.end()
+	return t.tx.Rollback()
+}
+
+func (t *encryptedTx) end() {
+	t.conn.inTx = false
+	unlockFile(t.conn.store.lock)
+}
+
+// openEncryptedDB opens the encrypted database at path
+func openEncryptedDB(path string) (*sql.DB, error) {
+	store, err := openEncryptedStore(path)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(&encryptedConnector{store: store})
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	return db, nil
+}
+
+// Passphrases in the OS keyring are stored under this service and account
+const (
+	keyringService = "ask"
+	keyringAccount = "database"
+)
+
+// keyringGet reads the database passphrase from the macOS keychain or the
+// Secret Service (secret-tool) on Linux
+func keyringGet() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	default:
+		return "", fmt.Errorf("no OS keyring support on %s", runtime.GOOS)
+	}
+	out, err := cmd.Output()
+	return strings.TrimRight(string(out), "\n"), err
+}
+
+// keyringSet stores the database passphrase in the OS keyring
+func keyringSet(passphrase string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		// security prompts for a trailing -w on /dev/tty, not stdin, so the
+		// command goes to security -i on stdin instead, which security(1)
+		// documents as reading commands there; the passphrase never reaches
+		// ps or a terminal. The quoting assumes -i splits lines like a shell
+		// with double quotes and backslashes. Both come from security(1)'s
+		// description of -i and haven't been run on a Mac yet. -i can exit 0
+		// when the command fails, so any output counts as an error, since
+		// add-generic-password prints nothing when it succeeds.
+		if strings.ContainsAny(passphrase, "\r\n") {
+			return fmt.Errorf("the passphrase can't contain line breaks")
+		}
+		line := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+			securityQuote(keyringService), securityQuote(keyringAccount), securityQuote(passphrase))
+		cmd := exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(line)
+		out, err := cmd.CombinedOutput()
+		if msg := strings.TrimSpace(string(out)); err != nil || msg != "" {
+			return fmt.Errorf("security: %v %s", err, msg)
+		}
+		return nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd := exec.Command("secret-tool", "store", "--label=ask database", "service", keyringService, "account", keyringAccount)
+		cmd.Stdin = strings.NewReader(passphrase)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("secret-tool: %v %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	return fmt.Errorf("no OS keyring support on %s", runtime.GOOS)
+}
+
+// securityQuote quotes s for a line read by security -i
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// keyringDelete removes the database passphrase from the OS keyring
+func keyringDelete() {
+	switch runtime.GOOS {
+	case "darwin":
+		exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", keyringAccount).Run()
+	case "linux", "freebsd", "openbsd", "netbsd":
+		exec.Command("secret-tool", "clear", "service", keyringService, "account", keyringAccount).Run()
+	}
+}
+
+// runDBCommand encrypts or decrypts the database, or reports which it is
+func runDBCommand(args []string) error {
+	action, args := args[0], args[1:]
+	useKeyring := false
+	fs := flag.NewFlagSet("db "+action, flag.ContinueOnError)
+	if action == "encrypt" {
+		fs.BoolVar(&useKeyring, "keyring", false, "Keep the passphrase in the OS keyring instead of asking for it")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	plainPath, err := storePath()
+	if err != nil {
+		return err
+	}
+	encPath, err := encryptedStorePath()
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "status":
+		if !storeEncrypted() {
+			fmt.Printf("Not encrypted: %s\n", plainPath)
+			return nil
+		}
+		source := "asked for on each run"
+		if os.Getenv("ASK_DB_PASSPHRASE") != "" {
+			source = "from ASK_DB_PASSPHRASE"
+		} else if p, _ := keyringGet(); p != "" {
+			source = "from the OS keyring"
+		}
+		fmt.Printf("Encrypted: %s\nPassphrase: %s\n", encPath, source)
+		return nil
+
+	case "encrypt":
+		if storeEncrypted() {
+			return fmt.Errorf("the database is already encrypted")
+		}
+		passphrase, err := readPassphrase("ASK_DB_PASSPHRASE", "New database passphrase", true)
+		if err != nil {
+			return err
+		}
+		// Take a consistent copy, then destroy the plain text
+		snapshot := plainPath + ".snapshot"
+		if err := snapshotStore(snapshot); err != nil {
+			return err
+		}
+		defer shredFile(snapshot)
+		plain, err := os.ReadFile(snapshot)
+		if err != nil {
+			return err
+		}
+		salt := make([]byte, syncSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		gcm, err := syncCipher(passphrase, salt)
+		if err != nil {
+			return err
+		}
+		if err := writeEncryptedFile(encPath, gcm, salt, plain); err != nil {
+			return err
+		}
+		if db, err := openStore(); err == nil {
+			db.Close()
+		}
+		// The session input history holds prompts too
+		plainFiles := []string{plainPath, plainPath + "-wal", plainPath + "-shm", plainPath + "-journal",
+			filepath.Join(filepath.Dir(plainPath), "history")}
+		for _, path := range plainFiles {
+			if err := shredFile(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("encrypted, but %s could not be removed: %w", path, err)
+			}
+		}
+		fmt.Printf("Encrypted the database to %s\n", encPath)
+		if useKeyring {
+			if err := keyringSet(passphrase); err != nil {
+				return fmt.Errorf("the passphrase could not be stored in the keyring: %w", err)
+			}
+			fmt.Println("The passphrase is stored in the OS keyring")
+		} else {
+			fmt.Println("ask will ask for the passphrase, or read it from ASK_DB_PASSPHRASE")
+		}
+		return nil
+
+	case "decrypt":
+		if !storeEncrypted() {
+			return fmt.Errorf("the database is not encrypted")
+		}
+		if err := snapshotStore(plainPath); err != nil {
+			return err
+		}
+		if err := os.Chmod(plainPath, 0600); err != nil {
+			return err
+		}
+		if db, err := openStore(); err == nil {
+			db.Close()
+		}
+		if err := os.Remove(encPath); err != nil {
+			return err
+		}
+		os.Remove(filepath.Join(filepath.Dir(encPath), "ask.db.lock"))
+		keyringDelete()
+		fmt.Printf("Decrypted the database to %s\n", plainPath)
+		return nil
+	}
+	return fmt.Errorf("unknown db command '%s' (encrypt, decrypt, status)", action)
+}
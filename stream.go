@@ -0,0 +1,221 @@
+// Package main provides live streaming output for session mode.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+
+	"github.com/chzyer/readline"
+)
+
+// startSpinner animates a spinner with a label, the elapsed time, and, once
+// bytes start arriving in received (if not nil), the tokens so far and the
+// rate, followed by hint. The returned stop function clears the line and is
+// safe to call repeatedly.
+func startSpinner(label, hint string, received *atomic.Int64) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	start := time.Now()
+
+	go func() {
+		defer wg.Done()
+		i := 0
+		for {
+			select {
+			case <-done:
+				fmt.Print(clearLine)
+				return
+			default:
+				frame := spinnerFrames[i%len(spinnerFrames)]
+				elapsed := time.Since(start)
+				status := fmt.Sprintf("%s %.1fs", label, elapsed.Seconds())
+				if received != nil {
+					if tokens := tokensForBytes(int(received.Load())); tokens > 0 {
+						status += fmt.Sprintf(" · ~%s tokens · %.0f tok/s", formatTokenCount(tokens), float64(tokens)/elapsed.Seconds())
+					}
+				}
+				if hint != "" {
+					status += " " + hint
+				}
+				fmt.Printf("\r%s%s %s%s%s\033[K", yellow, frame, dim, status, reset)
+				i++
+				time.Sleep(80 * time.Millisecond)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait() // Deterministically wait for spinner to clear line
+		})
+	}
+}
+
+// liveWriter echoes streamed chunks to the terminal as they arrive while
+// collecting the full response. The first chunk triggers onFirst.
+type liveWriter struct {
+	buf      strings.Builder
+	received atomic.Int64 // bytes so far, readable while streaming
+	onFirst  func()
+	once     sync.Once
+	quiet    bool      // collect without echoing
+	tee      io.Writer // also gets the raw stream, for --tee
+	teeErr   error     // the first failed write to tee
+}
+
+func (w *liveWriter) Write(p []byte) (int, error) {
+	if w.onFirst != nil {
+		w.once.Do(w.onFirst)
+	}
+	w.buf.Write(p)
+	w.received.Add(int64(len(p)))
+	if w.tee != nil && w.teeErr == nil {
+		_, w.teeErr = w.tee.Write(p)
+	}
+	if w.quiet {
+		return len(p), nil
+	}
+	return os.Stdout.Write(p)
+}
+
+// queryOnce sends a single prompt outside the one-shot path, with the
+// budget check, secret screening, and usage ledger entry named usage
+func queryOnce(config *Config, providerFlag, modelFlag, profileFlag, prompt, usage string) (string, error) {
+	providerName, modelName, err := ResolveModelAndProvider(providerFlag, modelFlag, profileFlag, config)
+	if err != nil {
+		return "", err
+	}
+	p, modelName, err := newSessionProvider(providerName, modelName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := checkBudget(config.Budget, budgetOK); err != nil {
+		return "", err
+	}
+	prompt, ok := screenOutgoing(prompt, confirmStdin)
+	if !ok {
+		return "", fmt.Errorf("not sent")
+	}
+
+	spec := providerName + "/" + modelName
+	out := &liveWriter{quiet: true}
+	start := time.Now()
+	if err := p.QueryStream(context.Background(), prompt, out); err != nil {
+		countError(err)
+		return "", fmt.Errorf("error querying %s: %v", spec, err)
+	}
+	answer := out.buf.String()
+	saveUsage(usage, newTurnUsage(spec, []provider.Message{{Role: "user", Content: prompt}}, answer, time.Since(start)))
+	return answer, nil
+}
+
+// streamReply queries the provider, printing the header and raw text as soon
+// as it arrives, then replaces the raw text with rendered markdown. Partial
+// text received before an error or cancellation stays on screen.
+func (s *Session) streamReply(p provider.Provider, spec string, msgs []provider.Message, header string) (string, error) {
+	ctx, done := s.beginRequest()
+	defer done()
+
+	s.applyOptions(p)
+	s.mu.Lock()
+	stream := !s.noStream
+	s.mu.Unlock()
+
+	start := time.Now()
+	var firstToken time.Time
+	w := &liveWriter{quiet: !stream}
+	stop := startSpinner("Thinking...", "(Esc to cancel)", &w.received)
+	w.onFirst = func() {
+		firstToken = time.Now()
+		if stream {
+			stop()
+			fmt.Print(header)
+		}
+	}
+
+	err := p.QueryStreamWithHistory(ctx, msgs, w)
+	stop()
+	elapsed := time.Since(start)
+	response := w.buf.String()
+	if ctx.Err() != nil {
+		err = context.Canceled // providers wrap this inconsistently
+	}
+	if err == nil || response != "" {
+		s.recordUsage(spec, msgs, response, elapsed)
+	}
+
+	if err != nil {
+		if response != "" {
+			if !stream {
+				fmt.Print(header + response)
+			}
+			fmt.Println()
+		}
+		return response, err
+	}
+
+	if response == "" || !stream {
+		fmt.Print(header)
+	}
+	if !stream || eraseStreamedText(response) {
+		renderMarkdownToTerminal(response)
+		printReplyStatus(spec, estimateTokens(response), elapsed, elapsed-firstToken.Sub(start))
+	} else {
+		fmt.Println() // not a terminal; the raw text is the output
+	}
+	s.notifyDone(spec, elapsed)
+	return response, nil
+}
+
+// printReplyStatus shows the model, answer size, elapsed time, and streaming
+// rate below an answer
+func printReplyStatus(spec string, tokens int, elapsed, streaming time.Duration) {
+	status := fmt.Sprintf("%s · ~%s tokens · %s", spec, formatTokenCount(tokens), elapsed.Round(100*time.Millisecond))
+	if streaming > 0 && tokens > 0 {
+		status += fmt.Sprintf(" · %.0f tok/s", float64(tokens)/streaming.Seconds())
+	}
+	fmt.Printf("%s  %s%s\n", dim, status, reset)
+}
+
+// eraseStreamedText clears raw streamed text from the terminal so it can be
+// re-rendered, returning false when output isn't a terminal. Text taller than
+// the screen can't be erased, so the screen is cleared instead and the
+// rendered answer starts at the top.
+func eraseStreamedText(text string) bool {
+	fd := int(os.Stdout.Fd())
+	if !readline.IsTerminal(fd) {
+		return false
+	}
+
+	width, height, err := readline.GetSize(fd)
+	if err != nil || width <= 0 {
+		return false
+	}
+
+	rows := 0
+	for _, line := range strings.Split(text, "\n") {
+		w := readline.Runes{}.WidthAll([]rune(strings.ReplaceAll(line, "\t", "        ")))
+		rows += max(1, (w+width-1)/width)
+	}
+
+	if rows >= height {
+		fmt.Print("\033[2J\033[H")
+		return true
+	}
+	if rows > 1 {
+		fmt.Printf("\033[%dA", rows-1)
+	}
+	fmt.Print("\r\033[J")
+	return true
+}
@@ -0,0 +1,342 @@
+// Package main provides `ask summarize`, which fetches a web page or a
+// GitHub issue or pull request and summarizes it with action items.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+
+	"golang.org/x/net/html"
+)
+
+// isSummarizeCommand reports whether the arguments invoke `ask summarize`.
+// A URL or flag must follow, so "ask summarize the plot of Hamlet" stays an
+// ordinary prompt.
+func isSummarizeCommand(args []string) bool {
+	if len(args) < 2 || args[0] != "summarize" {
+		return false
+	}
+	return strings.HasPrefix(args[1], "-") || strings.HasPrefix(args[1], "http://") || strings.HasPrefix(args[1], "https://")
+}
+
+// maxFetchBytes bounds how much of a page is downloaded
+const maxFetchBytes = 4 << 20
+
+// maxSummarizeText bounds how much extracted text is sent
+const maxSummarizeText = 60_000
+
+// githubIssueURL matches issue and pull request pages
+var githubIssueURL = regexp.MustCompile(`^https://github\.com/([^/]+)/([^/]+)/(issues|pull)/(\d+)`)
+
+// summarizePrompt asks for the structured summary
+const summarizePrompt = `Summarize the %s below in Markdown with these sections:
+
+## Summary
+Two to four sentences on what it is about.
+
+## Key Points
+Bullets with the facts, arguments, or decisions that matter.
+
+## Action Items
+A checklist ("- [ ] ...") of concrete follow-ups, naming who is responsible
+when the text says so, or "None" if there are none.
+%s
+Source: %s
+
+`
+
+// issueSections adds the part only issues and pull requests need
+const issueSections = `
+## Status
+Whether it is open or closed, what has been agreed, and what is unresolved.
+`
+
+// runSummarizeCommand fetches a URL and prints a rendered summary
+func runSummarizeCommand(args []string) error {
+	var providerFlag, modelFlag, profileFlag string
+	fs := flag.NewFlagSet("summarize", flag.ContinueOnError)
+	fs.StringVar(&modelFlag, "m", "", "Model to summarize with")
+	fs.StringVar(&providerFlag, "p", "", "Provider to summarize with")
+	fs.StringVar(&profileFlag, "P", "", "Profile to summarize with")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ask summarize [-m model] <url>")
+	}
+	target := fs.Arg(0)
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("'%s' is not an http or https URL", target)
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	markdownTheme = config.Theme
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%sFetching %s…%s\n", dim, target, reset)
+	kind, text, extra := "web page", "", ""
+	if m := githubIssueURL.FindStringSubmatch(target); m != nil {
+		if text, err = fetchGitHubIssue(m[1], m[2], m[4]); err != nil {
+			return err
+		}
+		kind, extra = "GitHub issue", issueSections
+		if m[3] == "pull" {
+			kind = "GitHub pull request"
+		}
+	} else if text, err = fetchPageText(target); err != nil {
+		return err
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("no text found at %s", target)
+	}
+	if len(text) > maxSummarizeText {
+		text = text[:maxSummarizeText] + "\n[truncated]"
+	}
+
+	prompt := fmt.Sprintf(summarizePrompt, kind, extra, target) + text
+	summary, err := queryOnce(config, providerFlag, modelFlag, profileFlag, prompt, "summarize")
+	if err != nil {
+		return err
+	}
+	if err := renderMarkdown(summary); err != nil {
+		fmt.Println(summary)
+	}
+	return nil
+}
+
+// httpGet fetches a URL with a size limit, returning the body and its
+// media type
+func httpGet(target string, header http.Header) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header = header
+	req.Header.Set("User-Agent", AppName+"/"+Version)
+	resp, err := provider.HTTPClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBytes))
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s: %s", target, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return body, mediaType, nil
+}
+
+// fetchPageText downloads a page and returns its readable text
+func fetchPageText(target string) (string, error) {
+	body, mediaType, err := httpGet(target, http.Header{"Accept": {"text/html, text/plain;q=0.9, */*;q=0.5"}})
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml" || mediaType == "":
+		return htmlText(string(body)), nil
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json":
+		return string(body), nil
+	}
+	return "", fmt.Errorf("can't summarize %s content", mediaType)
+}
+
+// skippedElements hold no readable text
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"nav": true, "header": true, "footer": true, "aside": true, "form": true, "iframe": true,
+}
+
+// blockElements start a new line
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "section": true, "article": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"pre": true, "blockquote": true, "table": true, "ul": true, "ol": true, "dt": true, "dd": true,
+}
+
+// htmlText extracts the title and visible text of a page, leaving out
+// scripts and page furniture such as navigation and footers
+func htmlText(page string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(page))
+	skip := 0
+	inTitle := false
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return collapseBlankLines(b.String())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if skippedElements[tag] && tt == html.StartTagToken {
+				skip++
+			}
+			if tag == "title" {
+				inTitle = true
+				b.WriteString("Title: ")
+			}
+			if blockElements[tag] {
+				b.WriteString("\n")
+			}
+			if tag == "li" {
+				b.WriteString("- ")
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if skippedElements[tag] && skip > 0 {
+				skip--
+			}
+			if tag == "title" {
+				inTitle = false
+				b.WriteString("\n")
+			}
+			if blockElements[tag] {
+				b.WriteString("\n")
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			text := strings.Join(strings.Fields(string(z.Text())), " ")
+			if text == "" {
+				continue
+			}
+			b.WriteString(text)
+			if !inTitle {
+				b.WriteString(" ")
+			}
+		}
+	}
+}
+
+// collapseBlankLines trims each line and keeps at most one blank line in a row
+func collapseBlankLines(text string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank && len(lines) > 0 {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, line)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// githubToken returns GITHUB_TOKEN or GH_TOKEN, or the gh CLI's token
+func githubToken() string {
+	for _, name := range []string{"GITHUB_TOKEN", "GH_TOKEN"} {
+		if token := os.Getenv(name); token != "" {
+			return token
+		}
+	}
+	if _, err := exec.LookPath("gh"); err == nil {
+		if out, err := exec.Command("gh", "auth", "token").Output(); err == nil {
+			return strings.TrimSpace(string(out))
+		}
+	}
+	return ""
+}
+
+// githubComment is an issue comment from the GitHub API
+type githubComment struct {
+	User      struct{ Login string } `json:"user"`
+	Body      string                 `json:"body"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// fetchGitHubIssue returns an issue or pull request as text. Its comments
+// are included when a GitHub token is available; without one the API's low
+// anonymous rate limit is saved for the issue itself.
+func fetchGitHubIssue(owner, repo, number string) (string, error) {
+	header := http.Header{"Accept": {"application/vnd.github+json"}}
+	token := githubToken()
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	api := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues/%s", owner, repo, number)
+	body, _, err := httpGet(api, header.Clone())
+	if err != nil {
+		return "", fmt.Errorf("GitHub API: %v", err)
+	}
+	var issue struct {
+		Title     string                  `json:"title"`
+		State     string                  `json:"state"`
+		User      struct{ Login string }  `json:"user"`
+		Body      string                  `json:"body"`
+		CreatedAt time.Time               `json:"created_at"`
+		Labels    []struct{ Name string } `json:"labels"`
+		Comments  int                     `json:"comments"`
+	}
+	if err := json.Unmarshal(body, &issue); err != nil {
+		return "", fmt.Errorf("GitHub API: %v", err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Title: %s\nState: %s\n", issue.Title, issue.State)
+	if len(issue.Labels) > 0 {
+		names := make([]string, len(issue.Labels))
+		for i, l := range issue.Labels {
+			names[i] = l.Name
+		}
+		fmt.Fprintf(&b, "Labels: %s\n", strings.Join(names, ", "))
+	}
+	fmt.Fprintf(&b, "\n@%s (%s):\n%s\n", issue.User.Login, issue.CreatedAt.Format("2006-01-02"), issue.Body)
+
+	if issue.Comments == 0 {
+		return b.String(), nil
+	}
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "%sSkipping %d comments; set GITHUB_TOKEN or log in with gh to include them%s\n", dim, issue.Comments, reset)
+		return b.String(), nil
+	}
+	for page := 1; page <= 10; page++ {
+		body, _, err := httpGet(fmt.Sprintf("%s/comments?per_page=100&page=%d", api, page), header.Clone())
+		if err != nil {
+			return "", fmt.Errorf("GitHub API: %v", err)
+		}
+		var comments []githubComment
+		if err := json.Unmarshal(body, &comments); err != nil {
+			return "", fmt.Errorf("GitHub API: %v", err)
+		}
+		for _, c := range comments {
+			fmt.Fprintf(&b, "\n@%s (%s):\n%s\n", c.User.Login, c.CreatedAt.Format("2006-01-02"), c.Body)
+		}
+		if len(comments) < 100 {
+			break
+		}
+	}
+	return b.String(), nil
+}
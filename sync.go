@@ -0,0 +1,464 @@
+// Package main provides `ask sync`, which keeps the database in step across
+// machines through an encrypted copy in a git repository or S3 bucket.
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// SyncConfig says where `ask sync` keeps the encrypted database
+type SyncConfig struct {
+	Backend string `yaml:"backend,omitempty"` // git or s3
+	Remote  string `yaml:"remote,omitempty"`  // git repository URL, or s3://bucket/prefix
+}
+
+// syncFileName is the name of the encrypted database in the remote
+const syncFileName = "ask.db.enc"
+
+// Encrypted files start with syncMagic, then the salt and nonce
+const (
+	syncMagic      = "ASKSYNC1"
+	syncSaltSize   = 16
+	syncIterations = 600000
+)
+
+// syncCommands are the actions of `ask sync`
+var syncCommands = map[string]bool{"push": true, "pull": true, "status": true}
+
+// isSyncCommand reports whether the arguments invoke `ask sync`
+func isSyncCommand(args []string) bool {
+	if len(args) == 0 || args[0] != "sync" {
+		return false
+	}
+	return len(args) == 1 || syncCommands[args[1]]
+}
+
+// syncBackend stores and fetches the encrypted database
+type syncBackend interface {
+	// download copies the remote file to path, returning false if nothing
+	// has been pushed yet
+	download(path string) (bool, error)
+	upload(path string) error
+}
+
+// newSyncBackend returns the configured backend
+func newSyncBackend(config SyncConfig) (syncBackend, error) {
+	if config.Remote == "" {
+		return nil, fmt.Errorf("sync is not configured. Set sync.backend and sync.remote in config.yaml")
+	}
+	switch config.Backend {
+	case "git":
+		dir, err := configDir()
+		if err != nil {
+			return nil, err
+		}
+		return &gitBackend{remote: config.Remote, dir: filepath.Join(dir, "sync")}, nil
+	case "s3":
+		if !strings.HasPrefix(config.Remote, "s3://") {
+			return nil, fmt.Errorf("sync.remote must be an s3://bucket/prefix URL for the s3 backend")
+		}
+		return &s3Backend{url: strings.TrimSuffix(config.Remote, "/") + "/" + syncFileName}, nil
+	}
+	return nil, fmt.Errorf("unknown sync backend '%s' (use git or s3)", config.Backend)
+}
+
+// runTool runs an external command, returning its combined output in the
+// error if it fails
+func runTool(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return fmt.Errorf("%s is not installed or not in PATH", name)
+		}
+		return fmt.Errorf("%s %s: %v\n%s", name, args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// gitBackend keeps the file in a clone of a git repository
+type gitBackend struct {
+	remote string
+	dir    string // local clone
+}
+
+// clone makes the local clone if it doesn't exist yet
+func (g *gitBackend) clone() error {
+	if _, err := os.Stat(filepath.Join(g.dir, ".git")); err == nil {
+		return nil
+	}
+	return runTool("git", "clone", "--quiet", g.remote, g.dir)
+}
+
+// branch returns the name of the clone's branch, which may have no
+// commits yet
+func (g *gitBackend) branch() (string, error) {
+	out, err := exec.Command("git", "-C", g.dir, "symbolic-ref", "--short", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("can't find the branch of %s: %v", g.dir, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (g *gitBackend) download(path string) (bool, error) {
+	if err := g.clone(); err != nil {
+		return false, err
+	}
+	branch, err := g.branch()
+	if err != nil {
+		return false, err
+	}
+	if err := runTool("git", "-C", g.dir, "fetch", "--quiet", "origin"); err != nil {
+		return false, err
+	}
+	// The clone only holds the sync file, so it simply follows the remote
+	if exec.Command("git", "-C", g.dir, "rev-parse", "--verify", "--quiet", "origin/"+branch).Run() == nil {
+		if err := runTool("git", "-C", g.dir, "reset", "--quiet", "--hard", "origin/"+branch); err != nil {
+			return false, err
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(g.dir, syncFileName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(path, data, 0600)
+}
+
+func (g *gitBackend) upload(path string) error {
+	if err := g.clone(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(g.dir, syncFileName), data, 0600); err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	if err := runTool("git", "-C", g.dir, "add", syncFileName); err != nil {
+		return err
+	}
+	if err := runTool("git", "-C", g.dir, "commit", "--quiet", "-m", "Sync from "+host); err != nil {
+		return err
+	}
+	branch, err := g.branch()
+	if err != nil {
+		return err
+	}
+	return runTool("git", "-C", g.dir, "push", "--quiet", "origin", "HEAD:"+branch)
+}
+
+// s3Backend keeps the file in an S3 bucket using the AWS CLI, so its
+// usual credentials and profiles apply
+type s3Backend struct {
+	url string
+}
+
+func (s *s3Backend) download(path string) (bool, error) {
+	if exec.Command("aws", "s3", "ls", s.url).Run() != nil {
+		if _, err := exec.LookPath("aws"); err != nil {
+			return false, fmt.Errorf("aws is not installed or not in PATH")
+		}
+		return false, nil
+	}
+	return true, runTool("aws", "s3", "cp", "--only-show-errors", s.url, path)
+}
+
+func (s *s3Backend) upload(path string) error {
+	return runTool("aws", "s3", "cp", "--only-show-errors", path, s.url)
+}
+
+// syncPassphrase reads the passphrase from ASK_SYNC_PASSPHRASE or the
+// terminal. confirm asks for it twice, for data nobody has encrypted yet.
+func syncPassphrase(confirm bool) (string, error) {
+	return readPassphrase("ASK_SYNC_PASSPHRASE", "Sync passphrase", confirm)
+}
+
+// readPassphrase reads a passphrase from the environment variable or the
+// terminal, asking twice if confirm is set
+func readPassphrase(envVar, label string, confirm bool) (string, error) {
+	if p := os.Getenv(envVar); p != "" {
+		return p, nil
+	}
+	if machineMode || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("set %s to use ask without a terminal", envVar)
+	}
+	read := func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		p, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(p), err
+	}
+	p, err := read(label + ": ")
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", fmt.Errorf("the passphrase can't be empty")
+	}
+	if confirm {
+		again, err := read("Repeat passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != p {
+			return "", fmt.Errorf("passphrases don't match")
+		}
+	}
+	return p, nil
+}
+
+// syncCipher derives the AES-256-GCM cipher for a passphrase and salt
+func syncCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, syncIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptFile encrypts src into dst with a fresh salt and nonce
+func encryptFile(src, dst, passphrase string) error {
+	plain, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, syncSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := syncCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(syncMagic)
+	out.Write(salt)
+	out.Write(nonce)
+	out.Write(gcm.Seal(nil, nonce, plain, []byte(syncMagic)))
+	return os.WriteFile(dst, out.Bytes(), 0600)
+}
+
+// decryptFile decrypts src, written by encryptFile, into dst
+func decryptFile(src, dst, passphrase string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte(syncMagic)) || len(data) < len(syncMagic)+syncSaltSize+12 {
+		return fmt.Errorf("the remote file is not an ask sync file")
+	}
+	data = data[len(syncMagic):]
+	gcm, err := syncCipher(passphrase, data[:syncSaltSize])
+	if err != nil {
+		return err
+	}
+	data = data[syncSaltSize:]
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(syncMagic))
+	if err != nil {
+		return fmt.Errorf("can't decrypt the remote copy: wrong passphrase, or the file is damaged")
+	}
+	return os.WriteFile(dst, plain, 0600)
+}
+
+// snapshotStore writes a consistent copy of the database to path
+func snapshotStore(path string) error {
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	os.Remove(path)
+	_, err = db.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// mergeStore adds what the database at path has and this one doesn't:
+// sessions that are new or were updated more recently, history, usage, and
+// favorites. Nothing is deleted.
+func mergeStore(path string) (int, int, error) {
+	remote, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer remote.Close()
+	var version int
+	if err := remote.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, 0, err
+	}
+	if version > len(migrations) {
+		return 0, 0, fmt.Errorf("the remote copy was pushed by a newer version of ask; update ask first")
+	}
+	if err := migrate(remote); err != nil {
+		return 0, 0, err
+	}
+
+	db, err := openStore()
+	if err != nil {
+		return 0, 0, err
+	}
+	sessions, err := readSessions(remote, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	merged := 0
+	for _, saved := range sessions {
+		var updated int64
+		err := db.QueryRow("SELECT updated_at FROM sessions WHERE name = ?", saved.Name).Scan(&updated)
+		if err == nil && updated >= toMillis(saved.UpdatedAt) {
+			continue
+		}
+		if err := writeSession(db, saved); err != nil {
+			return merged, 0, err
+		}
+		merged++
+	}
+
+	if _, err := db.Exec("ATTACH DATABASE ? AS remote", path); err != nil {
+		return merged, 0, err
+	}
+	defer db.Exec("DETACH DATABASE remote")
+	res, err := db.Exec(`INSERT INTO history (provider, model, prompt, response, latency_ms, partial, created_at)
+		SELECT provider, model, prompt, response, latency_ms, partial, created_at FROM remote.history r
+		WHERE NOT EXISTS (SELECT 1 FROM history h WHERE h.created_at = r.created_at AND h.prompt = r.prompt)`)
+	if err != nil {
+		return merged, 0, err
+	}
+	history, _ := res.RowsAffected()
+	_, err = db.Exec(`INSERT OR IGNORE INTO tags (kind, item_id, tag)
+		SELECT 'history', h.id, t.tag FROM remote.tags t
+		JOIN remote.history r ON t.kind = 'history' AND r.id = t.item_id
+		JOIN history h ON h.created_at = r.created_at AND h.prompt = r.prompt`)
+	if err != nil {
+		return merged, int(history), err
+	}
+	_, err = db.Exec(`INSERT INTO usage (session, provider, model, prompt_tokens, completion_tokens, latency_ms, cost, created_at)
+		SELECT session, provider, model, prompt_tokens, completion_tokens, latency_ms, cost, created_at FROM remote.usage r
+		WHERE NOT EXISTS (SELECT 1 FROM usage u WHERE u.created_at = r.created_at AND u.model = r.model
+			AND u.session = r.session AND u.completion_tokens = r.completion_tokens)`)
+	if err != nil {
+		return merged, int(history), err
+	}
+	_, err = db.Exec(`INSERT OR IGNORE INTO favorites (prompt, created_at) SELECT prompt, created_at FROM remote.favorites`)
+	if err != nil {
+		return merged, int(history), err
+	}
+	_, err = db.Exec(`INSERT OR IGNORE INTO pins (prompt, response, model, source, created_at)
+		SELECT prompt, response, model, source, created_at FROM remote.pins`)
+	return merged, int(history), err
+}
+
+// runSyncCommand pulls and merges the remote copy, pushes the local one,
+// or both
+func runSyncCommand(args []string) error {
+	action := "both"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if len(args) > 1 || action != "both" && !syncCommands[action] {
+		return fmt.Errorf("usage: ask sync [push|pull|status]")
+	}
+	config, err := LoadConfigSafe()
+	if err != nil {
+		return err
+	}
+	if action == "status" {
+		if config.Sync.Remote == "" {
+			fmt.Println("Sync is not configured. Set sync.backend and sync.remote in config.yaml.")
+			return nil
+		}
+		fmt.Printf("Backend: %s\nRemote:  %s\n", config.Sync.Backend, config.Sync.Remote)
+		for _, k := range []string{"pulled", "pushed"} {
+			when := metaValue("sync_" + k + "_at")
+			if when == "" {
+				when = "never"
+			}
+			fmt.Printf("Last %s: %s\n", k, when)
+		}
+		return nil
+	}
+	backend, err := newSyncBackend(config.Sync)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "ask-sync-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	encrypted := filepath.Join(tmp, syncFileName)
+	plain := filepath.Join(tmp, "ask.db")
+
+	exists, err := backend.download(encrypted)
+	if err != nil {
+		return err
+	}
+	passphrase, err := syncPassphrase(!exists && action != "pull")
+	if err != nil {
+		return err
+	}
+
+	if action == "pull" || action == "both" {
+		if !exists {
+			fmt.Println("Nothing has been pushed yet.")
+		} else {
+			if err := decryptFile(encrypted, plain, passphrase); err != nil {
+				return err
+			}
+			sessions, history, err := mergeStore(plain)
+			if err != nil {
+				return fmt.Errorf("merging the remote copy failed: %w", err)
+			}
+			setMetaValue("sync_pulled_at", time.Now().Format(time.RFC3339))
+			fmt.Printf("Pulled %d session(s) and %d history entries\n", sessions, history)
+		}
+	}
+
+	if action == "push" || action == "both" {
+		// Check the passphrase matches what's there before replacing it
+		if exists && action == "push" {
+			if err := decryptFile(encrypted, plain, passphrase); err != nil {
+				return err
+			}
+		}
+		if err := snapshotStore(plain); err != nil {
+			return err
+		}
+		if err := encryptFile(plain, encrypted, passphrase); err != nil {
+			return err
+		}
+		if err := backend.upload(encrypted); err != nil {
+			return err
+		}
+		setMetaValue("sync_pushed_at", time.Now().Format(time.RFC3339))
+		fmt.Println("Pushed the encrypted database to " + config.Sync.Remote)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// Package main provides tags on saved sessions and one-shot history.
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of tagged items
+const (
+	tagSession = "session"
+	tagHistory = "history"
+)
+
+// tagPattern is what a tag may look like after lowercasing
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// normalizeTag lowercases a tag and checks it is a single short word
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+	if !tagPattern.MatchString(tag) {
+		return "", fmt.Errorf("invalid tag '%s' (use up to 32 letters, digits, '.', '_' or '-')", tag)
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizes tags, dropping duplicates
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	var out []string
+	for _, t := range tags {
+		tag, err := normalizeTag(t)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	return out, nil
+}
+
+// sqlExecer is implemented by *sql.DB and *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// writeTags replaces the tags of an item
+func writeTags(db sqlExecer, kind string, id int64, tags []string) error {
+	if _, err := db.Exec("DELETE FROM tags WHERE kind = ? AND item_id = ?", kind, id); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := db.Exec("INSERT OR IGNORE INTO tags (kind, item_id, tag) VALUES (?, ?, ?)", kind, id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readTags returns the tags of every item of a kind, sorted, by item id
+func readTags(db *sql.DB, kind string) (map[int64][]string, error) {
+	rows, err := db.Query("SELECT item_id, tag FROM tags WHERE kind = ? ORDER BY tag", kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make(map[int64][]string)
+	for rows.Next() {
+		var id int64
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	return tags, rows.Err()
+}
+
+// tagHistoryEntry adds tags to, or with remove takes them off, a history entry
+func tagHistoryEntry(id int64, tags []string, remove bool) ([]string, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	var exists int
+	if err := db.QueryRow("SELECT count(*) FROM history WHERE id = ?", id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("history entry %d not found", id)
+	}
+	all, err := readTags(db, tagHistory)
+	if err != nil {
+		return nil, err
+	}
+	updated := updateTags(all[id], tags, remove)
+	return updated, writeTags(db, tagHistory, id, updated)
+}
+
+// updateTags returns current with tags added, or removed, sorted
+func updateTags(current, tags []string, remove bool) []string {
+	set := make(map[string]bool, len(current)+len(tags))
+	for _, t := range current {
+		set[t] = true
+	}
+	for _, t := range tags {
+		set[t] = !remove
+	}
+	var out []string
+	for t, on := range set {
+		if on {
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// tagLabel formats tags for listings, e.g. " #go #work"
+func tagLabel(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return " " + dim + "#" + strings.Join(tags, " #") + reset
+}
+
+// whereTagged is where, further limited to items of kind carrying the
+// filter's tag, if one was given
+func (f historyFilter) whereTagged(kind, timeColumn string) (string, []any) {
+	where, args := f.where(timeColumn)
+	if f.tag != "" {
+		where += " AND id IN (SELECT item_id FROM tags WHERE kind = ? AND tag = ?)"
+		args = append(args, kind, strings.ToLower(f.tag))
+	}
+	return where, args
+}
+
+// tagCommand shows, adds, or removes (/tag -name) tags on the session.
+// Tags of a saved session are stored right away; otherwise on /save.
+func (s *Session) tagCommand(args []string) {
+	if len(args) == 0 {
+		if len(s.tags) == 0 {
+			fmt.Printf("\n%sNo tags. Usage: /tag <tag>... (/tag -<tag> removes one)%s\n", dim, reset)
+			return
+		}
+		fmt.Printf("\n%sTags:%s\n", dim, tagLabel(s.tags))
+		return
+	}
+
+	var add, remove []string
+	for _, a := range args {
+		tag, err := normalizeTag(strings.TrimPrefix(a, "-"))
+		if err != nil {
+			fmt.Printf("\n%s✗ %v%s\n", red, err, reset)
+			return
+		}
+		if strings.HasPrefix(a, "-") {
+			remove = append(remove, tag)
+		} else {
+			add = append(add, tag)
+		}
+	}
+	s.tags = updateTags(updateTags(s.tags, add, false), remove, true)
+
+	if s.name != "" {
+		db, err := openStore()
+		if err == nil {
+			var id int64
+			if err = db.QueryRow("SELECT id FROM sessions WHERE name = ?", s.name).Scan(&id); err == nil {
+				err = writeTags(db, tagSession, id, s.tags)
+			}
+		}
+		if err != nil && err != sql.ErrNoRows {
+			fmt.Printf("\n%s✗ Error saving tags: %v%s\n", red, err, reset)
+			return
+		}
+	}
+	if len(s.tags) == 0 {
+		fmt.Printf("\n%s✓ Tags removed%s\n", green, reset)
+		return
+	}
+	fmt.Printf("\n%s✓ Tagged%s%s\n", green, reset, tagLabel(s.tags))
+}
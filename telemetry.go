@@ -0,0 +1,281 @@
+// Package main provides opt-in anonymous usage telemetry and the
+// `ask telemetry` command.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+)
+
+// telemetryInterval is how often complete days of counts are uploaded
+const telemetryInterval = 24 * time.Hour
+
+var (
+	telemetryOnce    sync.Once
+	telemetryEnabled bool
+)
+
+// isTelemetryCommand reports whether the arguments invoke `ask telemetry`
+func isTelemetryCommand(args []string) bool {
+	if len(args) == 0 || args[0] != "telemetry" {
+		return false
+	}
+	return len(args) == 1 || args[1] == "status" || args[1] == "enable" || args[1] == "disable"
+}
+
+// metaValue reads a value from the meta table, or "" if it is not set
+func metaValue(key string) string {
+	db, err := openStore()
+	if err != nil {
+		return ""
+	}
+	var value string
+	db.QueryRow("SELECT value FROM meta WHERE key = ?", key).Scan(&value)
+	return value
+}
+
+// setMetaValue stores a value in the meta table
+func setMetaValue(key, value string) error {
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)", key, value)
+	return err
+}
+
+// telemetryOn reports whether the user has opted in to telemetry
+func telemetryOn() bool {
+	telemetryOnce.Do(func() {
+		telemetryEnabled = metaValue("telemetry_enabled") == "1"
+	})
+	return telemetryEnabled
+}
+
+// countEvent adds one to today's count for a feature or error class. It
+// does nothing unless telemetry is enabled. Event names never contain
+// prompts, answers, paths, or other user data.
+func countEvent(event string) {
+	if !telemetryOn() {
+		return
+	}
+	db, err := openStore()
+	if err != nil {
+		return
+	}
+	db.Exec(`INSERT INTO telemetry (day, event, count) VALUES (?, ?, 1)
+		ON CONFLICT (day, event) DO UPDATE SET count = count + 1`, time.Now().UTC().Format("2006-01-02"), event)
+}
+
+// countCommand counts a session command under its canonical name, so
+// unknown input typed after a slash is never recorded
+func countCommand(cmd string) {
+	for _, c := range sessionCommandTable {
+		if cmd == c.name || strings.Contains(", "+c.aliases+",", ", "+cmd+",") {
+			countEvent("command:" + c.name)
+			return
+		}
+	}
+}
+
+// errorClass sorts an error into a coarse category without its message
+func errorClass(err error) string {
+	var netErr net.Error
+	var apiErr *provider.APIError
+	msg := strings.ToLower(err.Error())
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, provider.ErrInvalidAPIKey):
+		return "auth"
+	case errors.Is(err, provider.ErrInsufficientCredits):
+		return "billing"
+	case errors.Is(err, provider.ErrRateLimited):
+		return "rate_limit"
+	case strings.Contains(msg, "404") || strings.Contains(msg, "not found") || strings.Contains(msg, "does not exist"):
+		return "model_not_found"
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 500:
+		return "server"
+	case strings.Contains(msg, "failed to send request") || errors.As(err, &netErr):
+		return "network"
+	}
+	return "other"
+}
+
+// countError counts a failed request by its error class
+func countError(err error) {
+	if err != nil {
+		countEvent("error:" + errorClass(err))
+	}
+}
+
+// telemetryCount is one day's count of one event
+type telemetryCount struct {
+	Day   string `json:"day"`
+	Event string `json:"event"`
+	Count int    `json:"count"`
+}
+
+// pendingTelemetry returns the counts not uploaded yet, oldest first
+func pendingTelemetry() ([]telemetryCount, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT day, event, count FROM telemetry ORDER BY day, event")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []telemetryCount
+	for rows.Next() {
+		var c telemetryCount
+		if err := rows.Scan(&c.Day, &c.Event, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// uploadTelemetry sends the counts for complete days to TelemetryEndpoint,
+// at most once a day, and deletes them once accepted. Failures are silent;
+// the counts are kept for the next attempt.
+func uploadTelemetry() {
+	if !telemetryOn() || TelemetryEndpoint == "" {
+		return
+	}
+	if sent, err := time.Parse(time.RFC3339, metaValue("telemetry_sent_at")); err == nil && time.Since(sent) < telemetryInterval {
+		return
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+	counts, err := pendingTelemetry()
+	if err != nil {
+		return
+	}
+	var complete []telemetryCount
+	for _, c := range counts {
+		if c.Day < today {
+			complete = append(complete, c)
+		}
+	}
+	if len(complete) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"id":      metaValue("telemetry_id"),
+		"version": Version,
+		"os":      runtime.GOOS,
+		"arch":    runtime.GOARCH,
+		"counts":  complete,
+	})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", TelemetryEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := provider.HTTPClient.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return
+	}
+
+	if db, err := openStore(); err == nil {
+		db.Exec("DELETE FROM telemetry WHERE day < ?", today)
+	}
+	setMetaValue("telemetry_sent_at", time.Now().Format(time.RFC3339))
+}
+
+// runTelemetryCommand shows or changes the telemetry setting
+func runTelemetryCommand(args []string) error {
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("usage: ask telemetry [status|enable|disable]")
+	}
+
+	switch action {
+	case "enable":
+		if metaValue("telemetry_id") == "" {
+			id := make([]byte, 8)
+			rand.Read(id)
+			if err := setMetaValue("telemetry_id", hex.EncodeToString(id)); err != nil {
+				return err
+			}
+		}
+		if err := setMetaValue("telemetry_enabled", "1"); err != nil {
+			return err
+		}
+		fmt.Println("Telemetry enabled. Thank you!")
+		fmt.Println("Only counts of features used and of error classes are collected, never prompts or answers.")
+		fmt.Println("See them any time with: ask telemetry status")
+		return nil
+
+	case "disable":
+		if err := setMetaValue("telemetry_enabled", "0"); err != nil {
+			return err
+		}
+		db, err := openStore()
+		if err != nil {
+			return err
+		}
+		db.Exec("DELETE FROM telemetry")
+		db.Exec("DELETE FROM meta WHERE key IN ('telemetry_id', 'telemetry_sent_at')")
+		fmt.Println("Telemetry disabled. Counts not yet sent were deleted.")
+		return nil
+
+	case "status":
+		if !telemetryOn() {
+			fmt.Println("Telemetry is disabled (the default). Nothing is collected.")
+			fmt.Println("Help prioritize work with: ask telemetry enable")
+			return nil
+		}
+		fmt.Printf("Telemetry is enabled (anonymous id %s). Turn it off with: ask telemetry disable\n", metaValue("telemetry_id"))
+		if TelemetryEndpoint == "" {
+			fmt.Println("This build has no telemetry endpoint, so counts stay on this machine.")
+		} else if sent := metaValue("telemetry_sent_at"); sent != "" {
+			fmt.Printf("Last sent %s to %s\n", sent, TelemetryEndpoint)
+		}
+
+		counts, err := pendingTelemetry()
+		if err != nil {
+			return err
+		}
+		if len(counts) == 0 {
+			fmt.Println("\nNo counts waiting to be sent.")
+			return nil
+		}
+		fmt.Printf("\n%sWaiting to be sent:%s\n", dim, reset)
+		for _, c := range counts {
+			fmt.Printf("  %s  %-32s %5d\n", c.Day, c.Event, c.Count)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown telemetry command '%s' (status, enable, disable)", action)
+}
@@ -0,0 +1,79 @@
+// Package main provides rough token accounting for prompts and history.
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// estimateTokens approximates the token count of text. Providers use
+// different tokenizers, so this uses the common ~4 characters per token
+// heuristic rather than an exact count.
+func estimateTokens(text string) int {
+	return tokensForBytes(len(text))
+}
+
+// tokensForBytes estimates the tokens in n bytes of text
+func tokensForBytes(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	return (n + 3) / 4
+}
+
+// Context window sizes by model name prefix, most specific first
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gemini-1.5-pro", 2097152},
+	{"gemini", 1048576},
+	{"claude", 200000},
+	{"gpt-4.1", 1047576},
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4", 8192},
+	{"gpt-3.5", 16385},
+	{"o1-mini", 128000},
+	{"o1-preview", 128000},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"deepseek", 65536},
+	{"mistral-large", 131072},
+	{"codestral", 262144},
+	{"ministral", 131072},
+	{"pixtral", 131072},
+	{"mistral", 32768},
+	{"qwen-turbo", 1000000},
+	{"qwen-plus", 131072},
+	{"qwen2.5", 131072},
+	{"qwen", 32768},
+}
+
+// defaultContextWindow is assumed for models not in the table
+const defaultContextWindow = 32768
+
+// contextWindow returns the context size in tokens for a model
+func contextWindow(model string) int {
+	model = strings.ToLower(strings.TrimPrefix(model, "models/"))
+	for _, cw := range contextWindows {
+		if strings.HasPrefix(model, cw.prefix) {
+			return cw.tokens
+		}
+	}
+	return defaultContextWindow
+}
+
+// formatTokenCount shortens a token count for display (e.g. 1.2k, 128k, 1M)
+func formatTokenCount(n int) string {
+	switch {
+	case n >= 1000000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/1000000), ".0") + "M"
+	case n >= 10000:
+		return fmt.Sprintf("%dk", n/1000)
+	case n >= 1000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/1000), ".0") + "k"
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
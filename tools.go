@@ -0,0 +1,516 @@
+// Package main provides the tools a model can use in `ask agent`: listing,
+// reading, and searching files under the working directory, editing or
+// writing them, running commands, and running snippets in the sandbox.
+// Tools that change or run anything ask the user first.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Limits on what a tool returns to the model
+const (
+	maxToolOutput   = 20_000
+	maxReadLines    = 400
+	maxListedFiles  = 500
+	maxSearchHits   = 100
+	toolCommandTime = 2 * time.Minute
+)
+
+// toolArgs are the arguments of any tool; each uses a few of them
+type toolArgs struct {
+	Path     string `json:"path,omitempty"`
+	Start    int    `json:"start,omitempty"`
+	End      int    `json:"end,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+	Command  string `json:"command,omitempty"`
+	Language string `json:"language,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// agentTool is a tool the model can call
+type agentTool struct {
+	name    string
+	args    string // example arguments, shown to the model
+	desc    string
+	confirm bool // asks the user before running
+	run     func(t *toolRunner, args toolArgs) (string, error)
+	// preview describes the call for the user before confirming
+	preview func(t *toolRunner, args toolArgs) (string, error)
+	// offered reports whether the model is told about the tool; nil means always
+	offered func(t *toolRunner) bool
+}
+
+// agentTools are the tools offered to the model, in the order described
+var agentTools = []agentTool{
+	{
+		name: "list_files",
+		args: `{"path": "."}`,
+		desc: "List the files under a directory, recursively, skipping ignored and hidden ones",
+		run:  (*toolRunner).listFiles,
+	},
+	{
+		name: "read_file",
+		args: `{"path": "main.go", "start": 1, "end": 200}`,
+		desc: fmt.Sprintf("Read a file with line numbers; start and end are optional, at most %d lines at a time", maxReadLines),
+		run:  (*toolRunner).readFile,
+	},
+	{
+		name: "search",
+		args: `{"pattern": "func Load", "path": "."}`,
+		desc: "Find lines matching a regular expression in the files under path, as file:line: text",
+		run:  (*toolRunner).search,
+	},
+	{
+		name:    "edit_file",
+		args:    `{"path": "config.go", "old": "exact text to replace", "new": "replacement"}`,
+		desc:    "Replace text that occurs exactly once in a file; with an empty old, create a new file containing new",
+		confirm: true,
+		run:     (*toolRunner).editFile,
+		preview: (*toolRunner).previewEdit,
+	},
+	{
+		name:    "write_file",
+		args:    `{"path": "docs/notes.md", "content": "the whole new file"}`,
+		desc:    "Create a file, or replace all of an existing one; prefer edit_file for small changes",
+		confirm: true,
+		run:     (*toolRunner).writeFile,
+		preview: (*toolRunner).previewWrite,
+	},
+	{
+		name:    "run_command",
+		args:    `{"command": "go test ./..."}`,
+		desc:    "Run a shell command in the working directory, such as a build or the tests, and return its output and exit status",
+		confirm: true,
+		run:     (*toolRunner).runCommand,
+		preview: func(t *toolRunner, args toolArgs) (string, error) {
+			if strings.TrimSpace(args.Command) == "" {
+				return "", fmt.Errorf("command is required")
+			}
+			return "$ " + args.Command, nil
+		},
+	},
+	{
+		name:    "run_code",
+		args:    `{"language": "python", "code": "print(2**64)"}`,
+		desc:    "Run a python or go snippet in an empty scratch directory with time and memory limits, and return its output; use it to compute things exactly",
+		confirm: true,
+		run: func(t *toolRunner, args toolArgs) (string, error) {
+			result, err := runSandboxed(t.ctx, t.sandbox, args.Language, args.Code)
+			if err != nil {
+				return "", err
+			}
+			return result.String(), nil
+		},
+		preview: func(t *toolRunner, args toolArgs) (string, error) {
+			if _, ok := sandboxLanguageName(args.Language); !ok {
+				return "", fmt.Errorf("can't run %q code; the sandbox runs python and go", args.Language)
+			}
+			return fmt.Sprintf("%s%s snippet:%s\n%s", dim, args.Language, reset, args.Code), nil
+		},
+		offered: func(t *toolRunner) bool { return t.sandbox.Enabled },
+	},
+}
+
+// findTool looks a tool up by name
+func findTool(name string) (*agentTool, bool) {
+	for i := range agentTools {
+		if agentTools[i].name == name {
+			return &agentTools[i], true
+		}
+	}
+	return nil, false
+}
+
+// describeTools lists the tools offered for the model's instructions
+func (t *toolRunner) describeTools() string {
+	var b strings.Builder
+	for _, tool := range agentTools {
+		if tool.offered == nil || tool.offered(t) {
+			fmt.Fprintf(&b, "- %s %s: %s\n", tool.name, tool.args, tool.desc)
+		}
+	}
+	return b.String()
+}
+
+// workingDir returns the working directory with symlinks resolved, the root
+// tools and file proposals are confined to
+func workingDir() (string, error) {
+	root, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(root)
+}
+
+// toolRunner runs tools inside root
+type toolRunner struct {
+	root    string
+	ctx     context.Context
+	sandbox SandboxConfig // for run_code
+}
+
+// resolve maps a path from the model to one inside root, refusing anything
+// outside it, through .. or symlinks
+func (t *toolRunner) resolve(path string) (string, error) {
+	if path == "" {
+		path = "."
+	}
+	if filepath.IsAbs(path) {
+		return "", fmt.Errorf("use a path relative to the working directory, not %s", path)
+	}
+	full := filepath.Join(t.root, path)
+	check, err := resolveExisting(full)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(t.root, check)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the working directory", path)
+	}
+	return full, nil
+}
+
+// resolveExisting resolves the symlinks in the deepest ancestor of path that
+// exists and joins the missing rest back on, so a path through a symlinked
+// directory is checked where it really leads even before it's created
+func resolveExisting(path string) (string, error) {
+	var rest []string
+	for dir := path; ; {
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(append([]string{real}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if _, lerr := os.Lstat(dir); lerr == nil {
+			// a dangling symlink: writing through it would create its target
+			return "", fmt.Errorf("%s is a symlink to a missing file", dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(dir)}, rest...)
+		dir = parent
+	}
+}
+
+// relative shows a path as the model named it, relative to root
+func (t *toolRunner) relative(full string) string {
+	if rel, err := filepath.Rel(t.root, full); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return full
+}
+
+// skipDir reports whether listing and search leave a directory out
+func skipDir(name string) bool {
+	return name != "." && (strings.HasPrefix(name, ".") || name == "node_modules" || name == "vendor" || name == "__pycache__")
+}
+
+// walkFiles returns the files under dir: git's tracked and untracked but
+// not ignored files in a repository, or a plain walk elsewhere
+func (t *toolRunner) walkFiles(dir string) ([]string, error) {
+	cmd := exec.CommandContext(t.ctx, "git", "ls-files", "--cached", "--others", "--exclude-standard", "--", ".")
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err == nil {
+		var files []string
+		for _, f := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if f != "" {
+				files = append(files, filepath.Join(dir, f))
+			}
+		}
+		return files, nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && skipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(d.Name(), ".") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (t *toolRunner) listFiles(args toolArgs) (string, error) {
+	dir, err := t.resolve(args.Path)
+	if err != nil {
+		return "", err
+	}
+	files, err := t.walkFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "No files", nil
+	}
+	sort.Strings(files)
+	var b strings.Builder
+	for i, f := range files {
+		if i == maxListedFiles {
+			fmt.Fprintf(&b, "[%d more; list a subdirectory]\n", len(files)-i)
+			break
+		}
+		b.WriteString(t.relative(f) + "\n")
+	}
+	return b.String(), nil
+}
+
+func (t *toolRunner) readFile(args toolArgs) (string, error) {
+	path, err := t.resolve(args.Path)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1 {
+		return "", fmt.Errorf("%s is a binary file", args.Path)
+	}
+	lines := strings.Split(string(data), "\n")
+	start, end := max(args.Start, 1), args.End
+	if end <= 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return "", fmt.Errorf("%s has %d lines", args.Path, len(lines))
+	}
+	truncated := false
+	if end-start+1 > maxReadLines {
+		end, truncated = start+maxReadLines-1, true
+	}
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		fmt.Fprintf(&b, "%5d  %s\n", i, lines[i-1])
+	}
+	if truncated {
+		fmt.Fprintf(&b, "[%d lines in all; read more with start %d]\n", len(lines), end+1)
+	}
+	return b.String(), nil
+}
+
+func (t *toolRunner) search(args toolArgs) (string, error) {
+	re, err := regexp.Compile(args.Pattern)
+	if err != nil || args.Pattern == "" {
+		return "", fmt.Errorf("pattern must be a regular expression: %v", err)
+	}
+	dir, err := t.resolve(args.Path)
+	if err != nil {
+		return "", err
+	}
+	files, err := t.walkFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	var b strings.Builder
+	hits := 0
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1 {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for n := 1; scanner.Scan(); n++ {
+			if re.Match(scanner.Bytes()) {
+				if hits == maxSearchHits {
+					b.WriteString("[more matches; narrow the pattern or path]\n")
+					return b.String(), nil
+				}
+				fmt.Fprintf(&b, "%s:%d: %s\n", t.relative(f), n, strings.TrimSpace(scanner.Text()))
+				hits++
+			}
+		}
+	}
+	if hits == 0 {
+		return "No matches", nil
+	}
+	return b.String(), nil
+}
+
+// editedContent returns a file's content before and after an edit
+func (t *toolRunner) editedContent(args toolArgs) (path, before, after string, err error) {
+	if path, err = t.resolve(args.Path); err != nil {
+		return "", "", "", err
+	}
+	data, err := os.ReadFile(path)
+	if args.Old == "" {
+		if err == nil {
+			return "", "", "", fmt.Errorf("%s already exists; give the text to replace in old", args.Path)
+		}
+		return path, "", args.New, nil
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	before = string(data)
+	switch n := strings.Count(before, args.Old); n {
+	case 0:
+		return "", "", "", fmt.Errorf("old text not found in %s; read the file again and copy it exactly", args.Path)
+	case 1:
+	default:
+		return "", "", "", fmt.Errorf("old text occurs %d times in %s; include more surrounding lines", n, args.Path)
+	}
+	return path, before, strings.Replace(before, args.Old, args.New, 1), nil
+}
+
+func (t *toolRunner) previewEdit(args toolArgs) (string, error) {
+	_, before, after, err := t.editedContent(args)
+	if err != nil {
+		return "", err
+	}
+	return formatLineDiff(lineDiff(before, after)), nil
+}
+
+func (t *toolRunner) editFile(args toolArgs) (string, error) {
+	path, before, after, err := t.editedContent(args)
+	if err != nil {
+		return "", err
+	}
+	if err := writeKeepingMode(path, after); err != nil {
+		return "", err
+	}
+	if before == "" {
+		return fmt.Sprintf("Created %s", args.Path), nil
+	}
+	return fmt.Sprintf("Edited %s", args.Path), nil
+}
+
+// writtenContent returns a file's content before and after write_file
+func (t *toolRunner) writtenContent(args toolArgs) (path, before, after string, err error) {
+	if path, err = t.resolve(args.Path); err != nil {
+		return "", "", "", err
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return "", "", "", fmt.Errorf("%s is a directory", args.Path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", "", err
+	}
+	return path, string(data), args.Content, nil
+}
+
+func (t *toolRunner) previewWrite(args toolArgs) (string, error) {
+	_, before, after, err := t.writtenContent(args)
+	if err != nil {
+		return "", err
+	}
+	if before == after {
+		return "", fmt.Errorf("%s already has this content", args.Path)
+	}
+	return formatLineDiff(lineDiff(before, after)), nil
+}
+
+func (t *toolRunner) writeFile(args toolArgs) (string, error) {
+	path, before, after, err := t.writtenContent(args)
+	if err != nil {
+		return "", err
+	}
+	if err := writeKeepingMode(path, after); err != nil {
+		return "", err
+	}
+	if before == "" {
+		return fmt.Sprintf("Created %s", args.Path), nil
+	}
+	return fmt.Sprintf("Wrote %s", args.Path), nil
+}
+
+// writeKeepingMode writes a file, keeping the permissions of one it
+// replaces and creating missing parent directories for a new one
+func writeKeepingMode(path, content string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	} else if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), mode)
+}
+
+func (t *toolRunner) runCommand(args toolArgs) (string, error) {
+	ctx, cancel := context.WithTimeout(t.ctx, toolCommandTime)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", args.Command)
+	cmd.Dir = t.root
+	out, err := cmd.CombinedOutput()
+	status := "exit status 0"
+	if ctx.Err() == context.DeadlineExceeded {
+		status = fmt.Sprintf("killed after %s", toolCommandTime)
+	} else if err != nil {
+		status = err.Error()
+	}
+	return fmt.Sprintf("%s\n[%s]", clipToolOutput(string(out)), status), nil
+}
+
+// clipToolOutput keeps the start and end of long output
+func clipToolOutput(s string) string {
+	if len(s) <= maxToolOutput {
+		return s
+	}
+	half := maxToolOutput / 2
+	return s[:half] + fmt.Sprintf("\n[%d bytes omitted]\n", len(s)-maxToolOutput) + s[len(s)-half:]
+}
+
+// agentAction is one reply from the model in the agent loop
+type agentAction struct {
+	Thought string          `json:"thought"`
+	Tool    string          `json:"tool"`
+	Args    json.RawMessage `json:"args"`
+	Done    string          `json:"done"`
+}
+
+// parseAgentAction finds the JSON action in a reply, tolerating a code
+// fence or text around it
+func parseAgentAction(reply string) (*agentAction, toolArgs, error) {
+	var args toolArgs
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start == -1 || end < start {
+		return nil, args, fmt.Errorf("no JSON object found")
+	}
+	var a agentAction
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &a); err != nil {
+		return nil, args, err
+	}
+	if a.Done != "" {
+		return &a, args, nil
+	}
+	if _, ok := findTool(a.Tool); !ok {
+		return nil, args, fmt.Errorf("unknown tool '%s'", a.Tool)
+	}
+	if len(a.Args) > 0 {
+		if err := json.Unmarshal(a.Args, &args); err != nil {
+			return nil, args, fmt.Errorf("invalid args: %v", err)
+		}
+	}
+	return &a, args, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveStaysInRoot(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "missing"), filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	runner := &toolRunner{root: root}
+
+	for _, path := range []string{"main.go", "sub/new/file.go", "sub/../main.go", "."} {
+		if _, err := runner.resolve(path); err != nil {
+			t.Errorf("resolve(%q) = %v, want it allowed", path, err)
+		}
+	}
+	for _, path := range []string{
+		"../escape",
+		"sub/../../escape",
+		"link",
+		"link/file",
+		"link/new/file",
+		"dangling",
+		filepath.Join(root, "main.go"),
+	} {
+		if full, err := runner.resolve(path); err == nil {
+			t.Errorf("resolve(%q) = %s, want it refused", path, full)
+		}
+	}
+}
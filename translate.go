@@ -0,0 +1,227 @@
+// Package main provides `ask translate`, which translates text or a
+// Markdown document, leaving code blocks and formatting alone, in chunks
+// small enough for any model.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// isTranslateCommand reports whether the arguments invoke `ask translate`.
+// A flag must follow, so "ask translate hello into French" stays an
+// ordinary prompt.
+func isTranslateCommand(args []string) bool {
+	return len(args) >= 2 && args[0] == "translate" && strings.HasPrefix(args[1], "-")
+}
+
+// maxTranslateChunk is the most estimated tokens of prose sent at once;
+// answers run about as long, so this stays under small output limits
+const maxTranslateChunk = 1500
+
+// translatePrompt asks for a translation that keeps the Markdown intact
+const translatePrompt = `Translate the Markdown below into %s%s. Keep the formatting exactly as it
+is: headings, lists, tables, emphasis, HTML tags, and line breaks. Translate
+link text but not URLs, and leave inline code, commands, file names, and
+placeholders such as {name} or %%s as they are. If it is already in %[1]s,
+return it unchanged. Reply with the translation only, without notes.
+
+%[3]s`
+
+// translateSegment is a run of a document: prose to translate, or text to
+// copy as is
+type translateSegment struct {
+	text string
+	keep bool // copied as is: a code block, or only blank lines
+}
+
+// runTranslateCommand translates a file, the arguments, or stdin
+func runTranslateCommand(args []string) error {
+	var providerFlag, modelFlag, profileFlag, to, from, file, output string
+	fs := flag.NewFlagSet("translate", flag.ContinueOnError)
+	fs.StringVar(&to, "to", "", "Language to translate into, as a name or code (de, Japanese)")
+	fs.StringVar(&from, "from", "", "Language of the text (default: detected)")
+	fs.StringVar(&file, "f", "", "File to translate")
+	fs.StringVar(&output, "o", "", "Write the translation to this file instead of stdout")
+	fs.StringVar(&modelFlag, "m", "", "Model to translate with")
+	fs.StringVar(&providerFlag, "p", "", "Provider to translate with")
+	fs.StringVar(&profileFlag, "P", "", "Profile to translate with")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if to == "" {
+		return fmt.Errorf("usage: ask translate --to <language> [--from <language>] [-f file | text] [-o file]")
+	}
+
+	var text string
+	switch {
+	case file != "" && fs.NArg() > 0:
+		return fmt.Errorf("give either -f or text to translate, not both")
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	case fs.NArg() > 0:
+		text = strings.Join(fs.Args(), " ")
+	case !readline.IsTerminal(int(os.Stdin.Fd())):
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	default:
+		return fmt.Errorf("nothing to translate; give text, -f file, or pipe it in")
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("nothing to translate")
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	openRequestLog(config)
+	openAudit(config)
+	if err = openScreening(config); err != nil {
+		return err
+	}
+
+	source := ""
+	if from != "" {
+		source = " from " + from
+	}
+	segments := splitForTranslation(text, maxTranslateChunk)
+	parts := 0
+	for _, seg := range segments {
+		if !seg.keep {
+			parts++
+		}
+	}
+
+	var b strings.Builder
+	part := 0
+	for _, seg := range segments {
+		if seg.keep {
+			b.WriteString(seg.text)
+			continue
+		}
+		part++
+		if parts > 1 {
+			fmt.Fprintf(os.Stderr, "%s\rTranslating part %d of %d…%s", dim, part, parts, reset)
+		}
+		lead, core, trail := splitSpace(seg.text)
+		answer, err := queryOnce(config, providerFlag, modelFlag, profileFlag, fmt.Sprintf(translatePrompt, to, source, core), "translate")
+		if err != nil {
+			if parts > 1 {
+				fmt.Fprintln(os.Stderr)
+				err = fmt.Errorf("part %d of %d: %v", part, parts, err)
+			}
+			return err
+		}
+		answer = strings.TrimSpace(answer)
+		if m := codeFence.FindStringSubmatch(answer); m != nil {
+			answer = strings.TrimSpace(m[1])
+		}
+		b.WriteString(lead + answer + trail)
+	}
+	if parts > 1 {
+		fmt.Fprint(os.Stderr, clearLine)
+	}
+
+	translated := b.String()
+	jobOutcome = fmt.Sprintf("translated %d parts into %s", parts, to)
+	if output != "" {
+		if err := os.WriteFile(output, []byte(translated), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s✓ Wrote %s%s\n", green, output, reset)
+		return nil
+	}
+	fmt.Print(translated)
+	if !strings.HasSuffix(translated, "\n") {
+		fmt.Println()
+	}
+	return nil
+}
+
+// splitForTranslation cuts a document into code blocks, kept whole, and
+// prose chunks of about maxTokens, broken between paragraphs
+func splitForTranslation(text string, maxTokens int) []translateSegment {
+	var segments []translateSegment
+	var prose, block strings.Builder
+	var paragraph strings.Builder
+	fence := ""
+
+	flushProse := func() {
+		if prose.Len() > 0 {
+			segments = append(segments, translateSegment{text: prose.String()})
+			prose.Reset()
+		}
+	}
+	endParagraph := func() {
+		if paragraph.Len() == 0 {
+			return
+		}
+		if prose.Len() > 0 && estimateTokens(prose.String()+paragraph.String()) > maxTokens {
+			flushProse()
+		}
+		prose.WriteString(paragraph.String())
+		paragraph.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			block.WriteString(line)
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				segments = append(segments, translateSegment{text: block.String(), keep: true})
+				block.Reset()
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			endParagraph()
+			flushProse()
+			fence = trimmed[:3]
+			block.WriteString(line)
+			continue
+		}
+		paragraph.WriteString(line)
+		if trimmed == "" {
+			endParagraph()
+		}
+	}
+	endParagraph()
+	flushProse()
+	if block.Len() > 0 {
+		// An unterminated block runs to the end
+		segments = append(segments, translateSegment{text: block.String(), keep: true})
+	}
+
+	// Whitespace-only prose has nothing to translate
+	for i := range segments {
+		if !segments[i].keep && strings.TrimSpace(segments[i].text) == "" {
+			segments[i].keep = true
+		}
+	}
+	return segments
+}
+
+// splitSpace separates the leading and trailing whitespace of s, which the
+// translation keeps
+func splitSpace(s string) (lead, core, trail string) {
+	core = strings.TrimSpace(s)
+	if core == "" {
+		return s, "", ""
+	}
+	start := strings.Index(s, core)
+	return s[:start], core, s[start+len(core):]
+}
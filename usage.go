@@ -0,0 +1,251 @@
+// Package main provides the `ask usage` report over the usage ledger.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// isUsageCommand reports whether the arguments invoke `ask usage`. A prompt
+// that starts with the word ("ask usage of semicolons") is not a command.
+func isUsageCommand(args []string) bool {
+	if len(args) == 0 || args[0] != "usage" {
+		return false
+	}
+	return len(args) == 1 || strings.HasPrefix(args[1], "-")
+}
+
+// usageGroups maps --by values to the SQL expression rows are grouped by
+var usageGroups = map[string]string{
+	"model":    "provider || '/' || model",
+	"provider": "provider",
+	"day":      "strftime('%Y-%m-%d', created_at / 1000, 'unixepoch', 'localtime')",
+	"week":     "strftime('%Y-W%W', created_at / 1000, 'unixepoch', 'localtime')",
+	"month":    "strftime('%Y-%m', created_at / 1000, 'unixepoch', 'localtime')",
+}
+
+// Trend charts cover this many days unless --since is given, and never
+// use more than maxTrendBuckets characters
+const (
+	defaultTrendDays = 14
+	maxTrendBuckets  = 30
+)
+
+// usageRow is one line of the usage report
+type usageRow struct {
+	Key              string   `json:"key"`
+	Requests         int      `json:"requests"`
+	PromptTokens     int      `json:"prompt_tokens"`
+	CompletionTokens int      `json:"completion_tokens"`
+	Cost             *float64 `json:"cost_usd"` // nil when no request had a known price
+	Unpriced         int      `json:"unpriced_requests"`
+	AvgLatencyMs     int      `json:"avg_latency_ms"`
+	Trend            []int    `json:"trend_tokens,omitempty"` // tokens per trend bucket, oldest first
+}
+
+// usageWindow is the time span covered by trend charts
+type usageWindow struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	BucketDays int       `json:"bucket_days"`
+	Buckets    int       `json:"buckets"`
+}
+
+// trendWindow picks the span and bucket size for trend charts
+func trendWindow(f historyFilter) usageWindow {
+	end := time.Now()
+	if !f.until.IsZero() {
+		end = f.until
+	}
+	y, m, d := end.AddDate(0, 0, -defaultTrendDays+1).Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	if !f.since.IsZero() {
+		start = f.since
+	}
+
+	days := max(int(math.Ceil(end.Sub(start).Hours()/24)), 1)
+	bucketDays := (days + maxTrendBuckets - 1) / maxTrendBuckets
+	return usageWindow{Start: start, End: end, BucketDays: bucketDays, Buckets: (days + bucketDays - 1) / bucketDays}
+}
+
+// sparkline draws values as a row of block characters scaled to the largest
+func sparkline(values []int) string {
+	levels := []rune("▁▂▃▄▅▆▇█")
+	peak := 0
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		if v == 0 {
+			b.WriteString(dim + "·" + reset)
+			continue
+		}
+		b.WriteRune(levels[min(v*len(levels)/(peak+1), len(levels)-1)])
+	}
+	return b.String()
+}
+
+// runUsageCommand prints requests, tokens, and estimated cost from the usage
+// ledger, grouped and filtered by the flags in args
+func runUsageCommand(args []string) error {
+	by := "model"
+	asJSON := false
+	f, _, err := parseHistoryFlags("usage", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&by, "by", "model", "Group by `field`: model, provider, day, week, or month")
+		fs.BoolVar(&asJSON, "json", false, "Print the report as JSON")
+	})
+	if err != nil {
+		return err
+	}
+	if f.tag != "" {
+		return fmt.Errorf("usage can't be filtered by tag")
+	}
+	group, ok := usageGroups[by]
+	if !ok {
+		return fmt.Errorf("unknown grouping '%s' (use model, provider, day, week, or month)", by)
+	}
+
+	// Most expensive first, or newest first for dates
+	order := "sum(coalesce(cost, 0)) DESC, 1"
+	periodic := by == "day" || by == "week" || by == "month"
+	if periodic {
+		order = "1 DESC"
+	}
+
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	where, params := f.where("created_at")
+	rows, err := db.Query(`SELECT `+group+`, count(*), sum(prompt_tokens), sum(completion_tokens),
+			sum(cost), count(*) - count(cost), avg(latency_ms)
+		FROM usage `+where+` GROUP BY 1 ORDER BY `+order, params...)
+	if err != nil {
+		return err
+	}
+	var report []*usageRow
+	for rows.Next() {
+		r := &usageRow{}
+		var latency float64
+		if err := rows.Scan(&r.Key, &r.Requests, &r.PromptTokens, &r.CompletionTokens, &r.Cost, &r.Unpriced, &latency); err != nil {
+			rows.Close()
+			return err
+		}
+		r.AvgLatencyMs = int(latency)
+		report = append(report, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Per-model and per-provider rows get a chart of tokens over time
+	var window *usageWindow
+	if !periodic && len(report) > 0 {
+		w := trendWindow(f)
+		window = &w
+		if err := fillTrends(db, report, group, f, w); err != nil {
+			return err
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			GroupBy string       `json:"group_by"`
+			Rows    []*usageRow  `json:"rows"`
+			Trend   *usageWindow `json:"trend_window,omitempty"`
+		}{by, report, window})
+	}
+	printUsageReport(report, by, window)
+	return nil
+}
+
+// fillTrends adds token totals per time bucket to each row of the report
+func fillTrends(db *sql.DB, report []*usageRow, group string, f historyFilter, w usageWindow) error {
+	f.since, f.until = w.Start, w.End
+	where, params := f.where("created_at")
+	bucketMs := int64(w.BucketDays) * 24 * int64(time.Hour/time.Millisecond)
+	rows, err := db.Query(`SELECT `+group+`, (created_at - ?) / ?, sum(prompt_tokens + completion_tokens)
+		FROM usage `+where+` GROUP BY 1, 2`, append([]any{toMillis(w.Start), bucketMs}, params...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byKey := make(map[string]*usageRow, len(report))
+	for _, r := range report {
+		r.Trend = make([]int, w.Buckets)
+		byKey[r.Key] = r
+	}
+	for rows.Next() {
+		var key string
+		var bucket, tokens int
+		if err := rows.Scan(&key, &bucket, &tokens); err != nil {
+			return err
+		}
+		if r := byKey[key]; r != nil && bucket >= 0 && bucket < w.Buckets {
+			r.Trend[bucket] += tokens
+		}
+	}
+	return rows.Err()
+}
+
+// printUsageReport prints the report as a table with a total line
+func printUsageReport(report []*usageRow, by string, window *usageWindow) {
+	if len(report) == 0 {
+		fmt.Println("No usage recorded yet.")
+		return
+	}
+
+	header := strings.ToUpper(by[:1]) + by[1:]
+	trendHeader := ""
+	if window != nil {
+		trendHeader = fmt.Sprintf("  Tokens since %s", window.Start.Format("Jan 2"))
+	}
+	fmt.Printf("%s%-36s %6s %9s %11s %10s %8s%s%s\n", dim, header, "Reqs", "Prompt", "Completion", "Cost", "Latency", trendHeader, reset)
+
+	var total usageTotals
+	for _, r := range report {
+		t := usageTotals{requests: r.Requests, promptTokens: r.PromptTokens, completionTokens: r.CompletionTokens, unpriced: r.Unpriced > 0}
+		if r.Cost != nil {
+			t.cost = *r.Cost
+		}
+		key := r.Key
+		if len(key) > 36 {
+			key = key[:33] + "..."
+		}
+		trend := ""
+		if window != nil {
+			trend = "  " + sparkline(r.Trend)
+		}
+		fmt.Printf("%-36s %6d %9s %11s %10s %7.1fs%s\n", key, t.requests,
+			formatTokenCount(t.promptTokens), formatTokenCount(t.completionTokens), t.costLabel(),
+			float64(r.AvgLatencyMs)/1000, trend)
+
+		total.requests += t.requests
+		total.promptTokens += t.promptTokens
+		total.completionTokens += t.completionTokens
+		total.cost += t.cost
+		total.unpriced = total.unpriced || t.unpriced
+	}
+
+	fmt.Printf("%s%-36s %6d %9s %11s %10s%s\n", bold, "Total", total.requests,
+		formatTokenCount(total.promptTokens), formatTokenCount(total.completionTokens), total.costLabel(), reset)
+	fmt.Printf("%sToken counts and costs are estimates at list prices", dim)
+	if total.unpriced {
+		fmt.Print("; models without a known price are not costed")
+	}
+	if window != nil && window.BucketDays > 1 {
+		fmt.Printf("; each chart column is %d days", window.BucketDays)
+	}
+	fmt.Printf("%s\n", reset)
+}
@@ -5,4 +5,8 @@ package main
 var (
 	Version = "dev" // Overwritten at build time with git tag
 	AppName = "ask"
+
+	// TelemetryEndpoint receives opt-in usage counts; empty keeps them local
+	// Build: go build -ldflags="-X main.TelemetryEndpoint=https://..." .
+	TelemetryEndpoint = ""
 )
@@ -0,0 +1,412 @@
+// Package main provides --speak, which reads answers aloud, and --listen,
+// which records the prompt from the microphone and transcribes it.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/metolius25/ask/provider"
+
+	"github.com/chzyer/readline"
+)
+
+// VoiceConfig picks the backends for --speak and --listen
+type VoiceConfig struct {
+	TTS      string `yaml:"tts,omitempty"`       // auto (default), say, espeak, or openai
+	Voice    string `yaml:"voice,omitempty"`     // voice name for the backend
+	TTSModel string `yaml:"tts_model,omitempty"` // OpenAI speech model, default gpt-4o-mini-tts
+	STTModel string `yaml:"stt_model,omitempty"` // OpenAI transcription model, default whisper-1
+	Language string `yaml:"language,omitempty"`  // spoken language for --listen, e.g. en
+}
+
+// Voice defaults
+const (
+	defaultTTSModel  = "gpt-4o-mini-tts"
+	defaultTTSVoice  = "alloy"
+	defaultSTTModel  = "whisper-1"
+	maxSpeechChunk   = 4000 // OpenAI speech takes at most 4096 characters
+	maxRecordingTime = 2 * time.Minute
+	openAIAudioURL   = "https://api.openai.com/v1/audio/"
+)
+
+// speakAnswers is set by --speak; sessions read each answer aloud
+var speakAnswers bool
+
+// voice speaks and listens with the configured backends
+type voice struct {
+	cfg    VoiceConfig
+	apiKey string // OpenAI key from the chatgpt provider, if configured
+}
+
+// newVoice reads the voice settings and the OpenAI key from config
+func newVoice(config *Config) *voice {
+	v := &voice{cfg: config.Voice}
+	if pc, ok := config.Providers["chatgpt"]; ok && !isPlaceholderKey(pc.APIKey) {
+		v.apiKey = pc.APIKey
+	}
+	return v
+}
+
+// ttsBackend picks the configured speech backend, or for auto the first
+// local one installed, then OpenAI
+func (v *voice) ttsBackend() (string, error) {
+	switch v.cfg.TTS {
+	case "", "auto":
+		if _, err := exec.LookPath("say"); err == nil {
+			return "say", nil
+		}
+		if espeakCommand() != "" {
+			return "espeak", nil
+		}
+		if v.apiKey != "" {
+			return "openai", nil
+		}
+		return "", fmt.Errorf("no speech backend: install espeak-ng, or add a chatgpt key to use OpenAI speech")
+	case "say":
+		if _, err := exec.LookPath("say"); err != nil {
+			return "", fmt.Errorf("voice.tts is say, but say is not installed (it comes with macOS)")
+		}
+		return "say", nil
+	case "espeak":
+		if espeakCommand() == "" {
+			return "", fmt.Errorf("voice.tts is espeak, but neither espeak-ng nor espeak is installed")
+		}
+		return "espeak", nil
+	case "openai":
+		if v.apiKey == "" {
+			return "", fmt.Errorf("voice.tts is openai, but there is no chatgpt API key in config")
+		}
+		return "openai", nil
+	}
+	return "", fmt.Errorf("unknown voice.tts '%s' (use auto, say, espeak, or openai)", v.cfg.TTS)
+}
+
+// espeakCommand returns the installed espeak, preferring espeak-ng
+func espeakCommand() string {
+	for _, name := range []string{"espeak-ng", "espeak"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// speak reads markdown aloud, skipping code blocks and formatting. It
+// returns when done or when ctx is cancelled.
+func (v *voice) speak(ctx context.Context, markdown string) error {
+	text := speechText(markdown)
+	if text == "" {
+		return nil
+	}
+	backend, err := v.ttsBackend()
+	if err != nil {
+		return err
+	}
+	countEvent("speak:" + backend)
+	switch backend {
+	case "say":
+		args := []string{}
+		if v.cfg.Voice != "" {
+			args = append(args, "-v", v.cfg.Voice)
+		}
+		cmd := exec.CommandContext(ctx, "say", args...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	case "espeak":
+		args := []string{"--stdin"}
+		if v.cfg.Voice != "" {
+			args = append(args, "-v", v.cfg.Voice)
+		}
+		cmd := exec.CommandContext(ctx, espeakCommand(), args...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	for _, chunk := range speechChunks(text, maxSpeechChunk) {
+		if err := v.speakOpenAI(ctx, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// speakOpenAI synthesizes one chunk with OpenAI speech and plays it
+func (v *voice) speakOpenAI(ctx context.Context, text string) error {
+	model, name := v.cfg.TTSModel, v.cfg.Voice
+	if model == "" {
+		model = defaultTTSModel
+	}
+	if name == "" {
+		name = defaultTTSVoice
+	}
+	body, _ := json.Marshal(map[string]string{"model": model, "voice": name, "input": text, "response_format": "mp3"})
+	req, err := http.NewRequestWithContext(ctx, "POST", openAIAudioURL+"speech", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	audio, err := v.openAIRequest(req)
+	if err != nil {
+		return fmt.Errorf("OpenAI speech: %v", err)
+	}
+
+	f, err := os.CreateTemp("", "ask-speech-*.mp3")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(audio)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return playAudio(ctx, f.Name())
+}
+
+// openAIRequest sends an audio API request with the chatgpt key and returns
+// the response body
+func (v *voice) openAIRequest(req *http.Request) ([]byte, error) {
+	req.Header.Set("Authorization", "Bearer "+v.apiKey)
+	req.Header.Set("User-Agent", AppName+"/"+Version)
+	ctx, cancel := context.WithTimeout(req.Context(), 2*time.Minute)
+	defer cancel()
+	resp, err := provider.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return data, nil
+}
+
+// audioPlayers are tried in order to play speech from OpenAI
+var audioPlayers = [][]string{
+	{"afplay"},
+	{"mpv", "--no-video", "--really-quiet"},
+	{"ffplay", "-nodisp", "-autoexit", "-loglevel", "quiet"},
+	{"mpg123", "-q"},
+}
+
+// playAudio plays an audio file with the first player installed
+func playAudio(ctx context.Context, file string) error {
+	for _, player := range audioPlayers {
+		if _, err := exec.LookPath(player[0]); err == nil {
+			args := append(player[1:len(player):len(player)], file)
+			return exec.CommandContext(ctx, player[0], args...).Run()
+		}
+	}
+	return fmt.Errorf("no audio player found; install mpv, ffmpeg, or mpg123")
+}
+
+// Markdown that isn't read aloud
+var (
+	speechCodeBlock = regexp.MustCompile("(?s)```.*?(```|$)|~~~.*?(~~~|$)")
+	speechImage     = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	speechLink      = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	speechLinePunct = regexp.MustCompile(`(?m)^\s*(#{1,6}\s+|[-*+]\s+|>\s?|\|?\s*:?-{3,}.*$)`)
+	speechMarks     = regexp.MustCompile("[*_`~|]+")
+	speechSpaces    = regexp.MustCompile(`[ \t]+`)
+	speechSentence  = regexp.MustCompile(`(?s).*?([.!?]\s+|\n\n|$)`)
+)
+
+// speechText turns a markdown answer into plain text worth hearing: code
+// blocks are mentioned rather than read, and formatting is dropped
+func speechText(markdown string) string {
+	text := speechCodeBlock.ReplaceAllString(markdown, "\n(code block)\n")
+	text = speechImage.ReplaceAllString(text, "$1")
+	text = speechLink.ReplaceAllString(text, "$1")
+	text = speechLinePunct.ReplaceAllString(text, "")
+	text = speechMarks.ReplaceAllString(text, " ")
+	text = speechSpaces.ReplaceAllString(text, " ")
+	return strings.TrimSpace(collapseBlankLines(text))
+}
+
+// speechChunks splits text at paragraph and then sentence breaks so each
+// chunk is at most n bytes
+func speechChunks(text string, n int) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(current.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		current.Reset()
+	}
+	for _, piece := range speechSentence.FindAllString(text, -1) {
+		for len(piece) > n {
+			flush()
+			chunks = append(chunks, piece[:n])
+			piece = piece[n:]
+		}
+		if current.Len()+len(piece) > n {
+			flush()
+		}
+		current.WriteString(piece)
+	}
+	flush()
+	return chunks
+}
+
+// recorder returns the command that records mono 16 kHz audio to file until
+// interrupted, from the first recording tool installed
+func recorder(file string) (*exec.Cmd, error) {
+	if _, err := exec.LookPath("rec"); err == nil {
+		return exec.Command("rec", "-q", "-c", "1", "-r", "16000", "-b", "16", file), nil
+	}
+	if _, err := exec.LookPath("arecord"); err == nil {
+		return exec.Command("arecord", "-q", "-f", "S16_LE", "-c", "1", "-r", "16000", file), nil
+	}
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		input := []string{"-f", "pulse", "-i", "default"}
+		if runtime.GOOS == "darwin" {
+			input = []string{"-f", "avfoundation", "-i", ":0"}
+		}
+		args := append([]string{"-loglevel", "quiet", "-y"}, input...)
+		return exec.Command("ffmpeg", append(args, "-ac", "1", "-ar", "16000", file)...), nil
+	}
+	return nil, fmt.Errorf("no recorder found; install sox, alsa-utils (arecord), or ffmpeg")
+}
+
+// listen records from the microphone until Enter is pressed and returns
+// the transcript
+func (v *voice) listen() (string, error) {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("--listen needs a terminal to know when you're done speaking")
+	}
+	if v.apiKey == "" {
+		return "", fmt.Errorf("--listen transcribes with OpenAI Whisper; add a chatgpt API key to config")
+	}
+	dir, err := os.MkdirTemp("", "ask-listen-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "prompt.wav")
+	cmd, err := recorder(file)
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("starting %s: %v", filepath.Base(cmd.Path), err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s🎙 Listening… press Enter when you're done%s", magenta, reset)
+	stopped := make(chan struct{})
+	go func() {
+		bufio.NewReader(os.Stdin).ReadString('\n')
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(maxRecordingTime):
+		fmt.Fprintln(os.Stderr)
+	}
+	fmt.Fprint(os.Stderr, clearLine)
+	// An interrupt lets the recorder finish the file
+	cmd.Process.Signal(os.Interrupt)
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+	if info, err := os.Stat(file); err != nil || info.Size() <= 44 {
+		return "", fmt.Errorf("nothing was recorded; check the microphone")
+	}
+
+	countEvent("listen")
+	stop := startSpinner("Transcribing", "", nil)
+	text, err := v.transcribe(file)
+	stop()
+	if err != nil {
+		return "", err
+	}
+	if text == "" {
+		return "", fmt.Errorf("no speech was recognized")
+	}
+	fmt.Fprintf(os.Stderr, "%s🎙 %s%s\n", dim, text, reset)
+	return text, nil
+}
+
+// transcribe sends a recording to OpenAI Whisper
+func (v *voice) transcribe(file string) (string, error) {
+	model := v.cfg.STTModel
+	if model == "" {
+		model = defaultSTTModel
+	}
+	audio, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("model", model)
+	if v.cfg.Language != "" {
+		w.WriteField("language", v.cfg.Language)
+	}
+	part, err := w.CreateFormFile("file", filepath.Base(file))
+	if err != nil {
+		return "", err
+	}
+	part.Write(audio)
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", openAIAudioURL+"transcriptions", &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	data, err := v.openAIRequest(req)
+	if err != nil {
+		return "", fmt.Errorf("transcribing: %v", err)
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("transcribing: %v", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// speak reads a session answer aloud; Ctrl+C stops it
+func (s *Session) speak(answer string) {
+	ctx, done := s.beginRequest()
+	defer done()
+	if err := s.voice.speak(ctx, answer); err != nil && ctx.Err() == nil {
+		fmt.Printf("\n%s✗ Couldn't speak the answer: %v%s", red, err, reset)
+	}
+}
@@ -0,0 +1,239 @@
+// Package main provides `ask wipe`, which securely deletes everything ask
+// has stored on this machine.
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isWipeCommand reports whether the arguments invoke `ask wipe`. Anything
+// after it must be a flag, so "ask wipe the table" stays an ordinary prompt.
+func isWipeCommand(args []string) bool {
+	if len(args) == 0 || args[0] != "wipe" {
+		return false
+	}
+	for _, arg := range args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			return false
+		}
+	}
+	return true
+}
+
+// wipeTarget is a file or directory `ask wipe` deletes
+type wipeTarget struct {
+	path    string
+	label   string
+	size    int64
+	files   int
+	records []string // for audit records: only these files, never the directory
+	keyring bool     // the passphrase in the OS keyring rather than a file
+}
+
+// wipeTargets returns what exists of the database, input history, legacy
+// sessions, sync clone, request log, audit records, and keyring passphrase,
+// plus the config file if withConfig is set. The log file and audit
+// directory come from the config, so only the files ask wrote there are
+// included, in case they point somewhere shared.
+func wipeTargets(config *Config, withConfig bool) ([]wipeTarget, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+	candidates := []wipeTarget{
+		{path: filepath.Join(dir, "ask.db"), label: "sessions, history, usage, pins, and caches"},
+		{path: filepath.Join(dir, "ask.db.enc"), label: "encrypted database"},
+		{path: filepath.Join(dir, "ask.db.lock"), label: "database lock"},
+		{path: filepath.Join(dir, "ask.db-wal"), label: "database journal"},
+		{path: filepath.Join(dir, "ask.db-shm"), label: "database journal"},
+		{path: filepath.Join(dir, "ask.db-journal"), label: "database journal"},
+		{path: filepath.Join(dir, "history"), label: "session input history"},
+		{path: filepath.Join(dir, "sessions"), label: "sessions saved by older versions"},
+		{path: filepath.Join(dir, "sync"), label: "sync clone"},
+	}
+	if config != nil {
+		if config.LogFile != "" {
+			path := config.LogFile
+			if strings.HasPrefix(path, "~/") {
+				if homeDir, err := os.UserHomeDir(); err == nil {
+					path = filepath.Join(homeDir, path[2:])
+				}
+			}
+			if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+				candidates = append(candidates, wipeTarget{path: path, label: "request log"})
+			}
+		}
+	}
+	if withConfig {
+		candidates = append(candidates, wipeTarget{path: filepath.Join(dir, "config.yaml"), label: "config and API keys"})
+	}
+
+	var targets []wipeTarget
+	for _, t := range candidates {
+		err := filepath.WalkDir(t.path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					t.size += info.Size()
+				}
+				t.files++
+			}
+			return nil
+		})
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	if config != nil && config.AuditDir != "" {
+		auditDir := auditDirPath(config)
+		files, err := listAuditFiles(auditDir)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			t := wipeTarget{path: auditDir, label: "audit records"}
+			for _, f := range files {
+				t.records = append(t.records, filepath.Join(auditDir, f.name))
+				t.size += f.size
+			}
+			t.files = len(t.records)
+			targets = append(targets, t)
+		}
+	}
+	if _, err := keyringGet(); err == nil {
+		targets = append(targets, wipeTarget{path: "OS keyring", label: "database passphrase", keyring: true})
+	}
+	return targets, nil
+}
+
+// shredFile overwrites a file with random bytes, flushes it to disk, and
+// removes it
+func shredFile(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() && info.Size() > 0 {
+		// Audit records are read-only
+		if info.Mode().Perm()&0200 == 0 {
+			if err := os.Chmod(path, 0600); err != nil {
+				return err
+			}
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(f, rand.Reader, info.Size())
+		if err == nil {
+			err = f.Sync()
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// shredTree shreds every file under path, then removes the directories
+func shredTree(path string) error {
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return shredFile(p)
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// runWipeCommand shows what will be deleted, asks for confirmation, and
+// shreds it
+func runWipeCommand(args []string) error {
+	withConfig, yes := false, false
+	fset := flag.NewFlagSet("wipe", flag.ContinueOnError)
+	fset.BoolVar(&withConfig, "config", false, "Also delete config.yaml and its API keys")
+	fset.BoolVar(&yes, "yes", false, "Don't ask for confirmation")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+
+	config, _ := LoadConfigSafe() // still wipe the defaults if the config is unreadable
+	targets, err := wipeTargets(config, withConfig)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		fmt.Println("Nothing to wipe")
+		return nil
+	}
+
+	fmt.Println("This permanently deletes:")
+	for _, t := range targets {
+		if t.keyring {
+			fmt.Printf("  %-45s %s\n", t.path, t.label)
+			continue
+		}
+		fmt.Printf("  %-45s %s, %s\n", t.path, t.label, formatBytes(t.size))
+	}
+	if !withConfig {
+		fmt.Printf("%sconfig.yaml is kept; add --config to delete it too%s\n", dim, reset)
+	}
+	if !yes {
+		fmt.Print("\nType 'wipe' to confirm: ")
+		scanner := bufio.NewScanner(os.Stdin)
+		if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "wipe" {
+			fmt.Println("Cancelled")
+			return nil
+		}
+	}
+
+	var failed []string
+	for _, t := range targets {
+		switch {
+		case t.keyring:
+			keyringDelete()
+		case t.records != nil:
+			for _, record := range t.records {
+				if err := shredFile(record); err != nil {
+					failed = append(failed, fmt.Sprintf("%s: %v", record, err))
+				}
+			}
+			os.Remove(t.path) // only if nothing else is left in it
+		default:
+			if err := shredTree(t.path); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", t.path, err))
+			}
+		}
+	}
+	if withConfig {
+		if dir, err := configDir(); err == nil {
+			os.Remove(dir) // only if nothing else is left in it
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("some files could not be wiped:\n  %s", strings.Join(failed, "\n  "))
+	}
+	fmt.Printf("Wiped %d locations\n", len(targets))
+	return nil
+}